@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// Why a batch stopped
+const (
+	batchCompleted           = "completed"
+	batchFailure             = "failure"
+	batchBudgetExhausted     = "budget_exhausted"
+	batchTimeBudgetExhausted = "time_budget_exhausted"
+	batchSessionBudget       = "session_budget_exceeded" // Also the refused command's failure reason
+	batchInterrupted         = "interrupted"
+)
+
+// batchOptions controls how a batch runs
+type batchOptions struct {
+	concurrency   int
+	budget        int           // Tokens the emitted envelopes may use; 0 for no budget
+	timeBudget    time.Duration // Time the whole batch may take; 0 for no limit
+	stopOnFailure bool
+}
+
+// batchItem is a command in a batch
+type batchItem struct {
+	index   int    // From 1, in input order
+	id      string // Optional, from NDJSON input
+	command string
+}
+
+// batchResult is the outcome of running a batch item
+type batchResult struct {
+	item      batchItem
+	executor  *CommandExecutor // The item's own, for its cache key
+	output    *models.Output
+	started   bool
+	cancelled bool // The batch stopped before or while the command ran
+}
+
+// NewBatchCmd creates the batch command for running many commands at once
+func NewBatchCmd() *cobra.Command {
+	var opts batchOptions
+
+	batchCmd := &cobra.Command{
+		Use:   "batch [file|-]",
+		Short: "Run many commands concurrently, emitting each envelope as NDJSON as it finishes",
+		Long: `Run the commands in a file, or on stdin, several at a time, sharing one
+ctx process and its tokenizers. Each line is a command, or a JSON object
+with a command and an optional id; blank lines and lines starting with #
+are skipped.
+
+Each command's envelope is printed as a result event as soon as it
+finishes, with the command's index (from 1) and id, followed by a stop
+event whose batch field sums up the run. Results arrive in the order the
+commands finish, not the order they were given.
+
+The batch stops early after the first failure with --stop-on-failure,
+when --time-budget runs out, or when an envelope would take the emitted
+tokens past --budget; that envelope is withheld, with its output left in
+history. Once the session has spent its budget (see 'ctx session'), the
+next command is refused with failure_reason "session_budget_exceeded" and
+the batch stops. Commands that did not run, or were cut short, get an envelope
+with failure_reason "skipped" and the error saying why. ctx exits with 0
+when every command succeeded, and 1 otherwise.
+
+Examples:
+  ctx batch commands.txt
+  ctx batch --concurrency 8 --budget 20000 commands.txt
+  printf '%s\n' '{"id":"pods","command":"kubectl get pods"}' '{"id":"log","command":"git log -5"}' | ctx batch
+  ctx batch --stop-on-failure --time-budget 2m checks.txt`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+
+			var input io.Reader = cmd.InOrStdin()
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				input = f
+			}
+			items, err := parseBatch(input)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				return fmt.Errorf("no commands in the batch")
+			}
+
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			executor := NewCommandExecutor(appCtx)
+			if err := executor.checkSessionBudget(cmd.Context(), fmt.Sprintf("batch of %d commands", len(items))); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return executor.batch(ctx, cmd.OutOrStdout(), items, opts)
+		},
+	}
+
+	batchCmd.Flags().IntVarP(&opts.concurrency, "concurrency", "j", 4, "Commands run at the same time")
+	batchCmd.Flags().IntVar(&opts.budget, "budget", 0, "Tokens the emitted envelopes may use in total (0 for no budget)")
+	batchCmd.Flags().DurationVar(&opts.timeBudget, "time-budget", 0, "Time the whole batch may take, e.g. '2m' (0 for no limit)")
+	batchCmd.Flags().BoolVar(&opts.stopOnFailure, "stop-on-failure", false, "Stop the batch after the first command that fails")
+
+	return batchCmd
+}
+
+// parseBatch reads batch items, one per line: a command, or a JSON object
+// with "command" and optionally "id"
+func parseBatch(r io.Reader) ([]batchItem, error) {
+	var items []batchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		item := batchItem{index: len(items) + 1, command: line}
+		if strings.HasPrefix(line, "{") {
+			var entry struct {
+				ID      string `json:"id"`
+				Command string `json:"command"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if strings.TrimSpace(entry.Command) == "" {
+				return nil, fmt.Errorf("line %d: no command", n)
+			}
+			item.id, item.command = entry.ID, entry.Command
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+// batch runs the items with up to opts.concurrency at a time, emitting a
+// result event for each as NDJSON to w, then a stop event
+func (ce *CommandExecutor) batch(ctx context.Context, w io.Writer, items []batchItem, opts batchOptions) error {
+	start := time.Now()
+	summary := &models.BatchSummary{Commands: len(items)}
+	emit := func(event models.StreamEvent) {
+		if data, err := json.Marshal(event); err == nil {
+			fmt.Fprintln(w, string(data))
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if opts.timeBudget > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeout(runCtx, opts.timeBudget)
+		defer cancelTimeout()
+	}
+	var reason string
+	stopWith := func(r string) {
+		if reason == "" {
+			reason = r
+			cancel()
+		}
+	}
+
+	queue := make(chan batchItem)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < min(opts.concurrency, len(items)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range queue {
+				results <- ce.runBatchItem(runCtx, item)
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for _, item := range items {
+			select {
+			case queue <- item:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	reported := make(map[int]bool, len(items))
+	for res := range results {
+		reported[res.item.index] = true
+		if res.cancelled {
+			if reason == "" {
+				stopWith(ce.batchStopReason(ctx, runCtx))
+			}
+			summary.Skipped++
+			emit(ce.skippedEvent(ctx, res.item, reason))
+			continue
+		}
+
+		shown, withheld := res.output, false
+		if res.started {
+			saved := res.executor.finish(shown)
+			if opts.budget > 0 && summary.Tokens+shown.Tokens > opts.budget {
+				shown, withheld = ce.withhold(shown, opts.budget-summary.Tokens), true
+				stopWith(batchBudgetExhausted)
+			}
+			res.executor.enricher.RecordAs(shown, saved)
+		} else {
+			ce.enricher.EnrichContext(ctx, shown)
+		}
+
+		switch {
+		case withheld:
+			summary.Withheld++
+		case shown.Metadata.Success:
+			summary.Succeeded++
+		default:
+			summary.Failed++
+		}
+		summary.Tokens += shown.Tokens
+		emit(models.StreamEvent{Type: "result", Index: res.item.index, ID: res.item.id, Envelope: shown})
+
+		if shown.Metadata.FailureReason == batchSessionBudget {
+			stopWith(batchSessionBudget)
+		} else if !shown.Metadata.Success && opts.stopOnFailure {
+			stopWith(batchFailure)
+		}
+	}
+
+	// Commands the batch stopped before
+	for _, item := range items {
+		if !reported[item.index] {
+			summary.Skipped++
+			emit(ce.skippedEvent(ctx, item, reason))
+		}
+	}
+
+	if reason == "" {
+		reason = batchCompleted
+	}
+	summary.Reason = reason
+	summary.Duration = int(time.Since(start).Milliseconds())
+	emit(models.StreamEvent{Type: "stop", Batch: summary})
+
+	if summary.Succeeded < summary.Commands {
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+	return nil
+}
+
+// runBatchItem runs a command of a batch with an executor of its own,
+// reusing a cached result when caching applies. A command is refused once
+// the session has spent its budget, which earlier commands may have done.
+func (ce *CommandExecutor) runBatchItem(ctx context.Context, item batchItem) batchResult {
+	res := batchResult{item: item, executor: NewCommandExecutor(ce.appCtx)}
+	if ctx.Err() != nil {
+		res.cancelled = true
+		return res
+	}
+	if refusal := res.executor.sessionBudgetRefusal(ctx, item.command); refusal != nil {
+		res.output = refusal
+		return res
+	}
+
+	if key, ttl := res.executor.cacheKeyOf(item.command); key != "" {
+		if output := res.executor.cachedResult(key, ttl); output != nil {
+			res.output, res.started = output, true
+			return res
+		}
+		res.executor.cacheKey = key
+	}
+
+	output, started, err := res.executor.runEnvelope(ctx, item.command)
+	if err != nil {
+		output, started = models.NewOutput(item.command, []byte(err.Error()), 1, 0), false
+	}
+	res.output, res.started = output, started
+	res.cancelled = ctx.Err() != nil
+	return res
+}
+
+// batchStopReason tells why the batch's context ended when no result
+// stopped it: the time budget ran out or ctx was interrupted
+func (ce *CommandExecutor) batchStopReason(parent, runCtx context.Context) string {
+	if parent.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		return batchTimeBudgetExhausted
+	}
+	return batchInterrupted
+}
+
+// withhold returns an envelope without the output of a run that would take
+// the batch past its token budget
+func (ce *CommandExecutor) withhold(output *models.Output, remaining int) *models.Output {
+	shown := *output
+	shown.Output = ""
+	shown.Metadata.Success = false
+	shown.Metadata.FailureReason = batchBudgetExhausted
+	shown.Metadata.Error = fmt.Sprintf("output withheld: its %d tokens would exceed the %d left in the batch budget", output.Tokens, max(remaining, 0))
+	ce.enricher.Recount(&shown)
+	return &shown
+}
+
+// skippedEvent reports a command the batch did not run, or cut short
+func (ce *CommandExecutor) skippedEvent(ctx context.Context, item batchItem, reason string) models.StreamEvent {
+	output := models.NewOutput(item.command, nil, 1, 0)
+	ce.enricher.EnrichContext(ctx, output)
+	output.Metadata.Success = false
+	output.Metadata.FailureReason = "skipped"
+	output.Metadata.Error = "not run: the batch stopped (" + reason + ")"
+	return models.StreamEvent{Type: "result", Index: item.index, ID: item.id, Envelope: output}
+}
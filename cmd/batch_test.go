@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/app"
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/session"
+)
+
+func TestParseBatch(t *testing.T) {
+	input := `# checks
+git status
+
+{"id": "log", "command": "git log -5"}
+  ls -la
+`
+	items, err := parseBatch(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []batchItem{
+		{index: 1, command: "git status"},
+		{index: 2, id: "log", command: "git log -5"},
+		{index: 3, command: "ls -la"},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("parseBatch() = %+v, want %+v", items, want)
+	}
+
+	for _, bad := range []string{`{"id": "x"}`, `{"command": `} {
+		if _, err := parseBatch(strings.NewReader("echo ok\n" + bad)); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("parseBatch(%q) error = %v, want one on line 2", bad, err)
+		}
+	}
+}
+
+// runBatch runs commands one at a time and returns the result envelopes by
+// index and the summary
+func runBatch(t *testing.T, appCtx *app.AppContext, opts batchOptions, commands ...string) (map[int]*models.Output, *models.BatchSummary) {
+	t.Helper()
+	var items []batchItem
+	for i, command := range commands {
+		items = append(items, batchItem{index: i + 1, command: command})
+	}
+	opts.concurrency = 1
+
+	var out bytes.Buffer
+	_ = NewCommandExecutor(appCtx).batch(context.Background(), &out, items, opts)
+
+	results := make(map[int]*models.Output)
+	var summary *models.BatchSummary
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event models.StreamEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("bad event %q: %v", line, err)
+		}
+		switch event.Type {
+		case "result":
+			results[event.Index] = event.Envelope
+		case "stop":
+			summary = event.Batch
+		}
+	}
+	if len(results) != len(commands) || summary == nil {
+		t.Fatalf("got %d results and summary %v for %d commands:\n%s", len(results), summary, len(commands), out.String())
+	}
+	return results, summary
+}
+
+func TestBatchBudget(t *testing.T) {
+	results, summary := runBatch(t, newTestContext(&config.Config{}), batchOptions{budget: 50},
+		"seq 1 30", "seq 1 30", "seq 1 30")
+
+	if r := results[1]; !r.Metadata.Success || r.Tokens != 30 {
+		t.Errorf("first: success %v with %d tokens, want 30", r.Metadata.Success, r.Tokens)
+	}
+	if r := results[2]; r.Metadata.FailureReason != batchBudgetExhausted || r.Output != "" {
+		t.Errorf("second: failure %q with output %q, want it withheld", r.Metadata.FailureReason, r.Output)
+	}
+	if r := results[3]; r.Metadata.FailureReason != "skipped" {
+		t.Errorf("third: failure %q, want skipped", r.Metadata.FailureReason)
+	}
+	if summary.Reason != batchBudgetExhausted || summary.Succeeded != 1 || summary.Withheld != 1 || summary.Skipped != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestBatchStopOnFailure(t *testing.T) {
+	results, summary := runBatch(t, newTestContext(&config.Config{}), batchOptions{stopOnFailure: true},
+		"echo one", "false", "echo three")
+
+	if !results[1].Metadata.Success || results[2].Metadata.Success {
+		t.Errorf("success = %v, %v; want true, false", results[1].Metadata.Success, results[2].Metadata.Success)
+	}
+	if r := results[3]; r.Metadata.FailureReason != "skipped" || !strings.Contains(r.Metadata.Error, batchFailure) {
+		t.Errorf("third: failure %q, error %q; want skipped after a failure", r.Metadata.FailureReason, r.Metadata.Error)
+	}
+	if summary.Reason != batchFailure || summary.Succeeded != 1 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+
+	// Without --stop-on-failure every command runs
+	if _, summary := runBatch(t, newTestContext(&config.Config{}), batchOptions{}, "false", "echo two"); summary.Reason != batchCompleted || summary.Succeeded != 1 {
+		t.Errorf("summary without --stop-on-failure = %+v", summary)
+	}
+}
+
+func TestBatchSessionBudget(t *testing.T) {
+	sessions := session.NewManager(t.TempDir())
+	if _, err := sessions.Start("agent", 40); err != nil {
+		t.Fatal(err)
+	}
+	appCtx := newTestContext(&config.Config{})
+	appCtx.Sessions, appCtx.SessionID = sessions, "agent"
+
+	// The first command spends the session's budget; a later one is refused
+	// and stops the batch. The second may start before the first is counted.
+	results, summary := runBatch(t, appCtx, batchOptions{}, "seq 1 50", "echo two", "echo three", "echo four")
+
+	if !results[1].Metadata.Success {
+		t.Errorf("first command failed: %s", results[1].Metadata.Error)
+	}
+	refused := 0
+	for i := 2; i <= 4; i++ {
+		if results[i].Metadata.FailureReason == batchSessionBudget {
+			refused++
+			if results[i].Session == nil || results[i].Session.Tokens < 40 {
+				t.Errorf("refused command %d reports session %+v", i, results[i].Session)
+			}
+		}
+	}
+	if refused == 0 || results[4].Metadata.Success {
+		t.Errorf("refused %d commands, fourth succeeded %v; want later commands refused or skipped", refused, results[4].Metadata.Success)
+	}
+	if summary.Reason != batchSessionBudget {
+		t.Errorf("summary reason = %q, want %q", summary.Reason, batchSessionBudget)
+	}
+}
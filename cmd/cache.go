@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/cache"
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/files"
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+// newCachePolicy builds the result cache policy from the configuration. It
+// returns nil when nothing is cached.
+func newCachePolicy(cfg config.CacheConfig) (*cache.Policy, error) {
+	if cfg.TTL == "" && len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+	policy := &cache.Policy{Env: cfg.Env}
+	if cfg.TTL != "" {
+		ttl, err := history.ParseAge(cfg.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("cache ttl: %w", err)
+		}
+		policy.TTL = ttl
+	}
+	for _, rule := range cfg.Rules {
+		ttl, err := history.ParseAge(rule.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("cache rule %q: %w", rule.Match, err)
+		}
+		policy.Rules = append(policy.Rules, cache.Rule{Match: rule.Match, TTL: ttl, Env: rule.Env, Files: rule.Files})
+	}
+	return policy, nil
+}
+
+// cacheKeyOf returns the key a command's result is cached under and how long
+// it is reused, or "" when the command is not cached
+func (ce *CommandExecutor) cacheKeyOf(command string) (string, time.Duration) {
+	if ce.appCtx.History == nil || !ce.appCtx.History.IsEnabled() {
+		return "", 0
+	}
+	policy, err := newCachePolicy(ce.appCtx.Config.Cache)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: result caching is off: %v\n", err)
+		return "", 0
+	}
+	spec, ok := policy.For(command)
+	if !ok {
+		return "", 0
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", 0
+	}
+
+	// Settings that change the envelope are part of the key
+	cfg := ce.appCtx.Config
+	extra := []string{
+		"models", strings.Join(cfg.TokenModels, ","),
+		"accuracy", cfg.TokenAccuracy,
+		"explain", strconv.FormatBool(cfg.ExplainTokens),
+		"no-tokens", strconv.FormatBool(cfg.NoTokens),
+		"max-tokens", strconv.FormatInt(cfg.MaxTokens, 10),
+	}
+	for _, limit := range []*int64{cfg.Limits.MaxTokens, cfg.Limits.MaxLines, cfg.Limits.MaxOutputBytes} {
+		value := "none"
+		if limit != nil {
+			value = strconv.FormatInt(*limit, 10)
+		}
+		extra = append(extra, "limit", value)
+	}
+	return spec.Key(command, cwd, files.FindRoot(cwd), extra...), spec.TTL
+}
+
+// cachedResult returns the envelope of the newest successful run stored
+// under key within ttl, or nil when there is none
+func (ce *CommandExecutor) cachedResult(key string, ttl time.Duration) *models.Output {
+	store, err := ce.appCtx.History.Open()
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	entries, err := store.List(history.Filter{CacheKey: key, Since: now.Add(-ttl), Limit: 1})
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	output, err := store.Get(entries[0].ID)
+	if err != nil {
+		return nil
+	}
+	output.Metadata.Cache = &models.CacheInfo{
+		Hit:      true,
+		Age:      now.Sub(entries[0].Time).Milliseconds(),
+		SourceID: entries[0].ID,
+		Key:      key,
+	}
+	return output
+}
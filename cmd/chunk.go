@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/chunk"
+	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// NewChunkCmd creates the chunk subcommand for splitting input into token-bounded pieces
+func NewChunkCmd() *cobra.Command {
+	var overlap int
+
+	chunkCmd := &cobra.Command{
+		Use:   "chunk [file | - | -- command [args...]]",
+		Short: "Split a file, stdin or command output into chunks that fit a token budget",
+		Long: `Split a large input into chunks of at most --max-tokens tokens each, for
+map-reduce style workflows. The input is a file, stdin ("-" or no argument), or
+the output of a command after "--".
+
+Chunks end at function, paragraph or line boundaries where possible, and are
+counted exactly with the configured tokenizer. With --overlap, each chunk
+repeats about that many tokens from the end of the previous one.
+
+Output is NDJSON, one chunk per line, with its index, exact token count,
+source byte range and text.
+
+Examples:
+  ctx chunk --max-tokens 4000 large.log
+  ctx chunk --max-tokens 4000 --overlap 200 -- git log -p
+  cat notes.md | ctx --token-model openai chunk --max-tokens 2000`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+
+			// --max-tokens is the chunk size here, not an output limit
+			maxTokens, _ := cmd.Flags().GetInt64("max-tokens")
+			if maxTokens <= 0 {
+				return fmt.Errorf("--max-tokens is required for chunk")
+			}
+			tok, tokErr := appCtx.GetTokenizer()
+			if tok == nil && tokErr != nil {
+				return fmt.Errorf("cannot chunk without a tokenizer: %w", tokErr)
+			}
+
+			source, data, exitCode, err := readChunkInput(cmd, appCtx.Config.DefaultTimeout, args)
+			if err != nil {
+				return err
+			}
+
+			chunks, err := chunk.Split(tok, string(data), chunk.Options{MaxTokens: int(maxTokens), Overlap: overlap})
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			for _, c := range chunks {
+				if err := encoder.Encode(models.Chunk{
+					Index:  c.Index,
+					Tokens: c.Tokens,
+					Start:  c.Start,
+					End:    c.End,
+					Source: source,
+					Text:   c.Text,
+				}); err != nil {
+					return err
+				}
+			}
+
+			// The chunks are still printed when the command fails
+			if exitCode != 0 {
+				return &ExitError{Code: ExitCodeWrappedCmdError}
+			}
+			return nil
+		},
+	}
+
+	chunkCmd.Flags().IntVar(&overlap, "overlap", 0, "Tokens repeated from the end of each chunk at the start of the next")
+
+	return chunkCmd
+}
+
+// readChunkInput reads the input to chunk: a command's output after "--",
+// a file, or stdin. It returns the source name, the data and the command's exit code.
+func readChunkInput(cmd *cobra.Command, timeout time.Duration, args []string) (string, []byte, int, error) {
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 && dash < len(args) {
+		command := strings.Join(args[dash:], " ")
+		ctx := cmd.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		result, err := executor.ExecuteCommand(ctx, command)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return command, result.Output, result.ExitCode, nil
+	}
+
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == stdinPath):
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		return stdinPath, data, 0, nil
+	case len(args) == 1:
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return "", nil, 0, err
+		}
+		return args[0], data, 0, nil
+	default:
+		return "", nil, 0, fmt.Errorf("chunk takes a single file, \"-\" for stdin, or a command after \"--\"")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/slavakurilyak/ctx/internal/config"
 	"github.com/spf13/cobra"
@@ -99,7 +100,7 @@ type ConfigValue struct {
 func buildConfigOutput(cfg *config.Config) ConfigOutput {
 	output := ConfigOutput{
 		TokenModel: ConfigValue{
-			Value:  cfg.TokenModel,
+			Value:  strings.Join(cfg.TokenModels, ","),
 			Source: getSource(cfg, "TokenModel"),
 		},
 		DefaultTimeout: ConfigValue{
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/app"
+	"github.com/slavakurilyak/ctx/internal/enricher"
+	"github.com/slavakurilyak/ctx/internal/files"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+	"github.com/spf13/cobra"
+)
+
+// stdinPath is the argument that selects standard input
+const stdinPath = "-"
+
+// NewCountCmd creates the count subcommand for counting tokens in files
+func NewCountCmd() *cobra.Command {
+	var noIgnore bool
+	var top int
+
+	countCmd := &cobra.Command{
+		Use:   "count [paths...]",
+		Short: "Count tokens in files, directories, globs or stdin",
+		Long: `Count tokens in files, directories, globs or stdin without running a command.
+
+Directories are walked recursively, skipping .git and anything ignored by
+.gitignore. Globs may use "**" to match any number of directories; quote them
+so the shell does not expand them. Use "-" to count stdin.
+Binary files are reported as skipped.
+
+The envelope's tokens field is the total; the count section lists files and
+directory totals, most tokens first.
+
+Examples:
+  ctx count internal/
+  ctx count 'src/**/*.go' README.md
+  ctx --token-model anthropic,openai count .
+  git show HEAD | ctx count -`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+
+			// Without paths, count the current directory
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+
+			return runCount(cmd, appCtx, args, files.Options{NoIgnore: noIgnore}, top)
+		},
+	}
+
+	countCmd.Flags().BoolVar(&noIgnore, "no-ignore", false, "Include files ignored by .gitignore")
+	countCmd.Flags().IntVar(&top, "top", 0, "Only list the N files and directories with the most tokens (0 for all)")
+
+	return countCmd
+}
+
+// runCount counts the selected files and prints the envelope
+func runCount(cmd *cobra.Command, appCtx *app.AppContext, args []string, opts files.Options, top int) error {
+	start := time.Now()
+	cfg := appCtx.Config
+
+	if cfg.NoTokens {
+		return fmt.Errorf("token counting is disabled (--no-tokens)")
+	}
+	toks := appCtx.Tokenizers
+	if tok, _ := appCtx.GetTokenizer(); len(toks) == 0 && tok != nil {
+		toks = []tokenizer.Tokenizer{tok}
+	}
+
+	var paths []string
+	readStdin := false
+	for _, arg := range args {
+		if arg == stdinPath {
+			readStdin = true
+			continue
+		}
+		paths = append(paths, arg)
+	}
+
+	selected, err := files.Collect(paths, opts)
+	if err != nil {
+		return err
+	}
+	mode, threshold := cfg.TokenAccuracy, cfg.TokenAccuracyThreshold
+	counted := files.CountFiles(selected, toks, mode, threshold)
+	if readStdin {
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return fmt.Errorf("failed to read stdin: %w", err)
+		}
+		counted = append(counted, files.CountData(files.File{Path: stdinPath}, data, toks, mode, threshold))
+	}
+
+	output := newCountOutput(args, counted, top, time.Since(start))
+	if len(toks) == 0 || toks[0] == nil {
+		output.Metadata.TokenizerStatus = "unavailable"
+		if appCtx.TokenizerErr != nil {
+			output.Metadata.TokenizerError = appCtx.TokenizerErr.Error()
+		}
+	}
+
+	executor := NewCommandExecutor(appCtx)
+	executor.enricher.EnrichContext(cmd.Context(), output)
+
+	if cfg.MaxTokens > 0 && int64(output.Tokens) > cfg.MaxTokens {
+		limitErr := &TokenLimitExceededError{Limit: cfg.MaxTokens, Actual: output.Tokens}
+		output.Metadata.Error = limitErr.Error()
+		output.Metadata.Success = false
+		output.Metadata.FailureReason = "token_limit_exceeded"
+		_ = executor.outputResult(output)
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+
+	return executor.outputResult(output)
+}
+
+// newCountOutput builds the envelope for counted files
+func newCountOutput(args []string, counted []files.Counted, top int, duration time.Duration) *models.Output {
+	output := models.NewOutput("count "+strings.Join(args, " "), nil, 0, duration)
+
+	estimate, byModel := files.Total(counted)
+	output.Tokens = estimate.Tokens
+	output.TokensByModel = byModel
+	output.TokenEstimate = enricher.NewTokenEstimate(estimate)
+
+	section := &models.CountSection{Files: []models.FileCount{}}
+	var countedFiles []files.Counted
+	for _, c := range counted {
+		if c.Skipped != "" {
+			section.Skipped = append(section.Skipped, models.SkippedFile{Path: c.Path, Reason: c.Skipped, Error: c.Error})
+			continue
+		}
+		output.Metadata.Bytes += int(c.Size)
+		countedFiles = append(countedFiles, c)
+	}
+
+	files.SortByTokens(countedFiles)
+	for i, c := range countedFiles {
+		if top > 0 && i == top {
+			break
+		}
+		section.Files = append(section.Files, models.FileCount{
+			Path:          c.Path,
+			Tokens:        c.Tokens,
+			TokensByModel: c.TokensByModel,
+			Bytes:         c.Size,
+		})
+	}
+	for i, d := range files.DirTotals(countedFiles) {
+		if top > 0 && i == top {
+			break
+		}
+		section.Directories = append(section.Directories, models.DirCount{
+			Path:   d.Path,
+			Tokens: d.Tokens,
+			Bytes:  d.Bytes,
+			Files:  d.Files,
+		})
+	}
+
+	output.Count = section
+	return output
+}
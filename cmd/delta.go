@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/slavakurilyak/ctx/internal/diff"
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+// deltaContext is the number of unchanged lines shown around each change
+const deltaContext = 3
+
+// applyDelta replaces an output with its difference from the previous run
+// of the same command in the same directory, and returns a copy of the full
+// envelope to save to history. Without an earlier run the output is left
+// whole.
+func (ce *CommandExecutor) applyDelta(output *models.Output) *models.Output {
+	full := *output
+	base, err := ce.deltaBase(output)
+	if err != nil {
+		output.Metadata.Warnings = append(output.Metadata.Warnings, "delta: "+err.Error())
+		return &full
+	}
+	if base == nil {
+		return &full
+	}
+
+	id := base.Metadata.HistoryID
+	edits := diff.Lines(diff.SplitLines(base.Output), diff.SplitLines(output.Output))
+	added, removed := diff.Stats(edits)
+	output.Delta = &models.DeltaSection{
+		BaseID:    id,
+		Unchanged: added == 0 && removed == 0,
+		Added:     added,
+		Removed:   removed,
+	}
+	if output.Delta.Unchanged {
+		output.Output = "unchanged since " + id
+	} else {
+		output.Output = diff.Unified(edits, "", "", deltaContext) // delta.base_id names the old side
+	}
+
+	// tokens counts what is printed; full_tokens what it stands for
+	output.FullTokens = output.Tokens
+	ce.enricher.Recount(output)
+	return &full
+}
+
+// deltaBase returns the newest recorded run of the output's command in its
+// directory, or nil when there is none. Commands differing in a value share
+// a fingerprint, but their outputs are not versions of each other.
+func (ce *CommandExecutor) deltaBase(output *models.Output) (*models.Output, error) {
+	if ce.appCtx.History == nil || !ce.appCtx.History.IsEnabled() {
+		return nil, errors.New("history is disabled, so there is no previous run to compare with")
+	}
+	store, err := ce.appCtx.History.Open()
+	if err != nil {
+		return nil, err
+	}
+	dir := output.Metadata.Directory
+	entries, err := store.List(history.Filter{Fingerprint: output.Metadata.Fingerprint, Directory: dir})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Directory == dir && entry.Input == output.Input { // Not a subdirectory, nor another value
+			return store.Get(entry.ID)
+		}
+	}
+	return nil, nil
+}
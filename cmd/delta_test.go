@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func TestDeltaBaseMatchesInput(t *testing.T) {
+	t.Setenv("CTX_HISTORY_DIR", t.TempDir())
+	t.Setenv("CTX_NO_HISTORY", "")
+	if fingerprint.Of("seq 1 5") != fingerprint.Of("seq 1 6") {
+		t.Fatal("seq 1 5 and seq 1 6 should share a fingerprint")
+	}
+
+	appCtx := newTestContext(&config.Config{Delta: true})
+	appCtx.History = history.NewHistoryManager()
+	ce := NewCommandExecutor(appCtx)
+	run := func(command string) *models.Output {
+		t.Helper()
+		output, _, err := ce.runEnvelope(context.Background(), command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ce.enricher.RecordAs(output, ce.finish(output))
+		return output
+	}
+
+	first := run("seq 1 5")
+	if first.Delta != nil {
+		t.Fatalf("first run has a delta: %+v", first.Delta)
+	}
+	// Same fingerprint, different command: printed whole
+	if other := run("seq 1 6"); other.Delta != nil || other.Output != "1\n2\n3\n4\n5\n6\n" {
+		t.Errorf("seq 1 6 was compared with seq 1 5: delta %+v, output %q", other.Delta, other.Output)
+	}
+	again := run("seq 1 5")
+	if again.Delta == nil || !again.Delta.Unchanged || again.Delta.BaseID != first.Metadata.HistoryID {
+		t.Errorf("second seq 1 5: delta %+v, want unchanged since %s", again.Delta, first.Metadata.HistoryID)
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/diff"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// Diff modes
+const (
+	diffAuto = "auto"
+	diffLine = "line"
+	diffWord = "word"
+	diffJSON = "json"
+)
+
+// NewDiffCmd creates the diff command for comparing two recorded runs
+func NewDiffCmd() *cobra.Command {
+	var mode string
+	var context int
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <id-a> <id-b>",
+		Short: "Compare the outputs, exit codes, durations and tokens of two recorded runs",
+		Long: `Compare two runs recorded in history, by ID or unique ID prefix.
+
+The envelope's output is the diff of the two outputs, and its diff section
+reports whether they are identical, how many lines, words or JSON values
+were added and removed, and how the exit code, duration and tokens changed.
+Its tokens field counts the diff, so checking whether a fix changed a test's
+output costs far less than reading both outputs.
+
+Modes:
+  line   Unified diff of the lines
+  word   Changed lines with removed words in [-...-] and added words in {+...+}
+  json   One line per changed value, by path: ~ .status: "ready" (was "pending")
+  auto   json when both outputs are JSON documents, line otherwise (default)
+
+Examples:
+  ctx history list --fingerprint "go test ./..."
+  ctx diff 3f2a9c1d 8b7e0a44
+  ctx diff --mode word 3f2a9c1d 8b7e0a44`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch mode {
+			case diffAuto, diffLine, diffWord, diffJSON:
+			default:
+				return fmt.Errorf("unknown mode %q (want auto, line, word or json)", mode)
+			}
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+
+			start := time.Now()
+			from, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+			to, err := store.Get(args[1])
+			if err != nil {
+				return err
+			}
+			output, err := diffRuns(from, to, mode, context)
+			if err != nil {
+				return err
+			}
+			output.Metadata.Duration = int(time.Since(start).Milliseconds())
+
+			executor := NewCommandExecutor(appCtx)
+			executor.enricher.EnrichContext(cmd.Context(), output)
+			executor.enricher.Recount(output)
+			return executor.outputResult(output)
+		},
+	}
+
+	diffCmd.Flags().StringVar(&mode, "mode", diffAuto, "Diff mode: auto, line, word or json")
+	diffCmd.Flags().IntVar(&context, "context", 3, "Unchanged lines shown around each change (line mode)")
+
+	return diffCmd
+}
+
+// diffRuns builds the envelope comparing two recorded runs
+func diffRuns(from, to *models.Output, mode string, context int) (*models.Output, error) {
+	section := &models.DiffSection{
+		From:     from.Metadata.HistoryID,
+		To:       to.Metadata.HistoryID,
+		ExitCode: intChange(from.Metadata.ExitCode, to.Metadata.ExitCode),
+		Duration: intChange(from.Metadata.Duration, to.Metadata.Duration),
+		Tokens:   intChange(from.Tokens, to.Tokens),
+	}
+	if from.Input != to.Input {
+		section.Commands = []string{from.Input, to.Input}
+	}
+
+	fromJSON, fromIsJSON := diff.ParseJSON(from.Output)
+	toJSON, toIsJSON := diff.ParseJSON(to.Output)
+	if mode == diffAuto {
+		mode = diffLine
+		if fromIsJSON && toIsJSON {
+			mode = diffJSON
+		}
+	}
+	section.Mode = mode
+
+	var text string
+	switch mode {
+	case diffJSON:
+		if !fromIsJSON || !toIsJSON {
+			return nil, fmt.Errorf("json mode needs both outputs to be a JSON object or array")
+		}
+		changes := diff.JSON(fromJSON, toJSON)
+		for _, c := range changes {
+			switch c.Op {
+			case "added":
+				section.Added++
+			case "removed":
+				section.Removed++
+			default:
+				section.Changed++
+			}
+		}
+		text = diff.FormatJSON(changes)
+	case diffWord:
+		edits := diff.Words(from.Output, to.Output)
+		section.Added, section.Removed = diff.WordStats(edits)
+		text = diff.WordDiff(edits)
+	default:
+		edits := diff.Lines(diff.SplitLines(from.Output), diff.SplitLines(to.Output))
+		section.Added, section.Removed = diff.Stats(edits)
+		text = diff.Unified(edits, section.From, section.To, context)
+	}
+	section.Identical = from.Output == to.Output
+
+	output := models.NewOutput(fmt.Sprintf("diff %s %s", section.From, section.To), []byte(text), 0, 0)
+	output.Diff = section
+	return output, nil
+}
+
+func intChange(from, to int) models.IntChange {
+	return models.IntChange{From: from, To: to, Delta: to - from}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/app"
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/stats"
+	"github.com/spf13/cobra"
+)
+
+// NewEstimateCmd creates the estimate command, which predicts the size of a
+// command from history without running it
+func NewEstimateCmd() *cobra.Command {
+	var outputFormat string
+	var runs int
+
+	estimateCmd := &cobra.Command{
+		Use:   "estimate <command>",
+		Short: "Predict a command's tokens, bytes and duration from history without running it",
+		Long: `Predict the tokens, bytes and duration of a command from earlier runs of
+the same operation (the same fingerprint, so id=1 and id=2 count alike),
+without running it. Runs in the current directory are preferred; when there
+are none, runs in any directory are used at a lower confidence.
+
+The estimate gives the median, 90th percentile and maximum of the newest
+runs, a confidence (high, medium, low) from their number and spread, and
+"unknown" when no earlier run can be used. Runs stopped by a limit are
+counted but left out of the sizes.
+
+'ctx --estimate-only <command>' prints the same estimate in place of
+running the command.
+
+Examples:
+  ctx estimate "psql -c 'SELECT * FROM events'"
+  ctx estimate -f text kubectl logs api-7d9f8c6b5-x2k4p
+  ctx --estimate-only git log`,
+		Args: cobra.MinimumNArgs(1),
+		// Reading history must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFormat != "json" && outputFormat != "text" {
+				return fmt.Errorf("unknown format %q (want json or text)", outputFormat)
+			}
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+			estimate, err := estimateCommand(store, strings.Join(args, " "), runs)
+			if err != nil {
+				return err
+			}
+			return printEstimate(cmd.OutOrStdout(), estimate, outputFormat)
+		},
+	}
+
+	estimateCmd.Flags().IntVar(&runs, "runs", stats.DefaultEstimateRuns, "Newest runs to base the estimate on")
+	estimateCmd.Flags().StringVarP(&outputFormat, "format", "f", "json", "Output format: json or text")
+
+	return estimateCmd
+}
+
+// estimateOnly prints the estimate for a command, as JSON, in place of
+// running it (--estimate-only)
+func estimateOnly(w io.Writer, appCtx *app.AppContext, args []string) error {
+	if appCtx.Config.NoHistory {
+		return fmt.Errorf("--estimate-only reads history, which is disabled (CTX_NO_HISTORY or --no-history)")
+	}
+	store, err := appCtx.History.Open()
+	if err != nil {
+		return err
+	}
+	estimate, err := estimateCommand(store, strings.Join(args, " "), stats.DefaultEstimateRuns)
+	if err != nil {
+		return err
+	}
+	return printEstimate(w, estimate, "json")
+}
+
+// estimateCommand predicts a command from the recorded runs of its
+// fingerprint, preferring those in the working directory
+func estimateCommand(store *history.Store, command string, runs int) (*stats.Estimate, error) {
+	opts := stats.EstimateOptions{MaxRuns: runs}
+	if cwd, err := os.Getwd(); err == nil {
+		opts.Directory = cwd
+	}
+
+	entries, err := store.List(history.Filter{Fingerprint: parseFingerprint(command)})
+	if err != nil {
+		return nil, err
+	}
+	// List is newest first; Predict reads runs in the order they ran
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	records, err := store.Records(entries)
+	if err != nil {
+		return nil, err
+	}
+	return stats.Predict(command, records, opts), nil
+}
+
+// printEstimate prints an estimate as indented JSON or as text
+func printEstimate(w io.Writer, estimate *stats.Estimate, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(estimate)
+	}
+
+	fmt.Fprintf(w, "Command:     %s\n", estimate.Command)
+	fmt.Fprintf(w, "Template:    %s (%s)\n", estimate.Template, estimate.Fingerprint)
+	fmt.Fprintf(w, "Confidence:  %s\n", estimate.Confidence)
+	if estimate.Runs == 0 {
+		fmt.Fprintln(w, "\nNo earlier run of this command")
+		return nil
+	}
+	where := "in this directory"
+	if estimate.Scope == stats.ScopeAll {
+		where = "in other directories"
+	}
+	fmt.Fprintf(w, "Based on:    %s %s, last at %s\n", plural(estimate.Runs, "run"), where, estimate.LastRun)
+	fmt.Fprintf(w, "Failed:      %s\n", formatRate(estimate.FailureRate))
+	if estimate.LimitedRuns > 0 {
+		fmt.Fprintf(w, "Limited:     %s stopped by a limit, left out of the sizes\n", plural(estimate.LimitedRuns, "run"))
+	}
+	if estimate.Samples == 0 {
+		fmt.Fprintln(w, "\nEvery run was stopped by a limit; its full size is unknown")
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%-10s %10s %10s %10s\n", "", "MEDIAN", "P90", "MAX")
+	printRange(w, "Tokens", estimate.Tokens, func(v int64) string { return strconv.FormatInt(v, 10) })
+	printRange(w, "Bytes", estimate.Bytes, func(v int64) string { return formatBytes(int(v)) })
+	printRange(w, "Duration", estimate.DurationMs, formatMillis)
+	return nil
+}
+
+func printRange(w io.Writer, name string, r *stats.Range, format func(int64) string) {
+	fmt.Fprintf(w, "%-10s %10s %10s %10s\n", name, format(r.Median), format(r.P90), format(r.Max))
+}
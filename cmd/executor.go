@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/slavakurilyak/ctx/internal/enricher"
 	"github.com/slavakurilyak/ctx/internal/executor"
 	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
 )
 
 const (
@@ -62,19 +64,33 @@ func (e *OutputLimitExceededError) Error() string {
 	return fmt.Sprintf("output limit of %d bytes exceeded", e.Limit)
 }
 
+// SessionBudgetExceededError is returned when the session has spent its token budget
+type SessionBudgetExceededError struct {
+	Budget int64
+	Used   int64
+}
+
+func (e *SessionBudgetExceededError) Error() string {
+	return fmt.Sprintf("session token budget of %d exhausted (used: %d)", e.Budget, e.Used)
+}
+
 // CommandExecutor handles command execution with injected dependencies
 type CommandExecutor struct {
 	enricher *enricher.Enricher
 	appCtx   *app.AppContext
+	cacheKey string // Key the run's result is cached under, if it may be reused
 }
 
 // NewCommandExecutor creates a new command executor with the given app context
 func NewCommandExecutor(appCtx *app.AppContext) *CommandExecutor {
-	// Get tokenizer
-	tok, _ := appCtx.GetTokenizer() // Ignore error, will work without tokenizer
+	// Get tokenizer; the error is reported in the envelope rather than failing the run
+	tok, tokErr := appCtx.GetTokenizer()
 
 	// Create enricher with dependencies
-	enr := enricher.NewEnricher(tok, appCtx.History, appCtx.Telemetry, appCtx.Config)
+	enr := enricher.NewEnricher(tok, appCtx.History, appCtx.Telemetry, appCtx.Config,
+		enricher.WithTokenizers(appCtx.Tokenizers...),
+		enricher.WithTokenizerError(tokErr),
+		enricher.WithSession(appCtx.Sessions, appCtx.SessionID))
 
 	return &CommandExecutor{
 		enricher: enr,
@@ -84,6 +100,19 @@ func NewCommandExecutor(appCtx *app.AppContext) *CommandExecutor {
 
 // ExecuteCommand executes a command with the given arguments
 func (ce *CommandExecutor) ExecuteCommand(ctx context.Context, args []string) error {
+	command := strings.Join(args, " ")
+	if err := ce.checkSessionBudget(ctx, command); err != nil {
+		return err
+	}
+
+	// Reuse the result of an identical read-only run when caching is on
+	if key, ttl := ce.cacheKeyOf(command); key != "" {
+		if output := ce.cachedResult(key, ttl); output != nil {
+			return ce.recordAndOutput(output)
+		}
+		ce.cacheKey = key
+	}
+
 	isPipeline, stages := ce.parseArguments(args)
 
 	// Check pipeline stage limit if configured
@@ -98,6 +127,46 @@ func (ce *CommandExecutor) ExecuteCommand(ctx context.Context, args []string) er
 	return ce.executeSingleCommand(ctx, args)
 }
 
+// checkSessionBudget refuses a command once the current session has spent
+// its token budget, printing an envelope that says so. A session that
+// cannot be read does not stop the command.
+func (ce *CommandExecutor) checkSessionBudget(ctx context.Context, command string) error {
+	output := ce.sessionBudgetRefusal(ctx, command)
+	if output == nil {
+		return nil
+	}
+	_ = ce.outputResult(output)
+	return &ExitError{Code: ExitCodeWrappedCmdError}
+}
+
+// sessionBudgetRefusal returns the envelope refusing a command once the
+// current session has spent its token budget, or nil while it may run
+func (ce *CommandExecutor) sessionBudgetRefusal(ctx context.Context, command string) *models.Output {
+	if ce.appCtx.Sessions == nil || ce.appCtx.SessionID == "" {
+		return nil
+	}
+	state, err := ce.appCtx.Sessions.Get(ce.appCtx.SessionID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not check the session budget: %v\n", err)
+		return nil
+	}
+	if budget := ce.appCtx.Config.Session.Budget; budget > 0 {
+		state.Budget = budget
+	}
+	if !state.Exhausted() {
+		return nil
+	}
+
+	budgetErr := &SessionBudgetExceededError{Budget: state.Budget, Used: state.Tokens}
+	output := models.NewOutput(command, nil, 1, 0)
+	ce.enricher.EnrichContext(ctx, output)
+	output.Metadata.Error = budgetErr.Error()
+	output.Metadata.Success = false
+	output.Metadata.FailureReason = "session_budget_exceeded"
+	output.Session = state.Section()
+	return output
+}
+
 // parseArguments parses command arguments to detect pipeline mode
 func (ce *CommandExecutor) parseArguments(args []string) (bool, [][]string) {
 	var stages [][]string
@@ -123,8 +192,31 @@ func (ce *CommandExecutor) parseArguments(args []string) (bool, [][]string) {
 
 // executeSingleCommand executes a single command
 func (ce *CommandExecutor) executeSingleCommand(ctx context.Context, args []string) error {
-	command := strings.Join(args, " ")
+	output, started, err := ce.runEnvelope(ctx, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if !started {
+		ce.outputResult(output) // Still output the JSON
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+
+	if err := ce.recordAndOutput(output); err != nil {
+		return err
+	}
+
+	// The command failed or a limit was exceeded
+	if !output.Metadata.Success {
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+
+	return nil
+}
 
+// runEnvelope runs a command and returns its enriched envelope, with any
+// limit failure set, without recording or printing it. started is false
+// when the command could not be started; the envelope then holds the error.
+func (ce *CommandExecutor) runEnvelope(ctx context.Context, command string) (output *models.Output, started bool, err error) {
 	// Apply timeout from configuration if set
 	if ce.appCtx.Config.DefaultTimeout > 0 {
 		var cancel context.CancelFunc
@@ -138,22 +230,25 @@ func (ce *CommandExecutor) executeSingleCommand(ctx context.Context, args []stri
 
 	if needsStreaming {
 		// Use streaming execution for limit enforcement
-		return ce.executeSingleCommandWithStreaming(ctx, args)
+		output, err = ce.runEnvelopeWithStreaming(ctx, command)
+		return output, true, err
 	}
 
 	result, err := executor.ExecuteCommand(ctx, command)
 	if err != nil {
-		output := models.NewOutput(command, []byte(err.Error()), 1, 0)
-		ce.outputResult(output) // Still output the JSON
-		return &ExitError{Code: ExitCodeWrappedCmdError}
+		return models.NewOutput(command, []byte(err.Error()), 1, 0), false, nil
 	}
 
-	output, err := ce.enricher.EnrichOutput(ctx, result)
+	output, err = ce.enricher.EnrichOutput(ctx, result)
 	if err != nil {
-		return fmt.Errorf("failed to enrich output: %w", err)
+		return nil, true, fmt.Errorf("failed to enrich output: %w", err)
 	}
+	ce.checkTokenLimit(output)
+	return output, true, nil
+}
 
-	// Post-execution token check
+// checkTokenLimit fails an envelope whose tokens exceed --max-tokens
+func (ce *CommandExecutor) checkTokenLimit(output *models.Output) {
 	if ce.appCtx.Config.MaxTokens > 0 && int64(output.Tokens) > ce.appCtx.Config.MaxTokens {
 		limitErr := &TokenLimitExceededError{
 			Limit:  ce.appCtx.Config.MaxTokens,
@@ -162,38 +257,22 @@ func (ce *CommandExecutor) executeSingleCommand(ctx context.Context, args []stri
 		output.Metadata.Error = limitErr.Error()
 		output.Metadata.Success = false
 		output.Metadata.FailureReason = "token_limit_exceeded"
-		_ = ce.outputResult(output)
-		return &ExitError{Code: ExitCodeWrappedCmdError}
 	}
-
-	err = ce.outputResult(output)
-	if err != nil {
-		return err
-	}
-
-	// If the command ran but failed, return the exit code
-	if result.ExitCode != 0 {
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
-
-	return nil
 }
 
-// executeSingleCommandWithStreaming executes a single command with streaming to enforce limits,
-// but outputs in the same format as regular execution (non-streaming JSON)
-func (ce *CommandExecutor) executeSingleCommandWithStreaming(ctx context.Context, args []string) error {
-	command := strings.Join(args, " ")
-
+// runEnvelopeWithStreaming runs a command with streaming to enforce limits,
+// but returns the same envelope as regular execution (non-streaming JSON)
+func (ce *CommandExecutor) runEnvelopeWithStreaming(ctx context.Context, command string) (*models.Output, error) {
 	// Create a buffer to collect all output lines
 	var outputLines []string
-	
+
 	// Create streaming callback function that collects output
 	lineCb := func(line string, streamType string) {
 		outputLines = append(outputLines, line)
 	}
 
 	// Get tokenizer for limit checking
-	tok, _ := ce.appCtx.GetTokenizer()
+	tok := ce.limitTokenizer()
 
 	// Get limits from config
 	var maxBytes, maxLines, maxTokens int64
@@ -262,40 +341,18 @@ func (ce *CommandExecutor) executeSingleCommandWithStreaming(ctx context.Context
 			output = enrichedOutput
 		}
 
-		ce.outputResult(output)
-		return &ExitError{Code: ExitCodeWrappedCmdError}
+		return output, nil
 	}
 
 	// Enrich the final output
 	output, err := ce.enricher.EnrichOutput(ctx, result)
 	if err != nil {
-		return fmt.Errorf("failed to enrich output: %w", err)
+		return nil, fmt.Errorf("failed to enrich output: %w", err)
 	}
 
 	// Post-execution token check
-	if ce.appCtx.Config.MaxTokens > 0 && int64(output.Tokens) > ce.appCtx.Config.MaxTokens {
-		limitErr := &TokenLimitExceededError{
-			Limit:  ce.appCtx.Config.MaxTokens,
-			Actual: output.Tokens,
-		}
-		output.Metadata.Error = limitErr.Error()
-		output.Metadata.Success = false
-		output.Metadata.FailureReason = "token_limit_exceeded"
-		_ = ce.outputResult(output)
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
-
-	err = ce.outputResult(output)
-	if err != nil {
-		return err
-	}
-
-	// If the command ran but failed, return the exit code
-	if result.ExitCode != 0 {
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
-
-	return nil
+	ce.checkTokenLimit(output)
+	return output, nil
 }
 
 // executePipeline executes a pipeline of commands
@@ -322,25 +379,15 @@ func (ce *CommandExecutor) executePipeline(ctx context.Context, stages [][]strin
 	}
 
 	// Post-execution token check for pipeline
-	if ce.appCtx.Config.MaxTokens > 0 && int64(output.Tokens) > ce.appCtx.Config.MaxTokens {
-		limitErr := &TokenLimitExceededError{
-			Limit:  ce.appCtx.Config.MaxTokens,
-			Actual: output.Tokens,
-		}
-		output.Metadata.Error = limitErr.Error()
-		output.Metadata.Success = false
-		output.Metadata.FailureReason = "token_limit_exceeded"
-		_ = ce.outputResult(output)
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
+	ce.checkTokenLimit(output)
 
-	err = ce.outputResult(output)
+	err = ce.recordAndOutput(output)
 	if err != nil {
 		return err
 	}
 
-	// If the command ran but failed, return the exit code
-	if result.ExitCode != 0 {
+	// The pipeline failed or a limit was exceeded
+	if !output.Metadata.Success {
 		return &ExitError{Code: ExitCodeWrappedCmdError}
 	}
 
@@ -356,6 +403,40 @@ func (ce *CommandExecutor) buildPipelineCommand(stages [][]string) string {
 	return strings.Join(commands, " | ")
 }
 
+// recordAndOutput saves the final envelope to history and prints it
+func (ce *CommandExecutor) recordAndOutput(output *models.Output) error {
+	ce.enricher.RecordAs(output, ce.finish(output))
+	return ce.outputResult(output)
+}
+
+// finish prepares a final envelope for printing and returns the envelope to
+// save to history. A successful cacheable run is saved under its cache key,
+// and with --delta only the change since the previous run is printed while
+// the full output is saved. With --probe, an output of too many tokens is
+// replaced with a summary of it.
+func (ce *CommandExecutor) finish(output *models.Output) *models.Output {
+	if ce.cacheKey != "" && output.Metadata.Cache == nil {
+		output.Metadata.Cache = &models.CacheInfo{}
+		if output.Metadata.Success {
+			output.Metadata.Cache.Key = ce.cacheKey
+		}
+	}
+	saved := output
+	if ce.appCtx.Config.Delta {
+		saved = ce.applyDelta(output)
+	}
+	if threshold := ce.appCtx.Config.Probe; threshold > 0 {
+		if tokens, estimated := ce.probeTokens(output); tokens > threshold {
+			if saved == output {
+				full := *output
+				saved = &full
+			}
+			ce.applyProbe(output, threshold, tokens, estimated)
+		}
+	}
+	return saved
+}
+
 // outputResult outputs the result as JSON or pretty format
 func (ce *CommandExecutor) outputResult(output *models.Output) error {
 	// Check if pretty output is requested
@@ -468,12 +549,83 @@ func (ce *CommandExecutor) outputPretty(output *models.Output) error {
 		fmt.Printf("\nError: %s\n", output.Metadata.Error)
 	}
 
+	// File and directory totals (ctx count)
+	if c := output.Count; c != nil {
+		for _, dir := range c.Directories {
+			fmt.Printf("%8d  %s/ (%d files)\n", dir.Tokens, dir.Path, dir.Files)
+		}
+		for _, file := range c.Files {
+			fmt.Printf("%8d  %s\n", file.Tokens, file.Path)
+		}
+		for _, skipped := range c.Skipped {
+			reason := skipped.Reason
+			if skipped.Error != "" {
+				reason += ": " + skipped.Error
+			}
+			fmt.Printf("%8s  %s (%s)\n", "-", skipped.Path, reason)
+		}
+	}
+
+	// What went into a context bundle (ctx pack)
+	if p := output.Pack; p != nil {
+		fmt.Println()
+		for _, file := range p.Included {
+			fmt.Printf("  included  %6d tokens  %s\n", file.Tokens, file.Path)
+		}
+		for _, file := range p.Elided {
+			fmt.Printf("  outline   %6d tokens  %s\n", file.Tokens, file.Path)
+		}
+		for _, skipped := range p.Skipped {
+			fmt.Printf("  skipped   %-13s %s\n", skipped.Reason, skipped.Path)
+		}
+	}
+
+	// Summary of a withheld output (--probe)
+	if p := output.Probe; p != nil {
+		fmt.Printf("\nWithheld: %d tokens, %d lines, %s, %s with %d items\n", output.FullTokens, p.Lines, formatBytes(p.Bytes), p.Structure.Format, p.Structure.Items)
+		if len(p.Structure.Fields) > 0 {
+			fmt.Printf("  fields: %s\n", strings.Join(p.Structure.Fields, ", "))
+		}
+		for _, line := range p.Head {
+			fmt.Printf("  %s\n", line)
+		}
+		if len(p.Tail) > 0 {
+			fmt.Println("  ...")
+			for _, line := range p.Tail {
+				fmt.Printf("  %s\n", line)
+			}
+		}
+		if p.Spill != "" {
+			fmt.Printf("  full output: %s\n", p.Spill)
+		}
+	}
+
+	// Token breakdown, when requested
+	if b := output.TokenBreakdown; b != nil {
+		fmt.Println("\nToken breakdown:")
+		for _, line := range b.TopLines {
+			fmt.Printf("  line %-6d %6d tokens  %s\n", line.Line, line.Tokens, line.Text)
+		}
+		for _, section := range b.Sections {
+			fmt.Printf("  %-6s %-30s %6d tokens (%.0f%%)\n", b.SectionKind, section.Name, section.Tokens, section.Share*100)
+		}
+		for _, suggestion := range b.Suggestions {
+			fmt.Printf("  → %s\n", suggestion)
+		}
+	}
+
 	return nil
 }
 
 // ExecuteStreamCommand executes a command in streaming mode
 func (ce *CommandExecutor) ExecuteStreamCommand(ctx context.Context, args []string) error {
+	if err := ce.checkProbeMode("--stream"); err != nil {
+		return err
+	}
 	command := strings.Join(args, " ")
+	if err := ce.checkSessionBudget(ctx, command); err != nil {
+		return err
+	}
 
 	// Apply timeout from configuration if set
 	if ce.appCtx.Config.DefaultTimeout > 0 {
@@ -482,20 +634,37 @@ func (ce *CommandExecutor) ExecuteStreamCommand(ctx context.Context, args []stri
 		defer cancel()
 	}
 
-	// Create streaming callback function
-	lineCb := func(line string, streamType string) {
-		event := models.StreamEvent{
-			Type: streamType,
-			Line: line,
-		}
+	output, err := ce.stream(ctx, command, func(event models.StreamEvent) {
 		data, err := json.Marshal(event)
 		if err == nil {
 			fmt.Println(string(data))
 		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Return appropriate exit code
+	if !output.Metadata.Success {
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+	return nil
+}
+
+// stream runs a command, passing each line of its output to emit as a
+// stdout or stderr event, and then its envelope, without the output, as a
+// result event. It returns the envelope with the output.
+func (ce *CommandExecutor) stream(ctx context.Context, command string, emit func(models.StreamEvent)) (*models.Output, error) {
+	// Create streaming callback function
+	lineCb := func(line string, streamType string) {
+		emit(models.StreamEvent{
+			Type: streamType,
+			Line: line,
+		})
 	}
 
 	// Get tokenizer for limit checking
-	tok, _ := ce.appCtx.GetTokenizer()
+	tok := ce.limitTokenizer()
 
 	// Get limits from config
 	var maxBytes, maxLines, maxTokens int64
@@ -512,14 +681,15 @@ func (ce *CommandExecutor) ExecuteStreamCommand(ctx context.Context, args []stri
 	result, err := executor.ExecuteCommandStreaming(ctx, command, lineCb, tok, maxBytes, maxLines, maxTokens)
 	if err != nil {
 		// Output error as a stream event
-		errorEvent := models.StreamEvent{
+		emit(models.StreamEvent{
 			Type: "stderr",
 			Line: err.Error(),
-		}
-		data, _ := json.Marshal(errorEvent)
-		fmt.Println(string(data))
+		})
 
 		// Output final result envelope with error
+		if result == nil {
+			result = &executor.ExecutionResult{Command: command, ExitCode: 1} // The command did not start
+		}
 		output := models.NewOutput(command, result.Output, result.ExitCode, result.Duration)
 		output.Metadata.Error = err.Error()
 		output.Metadata.Success = false
@@ -534,60 +704,39 @@ func (ce *CommandExecutor) ExecuteStreamCommand(ctx context.Context, args []stri
 			output.Metadata.FailureReason = "token_limit_exceeded"
 		}
 
-		finalEvent := models.StreamEvent{
+		emit(models.StreamEvent{
 			Type:     "result",
 			Envelope: output,
-		}
-		data, _ = json.Marshal(finalEvent)
-		fmt.Println(string(data))
-
-		return &ExitError{Code: ExitCodeWrappedCmdError}
+		})
+		return output, nil
 	}
 
 	// Enrich the final output (note: data.output will be empty for streaming)
 	output, err := ce.enricher.EnrichOutput(ctx, result)
 	if err != nil {
-		return fmt.Errorf("failed to enrich output: %w", err)
+		return nil, fmt.Errorf("failed to enrich output: %w", err)
 	}
 
 	// Post-execution token check for streaming
-	if ce.appCtx.Config.MaxTokens > 0 && int64(output.Tokens) > ce.appCtx.Config.MaxTokens {
-		limitErr := &TokenLimitExceededError{
-			Limit:  ce.appCtx.Config.MaxTokens,
-			Actual: output.Tokens,
-		}
-		output.Metadata.Error = limitErr.Error()
-		output.Metadata.Success = false
-		output.Metadata.FailureReason = "token_limit_exceeded"
-	}
+	ce.checkTokenLimit(output)
+
+	ce.enricher.Record(output)
 
 	// Clear the output since it was already streamed
-	output.Output = ""
+	streamed := *output
+	streamed.Output = ""
 
 	// Output final result envelope
-	finalEvent := models.StreamEvent{
+	emit(models.StreamEvent{
 		Type:     "result",
-		Envelope: output,
-	}
-	data, err := json.Marshal(finalEvent)
-	if err != nil {
-		return fmt.Errorf("failed to marshal final result: %w", err)
-	}
-	fmt.Println(string(data))
-
-	// Return appropriate exit code
-	if !output.Metadata.Success {
-		// If the command itself failed, prioritize its exit code
-		if result.ExitCode != 0 {
-			return &ExitError{Code: ExitCodeWrappedCmdError}
-		}
-		// Otherwise, it was our token limit check that failed it
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
-
-	if result.ExitCode != 0 {
-		return &ExitError{Code: ExitCodeWrappedCmdError}
-	}
+		Envelope: &streamed,
+	})
+	return output, nil
+}
 
-	return nil
+// limitTokenizer returns the tokenizer used for streaming token limit checks.
+// Depending on the accuracy mode, large outputs are estimated rather than tokenized.
+func (ce *CommandExecutor) limitTokenizer() tokenizer.Tokenizer {
+	tok, _ := ce.appCtx.GetTokenizer()
+	return tokenizer.NewStreamEstimator(tok, ce.appCtx.Config.TokenAccuracy, ce.appCtx.Config.TokenAccuracyThreshold)
 }
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/app"
+	"github.com/slavakurilyak/ctx/internal/config"
+)
+
+// wordTokenizer counts words
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) (int, error) { return len(strings.Fields(text)), nil }
+func (wordTokenizer) GetModelName() string                 { return "words" }
+
+// newTestContext returns an app context counting words, without history,
+// telemetry or sessions
+func newTestContext(cfg *config.Config) *app.AppContext {
+	return &app.AppContext{Config: cfg, Tokenizer: wordTokenizer{}}
+}
+
+func TestFinishProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		noTokens  bool
+		command   string
+		withheld  bool
+		estimated bool
+	}{
+		{"counted over threshold", false, "seq 1 200", true, false},
+		{"counted under threshold", false, "seq 1 20", false, false},
+		// seq 1 2000 prints 8893 bytes, about 2500 tokens
+		{"uncounted over threshold", true, "seq 1 2000", true, true},
+		{"uncounted under threshold", true, "seq 1 20", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := NewCommandExecutor(newTestContext(&config.Config{Probe: 100, NoTokens: tt.noTokens}))
+			output, _, err := ce.runEnvelope(context.Background(), tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			full := output.Output
+			saved := ce.finish(output)
+
+			if saved.Output != full {
+				t.Errorf("saved output has %d bytes, want the full %d", len(saved.Output), len(full))
+			}
+			if !tt.withheld {
+				if output.Probe != nil || output.Output != full || !output.Metadata.Success {
+					t.Errorf("output was withheld: %+v", output.Metadata)
+				}
+				return
+			}
+			if output.Probe == nil || output.Output != "" || output.Metadata.FailureReason != "probe_over_budget" {
+				t.Fatalf("output was not withheld: probe %v, failure %q", output.Probe, output.Metadata.FailureReason)
+			}
+			if output.Probe.Estimated != tt.estimated {
+				t.Errorf("probe.estimated = %v, want %v", output.Probe.Estimated, tt.estimated)
+			}
+			if output.Probe.Bytes != len(full) || output.Probe.Head[0] != "1" {
+				t.Errorf("probe = %+v", output.Probe)
+			}
+			if !tt.estimated && output.FullTokens != strings.Count(full, "\n") {
+				t.Errorf("full_tokens = %d, want %d", output.FullTokens, strings.Count(full, "\n"))
+			}
+		})
+	}
+}
+
+func TestProbeRejectsStreaming(t *testing.T) {
+	ce := NewCommandExecutor(newTestContext(&config.Config{Probe: 100}))
+	err := ce.ExecuteStreamCommand(context.Background(), []string{"echo", "hi"})
+	if err == nil || !strings.Contains(err.Error(), "--stream") {
+		t.Errorf("ExecuteStreamCommand with --probe = %v, want an error", err)
+	}
+}
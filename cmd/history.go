@@ -0,0 +1,571 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/files"
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/spf13/cobra"
+)
+
+// NewHistoryCmd creates the history command with subcommands for browsing
+// recorded runs
+func NewHistoryCmd() *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List, show and search recorded command runs",
+		Long: `Browse the history of command runs recorded by ctx.
+
+Every run is appended to an indexed store and its envelope gets a
+metadata.history_id. History is kept in .ctx/ at the root of the git repository
+(or the working directory outside one), or with location "state" in
+$XDG_STATE_HOME/ctx/projects/, keyed by project. CTX_HISTORY_DIR overrides both.
+Records written by earlier versions, one JSON file per run, are imported on
+first use.
+
+Examples:
+  ctx history list --since 24h --failed
+  ctx history list --type vcs --tokens 1000..
+  ctx history list --fingerprint 'psql -c "SELECT * FROM users WHERE id=1"'
+  ctx history show 3f2a9c1e
+  ctx history search "connection refused" --format json
+  ctx history prune --dry-run
+  ctx history migrate`,
+		// Browsing history must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+
+	historyCmd.AddCommand(newHistoryListCmd())
+	historyCmd.AddCommand(newHistoryShowCmd())
+	historyCmd.AddCommand(newHistorySearchCmd())
+	historyCmd.AddCommand(newHistoryPruneCmd())
+	historyCmd.AddCommand(newHistoryMigrateCmd())
+
+	return historyCmd
+}
+
+// openHistoryStore opens the history store for the current configuration
+func openHistoryStore(cmd *cobra.Command) (*history.Store, error) {
+	cfg := config.NewFromFlagsAndEnv(cmd)
+	if cfg.NoHistory {
+		return nil, fmt.Errorf("history is disabled (CTX_NO_HISTORY or --no-history)")
+	}
+	hm, _ := newHistoryManager(cfg)
+	return hm.Open()
+}
+
+// newHistoryManager builds the history manager for the configuration; opts
+// override it. Invalid settings fall back to their defaults and are reported.
+// Only the retention limits that are configured are enforced automatically.
+func newHistoryManager(cfg *config.Config, opts ...history.Option) (*history.HistoryManager, error) {
+	retention, err := historyRetention(cfg, history.Retention{})
+	location := cfg.History.Location
+	if !history.ValidLocation(location) {
+		locationErr := fmt.Errorf("unknown history location %q (want project or state)", location)
+		if err != nil {
+			err = fmt.Errorf("%v; %v", err, locationErr)
+		} else {
+			err = locationErr
+		}
+		location = history.LocationProject
+	}
+	opts = append([]history.Option{history.WithRetention(retention), history.WithLocation(location), history.WithFsync(cfg.History.Fsync)}, opts...)
+	return history.NewHistoryManager(opts...), err
+}
+
+// historyRetention resolves the retention limits from the configuration,
+// falling back to defaults for unset or invalid values
+func historyRetention(cfg *config.Config, defaults history.Retention) (history.Retention, error) {
+	retention := defaults
+	var errs []string
+	if cfg.History.MaxAge != nil {
+		if d, err := history.ParseAge(*cfg.History.MaxAge); err == nil {
+			retention.MaxAge = d
+		} else {
+			errs = append(errs, "history max_age: "+err.Error())
+		}
+	}
+	if cfg.History.FailureMaxAge != nil {
+		if d, err := history.ParseAge(*cfg.History.FailureMaxAge); err == nil {
+			retention.FailureMaxAge = d
+		} else {
+			errs = append(errs, "history failure_max_age: "+err.Error())
+		}
+	}
+	if cfg.History.MaxRecords != nil {
+		retention.MaxRecords = *cfg.History.MaxRecords
+	}
+	if cfg.History.MaxTotalBytes != nil {
+		retention.MaxTotalBytes = *cfg.History.MaxTotalBytes
+	}
+	if len(errs) > 0 {
+		return retention, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return retention, nil
+}
+
+// historyFilterFlags holds the filter flags shared by list and search
+type historyFilterFlags struct {
+	since, until string
+	exitCode     int
+	failed       bool
+	commandType  string
+	tokens       string
+	dir          string
+	fingerprint  string
+	limit        int
+	format       string
+}
+
+func (f *historyFilterFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.since, "since", "", "Only runs after a time: a duration (30m, 24h, 7d) or a date (2006-01-02, RFC3339)")
+	cmd.Flags().StringVar(&f.until, "until", "", "Only runs before a time, in the same formats as --since")
+	cmd.Flags().IntVar(&f.exitCode, "exit-code", 0, "Only runs with this exit code")
+	cmd.Flags().BoolVar(&f.failed, "failed", false, "Only runs with a non-zero exit code")
+	cmd.Flags().StringVar(&f.commandType, "type", "", "Only runs of a command type (vcs, database, container, build, http, filesystem, system, general)")
+	cmd.Flags().StringVar(&f.tokens, "tokens", "", "Only runs within a token range: MIN..MAX, MIN.. or ..MAX")
+	cmd.Flags().StringVar(&f.dir, "dir", "", "Only runs in this directory or below it")
+	cmd.Flags().StringVar(&f.fingerprint, "fingerprint", "", "Only runs of the same operation: a fingerprint, or a command to take it from")
+	cmd.Flags().IntVarP(&f.limit, "limit", "n", 20, "Maximum number of runs to show (0 for all)")
+	cmd.Flags().StringVarP(&f.format, "format", "f", "table", "Output format: table or json (one envelope per line)")
+}
+
+func (f *historyFilterFlags) filter(cmd *cobra.Command) (history.Filter, error) {
+	if f.format != "table" && f.format != "json" {
+		return history.Filter{}, fmt.Errorf("unknown format %q (want table or json)", f.format)
+	}
+
+	filter := history.Filter{Failed: f.failed, Type: f.commandType, Fingerprint: parseFingerprint(f.fingerprint), Limit: f.limit}
+	now := time.Now()
+	var err error
+	if f.since != "" {
+		if filter.Since, err = parseHistoryTime(f.since, now); err != nil {
+			return filter, err
+		}
+	}
+	if f.until != "" {
+		if filter.Until, err = parseHistoryTime(f.until, now); err != nil {
+			return filter, err
+		}
+	}
+	if cmd.Flags().Changed("exit-code") {
+		filter.ExitCode = &f.exitCode
+	}
+	if f.tokens != "" {
+		if filter.MinTokens, filter.MaxTokens, err = parseTokenRange(f.tokens); err != nil {
+			return filter, err
+		}
+	}
+	if f.dir != "" {
+		if filter.Directory, err = filepath.Abs(f.dir); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// parseFingerprint takes a fingerprint as is, and a command as the
+// fingerprint of the command
+func parseFingerprint(value string) string {
+	if value == "" || fingerprint.IsFingerprint(value) {
+		return value
+	}
+	return fingerprint.Of(value)
+}
+
+// parseHistoryTime parses a time ago (30m, 24h, 7d) or an absolute date
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use a duration (30m, 24h, 7d) or a date (2006-01-02)", value)
+}
+
+// parseTokenRange parses MIN..MAX, MIN.. or ..MAX
+func parseTokenRange(value string) (int, int, error) {
+	lo, hi, ok := strings.Cut(value, "..")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid token range %q: use MIN..MAX, MIN.. or ..MAX", value)
+	}
+	var min, max int
+	var err error
+	if lo != "" {
+		if min, err = strconv.Atoi(lo); err != nil {
+			return 0, 0, fmt.Errorf("invalid token range %q: %w", value, err)
+		}
+	}
+	if hi != "" {
+		if max, err = strconv.Atoi(hi); err != nil {
+			return 0, 0, fmt.Errorf("invalid token range %q: %w", value, err)
+		}
+	}
+	if max > 0 && min > max {
+		return 0, 0, fmt.Errorf("invalid token range %q: minimum is above maximum", value)
+	}
+	return min, max, nil
+}
+
+// newHistoryListCmd creates the history list subcommand
+func newHistoryListCmd() *cobra.Command {
+	var flags historyFilterFlags
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recorded runs, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := flags.filter(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+			entries, err := store.List(filter)
+			if err != nil {
+				return err
+			}
+			return printHistoryEntries(cmd.OutOrStdout(), store, entries, flags.format)
+		},
+	}
+	flags.register(listCmd)
+
+	return listCmd
+}
+
+// newHistorySearchCmd creates the history search subcommand
+func newHistorySearchCmd() *cobra.Command {
+	var flags historyFilterFlags
+
+	searchCmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Find recorded runs whose command or output contains every word of a query",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter, err := flags.filter(cmd)
+			if err != nil {
+				return err
+			}
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+			entries, err := store.Search(strings.Join(args, " "), filter)
+			if err != nil {
+				return err
+			}
+			return printHistoryEntries(cmd.OutOrStdout(), store, entries, flags.format)
+		},
+	}
+	flags.register(searchCmd)
+
+	return searchCmd
+}
+
+// newHistoryShowCmd creates the history show subcommand
+func newHistoryShowCmd() *cobra.Command {
+	var outputFormat string
+
+	showCmd := &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a recorded run by its ID or a unique ID prefix",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+			output, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			switch outputFormat {
+			case "json":
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(output)
+			case "text":
+				fmt.Fprintf(w, "ID:        %s\n", output.Metadata.HistoryID)
+				fmt.Fprintf(w, "Command:   %s\n", output.Input)
+				if output.Metadata.Fingerprint != "" {
+					fmt.Fprintf(w, "Template:  %s (%s)\n", fingerprint.Template(output.Input), output.Metadata.Fingerprint)
+				}
+				fmt.Fprintf(w, "Time:      %s\n", output.Metadata.Timestamp)
+				fmt.Fprintf(w, "Directory: %s\n", output.Metadata.Directory)
+				fmt.Fprintf(w, "Exit code: %d\n", output.Metadata.ExitCode)
+				fmt.Fprintf(w, "Tokens:    %d\n", output.Tokens)
+				fmt.Fprintf(w, "Duration:  %dms\n\n", output.Metadata.Duration)
+				fmt.Fprint(w, output.Output)
+				if output.Output != "" && !strings.HasSuffix(output.Output, "\n") {
+					fmt.Fprintln(w)
+				}
+				return nil
+			default:
+				return fmt.Errorf("unknown format %q (want json or text)", outputFormat)
+			}
+		},
+	}
+
+	showCmd.Flags().StringVarP(&outputFormat, "format", "f", "json", "Output format: json (the envelope) or text")
+
+	return showCmd
+}
+
+// newHistoryPruneCmd creates the history prune subcommand
+func newHistoryPruneCmd() *cobra.Command {
+	var dryRun bool
+	var maxAge, failureMaxAge, outputFormat string
+	var maxRecords int
+	var maxTotalBytes int64
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove runs outside the retention limits",
+		Long: `Remove recorded runs that fall outside the retention limits.
+
+Runs older than max_age are removed, except failed runs, which are kept until
+failure_max_age. Beyond max_records runs or max_total_bytes of log, the oldest
+successful runs go first. The limits come from the history section of the
+config file or CTX_HISTORY_* variables, and flags override them. The limits
+that are configured are also enforced automatically after runs are recorded;
+without any, history is never removed unless you run this command.
+
+Defaults here: max_age 30d, failure_max_age 90d, max_records 5000,
+max_total_bytes 100MB. A limit of 0 disables it.
+
+Examples:
+  ctx history prune --dry-run
+  ctx history prune --max-age 7d --max-records 500`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFormat != "text" && outputFormat != "json" {
+				return fmt.Errorf("unknown format %q (want text or json)", outputFormat)
+			}
+			cfg := config.NewFromFlagsAndEnv(cmd)
+			if cfg.NoHistory {
+				return fmt.Errorf("history is disabled (CTX_NO_HISTORY or --no-history)")
+			}
+			retention, err := historyRetention(cfg, history.DefaultRetention)
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("max-age") {
+				if retention.MaxAge, err = history.ParseAge(maxAge); err != nil {
+					return err
+				}
+			}
+			if cmd.Flags().Changed("failure-max-age") {
+				if retention.FailureMaxAge, err = history.ParseAge(failureMaxAge); err != nil {
+					return err
+				}
+			}
+			if cmd.Flags().Changed("max-records") {
+				retention.MaxRecords = maxRecords
+			}
+			if cmd.Flags().Changed("max-total-bytes") {
+				retention.MaxTotalBytes = maxTotalBytes
+			}
+
+			hm, err := newHistoryManager(cfg, history.WithRetention(retention))
+			if err != nil {
+				return err
+			}
+			result, err := hm.Prune(dryRun)
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			if outputFormat == "json" {
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(result)
+			}
+			if len(result.Removed) > 0 {
+				printHistoryEntries(w, nil, result.Removed, "table")
+				fmt.Fprintln(w)
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			fmt.Fprintf(w, "%s %s, freeing %s; %d kept\n", verb, plural(len(result.Removed), "run"), formatBytes(int(result.FreedBytes)), result.Kept)
+			return nil
+		},
+	}
+
+	pruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be removed without removing it")
+	pruneCmd.Flags().StringVar(&maxAge, "max-age", "", "Remove successful runs older than this (e.g. 30d, 12h; 0 for no limit)")
+	pruneCmd.Flags().StringVar(&failureMaxAge, "failure-max-age", "", "Remove failed runs older than this")
+	pruneCmd.Flags().IntVar(&maxRecords, "max-records", 0, "Keep at most this many runs")
+	pruneCmd.Flags().Int64Var(&maxTotalBytes, "max-total-bytes", 0, "Keep the history log under this many bytes")
+	pruneCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return pruneCmd
+}
+
+// newHistoryMigrateCmd creates the history migrate subcommand
+func newHistoryMigrateCmd() *cobra.Command {
+	var dryRun bool
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Consolidate stray .ctx directories of a project into its history store",
+		Long: `Find the .ctx history directories that earlier versions left in the
+subdirectories of the current project, merge their runs into the project's
+history store in time order, and remove them. Other files in those
+directories are left alone.
+
+With location "state", the .ctx directory at the project root is migrated too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg := config.NewFromFlagsAndEnv(cmd)
+			if cfg.NoHistory {
+				return fmt.Errorf("history is disabled (CTX_NO_HISTORY or --no-history)")
+			}
+			hm, err := newHistoryManager(cfg)
+			if err != nil {
+				return err
+			}
+			cwd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+
+			target := hm.GetHistoryDir()
+			stray, err := history.FindStray(files.FindRoot(cwd), target)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			if len(stray) == 0 {
+				fmt.Fprintf(w, "No stray history directories; history is in %s\n", target)
+				return nil
+			}
+
+			var sources []*history.Store
+			for _, dir := range stray {
+				if dryRun {
+					fmt.Fprintf(w, "Would migrate %s\n", dir)
+					continue
+				}
+				source, err := history.OpenStore(dir)
+				if err != nil {
+					return fmt.Errorf("failed to open %s: %w", dir, err)
+				}
+				sources = append(sources, source)
+			}
+			if dryRun {
+				fmt.Fprintf(w, "Would merge %s into %s\n", plural(len(stray), "directory"), target)
+				return nil
+			}
+
+			store, err := hm.Open()
+			if err != nil {
+				return err
+			}
+			added, err := store.Merge(sources...)
+			if err != nil {
+				return err
+			}
+			for _, source := range sources {
+				if err := source.Remove(); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", source.Dir(), err)
+				}
+				fmt.Fprintf(w, "Migrated %s\n", source.Dir())
+			}
+			fmt.Fprintf(w, "Merged %s from %s into %s\n", plural(added, "run"), plural(len(sources), "directory"), target)
+			return nil
+		},
+	}
+
+	migrateCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the directories that would be migrated without changing anything")
+
+	return migrateCmd
+}
+
+// printHistoryEntries prints entries as a table, or their envelopes as NDJSON
+func printHistoryEntries(w io.Writer, store *history.Store, entries []history.Entry, format string) error {
+	if format == "json" {
+		outputs, err := store.Records(entries)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		for _, output := range outputs {
+			if err := encoder.Encode(output); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No matching runs")
+		return nil
+	}
+	fmt.Fprintf(w, "%-8s  %-16s  %4s  %8s  %-10s  %s\n", "ID", "TIME", "EXIT", "TOKENS", "TYPE", "COMMAND")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%-8s  %-16s  %4d  %8d  %-10s  %s\n",
+			shortHistoryID(entry.ID), formatHistoryTime(entry.Time), entry.ExitCode, entry.Tokens, entry.Type, truncateCommand(entry.Input, 60))
+	}
+	return nil
+}
+
+func shortHistoryID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func formatHistoryTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func truncateCommand(command string, max int) string {
+	command = strings.Join(strings.Fields(command), " ")
+	if len([]rune(command)) <= max {
+		return command
+	}
+	return string([]rune(command)[:max-1]) + "…"
+}
+
+// plural formats a count with a singular or plural noun
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	if strings.HasSuffix(noun, "y") {
+		return fmt.Sprintf("%d %sies", n, strings.TrimSuffix(noun, "y"))
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,430 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/app"
+	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/jobs"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// jobPollInterval is how often ctx wait and ctx kill check a job's state
+const jobPollInterval = 200 * time.Millisecond
+
+// supervisorLogName is the file in a job's directory that receives the
+// supervisor's own output, such as warnings
+const supervisorLogName = "supervisor.log"
+
+// Root flags that only matter to the ctx process that detaches the job
+var detachLocalFlags = map[string]bool{
+	"stream":        true,
+	"estimate-only": true,
+	"pretty":        true,
+	"output":        true,
+}
+
+// detach starts a command as a background job under a ctx supervisor and
+// prints an envelope describing the job
+func (ce *CommandExecutor) detach(cmd *cobra.Command, args []string) error {
+	if err := ce.checkProbeMode("--detach"); err != nil {
+		return err
+	}
+	command := strings.Join(args, " ")
+	if err := ce.checkSessionBudget(cmd.Context(), command); err != nil {
+		return err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	store := jobs.NewStore(jobs.DefaultDir())
+	job, err := store.Create(command, cwd)
+	if err != nil {
+		return err
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the ctx executable: %w", err)
+	}
+	// The supervisor runs with the same ctx flags, e.g. --max-lines
+	var supervisorArgs []string
+	rootFlags := cmd.Root().PersistentFlags()
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if rootFlags.Lookup(f.Name) != nil && !detachLocalFlags[f.Name] {
+			supervisorArgs = append(supervisorArgs, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	supervisorArgs = append(supervisorArgs, "supervise", job.ID)
+
+	logFile, err := os.Create(filepath.Join(store.Dir(job.ID), supervisorLogName))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	supervisor := exec.Command(self, supervisorArgs...)
+	supervisor.Dir = cwd
+	supervisor.Stdout = logFile
+	supervisor.Stderr = logFile
+	if err := executor.StartDetached(supervisor); err != nil {
+		return fmt.Errorf("failed to start job supervisor: %w", err)
+	}
+	pid := supervisor.Process.Pid
+	_ = supervisor.Process.Release()
+	if job, err = store.SetPID(job.ID, pid); err != nil {
+		return err
+	}
+
+	output := models.NewOutput(command, []byte(fmt.Sprintf("started job %s: follow it with 'ctx logs %s', wait for it with 'ctx wait %s', stop it with 'ctx kill %s'\n", job.ID, job.ID, job.ID, job.ID)), 0, 0)
+	output.Job = job.Section()
+	ce.enricher.EnrichContext(cmd.Context(), output)
+	ce.enricher.Recount(output)
+	return ce.outputResult(output)
+}
+
+// NewSuperviseCmd creates the hidden command that runs a background job,
+// started by 'ctx run --detach'
+func NewSuperviseCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:    "supervise <job>",
+		Short:  "Run a background job (started by 'ctx run --detach')",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			store := jobs.NewStore(jobs.DefaultDir())
+			job, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+
+			// ctx kill sends SIGTERM; closing the terminal must not stop the job
+			signal.Ignore(syscall.SIGHUP)
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			// Jobs are for commands that outlast the default timeout, so only
+			// an explicit one applies
+			if cmd.Flags().Changed("timeout") || os.Getenv("CTX_TIMEOUT") != "" {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, appCtx.Config.DefaultTimeout)
+				defer cancel()
+			}
+			return NewCommandExecutor(appCtx).supervise(ctx, store, job)
+		},
+	}
+}
+
+// supervise runs a job's command, logging its events and recording its
+// envelope in the job store
+func (ce *CommandExecutor) supervise(ctx context.Context, store *jobs.Store, job *jobs.Job) error {
+	log, err := store.OpenLog(job.ID)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	job.PID = os.Getpid()
+	if err := store.Update(job); err != nil {
+		return err
+	}
+
+	output, err := ce.stream(ctx, job.Command, log.Append)
+	if err != nil {
+		output = models.NewOutput(job.Command, nil, 1, 0)
+		output.Metadata.Success = false
+		output.Metadata.Error = err.Error()
+	}
+	killed := errors.Is(ctx.Err(), context.Canceled)
+	if killed && output.Metadata.FailureReason == "" {
+		output.Metadata.FailureReason = "killed"
+	}
+	return store.Finish(job, output, killed)
+}
+
+// NewJobsCmd creates the jobs command for listing background jobs
+func NewJobsCmd() *cobra.Command {
+	var outputFormat string
+
+	jobsCmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "List background jobs started with 'ctx run --detach'",
+		Long: `List background jobs, newest first, with their state: running, done,
+failed, killed, or lost when the supervisor exited without a result.
+
+A job runs a command under a ctx supervisor process, so the tool call that
+started it returns at once. The supervisor writes the command's output
+events and its final envelope to $XDG_STATE_HOME/ctx/jobs/<id>/.
+
+Examples:
+  ctx run --detach npm run build
+  ctx jobs
+  ctx logs 3f2a9c1d --since-seq 120
+  ctx wait 3f2a9c1d --timeout 2m
+  ctx kill 3f2a9c1d`,
+		Args: cobra.NoArgs,
+		// Listing jobs must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFormat != "text" && outputFormat != "json" {
+				return fmt.Errorf("unknown format %q (want text or json)", outputFormat)
+			}
+			list, err := jobs.NewStore(jobs.DefaultDir()).List()
+			if err != nil {
+				return err
+			}
+			return printJobs(cmd.OutOrStdout(), list, outputFormat)
+		},
+	}
+
+	jobsCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return jobsCmd
+}
+
+func printJobs(w io.Writer, list []*jobs.Job, format string) error {
+	if format == "json" {
+		if list == nil {
+			list = []*jobs.Job{}
+		}
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+		return nil
+	}
+
+	if len(list) == 0 {
+		fmt.Fprintln(w, "No jobs. Start one with 'ctx run --detach <command>'.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tEXIT\tSTARTED\tDURATION\tCOMMAND")
+	for _, job := range list {
+		exit := "-"
+		if job.ExitCode != nil {
+			exit = fmt.Sprint(*job.ExitCode)
+		}
+		end := time.Now()
+		if job.Ended != nil {
+			end = *job.Ended
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", job.ID, job.State, exit,
+			job.Started.Local().Format("2006-01-02 15:04:05"), end.Sub(job.Started).Round(time.Second), job.Command)
+	}
+	return tw.Flush()
+}
+
+// NewLogsCmd creates the logs command for reading a background job's events
+func NewLogsCmd() *cobra.Command {
+	var sinceSeq int
+
+	logsCmd := &cobra.Command{
+		Use:   "logs <job>",
+		Short: "Print a background job's output events as NDJSON",
+		Long: `Print the events a background job has logged so far, one JSON object per
+line, as with --stream: stdout and stderr lines, then the result event once
+the command has finished. Each event has a seq number; pass the last one
+seen as --since-seq to read only newer events.
+
+Examples:
+  ctx logs 3f2a9c1d
+  ctx logs 3f2a9c1d --since-seq 120`,
+		Args: cobra.ExactArgs(1),
+		// Reading logs must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := jobs.NewStore(jobs.DefaultDir())
+			job, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+			events, err := store.Events(job.ID, sinceSeq)
+			if err != nil {
+				return err
+			}
+			w := cmd.OutOrStdout()
+			for _, event := range events {
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(w, string(data))
+			}
+			return nil
+		},
+	}
+
+	logsCmd.Flags().IntVar(&sinceSeq, "since-seq", 0, "Print only events after this sequence number")
+
+	return logsCmd
+}
+
+// NewWaitCmd creates the wait command for waiting on a background job
+func NewWaitCmd() *cobra.Command {
+	var timeout time.Duration
+
+	waitCmd := &cobra.Command{
+		Use:   "wait <job>",
+		Short: "Wait for a background job to finish and print its envelope",
+		Long: `Wait for a background job to finish and print its envelope, with the
+command's full output and a job section. ctx exits with 1 when the command
+failed, as when running it directly.
+
+When the job is still running after --timeout, the envelope has no output,
+failure_reason "wait_timeout" and the job's last_seq, so the output so far
+can be read with 'ctx logs --since-seq'; waiting again resumes the wait.
+
+Examples:
+  ctx wait 3f2a9c1d
+  ctx wait 3f2a9c1d --timeout 5m`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			store := jobs.NewStore(jobs.DefaultDir())
+			job, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+
+			var deadline <-chan time.Time
+			if timeout > 0 {
+				deadline = time.After(timeout)
+			}
+			for !job.Finished() {
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-deadline:
+					output := models.NewOutput(job.Command, nil, 0, 0)
+					output.Metadata.Success = false
+					output.Metadata.Error = fmt.Sprintf("job %s is still running after %s", job.ID, timeout)
+					output.Metadata.FailureReason = "wait_timeout"
+					return exitUnlessSucceeded(printJobEnvelope(cmd.Context(), appCtx, job, output), output)
+				case <-time.After(jobPollInterval):
+				}
+				if job, err = store.Get(job.ID); err != nil {
+					return err
+				}
+			}
+			output := jobResult(store, job)
+			return exitUnlessSucceeded(printJobEnvelope(cmd.Context(), appCtx, job, output), output)
+		},
+	}
+
+	waitCmd.Flags().DurationVar(&timeout, "timeout", time.Minute, "How long to wait before reporting the job as still running (0 to wait indefinitely)")
+
+	return waitCmd
+}
+
+// NewKillCmd creates the kill command for stopping a background job
+func NewKillCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "kill <job>",
+		Short: "Stop a background job and its processes",
+		Long: `Stop a background job. Its supervisor terminates the command's whole
+process group, as when a timeout expires, and records the envelope with the
+output so far and state "killed". The envelope is printed.
+
+Examples:
+  ctx kill 3f2a9c1d`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			store := jobs.NewStore(jobs.DefaultDir())
+			job, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+			if job.Finished() {
+				return fmt.Errorf("job %s has already finished (%s)", job.ID, job.State)
+			}
+
+			if err := executor.TerminateProcess(job.PID); err != nil && executor.ProcessAlive(job.PID) {
+				return fmt.Errorf("failed to stop job %s: %w", job.ID, err)
+			}
+			// The supervisor records the result once the process group is gone
+			deadline := time.Now().Add(10 * time.Second)
+			for !job.Finished() && time.Now().Before(deadline) {
+				time.Sleep(jobPollInterval)
+				if job, err = store.Get(job.ID); err != nil {
+					return err
+				}
+			}
+			if !job.Finished() || job.State == jobs.StateLost {
+				// The supervisor could not record it, e.g. when killed outright on Windows
+				now := time.Now().UTC()
+				job.State, job.Ended = jobs.StateKilled, &now
+				if err := store.Update(job); err != nil {
+					return err
+				}
+			}
+
+			return printJobEnvelope(cmd.Context(), appCtx, job, jobResult(store, job))
+		},
+	}
+}
+
+// jobResult returns a finished job's envelope, or one describing the job
+// when its supervisor recorded none
+func jobResult(store *jobs.Store, job *jobs.Job) *models.Output {
+	if output, err := store.Result(job.ID); err == nil {
+		return output
+	}
+	output := models.NewOutput(job.Command, nil, 1, 0)
+	output.Metadata.Success = false
+	output.Metadata.FailureReason = "job_" + job.State
+	output.Metadata.Error = fmt.Sprintf("job %s was %s without a result; see 'ctx logs %s'", job.ID, job.State, job.ID)
+	output.Metadata.Directory = job.Directory
+	return output
+}
+
+// printJobEnvelope prints a job's envelope with its job section
+func printJobEnvelope(ctx context.Context, appCtx *app.AppContext, job *jobs.Job, output *models.Output) error {
+	executor := NewCommandExecutor(appCtx)
+	if output.Metadata.Timestamp == "" {
+		executor.enricher.EnrichContext(ctx, output)
+	}
+	output.Job = job.Section()
+	executor.enricher.Recount(output)
+	return executor.outputResult(output)
+}
+
+// exitUnlessSucceeded exits with 1 when the printed envelope reports a failure
+func exitUnlessSucceeded(err error, output *models.Output) error {
+	if err == nil && !output.Metadata.Success {
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/files"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/pack"
+	"github.com/spf13/cobra"
+)
+
+// NewPackCmd creates the pack subcommand for building context bundles
+func NewPackCmd() *cobra.Command {
+	var opts pack.Options
+	var noIgnore bool
+
+	packCmd := &cobra.Command{
+		Use:   "pack [paths...]",
+		Short: "Pack files into a single context bundle that fits a token budget",
+		Long: `Pack repository files into a single markdown or XML-tagged bundle that fits
+a token budget.
+
+Files are selected like ctx count (directories, globs, .gitignore) and packed
+in priority order: files matching --prefer globs first, then by --priority
+(recent: last commit or modification time; size: smallest first; path).
+Files that do not fit in full are reduced to an outline of their signatures,
+and skipped when even the outline does not fit.
+
+The bundle is the envelope's output; tokens is its exact token count, and the
+pack section lists what was included, elided or skipped.
+
+Examples:
+  ctx pack --budget 50000 .
+  ctx pack --budget 20000 --prefer 'internal/tokenizer/**' --prefer '*.md' .
+  ctx pack --budget 8000 --format xml --priority size src/ | jq -r .output`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				args = []string{"."}
+			}
+			if opts.Budget < 0 {
+				return fmt.Errorf("--budget must not be negative")
+			}
+			if opts.Format, err = pack.ParseFormat(opts.Format); err != nil {
+				return err
+			}
+			if opts.Priority, err = pack.ParsePriority(opts.Priority); err != nil {
+				return err
+			}
+
+			start := time.Now()
+			selected, err := files.Collect(args, files.Options{NoIgnore: noIgnore})
+			if err != nil {
+				return err
+			}
+			tok, tokErr := appCtx.GetTokenizer()
+			if tok == nil && tokErr != nil {
+				return fmt.Errorf("cannot pack without a tokenizer: %w", tokErr)
+			}
+			result, err := pack.Pack(selected, tok, opts)
+			if err != nil {
+				return err
+			}
+
+			// The bundle gets the same envelope as command output
+			ce := NewCommandExecutor(appCtx)
+			output, err := ce.enricher.EnrichOutput(cmd.Context(), &executor.ExecutionResult{
+				Command:  "pack " + strings.Join(args, " "),
+				Output:   []byte(result.Bundle),
+				Duration: time.Since(start),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to enrich output: %w", err)
+			}
+			output.Tokens = result.Tokens
+			output.TokenEstimate = nil
+			output.Pack = newPackSection(result, opts)
+
+			return ce.recordAndOutput(output)
+		},
+	}
+
+	packCmd.Flags().IntVar(&opts.Budget, "budget", 0, "Maximum tokens in the bundle (0 for no limit)")
+	packCmd.Flags().StringVar(&opts.Format, "format", pack.FormatMarkdown, "Bundle format: markdown or xml")
+	packCmd.Flags().StringVar(&opts.Priority, "priority", pack.PriorityRecent, "Which files to pack first: recent, size or path")
+	packCmd.Flags().StringArrayVar(&opts.Prefer, "prefer", nil, "Glob of files to pack first (repeatable, in order)")
+	packCmd.Flags().BoolVar(&noIgnore, "no-ignore", false, "Include files ignored by .gitignore")
+
+	return packCmd
+}
+
+// newPackSection reports what went into the bundle
+func newPackSection(result *pack.Result, opts pack.Options) *models.PackSection {
+	section := &models.PackSection{
+		Budget:   opts.Budget,
+		Format:   opts.Format,
+		Priority: opts.Priority,
+		Included: []models.PackedFile{},
+	}
+	for _, entry := range result.Entries {
+		file := models.PackedFile{Path: entry.Path, Tokens: entry.Tokens}
+		if entry.Mode == pack.ModeOutline {
+			section.Elided = append(section.Elided, file)
+		} else {
+			section.Included = append(section.Included, file)
+		}
+	}
+	for _, skipped := range result.Skipped {
+		section.Skipped = append(section.Skipped, models.SkippedFile{Path: skipped.Path, Reason: skipped.Reason})
+	}
+	return section
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/probe"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// checkProbeMode refuses --probe where the output is printed as it
+// arrives, before its tokens are known and so before it could be withheld
+func (ce *CommandExecutor) checkProbeMode(mode string) error {
+	if ce.appCtx.Config.Probe > 0 {
+		return fmt.Errorf("--probe cannot be used with %s, whose output is printed before its tokens are known; drop --probe or unset CTX_PROBE", mode)
+	}
+	return nil
+}
+
+// probeTokens returns the tokens of an output to compare with the --probe
+// threshold. When they were not counted, with --no-tokens or without a
+// tokenizer, they are estimated from the output's size, so an output is
+// never printed only because its tokens are unknown.
+func (ce *CommandExecutor) probeTokens(output *models.Output) (tokens int64, estimated bool) {
+	if output.Tokens > 0 || output.Output == "" {
+		return int64(output.Tokens), false
+	}
+	return int64(tokenizer.EstimateHeuristic(ce.appCtx.Tokenizer, len(output.Output)).Tokens), true
+}
+
+// applyProbe withholds an output of more tokens than the --probe threshold,
+// leaving its size, samples and structure in the probe section so the agent
+// can narrow the command before paying for the output. With --probe-spill
+// the output is written to a file instead of being lost.
+func (ce *CommandExecutor) applyProbe(output *models.Output, threshold, tokens int64, estimated bool) {
+	section := probe.Summarize(output.Output)
+	section.Threshold = threshold
+	section.Estimated = estimated
+	if ce.appCtx.Config.ProbeSpill {
+		path, err := spill(output.Output)
+		if err != nil {
+			output.Metadata.Warnings = append(output.Metadata.Warnings, "probe: "+err.Error())
+		}
+		section.Spill = path
+	}
+
+	reason := fmt.Sprintf("output withheld: its %d tokens exceed the probe threshold of %d", tokens, threshold)
+	if estimated {
+		reason = fmt.Sprintf("output withheld: its estimated %d tokens exceed the probe threshold of %d", tokens, threshold)
+	}
+	if output.Metadata.Error != "" {
+		reason += "; " + output.Metadata.Error
+	}
+	output.Probe = section
+	output.Output = ""
+	output.Metadata.Success = false
+	output.Metadata.FailureReason = "probe_over_budget"
+	output.Metadata.Error = reason
+
+	// tokens counts what is printed; full_tokens what it stands for
+	if output.FullTokens == 0 {
+		output.FullTokens = output.Tokens
+	}
+	ce.enricher.Recount(output)
+}
+
+// spill writes a withheld output to a new file readable only by the user
+// and returns its path
+func spill(output string) (string, error) {
+	f, err := os.CreateTemp("", "ctx-probe-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(output); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"github.com/slavakurilyak/ctx/internal/app"
 	"github.com/slavakurilyak/ctx/internal/config"
 	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/session"
 	"github.com/slavakurilyak/ctx/internal/telemetry"
 	"github.com/slavakurilyak/ctx/internal/tokenizer"
 	"github.com/slavakurilyak/ctx/internal/updater"
@@ -34,7 +36,7 @@ func NewRootCmdWithDI() *cobra.Command {
 		StyledHeader("RECOMMENDED USAGE:") + "\n\n" +
 		"  " + StyledDescription("Three workflows to prevent token explosions:") + "\n\n" +
 		"  " + StyledSubHeader("PROBE") + "\n" +
-		"    " + StyledCommand("ctx psql -c \"SELECT * FROM users\" | jq '.tokens'") + "  " + StyledDescription("# See: 25000 tokens!") + "\n\n" +
+		"    " + StyledCommand("ctx --probe 2000 psql -c \"SELECT * FROM users\"") + "  " + StyledDescription("# Rows if under 2000 tokens, else only their size and shape") + "\n\n" +
 		"  " + StyledSubHeader("PROBE-ACT") + "\n" +
 		"    " + StyledCommand("ctx --max-lines 1 docker logs app | jq '.tokens'") + "  " + StyledDescription("# Check: 45 tokens") + "\n" +
 		"    " + StyledCommand("ctx docker logs app --tail 100") + "  " + StyledDescription("# Act: safe to run") + "\n\n" +
@@ -59,10 +61,14 @@ func NewRootCmdWithDI() *cobra.Command {
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			// 1. Build config, respecting precedence (Flags > Env > Default)
 			cfg := config.NewFromFlagsAndEnv(cmd)
+			accuracy, err := tokenizer.ParseAccuracy(cfg.TokenAccuracy)
+			if err != nil {
+				return err
+			}
+			cfg.TokenAccuracy = accuracy
 
 			// 2. Initialize Telemetry
 			var tel *telemetry.Manager
-			var err error
 			if !cfg.NoTelemetry {
 				tel, err = telemetry.Initialize(cmd.Context())
 				if err != nil {
@@ -71,36 +77,73 @@ func NewRootCmdWithDI() *cobra.Command {
 				}
 			}
 
-			// 3. Initialize Tokenizer (if not disabled)
+			// 3. Initialize Tokenizers (if not disabled), one per configured model.
+			// Vocabulary files are loaded through the local asset store.
+			tokenizer.SetAssetStore(tokenizer.NewAssetStore(cfg.CacheDir, cfg.TokenizerOffline))
+			for alias, path := range cfg.HFTokenizers {
+				tokenizer.RegisterHFAlias(alias, path)
+			}
+
 			var tok tokenizer.Tokenizer
-			if !cfg.NoTokens && cfg.TokenModel != "" {
-				factory := &tokenizer.DefaultTokenizerFactory{}
-				tokenizerCache := tokenizer.NewTokenizerCache(factory)
-				tok, err = tokenizerCache.GetOrCreate(cfg.TokenModel)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: could not initialize tokenizer for provider %q: %v\n", cfg.TokenModel, err)
+			var toks []tokenizer.Tokenizer
+			var tokErr error
+			factory := &tokenizer.DefaultTokenizerFactory{}
+			tokenizerCache := tokenizer.NewTokenizerCache(factory)
+			// Estimates read history only, so they need no tokenizer
+			estimating, _ := cmd.Flags().GetBool("estimate-only")
+			if !cfg.NoTokens && !estimating {
+				for i, model := range cfg.TokenModels {
+					// A model that fails keeps its place as nil, so the
+					// primary stays first and is reported as unavailable
+					t, err := tokenizerCache.GetOrCreate(model)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: could not initialize tokenizer for provider %q: %v\n", model, err)
+						if i == 0 {
+							tokErr = err
+						}
+						t = nil
+					}
+					if i == 0 {
+						tok = t
+					}
+					toks = append(toks, t)
 				}
 			}
 
 			// 4. Initialize History Manager
 			var hm *history.HistoryManager
 			if !cfg.NoHistory {
-				hm = history.NewHistoryManager()
+				var err error
+				hm, err = newHistoryManager(cfg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v; using defaults\n", err)
+				}
 			}
 
-			// 5. Build the final AppContext
+			// 5. Find the current agent session, if any
+			sessions := session.NewManager(session.DefaultDir())
+			sessionID, _, err := sessions.Current()
+			if err != nil && !errors.Is(err, session.ErrNoSession) {
+				fmt.Fprintf(os.Stderr, "Warning: could not read the current session: %v\n", err)
+			}
+
+			// 6. Build the final AppContext
 			appCtx := app.NewAppContext(
 				app.WithConfig(cfg),
 				app.WithHistory(hm),
+				app.WithSession(sessions, sessionID),
 				app.WithTelemetry(tel),
 				app.WithTokenizer(tok),
+				app.WithTokenizers(toks),
+				app.WithTokenizerError(tokErr),
+				app.WithTokenizerCache(tokenizerCache),
 			)
 
-			// 6. Inject AppContext into the command's context for RunE to use.
+			// 7. Inject AppContext into the command's context for RunE to use.
 			newCmdCtx := context.WithValue(cmd.Context(), app.AppContextKey, appCtx)
 			cmd.SetContext(newCmdCtx)
 
-			// 7. Check for updates if enabled (non-blocking)
+			// 8. Check for updates if enabled (non-blocking)
 			go checkForUpdatesIfNeeded(cfg)
 
 			return nil
@@ -128,6 +171,11 @@ func NewRootCmdWithDI() *cobra.Command {
 				}
 			}
 
+			// Predict the command from history instead of running it
+			if estimate, _ := cmd.Flags().GetBool("estimate-only"); estimate {
+				return estimateOnly(cmd.OutOrStdout(), appCtx, args)
+			}
+
 			executor := NewCommandExecutor(appCtx)
 
 			// Check if streaming is enabled
@@ -143,9 +191,15 @@ func NewRootCmdWithDI() *cobra.Command {
 	}
 
 	// Add persistent flags that will be available to all subcommands (if any)
-	rootCmd.PersistentFlags().String("token-model", "", "Token provider (anthropic, openai, gemini). Overrides CTX_TOKEN_MODEL.")
+	rootCmd.PersistentFlags().String("token-model", "", "Token provider (anthropic, openai, gemini, hf:<tokenizer.json or alias>), or a comma-separated list to count with several models. Overrides CTX_TOKEN_MODEL.")
+	rootCmd.PersistentFlags().String("token-accuracy", "", "Token counting accuracy: exact, sampled or heuristic (default: exact, sampled above CTX_TOKEN_ACCURACY_THRESHOLD bytes). Overrides CTX_TOKEN_ACCURACY.")
+	rootCmd.PersistentFlags().Bool("delta", false, "Print only what changed since the previous run of the command in this directory, as a unified diff.")
+	rootCmd.PersistentFlags().Int64("probe", 0, "Print the output only if it has at most this many tokens, and otherwise its size, first and last lines and structure, with failure_reason probe_over_budget (0 to always print). Overrides CTX_PROBE.")
+	rootCmd.PersistentFlags().Bool("probe-spill", false, "With --probe, write a withheld output to a file named in probe.spill.")
+	rootCmd.PersistentFlags().Bool("explain-tokens", false, "Add a token_breakdown section explaining where the tokens go, with suggestions.")
 	rootCmd.PersistentFlags().Bool("no-tokens", false, "Disable token counting. Overrides CTX_NO_TOKENS.")
 	rootCmd.PersistentFlags().Int64("max-tokens", 0, "Maximum tokens allowed in output (0 for no limit). Overrides CTX_MAX_TOKENS.")
+	rootCmd.PersistentFlags().Int64("session-budget", 0, "Tokens the current session may use; commands are refused once they are spent (0 for no budget). Overrides CTX_SESSION_BUDGET.")
 	rootCmd.PersistentFlags().Int64("max-output-bytes", 0, "Maximum bytes allowed in output (0 for no limit). Overrides CTX_MAX_OUTPUT_BYTES.")
 	rootCmd.PersistentFlags().Int64("max-lines", 0, "Maximum lines allowed in output (0 for no limit). Overrides CTX_MAX_LINES.")
 	rootCmd.PersistentFlags().Int("max-pipeline-stages", 0, "Maximum pipeline stages allowed (0 for no limit). Overrides CTX_MAX_PIPELINE_STAGES.")
@@ -155,6 +209,8 @@ func NewRootCmdWithDI() *cobra.Command {
 	rootCmd.PersistentFlags().Duration("timeout", 0, "Command execution timeout (e.g., '5s', '1m'). Overrides CTX_TIMEOUT.")
 	rootCmd.PersistentFlags().String("output", "json", "Output format ('json').")
 	rootCmd.PersistentFlags().Bool("stream", false, "Stream command output line by line for long-running tasks.")
+	rootCmd.PersistentFlags().Duration("cache-ttl", 0, "Reuse the result of an identical read-only command run within this time instead of running it again (e.g., '30s'). Overrides CTX_CACHE_TTL.")
+	rootCmd.PersistentFlags().Bool("estimate-only", false, "Print the command's predicted tokens, bytes and duration from history instead of running it (see 'ctx estimate').")
 	rootCmd.PersistentFlags().Bool("pretty", false, "Output in pretty format instead of JSON.")
 
 	// Version flag
@@ -173,6 +229,19 @@ func NewRootCmdWithDI() *cobra.Command {
 	// Add run subcommand for explicit command execution
 	rootCmd.AddCommand(NewRunCmd())
 
+	// Add concurrent runs of many commands sharing one budget
+	rootCmd.AddCommand(NewBatchCmd())
+
+	// Add workflows of steps conditioned on earlier envelopes
+	rootCmd.AddCommand(NewSeqCmd())
+
+	// Add background job management for 'ctx run --detach'
+	rootCmd.AddCommand(NewJobsCmd())
+	rootCmd.AddCommand(NewLogsCmd())
+	rootCmd.AddCommand(NewWaitCmd())
+	rootCmd.AddCommand(NewKillCmd())
+	rootCmd.AddCommand(NewSuperviseCmd())
+
 	// Add setup subcommand for setting up coding agents
 	rootCmd.AddCommand(NewSetupCmd())
 
@@ -184,6 +253,36 @@ func NewRootCmdWithDI() *cobra.Command {
 	// Add update command
 	rootCmd.AddCommand(NewUpdateCmd())
 
+	// Add tokenizer asset management commands
+	rootCmd.AddCommand(NewTokenizerCmd())
+
+	// Add token counting for files and directories
+	rootCmd.AddCommand(NewCountCmd())
+
+	// Add context packing under a token budget
+	rootCmd.AddCommand(NewPackCmd())
+
+	// Add token-bounded chunking
+	rootCmd.AddCommand(NewChunkCmd())
+
+	// Add history browsing
+	rootCmd.AddCommand(NewHistoryCmd())
+
+	// Add usage analytics over history
+	rootCmd.AddCommand(NewStatsCmd())
+
+	// Add size predictions from history
+	rootCmd.AddCommand(NewEstimateCmd())
+
+	// Add agent sessions with token budgets
+	rootCmd.AddCommand(NewSessionCmd())
+
+	// Add comparison of recorded runs
+	rootCmd.AddCommand(NewDiffCmd())
+
+	// Add periodic re-runs that report only changes
+	rootCmd.AddCommand(NewWatchCmd())
+
 	return rootCmd
 }
 
@@ -195,6 +294,15 @@ func parseQuotedCommand(cmdStr string) []string {
 	return strings.Fields(cmdStr)
 }
 
+// appContextFromCmd returns the AppContext prepared by the root command's PersistentPreRunE
+func appContextFromCmd(cmd *cobra.Command) (*app.AppContext, error) {
+	appCtx, ok := cmd.Context().Value(app.AppContextKey).(*app.AppContext)
+	if !ok || appCtx == nil {
+		return nil, fmt.Errorf("application context not initialized")
+	}
+	return appCtx, nil
+}
+
 // checkForUpdatesIfNeeded checks for updates in the background if conditions are met
 func checkForUpdatesIfNeeded(cfg *config.Config) {
 	// Only check if installation method supports auto-updates
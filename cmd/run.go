@@ -10,15 +10,25 @@ import (
 
 // NewRunCmd creates the run subcommand for explicit command execution
 func NewRunCmd() *cobra.Command {
-	return &cobra.Command{
+	var detach bool
+
+	runCmd := &cobra.Command{
 		Use:   "run [command] [args...]",
 		Short: "Execute a command with ctx wrapping",
 		Long: `Execute a command with ctx wrapping. All arguments after 'run' are passed to the command.
 
-This is an alternative to using the -- separator.
+This is an alternative to using the -- separator. Flags are read as ctx's
+wherever they appear, so put -- before a command with flags of its own.
+
+With --detach, the command runs in the background under a ctx supervisor
+and ctx returns at once with the job's ID. Follow it with 'ctx logs', wait
+for its envelope with 'ctx wait' and stop it with 'ctx kill'; 'ctx jobs'
+lists jobs. Detached jobs have no timeout unless --timeout or CTX_TIMEOUT
+sets one.
 
 Examples:
-  ctx run ls -la
+  ctx run -- ls -la
+  ctx run --detach npm run build
   ctx --max-tokens 5000 run psql -c "SELECT * FROM users"
   ctx --no-tokens run docker ps`,
 		Args: cobra.ArbitraryArgs, // Accept any number of args
@@ -31,7 +41,7 @@ Examples:
 
 			// Check if we have a command to run
 			if len(args) == 0 {
-				return fmt.Errorf("no command specified after 'run'\n\nUsage: ctx run <command> [args...]\nExample: ctx run ls -la")
+				return fmt.Errorf("no command specified after 'run'\n\nUsage: ctx run <command> [args...]\nExample: ctx run -- ls -la")
 			}
 
 			// Use the parent's context which has AppContext
@@ -45,7 +55,15 @@ Examples:
 				}
 			}
 
+			// Predict the command from history instead of running it
+			if estimate, _ := parentCmd.Flags().GetBool("estimate-only"); estimate {
+				return estimateOnly(cmd.OutOrStdout(), appCtx, args)
+			}
+
 			executor := NewCommandExecutor(appCtx)
+			if detach {
+				return executor.detach(cmd, args)
+			}
 
 			// Check if streaming is enabled on parent
 			isStream, _ := parentCmd.Flags().GetBool("stream")
@@ -56,4 +74,8 @@ Examples:
 			return executor.ExecuteCommand(context.Background(), args)
 		},
 	}
+
+	runCmd.Flags().BoolVar(&detach, "detach", false, "Run the command in the background and print its job ID (see 'ctx jobs')")
+
+	return runCmd
 }
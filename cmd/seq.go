@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/condition"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/workflow"
+	"github.com/spf13/cobra"
+)
+
+// Why a workflow stopped
+const (
+	seqCompleted       = "completed"
+	seqFailure         = "failure"
+	seqBudgetExhausted = "budget_exhausted"
+	seqInterrupted     = "interrupted"
+)
+
+// NewSeqCmd creates the seq command for running workflows of conditional steps
+func NewSeqCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "seq <workflow.yaml>",
+		Short: "Run a workflow of steps that depend on earlier steps' envelopes",
+		Long: `Run the steps of a YAML workflow one after another, and print one envelope
+combining them. This encodes the PROBE-FILTER-ACT pattern once, instead of
+in every agent prompt:
+
+  name: error-events
+  budget: 20000            # Tokens all steps may use together
+  limits:                  # For every step, unless it sets its own
+    max_tokens: 5000
+    timeout: 30s
+  steps:
+    - name: probe
+      run: psql -tA -c "SELECT COUNT(*) FROM events WHERE status='error'"
+      quiet: true          # Leave its output out of the combined envelope
+    - name: errors
+      if: probe.output < 1000
+      run: psql -c "SELECT * FROM events WHERE status='error'"
+      else: psql -c "SELECT * FROM events WHERE status='error' LIMIT 100"
+
+A step's if is a condition, as with ctx watch --until, on the envelopes of
+earlier steps: probe.tokens, probe.exit, probe.output and the other fields
+prefixed with the step's name, plus probe.ran and probe.branch. When it
+does not hold, the step's else command runs instead, or the step is
+skipped. Steps may set max_tokens, max_lines, max_output_bytes and timeout,
+and continue_on_error to go on after a failure, which otherwise stops the
+workflow. With a budget, each step's max_tokens is capped at the tokens
+left.
+
+The envelope's output holds each step's output under a "## <step>" line,
+its tokens count that output, and its seq section lists how every step ran.
+Each step is recorded in history. ctx exits with 1 when a step stopped the
+workflow.
+
+Examples:
+  ctx seq workflows/error-events.yaml
+  ctx --pretty seq probe.yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wf, err := workflow.Load(args[0])
+			if err != nil {
+				return err
+			}
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+			command := "seq " + args[0]
+			executor := NewCommandExecutor(appCtx)
+			if err := executor.checkSessionBudget(cmd.Context(), command); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			output, err := executor.runWorkflow(ctx, command, wf)
+			if err != nil {
+				return err
+			}
+			if err := executor.outputResult(output); err != nil {
+				return err
+			}
+			if !output.Metadata.Success {
+				return &ExitError{Code: ExitCodeWrappedCmdError}
+			}
+			return nil
+		},
+	}
+}
+
+// runWorkflow runs a workflow's steps and returns the combined envelope
+func (ce *CommandExecutor) runWorkflow(ctx context.Context, command string, wf *workflow.Workflow) (*models.Output, error) {
+	start := time.Now()
+	section := &models.SeqSection{Name: wf.Name, Budget: wf.Budget}
+	vars := condition.Vars{}
+	var combined strings.Builder
+	var stopped models.SeqStep // The step that stopped the workflow
+
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if section.Reason != "" {
+			section.Steps = append(section.Steps, models.SeqStep{Name: step.Name, Branch: workflow.BranchNotRun, Condition: step.If})
+			continue
+		}
+
+		run, branch := step.Run, workflow.BranchRun
+		if c := step.Condition(); c != nil {
+			holds, err := c.Eval(vars)
+			if err != nil {
+				return nil, fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			if !holds {
+				run, branch = step.Else, workflow.BranchElse
+				if run == "" {
+					branch = workflow.BranchSkipped
+				}
+			}
+		}
+		result := models.SeqStep{Name: step.Name, Branch: branch, Condition: step.If}
+		if branch == workflow.BranchSkipped {
+			vars.Merge(workflow.StepVars(step.Name, &models.Output{}, branch))
+			section.Steps = append(section.Steps, result)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			section.Reason = seqInterrupted
+			result.Branch = workflow.BranchNotRun
+			section.Steps = append(section.Steps, result)
+			continue
+		}
+		limits := wf.Limits.Merge(step.Limits)
+		if wf.Budget > 0 {
+			left := wf.Budget - int64(section.Tokens)
+			if left <= 0 {
+				section.Reason = seqBudgetExhausted
+				result.Branch = workflow.BranchNotRun
+				section.Steps = append(section.Steps, result)
+				continue
+			}
+			if limits.MaxTokens == nil || *limits.MaxTokens <= 0 || *limits.MaxTokens > left {
+				limits.MaxTokens = &left
+			}
+		}
+
+		output, err := ce.runStep(ctx, run, limits)
+		if err != nil {
+			return nil, fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		result.Input = output.Input
+		result.ExitCode = output.Metadata.ExitCode
+		result.Success = output.Metadata.Success
+		result.Tokens = output.Tokens
+		result.Duration = output.Metadata.Duration
+		result.FailureReason = output.Metadata.FailureReason
+		result.HistoryID = output.Metadata.HistoryID
+		section.Steps = append(section.Steps, result)
+		vars.Merge(workflow.StepVars(step.Name, output, branch))
+
+		// Output over the step's token limit stays in history, so limits
+		// and the budget bound the combined envelope
+		overLimit := output.Metadata.FailureReason == "token_limit_exceeded"
+		if !overLimit {
+			section.Tokens += output.Tokens
+		}
+		switch {
+		case step.Quiet:
+		case overLimit:
+			fmt.Fprintf(&combined, "## %s\n(output withheld: %d tokens exceed the step's limit)\n", step.Name, output.Tokens)
+		default:
+			fmt.Fprintf(&combined, "## %s\n%s", step.Name, output.Output)
+			if output.Output != "" && !strings.HasSuffix(output.Output, "\n") {
+				combined.WriteString("\n")
+			}
+		}
+		if !output.Metadata.Success && !step.ContinueOnError {
+			section.Reason = seqFailure
+			stopped = result
+		}
+	}
+
+	if section.Reason == "" {
+		section.Reason = seqCompleted
+	}
+	output := models.NewOutput(command, []byte(combined.String()), 0, time.Since(start))
+	output.Seq = section
+	switch section.Reason {
+	case seqFailure:
+		output.Metadata.ExitCode = max(stopped.ExitCode, 1)
+		output.Metadata.Success = false
+		output.Metadata.FailureReason = "step_failed"
+		output.Metadata.Error = fmt.Sprintf("step %q failed", stopped.Name)
+		if stopped.FailureReason != "" {
+			output.Metadata.Error += " (" + stopped.FailureReason + ")"
+		}
+	case seqBudgetExhausted, seqInterrupted:
+		output.Metadata.ExitCode = 1
+		output.Metadata.Success = false
+		output.Metadata.FailureReason = section.Reason
+		output.Metadata.Error = "the workflow stopped before all its steps ran"
+	}
+	ce.enricher.EnrichContext(ctx, output)
+	ce.enricher.Recount(output)
+	return output, nil
+}
+
+// runStep runs a step's command with its limits and records it in history
+func (ce *CommandExecutor) runStep(ctx context.Context, command string, limits workflow.Limits) (*models.Output, error) {
+	cfg := *ce.appCtx.Config
+	if limits.MaxTokens != nil {
+		cfg.MaxTokens = *limits.MaxTokens
+		cfg.Limits.MaxTokens = limits.MaxTokens
+	}
+	if limits.MaxLines != nil {
+		cfg.Limits.MaxLines = limits.MaxLines
+	}
+	if limits.MaxOutputBytes != nil {
+		cfg.Limits.MaxOutputBytes = limits.MaxOutputBytes
+	}
+	if timeout, _ := limits.TimeoutDuration(); timeout > 0 {
+		cfg.DefaultTimeout = timeout
+	}
+	appCtx := *ce.appCtx
+	appCtx.Config = &cfg
+	step := NewCommandExecutor(&appCtx)
+
+	output, started, err := step.runEnvelope(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	if started {
+		step.enricher.Record(output)
+	} else {
+		step.enricher.EnrichContext(ctx, output)
+	}
+	return output, nil
+}
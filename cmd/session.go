@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// NewSessionCmd creates the session command with subcommands for starting,
+// ending and inspecting agent sessions
+func NewSessionCmd() *cobra.Command {
+	sessionCmd := &cobra.Command{
+		Use:   "session",
+		Short: "Start, end and inspect agent sessions with cumulative token budgets",
+		Long: `Group runs into an agent session whose commands, tokens and cost add up.
+
+The session ID is taken from CTX_SESSION_ID or from the session started
+with 'ctx session start'; an agent can export CTX_SESSION_ID with its own
+conversation ID. Runs outside a session are not counted.
+
+Each run's envelope reports the session's totals in its session section.
+With a budget (--session-budget, CTX_SESSION_BUDGET, or --budget when
+starting), ctx refuses new commands once the session has used that many
+tokens, and the envelope reports session.remaining_tokens.
+
+Session state is kept in $XDG_STATE_HOME/ctx/sessions/.
+
+Examples:
+  ctx session start --budget 200000
+  ctx --session-budget 50000 -- git log
+  ctx session status
+  ctx session end`,
+		// Sessions must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+
+	sessionCmd.AddCommand(newSessionStartCmd())
+	sessionCmd.AddCommand(newSessionEndCmd())
+	sessionCmd.AddCommand(newSessionStatusCmd())
+
+	return sessionCmd
+}
+
+// newSessionStartCmd creates the session start subcommand
+func newSessionStartCmd() *cobra.Command {
+	var id, outputFormat string
+	var budget int64
+
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start a session, or resume it when it exists",
+		Long: `Start a session. Its ID is --id, the ID set in the environment, or a new
+one. A session that is not set in the environment becomes the current one
+for every ctx run until 'ctx session end'.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSessionFormat(outputFormat); err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed("budget") {
+				budget = config.NewFromFlagsAndEnv(cmd).Session.Budget
+			}
+			state, err := session.NewManager(session.DefaultDir()).Start(id, budget)
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			if outputFormat == "json" {
+				return printSessionJSON(w, state)
+			}
+			verb := "Started"
+			if state.Commands > 0 {
+				verb = "Resumed"
+			}
+			fmt.Fprintf(w, "%s session %s\n", verb, state.ID)
+			if state.Budget > 0 {
+				fmt.Fprintf(w, "Budget: %d tokens, %d remaining\n", state.Budget, state.Remaining())
+			}
+			if state.Source == session.SourceStarted {
+				fmt.Fprintf(w, "\nRuns count toward it until 'ctx session end'. To run several sessions at once, set the ID per shell:\n  export %s=%s\n", session.EnvSessionID, state.ID)
+			}
+			return nil
+		},
+	}
+
+	startCmd.Flags().StringVar(&id, "id", "", "Session ID (default: the ID set in the environment, or a new one)")
+	startCmd.Flags().Int64Var(&budget, "budget", 0, "Tokens the session may use before commands are refused (0 for no budget)")
+	startCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return startCmd
+}
+
+// newSessionEndCmd creates the session end subcommand
+func newSessionEndCmd() *cobra.Command {
+	var outputFormat string
+
+	endCmd := &cobra.Command{
+		Use:   "end [id]",
+		Short: "End the current session, or the given one, and show its totals",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSessionFormat(outputFormat); err != nil {
+				return err
+			}
+			sessions := session.NewManager(session.DefaultDir())
+			id, err := sessionArg(sessions, args)
+			if err != nil {
+				return err
+			}
+			state, err := sessions.End(id)
+			if err != nil {
+				return err
+			}
+
+			w := cmd.OutOrStdout()
+			if outputFormat == "json" {
+				return printSessionJSON(w, state)
+			}
+			fmt.Fprintf(w, "Ended session %s\n\n", state.ID)
+			printSessionState(w, state)
+			return nil
+		},
+	}
+
+	endCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return endCmd
+}
+
+// newSessionStatusCmd creates the session status subcommand
+func newSessionStatusCmd() *cobra.Command {
+	var outputFormat string
+
+	statusCmd := &cobra.Command{
+		Use:   "status [id]",
+		Short: "Show the commands, tokens, cost and budget of the current session, or the given one",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkSessionFormat(outputFormat); err != nil {
+				return err
+			}
+			sessions := session.NewManager(session.DefaultDir())
+			id, err := sessionArg(sessions, args)
+			if err != nil {
+				return err
+			}
+			state, err := sessions.Get(id)
+			if err != nil {
+				return err
+			}
+			// A budget given for this run applies as it would to a command
+			if budget := config.NewFromFlagsAndEnv(cmd).Session.Budget; budget > 0 {
+				state.Budget = budget
+			}
+
+			w := cmd.OutOrStdout()
+			if outputFormat == "json" {
+				return printSessionJSON(w, state)
+			}
+			printSessionState(w, state)
+			return nil
+		},
+	}
+
+	statusCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return statusCmd
+}
+
+// sessionArg returns the session named on the command line, or the current one
+func sessionArg(sessions *session.Manager, args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	id, _, err := sessions.Current()
+	return id, err
+}
+
+func checkSessionFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format %q (want text or json)", format)
+	}
+	return nil
+}
+
+// printSessionJSON prints a session's state with its remaining budget
+func printSessionJSON(w io.Writer, state *session.State) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		*session.State
+		RemainingTokens *int64 `json:"remaining_tokens,omitempty"`
+	}{state, state.Section().RemainingTokens})
+}
+
+func printSessionState(w io.Writer, state *session.State) {
+	fmt.Fprintf(w, "Session:   %s (%s)\n", state.ID, state.Source)
+	fmt.Fprintf(w, "Started:   %s\n", state.Started.Local().Format(time.RFC3339))
+	if state.Ended != nil {
+		fmt.Fprintf(w, "Ended:     %s\n", state.Ended.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Commands:  %d\n", state.Commands)
+	fmt.Fprintf(w, "Tokens:    %d\n", state.Tokens)
+	fmt.Fprintf(w, "Cost:      $%.4f\n", state.Cost)
+	switch {
+	case state.Budget <= 0:
+		fmt.Fprintln(w, "Budget:    none")
+	case state.Exhausted():
+		fmt.Fprintf(w, "Budget:    %d tokens, exhausted; new commands are refused\n", state.Budget)
+	default:
+		fmt.Fprintf(w, "Budget:    %d tokens, %d remaining\n", state.Budget, state.Remaining())
+	}
+}
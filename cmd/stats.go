@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/stats"
+	"github.com/spf13/cobra"
+)
+
+// NewStatsCmd creates the stats command, which aggregates recorded runs
+func NewStatsCmd() *cobra.Command {
+	var since, until, dir, commandType, fp, outputFormat string
+	var top int
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize token usage, failures and savings from history",
+		Long: `Aggregate the runs recorded in history into a usage report:
+
+  • total runs, tokens, bytes and duration
+  • the most expensive commands, grouping runs with the same fingerprint
+    (the command with its literals replaced, e.g. id=1 and id=2 by id=?)
+  • failure rates, and how often each limit stopped a run
+  • tokens saved by limits and filters (head, grep, LIMIT, --tail, ...),
+    estimated against unlimited, unfiltered runs of the same command
+  • totals by command type, directory and session
+
+Examples:
+  ctx stats --since 7d
+  ctx stats --type database --top 5
+  ctx stats --since 2025-01-01 --format markdown > usage.md`,
+		Args: cobra.NoArgs,
+		// Reading history must not trigger tokenizer initialization
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if outputFormat != "table" && outputFormat != "json" && outputFormat != "markdown" {
+				return fmt.Errorf("unknown format %q (want table, json or markdown)", outputFormat)
+			}
+			filter := history.Filter{Type: commandType, Fingerprint: parseFingerprint(fp)}
+			now := time.Now()
+			var err error
+			if since != "" {
+				if filter.Since, err = parseHistoryTime(since, now); err != nil {
+					return err
+				}
+			}
+			if until != "" {
+				if filter.Until, err = parseHistoryTime(until, now); err != nil {
+					return err
+				}
+			}
+			if dir != "" {
+				if filter.Directory, err = filepath.Abs(dir); err != nil {
+					return err
+				}
+			}
+
+			store, err := openHistoryStore(cmd)
+			if err != nil {
+				return err
+			}
+			entries, err := store.List(filter)
+			if err != nil {
+				return err
+			}
+			// List is newest first; reports read runs in the order they ran
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+			records, err := store.Records(entries)
+			if err != nil {
+				return err
+			}
+
+			report := stats.Compute(records, stats.Options{Top: top})
+			if !filter.Since.IsZero() {
+				report.Since = filter.Since.Format(time.RFC3339)
+			}
+			if !filter.Until.IsZero() {
+				report.Until = filter.Until.Format(time.RFC3339)
+			}
+
+			w := cmd.OutOrStdout()
+			if outputFormat == "json" {
+				encoder := json.NewEncoder(w)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(report)
+			}
+			printStatsReport(w, report, outputFormat == "markdown")
+			return nil
+		},
+	}
+
+	statsCmd.Flags().StringVar(&since, "since", "", "Only runs after a time: a duration (30m, 24h, 7d) or a date (2006-01-02, RFC3339)")
+	statsCmd.Flags().StringVar(&until, "until", "", "Only runs before a time, in the same formats as --since")
+	statsCmd.Flags().StringVar(&dir, "dir", "", "Only runs in this directory or below it")
+	statsCmd.Flags().StringVar(&commandType, "type", "", "Only runs of a command type (vcs, database, container, build, http, filesystem, system, general)")
+	statsCmd.Flags().StringVar(&fp, "fingerprint", "", "Only runs of the same operation: a fingerprint, or a command to take it from")
+	statsCmd.Flags().IntVar(&top, "top", 10, "Commands, directories and sessions to list (0 for all)")
+	statsCmd.Flags().StringVarP(&outputFormat, "format", "f", "table", "Output format: table, json or markdown")
+
+	return statsCmd
+}
+
+// printStatsReport prints a report as aligned text tables, or as markdown
+func printStatsReport(w io.Writer, report *stats.Report, markdown bool) {
+	if report.Totals.Runs == 0 {
+		fmt.Fprintln(w, "No matching runs")
+		return
+	}
+
+	t := report.Totals
+	section(w, "Totals", markdown)
+	statsTable(w, markdown, []string{"RUNS", "FAILED", "TOKENS", "BYTES", "DURATION", "SAVED"}, [][]string{{
+		strconv.Itoa(t.Runs), formatRate(t.FailureRate), strconv.FormatInt(t.Tokens, 10),
+		formatBytes(int(t.Bytes)), formatMillis(t.DurationMs), strconv.FormatInt(report.Savings.Tokens, 10),
+	}})
+
+	section(w, "Most expensive commands", markdown)
+	var rows [][]string
+	for _, c := range report.TopCommands {
+		rows = append(rows, []string{
+			strconv.FormatInt(c.Tokens, 10), strconv.Itoa(c.Runs), strconv.Itoa(c.AvgTokens),
+			formatRate(c.FailureRate), truncateCommand(c.Template, 60),
+		})
+	}
+	statsTable(w, markdown, []string{"TOKENS", "RUNS", "AVG", "FAILED", "COMMAND"}, rows)
+
+	groups := []struct {
+		title, column string
+		groups        []stats.Group
+	}{
+		{"By command type", "TYPE", report.ByType},
+		{"By directory", "DIRECTORY", report.ByDirectory},
+		{"By session", "SESSION", report.BySession},
+	}
+	for _, g := range groups {
+		section(w, g.title, markdown)
+		rows = nil
+		for _, group := range g.groups {
+			key := group.Key
+			if key == "" {
+				key = "-"
+			}
+			rows = append(rows, []string{
+				strconv.FormatInt(group.Tokens, 10), strconv.Itoa(group.Runs),
+				formatRate(group.FailureRate), formatMillis(group.DurationMs), key,
+			})
+		}
+		statsTable(w, markdown, []string{"TOKENS", "RUNS", "FAILED", "DURATION", g.column}, rows)
+	}
+
+	section(w, "Limits", markdown)
+	if len(report.Limits) == 0 {
+		fmt.Fprintln(w, "No run hit a limit")
+	} else {
+		rows = nil
+		for _, l := range report.Limits {
+			rows = append(rows, []string{l.Limit, strconv.Itoa(l.Runs), formatRate(l.Rate)})
+		}
+		statsTable(w, markdown, []string{"LIMIT", "RUNS", "OF ALL RUNS"}, rows)
+	}
+
+	s := report.Savings
+	section(w, "Estimated savings", markdown)
+	statsTable(w, markdown, []string{"BY", "RUNS", "TOKENS SAVED"}, [][]string{
+		{"limits", strconv.Itoa(s.LimitedRuns), strconv.FormatInt(s.LimitTokens, 10)},
+		{"filters", strconv.Itoa(s.FilteredRuns), strconv.FormatInt(s.FilterTokens, 10)},
+	})
+	if s.UnestimatedRuns > 0 {
+		fmt.Fprintf(w, "\n%s without an unfiltered run of the same command to compare with\n", plural(s.UnestimatedRuns, "run"))
+	}
+}
+
+func section(w io.Writer, title string, markdown bool) {
+	if markdown {
+		fmt.Fprintf(w, "\n## %s\n\n", title)
+		return
+	}
+	fmt.Fprintf(w, "\n%s\n", strings.ToUpper(title))
+}
+
+// statsTable prints rows under headers, left-aligning the last column and
+// right-aligning the others, or as a markdown table
+func statsTable(w io.Writer, markdown bool, headers []string, rows [][]string) {
+	if markdown {
+		fmt.Fprintf(w, "| %s |\n", strings.Join(headers, " | "))
+		separators := make([]string, len(headers))
+		for i := range separators {
+			separators[i] = "---:"
+		}
+		separators[len(separators)-1] = "---"
+		fmt.Fprintf(w, "|%s|\n", strings.Join(separators, "|"))
+		for _, row := range rows {
+			for i, cell := range row {
+				row[i] = strings.ReplaceAll(cell, "|", "\\|")
+			}
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+		}
+		return
+	}
+
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = len(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := len([]rune(cell)); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	printRow := func(cells []string) {
+		last := len(cells) - 1
+		for i, cell := range cells {
+			if i == last {
+				fmt.Fprintln(w, cell)
+			} else {
+				fmt.Fprintf(w, "%*s  ", widths[i], cell)
+			}
+		}
+	}
+	printRow(headers)
+	for _, row := range rows {
+		printRow(row)
+	}
+}
+
+func formatRate(rate float64) string {
+	return fmt.Sprintf("%.0f%%", rate*100)
+}
+
+func formatMillis(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d >= time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
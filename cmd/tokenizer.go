@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+	"github.com/spf13/cobra"
+)
+
+// NewTokenizerCmd creates the tokenizer command with subcommands for managing
+// tokenizer vocabulary files (useful on air-gapped machines)
+func NewTokenizerCmd() *cobra.Command {
+	tokenizerCmd := &cobra.Command{
+		Use:   "tokenizer",
+		Short: "Manage tokenizer vocabulary files",
+		Long: `Manage the vocabulary files that tokenizers need to count tokens.
+
+Tokenizers download their vocabulary on first use. On machines without network
+access, fetch the files once elsewhere and import them, then set
+CTX_TOKENIZER_OFFLINE=true so ctx never tries to download.
+
+Examples:
+  ctx tokenizer list
+  ctx tokenizer fetch cl100k_base
+  ctx tokenizer import ./tokenizers/
+  ctx tokenizer import --hf llama3 ./Meta-Llama-3-8B/tokenizer.json
+  ctx tokenizer verify`,
+		// Managing assets must not trigger tokenizer initialization (and downloads)
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+
+	tokenizerCmd.AddCommand(newTokenizerListCmd())
+	tokenizerCmd.AddCommand(newTokenizerFetchCmd())
+	tokenizerCmd.AddCommand(newTokenizerImportCmd())
+	tokenizerCmd.AddCommand(newTokenizerVerifyCmd())
+
+	return tokenizerCmd
+}
+
+// newAssetStoreFromConfig builds the asset store for the current configuration
+func newAssetStoreFromConfig(cmd *cobra.Command) *tokenizer.AssetStore {
+	cfg := config.NewFromFlagsAndEnv(cmd)
+	return tokenizer.NewAssetStore(cfg.CacheDir, cfg.TokenizerOffline)
+}
+
+// newTokenizerListCmd creates the tokenizer list subcommand
+func newTokenizerListCmd() *cobra.Command {
+	var outputFormat string
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List known tokenizer files and whether they are installed",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newAssetStoreFromConfig(cmd)
+			statuses := store.List()
+			hfStatuses := store.ListHF()
+
+			if outputFormat == "json" {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(struct {
+					Assets      []tokenizer.AssetStatus   `json:"assets"`
+					HuggingFace []tokenizer.HFAssetStatus `json:"huggingface,omitempty"`
+				}{statuses, hfStatuses})
+			}
+
+			fmt.Printf("Tokenizer directory: %s", store.Dir())
+			if store.Offline() {
+				fmt.Print(" (offline)")
+			}
+			fmt.Println()
+			fmt.Println()
+			fmt.Printf("%-12s %-9s %-10s %-10s %s\n", "NAME", "KIND", "STATUS", "SIZE", "PATH")
+			for _, status := range statuses {
+				size := "-"
+				if status.Installed {
+					size = formatBytes(int(status.Size))
+				}
+				fmt.Printf("%-12s %-9s %-10s %-10s %s\n", status.Name, status.Kind, assetState(status), size, status.Path)
+			}
+			for _, status := range hfStatuses {
+				state := "installed"
+				if !status.Verified {
+					state = "corrupt"
+				}
+				fmt.Printf("%-12s %-9s %-10s %-10s %s\n", "hf:"+status.Alias, "hf", state, formatBytes(int(status.Size)), status.Path)
+			}
+			return nil
+		},
+	}
+
+	listCmd.Flags().StringVarP(&outputFormat, "format", "f", "text", "Output format: text or json")
+
+	return listCmd
+}
+
+// newTokenizerFetchCmd creates the tokenizer fetch subcommand
+func newTokenizerFetchCmd() *cobra.Command {
+	var all bool
+
+	fetchCmd := &cobra.Command{
+		Use:   "fetch [name...]",
+		Short: "Download and verify tokenizer files",
+		Long: `Download tokenizer files, verify their checksums and install them.
+
+Without arguments, fetches the files needed by the configured token models.
+Use --all to fetch every known file.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newAssetStoreFromConfig(cmd)
+
+			assets, err := selectAssets(cmd, args, all)
+			if err != nil {
+				return err
+			}
+
+			var failed int
+			for _, asset := range assets {
+				path, err := store.Fetch(asset)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "✗ %s: %v\n", asset.Name, err)
+					failed++
+					continue
+				}
+				fmt.Printf("✓ %s → %s\n", asset.Name, path)
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("failed to fetch %d of %d tokenizer files", failed, len(assets))
+			}
+			return nil
+		},
+	}
+
+	fetchCmd.Flags().BoolVar(&all, "all", false, "Fetch every known tokenizer file")
+
+	return fetchCmd
+}
+
+// newTokenizerImportCmd creates the tokenizer import subcommand
+func newTokenizerImportCmd() *cobra.Command {
+	var hfAlias string
+
+	importCmd := &cobra.Command{
+		Use:   "import <file-or-directory>",
+		Short: "Install tokenizer files from a local file or directory",
+		Long: `Install tokenizer files from a local file or directory.
+
+Files are recognised by checksum, so they may have any name. This also works
+with the cache directories of tiktoken and the Vertex AI tokenizer.
+
+With --hf, installs a Hugging Face tokenizer.json under an alias, which can
+then be used as a token model: --token-model hf:<alias>.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newAssetStoreFromConfig(cmd)
+
+			if hfAlias != "" {
+				path := args[0]
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					path = filepath.Join(path, "tokenizer.json")
+				}
+				dest, err := store.ImportHF(hfAlias, path)
+				if err != nil {
+					return fmt.Errorf("import failed: %w", err)
+				}
+				fmt.Printf("✓ hf:%s → %s\n", strings.ToLower(hfAlias), dest)
+				return nil
+			}
+
+			imported, err := store.Import(args[0])
+			for _, asset := range imported {
+				fmt.Printf("✓ %s → %s\n", asset.Name, store.Path(asset))
+			}
+			if err != nil {
+				return fmt.Errorf("import failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	importCmd.Flags().StringVar(&hfAlias, "hf", "", "Install a Hugging Face tokenizer.json under this alias")
+
+	return importCmd
+}
+
+// newTokenizerVerifyCmd creates the tokenizer verify subcommand
+func newTokenizerVerifyCmd() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify [name...]",
+		Short: "Verify the checksums of installed tokenizer files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store := newAssetStoreFromConfig(cmd)
+
+			assets, err := selectAssets(cmd, args, len(args) == 0)
+			if err != nil {
+				return err
+			}
+
+			var corrupt int
+			for _, asset := range assets {
+				status := store.Status(asset)
+				switch {
+				case !status.Installed:
+					if len(args) > 0 {
+						fmt.Printf("✗ %s: not installed\n", asset.Name)
+						corrupt++
+					}
+				case status.Verified:
+					fmt.Printf("✓ %s: ok (%s)\n", asset.Name, status.Path)
+				default:
+					fmt.Printf("✗ %s: %s (%s)\n", asset.Name, status.Error, status.Path)
+					corrupt++
+				}
+			}
+
+			if len(args) == 0 {
+				for _, status := range store.ListHF() {
+					if status.Verified {
+						fmt.Printf("✓ hf:%s: ok (%s)\n", status.Alias, status.Path)
+					} else {
+						fmt.Printf("✗ hf:%s: %s (%s)\n", status.Alias, status.Error, status.Path)
+						corrupt++
+					}
+				}
+			}
+
+			if corrupt > 0 {
+				return fmt.Errorf("%d tokenizer file(s) failed verification", corrupt)
+			}
+			return nil
+		},
+	}
+
+	return verifyCmd
+}
+
+// selectAssets resolves asset names from the arguments, every asset, or the
+// assets needed by the configured token models
+func selectAssets(cmd *cobra.Command, names []string, all bool) ([]tokenizer.Asset, error) {
+	if len(names) > 0 {
+		var assets []tokenizer.Asset
+		for _, name := range names {
+			asset, ok := tokenizer.FindAsset(name)
+			if !ok {
+				var known []string
+				for _, a := range tokenizer.AssetCatalog {
+					known = append(known, a.Name)
+				}
+				return nil, fmt.Errorf("unknown tokenizer file %q (known: %s)", name, strings.Join(known, ", "))
+			}
+			assets = append(assets, asset)
+		}
+		return assets, nil
+	}
+
+	if all {
+		return tokenizer.AssetCatalog, nil
+	}
+
+	cfg := config.NewFromFlagsAndEnv(cmd)
+	var assets []tokenizer.Asset
+	seen := make(map[string]bool)
+	for _, model := range cfg.TokenModels {
+		for _, asset := range tokenizer.AssetsForProvider(model) {
+			if !seen[asset.Name] {
+				seen[asset.Name] = true
+				assets = append(assets, asset)
+			}
+		}
+	}
+	return assets, nil
+}
+
+// assetState returns a short label for an asset's local state
+func assetState(status tokenizer.AssetStatus) string {
+	switch {
+	case status.Verified:
+		return "installed"
+	case status.Installed:
+		return "corrupt"
+	default:
+		return "missing"
+	}
+}
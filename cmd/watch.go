@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/condition"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/spf13/cobra"
+)
+
+// Why a watch stopped
+const (
+	watchUntil           = "until"
+	watchMaxIterations   = "max_iterations"
+	watchBudgetExhausted = "budget_exhausted"
+	watchInterrupted     = "interrupted"
+	watchError           = "error"
+)
+
+// watchOptions controls how a command is watched
+type watchOptions struct {
+	interval      time.Duration
+	until         *condition.Condition // nil watches until another limit stops it
+	maxIterations int                  // 0 for no limit
+	budget        int                  // Tokens the emitted envelopes may use; 0 for no budget
+}
+
+// NewWatchCmd creates the watch command for re-running a command until it
+// changes or a condition holds
+func NewWatchCmd() *cobra.Command {
+	var opts watchOptions
+	var until string
+
+	watchCmd := &cobra.Command{
+		Use:   "watch [flags] [--] <command> [args...]",
+		Short: "Re-run a command periodically, emitting envelopes only when its output changes",
+		Long: `Re-run a command every --interval and print an NDJSON event only when
+something happened, instead of an envelope per run:
+
+  change  The output or exit code differs from the previous run (and the first run)
+  until   The --until condition became true; the watch stops
+  stop    The watch ended; its watch field says why, after how many runs,
+          and how many tokens the emitted envelopes used
+
+--until is a condition on the envelope, over the fields exit, success,
+tokens, bytes, lines, duration, output, input and failure_reason:
+
+  exit==0                           The command succeeded
+  output ~ /successfully rolled out/  The output matches a pattern
+  tokens > 2000 || exit != 0        Conditions joined with && and ||
+
+The watch also stops after --max-iterations runs, or before an envelope that
+would take the emitted tokens past --budget. It exits with 0 when the
+condition was met (or without --until, when it ran out of iterations), and 1
+otherwise. With --delta, a change event holds only what changed.
+
+Examples:
+  ctx watch --until 'exit==0' -- kubectl rollout status deploy/api
+  ctx watch --interval 30s --until 'output ~ /completed/' --budget 5000 -- gh run view 123
+  ctx --delta watch --max-iterations 20 -- kubectl get pods`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.interval <= 0 {
+				return fmt.Errorf("--interval must be positive")
+			}
+			if until != "" {
+				c, err := condition.Parse(until)
+				if err != nil {
+					return err
+				}
+				if err := c.Check(condition.EnvelopeVars("", &models.Output{})); err != nil {
+					return err
+				}
+				opts.until = c
+			}
+			appCtx, err := appContextFromCmd(cmd)
+			if err != nil {
+				return err
+			}
+
+			command := strings.Join(args, " ")
+			executor := NewCommandExecutor(appCtx)
+			if err := executor.checkSessionBudget(cmd.Context(), command); err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return executor.watch(ctx, cmd.OutOrStdout(), command, opts)
+		},
+	}
+
+	// Flags after the command belong to it
+	watchCmd.Flags().SetInterspersed(false)
+	watchCmd.Flags().DurationVar(&opts.interval, "interval", 5*time.Second, "Time between the end of one run and the start of the next")
+	watchCmd.Flags().StringVar(&until, "until", "", "Stop once this condition on the envelope holds, e.g. 'exit==0'")
+	watchCmd.Flags().IntVar(&opts.maxIterations, "max-iterations", 100, "Stop after this many runs (0 for no limit)")
+	watchCmd.Flags().IntVar(&opts.budget, "budget", 0, "Tokens the emitted envelopes may use in total (0 for no budget)")
+
+	return watchCmd
+}
+
+// watch re-runs a command until the condition holds or a limit stops it,
+// emitting events as NDJSON to w
+func (ce *CommandExecutor) watch(ctx context.Context, w io.Writer, command string, opts watchOptions) error {
+	summary := &models.WatchSummary{}
+	if opts.until != nil {
+		summary.Until = opts.until.String()
+	}
+	emit := func(event models.StreamEvent) {
+		if data, err := json.Marshal(event); err == nil {
+			fmt.Fprintln(w, string(data))
+		}
+	}
+	stopWith := func(reason string) error {
+		summary.Reason = reason
+		emit(models.StreamEvent{Type: "stop", Iteration: summary.Iterations, Watch: summary})
+		if reason == watchUntil || (reason == watchMaxIterations && opts.until == nil) {
+			return nil
+		}
+		return &ExitError{Code: ExitCodeWrappedCmdError}
+	}
+
+	var last *models.Output
+	for {
+		if ctx.Err() != nil {
+			return stopWith(watchInterrupted)
+		}
+		output, _, err := ce.runEnvelope(ctx, command)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return stopWith(watchInterrupted) // The run was cut short
+		}
+		summary.Iterations++
+
+		met := false
+		if opts.until != nil {
+			met, err = opts.until.Eval(condition.EnvelopeVars("", output))
+			if err != nil {
+				summary.Error = err.Error()
+				return stopWith(watchError)
+			}
+		}
+		changed := last == nil || output.Output != last.Output || output.Metadata.ExitCode != last.Metadata.ExitCode
+		last = output
+
+		if changed || met {
+			shown := *output
+			saved := ce.finish(&shown)
+			if opts.budget > 0 && summary.Tokens+shown.Tokens > opts.budget {
+				return stopWith(watchBudgetExhausted)
+			}
+			ce.enricher.RecordAs(&shown, saved)
+			summary.Events++
+			summary.Tokens += shown.Tokens
+
+			eventType := "change"
+			if met {
+				eventType = "until"
+			}
+			emit(models.StreamEvent{Type: eventType, Iteration: summary.Iterations, Envelope: &shown})
+		}
+
+		if met {
+			return stopWith(watchUntil)
+		}
+		if opts.maxIterations > 0 && summary.Iterations >= opts.maxIterations {
+			return stopWith(watchMaxIterations)
+		}
+
+		select {
+		case <-ctx.Done():
+			return stopWith(watchInterrupted)
+		case <-time.After(opts.interval):
+		}
+	}
+}
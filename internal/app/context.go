@@ -3,6 +3,7 @@ package app
 import (
 	"github.com/slavakurilyak/ctx/internal/config"
 	"github.com/slavakurilyak/ctx/internal/history"
+	"github.com/slavakurilyak/ctx/internal/session"
 	"github.com/slavakurilyak/ctx/internal/telemetry"
 	"github.com/slavakurilyak/ctx/internal/tokenizer"
 )
@@ -14,9 +15,16 @@ const AppContextKey contextKey = "appContext"
 
 // AppContext is the central dependency injection container for the application
 type AppContext struct {
-	// Tokenizer handles token counting for different LLM models
+	// Tokenizer handles token counting for the primary LLM model
 	Tokenizer tokenizer.Tokenizer
 
+	// Tokenizers holds one tokenizer per configured token model, primary first;
+	// a model whose tokenizer could not be initialized is nil
+	Tokenizers []tokenizer.Tokenizer
+
+	// TokenizerErr records why the primary tokenizer could not be initialized
+	TokenizerErr error
+
 	// TokenizerCache manages tokenizer instances
 	TokenizerCache *tokenizer.TokenizerCache
 
@@ -26,6 +34,10 @@ type AppContext struct {
 	// History manages command history
 	History *history.HistoryManager
 
+	// Sessions tracks agent sessions; SessionID is the current one, if any
+	Sessions  *session.Manager
+	SessionID string
+
 	// Config holds application configuration
 	Config *config.Config
 }
@@ -63,6 +75,20 @@ func WithTokenizer(tok tokenizer.Tokenizer) Option {
 	}
 }
 
+// WithTokenizers sets the tokenizers for all configured token models
+func WithTokenizers(toks []tokenizer.Tokenizer) Option {
+	return func(ctx *AppContext) {
+		ctx.Tokenizers = toks
+	}
+}
+
+// WithTokenizerError records a tokenizer initialization failure
+func WithTokenizerError(err error) Option {
+	return func(ctx *AppContext) {
+		ctx.TokenizerErr = err
+	}
+}
+
 // WithTokenizerCache sets the tokenizer cache
 func WithTokenizerCache(cache *tokenizer.TokenizerCache) Option {
 	return func(ctx *AppContext) {
@@ -84,6 +110,14 @@ func WithHistory(hm *history.HistoryManager) Option {
 	}
 }
 
+// WithSession sets the session manager and the current session ID
+func WithSession(sessions *session.Manager, id string) Option {
+	return func(ctx *AppContext) {
+		ctx.Sessions = sessions
+		ctx.SessionID = id
+	}
+}
+
 // WithConfig sets the configuration
 func WithConfig(cfg *config.Config) Option {
 	return func(ctx *AppContext) {
@@ -103,6 +137,11 @@ func (ctx *AppContext) GetTokenizer() (tokenizer.Tokenizer, error) {
 		model = "claude-4.1-opus" // Default
 	}
 
+	// Don't retry a tokenizer that already failed to initialize
+	if ctx.TokenizerErr != nil {
+		return nil, ctx.TokenizerErr
+	}
+
 	// Get or create from cache
 	tok, err := ctx.TokenizerCache.GetOrCreate(model)
 	if err != nil {
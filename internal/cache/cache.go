@@ -0,0 +1,147 @@
+// Package cache decides which command results may be reused and computes
+// the keys they are stored under. Results themselves live in the history
+// store: a cacheable run's envelope carries its key in metadata.cache, and a
+// later run with the same key within the TTL returns that envelope instead
+// of executing again.
+//
+// A key covers the command line, the working directory, selected
+// environment variables and the modification times of selected files, so a
+// cached result is not reused once any of them changes. Commands that may
+// change something (see Mutating) are never cached, and only those known
+// to only read (see ReadOnly) are cached without a rule.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule sets how commands starting with Match are cached. A TTL of zero
+// never caches them.
+type Rule struct {
+	Match string        // Leading words of the command, e.g. "kubectl get"
+	TTL   time.Duration // How long a result is reused
+	Env   []string      // Variables whose values are part of the key
+	Files []string      // Files whose modification times are part of the key, relative to the project root
+}
+
+// Policy decides whether and how long commands are cached
+type Policy struct {
+	TTL   time.Duration // For commands no rule matches; zero caches nothing
+	Env   []string      // Variables that are part of every key
+	Rules []Rule        // The first matching rule wins
+}
+
+// Spec is how one command is cached
+type Spec struct {
+	TTL   time.Duration
+	Env   []string
+	Files []string
+}
+
+// toolEnv are variables that select what a tool reads, so they are part of
+// the key of its commands
+var toolEnv = map[string][]string{
+	"kubectl": {"KUBECONFIG"},
+	"helm":    {"KUBECONFIG", "HELM_NAMESPACE"},
+	"docker":  {"DOCKER_HOST", "DOCKER_CONTEXT"},
+	"aws":     {"AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"},
+	"gcloud":  {"CLOUDSDK_CORE_PROJECT", "CLOUDSDK_ACTIVE_CONFIG_NAME"},
+	"psql":    {"PGHOST", "PGPORT", "PGDATABASE", "PGUSER", "PGSERVICE"},
+	"mysql":   {"MYSQL_HOST", "MYSQL_TCP_PORT"},
+}
+
+// toolFiles are files that change when a tool's state does: a commit or
+// checkout moves HEAD, and staging rewrites the index
+var toolFiles = map[string][]string{
+	"git": {".git/HEAD", ".git/index"},
+}
+
+// For returns how a command is cached, or false when it is not: when no TTL
+// applies, or when the command may change something. The default TTL only
+// applies to commands known to only read (see ReadOnly); a rule may cache
+// others that are not mutating.
+func (p *Policy) For(command string) (Spec, bool) {
+	a := commandAccess(command)
+	if p == nil || a == accessWrite {
+		return Spec{}, false
+	}
+	spec := Spec{Env: append([]string(nil), p.Env...)}
+	if a == accessRead {
+		spec.TTL = p.TTL
+	}
+	fields := strings.Fields(command)
+	for _, rule := range p.Rules {
+		if matches(fields, strings.Fields(rule.Match)) {
+			spec.TTL = rule.TTL
+			spec.Env = append(spec.Env, rule.Env...)
+			spec.Files = append(spec.Files, rule.Files...)
+			break
+		}
+	}
+	if spec.TTL <= 0 {
+		return Spec{}, false
+	}
+	if len(fields) > 0 {
+		name := filepath.Base(fields[0])
+		spec.Env = append(spec.Env, toolEnv[name]...)
+		spec.Files = append(spec.Files, toolFiles[name]...)
+	}
+	return spec, true
+}
+
+func matches(fields, prefix []string) bool {
+	if len(prefix) == 0 || len(prefix) > len(fields) {
+		return false
+	}
+	for i, word := range prefix {
+		if fields[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// Key returns the key of a command's result: a hash of the command, the
+// directory it runs in, the spec's variables and file modification times
+// (files resolved against root), and extra settings that change the
+// envelope, such as the token model and limits
+func (s Spec) Key(command, dir, root string, extra ...string) string {
+	h := sha256.New()
+	write := func(parts ...string) {
+		for _, part := range parts {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+	}
+	write("command", command, "dir", dir)
+
+	env := append([]string(nil), s.Env...)
+	sort.Strings(env)
+	for i, name := range env {
+		if i > 0 && env[i-1] == name {
+			continue
+		}
+		value, set := os.LookupEnv(name)
+		write("env", name, strconv.FormatBool(set), value)
+	}
+	for _, file := range s.Files {
+		path := file
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		stamp := "missing"
+		if info, err := os.Stat(path); err == nil {
+			stamp = strconv.FormatInt(info.ModTime().UnixNano(), 10) + "/" + strconv.FormatInt(info.Size(), 10)
+		}
+		write("file", file, stamp)
+	}
+	write(extra...)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
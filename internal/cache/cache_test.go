@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMutating(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"ls -la", false},
+		{"cat README.md | grep ctx", false},
+		{"git status", false},
+		{"git log --oneline -5", false},
+		{"git -C repo diff", false},
+		{"git branch -a", false},
+		{"git branch feature", true},
+		{"git commit -m fix", true},
+		{"git stash", true},
+		{"git stash list", false},
+		{"kubectl get pods -n prod", false},
+		{"kubectl apply -f deploy.yaml", true},
+		{"kubectl config use-context prod", true},
+		{"docker ps", false},
+		{"docker run alpine", true},
+		{"psql -c 'select * from users'", false},
+		{"psql -c 'delete from users'", true},
+		{"curl https://example.com", false},
+		{"curl -X POST https://example.com", true},
+		{"curl -d a=1 https://example.com", true},
+		{"http POST example.com", true},
+		{"sed -n 1,5p file", false},
+		{"sed -i s/a/b/ file", true},
+		{"find . -name '*.go'", false},
+		{"find . -name '*.tmp' -delete", true},
+		{"ls | xargs rm", true},
+		{"ls > out.txt", true},
+		{"ls 2>/dev/null", false},
+		{"rm -rf build", true},
+		{"FOO=1 timeout 5 rm x", true},
+		{"env -i make", true},
+		{"echo hi && touch x", true},
+	}
+	for _, tt := range tests {
+		if got := Mutating(tt.command); got != tt.want {
+			t.Errorf("Mutating(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"ls -la", true},
+		{"cat README.md | grep ctx | wc -l", true},
+		{"git log --oneline -5", true},
+		{"git diff --cached", true},
+		{"kubectl get pods", true},
+		{"psql -c 'select 1'", true},
+		{"curl -s https://example.com", true},
+		{"wget -qO- https://example.com", true},
+		{"ls | xargs wc -l", true},
+
+		// Unknown commands may do anything
+		{"./deploy.sh", false},
+		{"python manage.py migrate", false},
+		{"bash -c 'ls'", false},
+		{"node script.js", false},
+		{"npx prisma migrate deploy", false},
+		{"ls | ./process.sh", false},
+		{"psql -f migrate.sql", false},
+		{"perl -ne print file", false},
+
+		// Reading the working tree, which the key does not cover
+		{"git status", false},
+		{"git diff", false},
+		{"git -C repo status --short", false},
+
+		// Writing
+		{"wget https://example.com/file.tar.gz", false},
+		{"sort -o out.txt in.txt", false},
+		{"uniq in.txt out.txt", false},
+		{"rm -rf build", false},
+		{"ls > out.txt", false},
+	}
+	for _, tt := range tests {
+		if got := ReadOnly(tt.command); got != tt.want {
+			t.Errorf("ReadOnly(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	policy := &Policy{
+		TTL: time.Minute,
+		Env: []string{"LANG"},
+		Rules: []Rule{
+			{Match: "kubectl get", TTL: 10 * time.Second, Files: []string{"deploy.yaml"}},
+			{Match: "date", TTL: 0},
+			{Match: "./status.sh", TTL: time.Second},
+		},
+	}
+
+	spec, ok := policy.For("kubectl get pods")
+	if !ok || spec.TTL != 10*time.Second {
+		t.Fatalf("For(kubectl get) = %+v, %v; want the rule's TTL", spec, ok)
+	}
+	if len(spec.Files) != 1 || len(spec.Env) != 2 || spec.Env[1] != "KUBECONFIG" {
+		t.Errorf("For(kubectl get) = %+v, want the rule's files and KUBECONFIG", spec)
+	}
+	if spec, ok := policy.For("ls"); !ok || spec.TTL != time.Minute {
+		t.Errorf("For(ls) = %+v, %v; want the default TTL", spec, ok)
+	}
+	if _, ok := policy.For("date +%s"); ok {
+		t.Error("For(date) is cached despite a rule with no TTL")
+	}
+	if _, ok := policy.For("rm -rf build"); ok {
+		t.Error("For(rm) caches a mutating command")
+	}
+	for _, command := range []string{"./deploy.sh", "python manage.py migrate", "git status"} {
+		if _, ok := policy.For(command); ok {
+			t.Errorf("For(%q) applies the default TTL to a command not known to only read", command)
+		}
+	}
+	if spec, ok := policy.For("./status.sh --short"); !ok || spec.TTL != time.Second {
+		t.Errorf("For(./status.sh) = %+v, %v; want the rule's TTL", spec, ok)
+	}
+	if _, ok := (&Policy{}).For("ls"); ok {
+		t.Error("a policy without a TTL caches commands")
+	}
+	var none *Policy
+	if _, ok := none.For("ls"); ok {
+		t.Error("a nil policy caches commands")
+	}
+}
+
+func TestKey(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "state")
+	if err := os.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CTX_CACHE_TEST", "one")
+	spec := Spec{TTL: time.Minute, Env: []string{"CTX_CACHE_TEST"}, Files: []string{"state"}}
+
+	key := spec.Key("ls", root, root)
+	if key != spec.Key("ls", root, root) {
+		t.Fatal("Key() is not stable")
+	}
+	if key == spec.Key("ls -a", root, root) || key == spec.Key("ls", "/other", root) ||
+		key == spec.Key("ls", root, root, "openai") {
+		t.Error("Key() ignores the command, directory or extra settings")
+	}
+
+	t.Setenv("CTX_CACHE_TEST", "two")
+	if key == spec.Key("ls", root, root) {
+		t.Error("Key() ignores the environment")
+	}
+	t.Setenv("CTX_CACHE_TEST", "one")
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if key == spec.Key("ls", root, root) {
+		t.Error("Key() ignores file modification times")
+	}
+}
@@ -0,0 +1,360 @@
+package cache
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+)
+
+// access is what a command is known to do
+type access int
+
+const (
+	accessUnknown access = iota // Neither known to only read nor to change anything
+	accessRead                  // Only reads
+	accessWrite                 // May change something
+)
+
+// readOnlyCommands only read whatever their arguments, apart from the
+// exceptions stageAccess checks
+var readOnlyCommands = set("ls", "cat", "head", "tail", "grep", "egrep", "fgrep", "rg", "ag", "wc",
+	"sort", "uniq", "cut", "tr", "nl", "tac", "rev", "column", "fold", "comm", "diff", "cmp",
+	"jq", "yq", "echo", "printf", "pwd", "whoami", "id", "uname", "hostname", "date", "uptime",
+	"df", "du", "free", "ps", "printenv", "which", "file", "stat", "tree", "basename", "dirname",
+	"realpath", "readlink", "md5sum", "sha1sum", "sha256sum", "seq", "true", "false", "test")
+
+// mutatingCommands change files, processes or the system whatever their
+// arguments
+var mutatingCommands = map[string]bool{
+	"rm": true, "rmdir": true, "mv": true, "cp": true, "mkdir": true, "touch": true, "tee": true,
+	"ln": true, "chmod": true, "chown": true, "chgrp": true, "dd": true, "truncate": true,
+	"install": true, "shred": true, "kill": true, "pkill": true, "killall": true,
+	"shutdown": true, "reboot": true, "systemctl": true, "service": true, "crontab": true,
+	"make": true, "cmake": true, "ninja": true, "bazel": true, "gradle": true, "mvn": true,
+	"pip": true, "pip3": true, "apt": true, "apt-get": true, "brew": true, "yum": true, "dnf": true,
+	"ssh": true, "scp": true, "rsync": true, "sudo": true, "su": true,
+}
+
+// readOnlySubcommands lists what only reads, for tools whose other
+// subcommands may change something
+var readOnlySubcommands = map[string]map[string]bool{
+	"git": set("status", "log", "diff", "show", "blame", "shortlog", "describe", "rev-parse",
+		"rev-list", "ls-files", "ls-tree", "ls-remote", "cat-file", "grep", "reflog", "branch",
+		"tag", "stash", "remote", "config", "merge-base", "name-rev", "whatchanged", "count-objects"),
+	"kubectl": set("get", "describe", "logs", "top", "explain", "api-resources", "api-versions",
+		"version", "cluster-info", "config", "auth", "diff", "events"),
+	"docker": set("ps", "images", "inspect", "logs", "version", "info", "top", "port", "diff",
+		"history", "search", "compose"),
+	"helm":      set("list", "ls", "status", "get", "history", "show", "search", "template", "version", "lint"),
+	"terraform": set("plan", "show", "output", "validate", "version", "providers", "graph"),
+	"npm":       set("ls", "list", "view", "info", "outdated", "search", "config", "version", "whoami"),
+	"go":        set("list", "version", "env", "doc", "vet"),
+	"cargo":     set("tree", "metadata", "version", "search", "check", "clippy"),
+}
+
+// worktreeReads are read-only subcommands whose output depends on the
+// working tree, which their cache key does not cover (see toolFiles): an
+// edit would not invalidate a cached git status
+var worktreeReads = map[string]func(args []string) bool{
+	"git status":   always,
+	"git diff":     noneOf("--cached", "--staged"),
+	"git grep":     noneOf("--cached"),
+	"git blame":    always,
+	"git ls-files": always,
+}
+
+// readOnlyArgs narrows subcommands that read without arguments but write
+// with some: git branch foo creates a branch, git branch lists them
+var readOnlyArgs = map[string]func(args []string) bool{
+	"git branch": listOnly("-a", "--all", "-r", "--remotes", "-v", "-vv", "--verbose", "--list", "-l",
+		"--show-current", "--contains", "--no-contains", "--merged", "--no-merged", "--sort", "--format", "--color", "--no-color"),
+	"git tag":        listOnly("-l", "--list", "-n", "--contains", "--merged", "--sort", "--format", "--points-at"),
+	"git stash":      firstArgIn("list", "show"),
+	"git remote":     firstArgIn("", "-v", "--verbose", "show", "get-url"),
+	"git config":     anyArgIn("--get", "--get-all", "--get-regexp", "--list", "-l"),
+	"kubectl config": firstArgIn("view", "get-contexts", "get-clusters", "get-users", "current-context"),
+	"kubectl auth":   firstArgIn("can-i", "whoami"),
+	"docker compose": firstArgIn("ps", "logs", "config", "ls", "images", "top", "version"),
+	"npm config":     firstArgIn("get", "list", "ls"),
+}
+
+var (
+	// Statements that change a database
+	sqlWrite = regexp.MustCompile(`(?i)\b(INSERT\s+INTO|UPDATE\s+\S+\s+SET|DELETE\s+FROM|MERGE\s+INTO|REPLACE\s+INTO|CREATE|ALTER|DROP|TRUNCATE|GRANT|REVOKE|VACUUM|REINDEX|CLUSTER|REFRESH)\b`)
+	// HTTP methods that change a server
+	httpWrite = regexp.MustCompile(`(?i)^(POST|PUT|PATCH|DELETE)$`)
+)
+
+// Mutating reports whether a command may change something: files, a
+// repository, a cluster, a database or a server. Such commands are never
+// cached, not even by a rule.
+func Mutating(command string) bool {
+	return commandAccess(command) == accessWrite
+}
+
+// ReadOnly reports whether a command is known to only read, every one of
+// its stages being a command listed as such. Only these get the default
+// TTL; other commands that are not mutating are cached only by a rule.
+// Git commands reading the working tree, such as git status, are not
+// counted, since an edit would not change their key.
+func ReadOnly(command string) bool {
+	return commandAccess(command) == accessRead
+}
+
+// commandAccess classifies a command line by its least safe stage
+func commandAccess(command string) access {
+	result := accessRead
+	for _, stage := range fingerprint.Stages(command) {
+		a := stageAccess(stage.Words)
+		if stage.Writes {
+			a = accessWrite
+		}
+		if a == accessWrite {
+			return accessWrite
+		}
+		if a == accessUnknown {
+			result = accessUnknown
+		}
+	}
+	return result
+}
+
+// stageAccess classifies one simple command
+func stageAccess(words []string) access {
+	words = unwrap(words)
+	if len(words) == 0 {
+		return accessRead
+	}
+	name, args := filepath.Base(words[0]), words[1:]
+	if mutatingCommands[name] {
+		return accessWrite
+	}
+	if readOnly, ok := readOnlySubcommands[name]; ok {
+		sub, rest := subcommand(args)
+		if !readOnly[sub] {
+			return accessWrite
+		}
+		if check, ok := readOnlyArgs[name+" "+sub]; ok && !check(rest) {
+			return accessWrite
+		}
+		if reads, ok := worktreeReads[name+" "+sub]; ok && reads(rest) {
+			return accessUnknown
+		}
+		return accessRead
+	}
+
+	switch name {
+	case "psql", "mysql", "mariadb", "sqlite3", "duckdb", "clickhouse-client":
+		switch {
+		case sqlWrite.MatchString(strings.Join(args, " ")):
+			return accessWrite
+		case hasFlagPrefix(args, "-f", "--file"):
+			return accessUnknown // A script, which may write
+		}
+		return accessRead
+	case "curl", "http", "https":
+		return writesIf(httpWrites(args))
+	case "wget":
+		// wget saves what it downloads unless told to print it
+		return writesIf(httpWrites(args) || !toStdout(args))
+	case "sed":
+		return writesIf(hasFlagPrefix(args, "-i", "--in-place"))
+	case "perl":
+		if hasFlagPrefix(args, "-i") {
+			return accessWrite
+		}
+		return accessUnknown
+	case "find":
+		return writesIf(hasFlagPrefix(args, "-delete", "-exec", "-execdir", "-ok", "-fprint"))
+	case "sort":
+		return writesIf(hasFlagPrefix(args, "-o", "--output"))
+	case "uniq":
+		return writesIf(len(positional(args)) > 1) // uniq in out
+	case "xargs":
+		return xargsAccess(args)
+	}
+	if readOnlyCommands[name] {
+		return accessRead
+	}
+	return accessUnknown
+}
+
+func writesIf(writes bool) access {
+	if writes {
+		return accessWrite
+	}
+	return accessRead
+}
+
+// unwrap drops what runs a command rather than being it: environment
+// assignments and wrappers such as env, time, nice and timeout
+func unwrap(words []string) []string {
+	for len(words) > 0 {
+		switch w := words[0]; {
+		case strings.Contains(w, "=") && !strings.HasPrefix(w, "-"):
+			words = words[1:]
+		case w == "env" || w == "time" || w == "nice" || w == "nohup" || w == "command":
+			words = words[1:]
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				words = words[1:]
+			}
+		case w == "timeout" && len(words) > 1:
+			words = words[1:]
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				words = words[1:]
+			}
+			if len(words) > 0 {
+				words = words[1:] // The duration
+			}
+		default:
+			return words
+		}
+	}
+	return words
+}
+
+// subcommand returns the first argument that is not a flag and those after
+// it. Global flags with values (git -C dir) are skipped with their value.
+func subcommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-C", "-c", "-n", "--namespace", "--context", "--kubeconfig", "-H", "--host":
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return args[i], args[i+1:]
+		}
+	}
+	return "", nil
+}
+
+// listOnly allows no arguments but the given flags, and their values
+func listOnly(flags ...string) func([]string) bool {
+	allowed := set(flags...)
+	return func(args []string) bool {
+		for _, arg := range args {
+			name, _, _ := strings.Cut(arg, "=")
+			if !allowed[name] {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// firstArgIn allows the given first arguments; "" stands for none
+func firstArgIn(first ...string) func([]string) bool {
+	allowed := set(first...)
+	return func(args []string) bool {
+		if len(args) == 0 {
+			return allowed[""]
+		}
+		return allowed[args[0]]
+	}
+}
+
+// noneOf holds when none of the given arguments are present
+func noneOf(values ...string) func([]string) bool {
+	has := anyArgIn(values...)
+	return func(args []string) bool {
+		return !has(args)
+	}
+}
+
+func always([]string) bool { return true }
+
+// anyArgIn requires one of the given arguments
+func anyArgIn(values ...string) func([]string) bool {
+	allowed := set(values...)
+	return func(args []string) bool {
+		for _, arg := range args {
+			if allowed[arg] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// httpWrites reports whether an HTTP client sends data or a writing method
+func httpWrites(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case (arg == "-X" || arg == "--request") && i+1 < len(args) && httpWrite.MatchString(args[i+1]):
+			return true
+		case strings.HasPrefix(arg, "-X") && httpWrite.MatchString(strings.TrimPrefix(arg, "-X")):
+			return true
+		case strings.HasPrefix(arg, "--request=") && httpWrite.MatchString(strings.TrimPrefix(arg, "--request=")):
+			return true
+		case arg == "-d" || arg == "-F" || arg == "-T" || arg == "-o" || arg == "-O" ||
+			strings.HasPrefix(arg, "--data") || strings.HasPrefix(arg, "--form") ||
+			strings.HasPrefix(arg, "--upload-file") || strings.HasPrefix(arg, "--output") ||
+			strings.HasPrefix(arg, "--post-"):
+			return true
+		case httpWrite.MatchString(arg): // httpie: http POST url
+			return true
+		}
+	}
+	return false
+}
+
+// xargsAccess classifies the command xargs runs
+func xargsAccess(args []string) access {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-I", "-n", "-P", "-L", "-d", "-s", "-E":
+			i++
+			continue
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			return stageAccess(args[i:])
+		}
+	}
+	return accessRead // xargs runs echo by default
+}
+
+// toStdout reports whether wget prints what it downloads
+func toStdout(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "-O-" || arg == "-qO-" || arg == "--output-document=-":
+			return true
+		case (arg == "-O" || arg == "--output-document") && i+1 < len(args) && args[i+1] == "-":
+			return true
+		}
+	}
+	return false
+}
+
+// positional returns the arguments that are not flags
+func positional(args []string) []string {
+	var out []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			out = append(out, arg)
+		}
+	}
+	return out
+}
+
+func hasFlagPrefix(args []string, flags ...string) bool {
+	for _, arg := range args {
+		for _, flag := range flags {
+			if strings.HasPrefix(arg, flag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func set(values ...string) map[string]bool {
+	m := make(map[string]bool, len(values))
+	for _, v := range values {
+		m[v] = true
+	}
+	return m
+}
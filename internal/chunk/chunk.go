@@ -0,0 +1,278 @@
+// Package chunk splits text into pieces that each fit a token budget,
+// preferring to cut at function, paragraph and line boundaries.
+package chunk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// Boundary strengths, strongest last. A chunk ends at the strongest boundary
+// in the second half of the text that fits.
+const (
+	boundaryNone = iota
+	boundaryWord
+	boundaryLine
+	boundaryParagraph
+	boundaryFunction
+)
+
+// declaration matches unindented lines that start a function, type or class
+// in common languages
+var declaration = regexp.MustCompile(`^(func|def|async def|class|fn|pub(\([^)]*\))? (fn|struct|enum|trait|mod|impl)|impl|struct|enum|trait|type|interface|function|export|module|public|private|protected|static|sub|proc)\b`)
+
+// commentLine matches lines that are only a comment, which belong to the declaration below them
+var commentLine = regexp.MustCompile(`^\s*(//|#|/\*|\*|--|;)`)
+
+// Options control chunking
+type Options struct {
+	MaxTokens int // Maximum tokens per chunk
+	Overlap   int // Tokens repeated from the end of one chunk at the start of the next
+}
+
+// Chunk is a piece of the input
+type Chunk struct {
+	Index  int
+	Tokens int    // Exact token count of Text
+	Start  int    // Byte offset of the chunk in the input
+	End    int    // Byte offset just past the chunk
+	Text   string // input[Start:End]
+}
+
+// piece is the smallest unit chunks are built from
+type piece struct {
+	start, end int
+	tokens     int
+	boundary   int // Strength of the boundary at the piece's start
+}
+
+// Split cuts text into chunks of at most opts.MaxTokens tokens, counted
+// exactly with tok. Consecutive chunks share about opts.Overlap tokens.
+func Split(tok tokenizer.Tokenizer, text string, opts Options) ([]Chunk, error) {
+	if tok == nil {
+		return nil, fmt.Errorf("no tokenizer available to chunk by tokens")
+	}
+	if opts.MaxTokens <= 0 {
+		return nil, fmt.Errorf("max tokens must be positive")
+	}
+	if opts.Overlap < 0 || opts.Overlap >= opts.MaxTokens {
+		return nil, fmt.Errorf("overlap must be between 0 and max tokens (%d)", opts.MaxTokens-1)
+	}
+	if text == "" {
+		return nil, nil
+	}
+
+	pieces, err := splitPieces(tok, text, opts.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []Chunk
+	for first := 0; first < len(pieces); {
+		last := fitPieces(pieces, first, opts.MaxTokens)
+		last = bestCut(pieces, first, last)
+
+		// Per-piece counts are close to exact; trim until the real count fits
+		var tokens int
+		for {
+			tokens, err = tok.CountTokens(text[pieces[first].start:pieces[last].end])
+			if err != nil {
+				return nil, err
+			}
+			if tokens <= opts.MaxTokens || last == first {
+				break
+			}
+			last--
+		}
+
+		start, end := pieces[first].start, pieces[last].end
+		chunks = append(chunks, Chunk{Index: len(chunks), Tokens: tokens, Start: start, End: end, Text: text[start:end]})
+		if last == len(pieces)-1 {
+			break
+		}
+		first = nextStart(pieces, first, last, opts.Overlap)
+	}
+	return chunks, nil
+}
+
+// fitPieces returns the last piece that keeps the chunk from first within max tokens
+func fitPieces(pieces []piece, first, max int) int {
+	sum := pieces[first].tokens
+	last := first
+	for last+1 < len(pieces) && sum+pieces[last+1].tokens <= max {
+		last++
+		sum += pieces[last].tokens
+	}
+	return last
+}
+
+// bestCut picks where to end a chunk spanning pieces first..last: the
+// strongest boundary in the second half, the latest among equals
+func bestCut(pieces []piece, first, last int) int {
+	if last == len(pieces)-1 {
+		return last
+	}
+	best, bestStrength := last, pieces[last+1].boundary
+	half := pieces[first].start + (pieces[last].end-pieces[first].start)/2
+	for i := last; i > first; i-- {
+		if pieces[i].start < half {
+			break
+		}
+		if pieces[i].boundary > bestStrength {
+			best, bestStrength = i-1, pieces[i].boundary
+		}
+	}
+	return best
+}
+
+// nextStart returns the first piece of the next chunk, reaching back over
+// up to overlap tokens while always moving forward
+func nextStart(pieces []piece, first, last, overlap int) int {
+	next := last + 1
+	if overlap == 0 {
+		return next
+	}
+	sum := 0
+	for next-1 > first && sum+pieces[next-1].tokens <= overlap {
+		next--
+		sum += pieces[next].tokens
+	}
+	return next
+}
+
+// splitPieces splits text into lines marked with their boundary strength,
+// and splits lines over max tokens into words, and words into runes
+func splitPieces(tok tokenizer.Tokenizer, text string, max int) ([]piece, error) {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	boundaries := lineBoundaries(lines)
+	cache := make(map[string]int)
+	var pieces []piece
+	offset := 0
+	for i, line := range lines {
+		tokens, ok := cache[line]
+		if !ok {
+			n, err := tok.CountTokens(line)
+			if err != nil {
+				return nil, err
+			}
+			tokens = n
+			cache[line] = n
+		}
+
+		if tokens <= max {
+			pieces = append(pieces, piece{start: offset, end: offset + len(line), tokens: tokens, boundary: boundaries[i]})
+		} else {
+			long, err := splitLong(tok, line, offset, max)
+			if err != nil {
+				return nil, err
+			}
+			long[0].boundary = boundaries[i]
+			pieces = append(pieces, long...)
+		}
+		offset += len(line)
+	}
+	return pieces, nil
+}
+
+// lineBoundaries rates the boundary before each line
+func lineBoundaries(lines []string) []int {
+	boundaries := make([]int, len(lines))
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			boundaries[i] = boundaryNone
+		case declaration.MatchString(line):
+			boundaries[i] = boundaryFunction
+			// Doc comments directly above belong to the declaration
+			j := i
+			for j > 1 && commentLine.MatchString(lines[j-1]) && strings.TrimSpace(lines[j-1]) != "" {
+				j--
+			}
+			if j < i {
+				boundaries[i] = boundaryLine
+				boundaries[j] = boundaryFunction
+			}
+		case strings.TrimSpace(lines[i-1]) == "" && strings.TrimSpace(line) != "":
+			boundaries[i] = boundaryParagraph
+		default:
+			boundaries[i] = boundaryLine
+		}
+	}
+	return boundaries
+}
+
+// splitLong splits a line that exceeds max tokens at spaces, and any word
+// that still exceeds it at rune boundaries
+func splitLong(tok tokenizer.Tokenizer, line string, offset, max int) ([]piece, error) {
+	var pieces []piece
+	for start := 0; start < len(line); {
+		end := start + 1
+		for end < len(line) && line[end] != ' ' {
+			end++
+		}
+		for end < len(line) && line[end] == ' ' {
+			end++
+		}
+
+		word := line[start:end]
+		tokens, err := tok.CountTokens(word)
+		if err != nil {
+			return nil, err
+		}
+		if tokens <= max {
+			pieces = append(pieces, piece{start: offset + start, end: offset + end, tokens: tokens, boundary: boundaryWord})
+		} else {
+			runes, err := splitRunes(tok, word, offset+start, max)
+			if err != nil {
+				return nil, err
+			}
+			pieces = append(pieces, runes...)
+		}
+		start = end
+	}
+	return pieces, nil
+}
+
+// splitRunes cuts text into the longest rune-aligned prefixes that fit max tokens
+func splitRunes(tok tokenizer.Tokenizer, text string, offset, max int) ([]piece, error) {
+	var pieces []piece
+	for start := 0; start < len(text); {
+		// Binary search for the longest prefix that fits
+		lo, hi := start+1, len(text)
+		for lo < len(text) && !utf8.RuneStart(text[lo]) {
+			lo++
+		}
+		best := lo
+		for lo <= hi {
+			mid := (lo + hi) / 2
+			for mid < len(text) && !utf8.RuneStart(text[mid]) {
+				mid++
+			}
+			n, err := tok.CountTokens(text[start:mid])
+			if err != nil {
+				return nil, err
+			}
+			if n <= max {
+				best = mid
+				lo = mid + 1
+			} else {
+				hi = mid - 1
+			}
+		}
+		n, err := tok.CountTokens(text[start:best])
+		if err != nil {
+			return nil, err
+		}
+		pieces = append(pieces, piece{start: offset + start, end: offset + best, tokens: n, boundary: boundaryNone})
+		start = best
+	}
+	return pieces, nil
+}
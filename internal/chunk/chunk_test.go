@@ -0,0 +1,128 @@
+package chunk
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wordTokenizer counts whitespace-separated words
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) (int, error) { return len(strings.Fields(text)), nil }
+func (wordTokenizer) GetModelName() string                 { return "words" }
+
+// charTokenizer counts one token per byte, so single words can exceed a budget
+type charTokenizer struct{}
+
+func (charTokenizer) CountTokens(text string) (int, error) { return len(text), nil }
+func (charTokenizer) GetModelName() string                 { return "chars" }
+
+func checkChunks(t *testing.T, text string, chunks []Chunk, max int) {
+	t.Helper()
+	if len(chunks) == 0 {
+		t.Fatal("no chunks")
+	}
+	if chunks[0].Start != 0 || chunks[len(chunks)-1].End != len(text) {
+		t.Errorf("chunks cover %d..%d, want 0..%d", chunks[0].Start, chunks[len(chunks)-1].End, len(text))
+	}
+	for i, c := range chunks {
+		if c.Index != i || c.Text != text[c.Start:c.End] {
+			t.Errorf("chunk %d: index %d, text does not match its byte range", i, c.Index)
+		}
+		if c.Tokens > max {
+			t.Errorf("chunk %d has %d tokens, max %d", i, c.Tokens, max)
+		}
+		if i > 0 && c.Start > chunks[i-1].End {
+			t.Errorf("gap between chunk %d and %d", i-1, i)
+		}
+	}
+}
+
+func TestSplitProse(t *testing.T) {
+	var paragraphs []string
+	for i := 0; i < 12; i++ {
+		paragraphs = append(paragraphs, strings.TrimSpace(strings.Repeat(fmt.Sprintf("sentence %d goes here. ", i), 3)))
+	}
+	text := strings.Join(paragraphs, "\n\n") + "\n"
+
+	chunks, err := Split(wordTokenizer{}, text, Options{MaxTokens: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, text, chunks, 40)
+	for i, c := range chunks {
+		if i > 0 && c.Start != chunks[i-1].End {
+			t.Errorf("chunks %d and %d overlap without --overlap", i-1, i)
+		}
+		// Cut at paragraph boundaries
+		if c.End < len(text) && !strings.HasSuffix(c.Text, "\n\n") {
+			t.Errorf("chunk %d does not end at a paragraph: %q", i, c.Text[len(c.Text)-20:])
+		}
+	}
+}
+
+func TestSplitCodeAtFunctions(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("package demo\n\n")
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&sb, "// F%d does work\nfunc F%d() {\n\tx := %d\n\n\ty := x + 1\n\tprintln(x, y)\n}\n", i, i, i)
+	}
+	text := sb.String()
+
+	chunks, err := Split(wordTokenizer{}, text, Options{MaxTokens: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, text, chunks, 30)
+	for i, c := range chunks[1:] {
+		if !strings.HasPrefix(c.Text, "// F") {
+			t.Errorf("chunk %d should start at a function's doc comment: %q", i+1, c.Text[:20])
+		}
+	}
+}
+
+func TestSplitOverlap(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	text := strings.Join(lines, "\n") + "\n"
+
+	chunks, err := Split(wordTokenizer{}, text, Options{MaxTokens: 20, Overlap: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, text, chunks, 20)
+	for i := 1; i < len(chunks); i++ {
+		shared := text[chunks[i].Start:chunks[i-1].End]
+		if n := len(strings.Fields(shared)); n == 0 || n > 6 {
+			t.Errorf("chunks %d and %d share %d tokens, want 1..6", i-1, i, n)
+		}
+	}
+}
+
+func TestSplitLongLines(t *testing.T) {
+	text := strings.Repeat("abcdefghij ", 20) + strings.Repeat("x", 95) + "\n"
+
+	chunks, err := Split(charTokenizer{}, text, Options{MaxTokens: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkChunks(t, text, chunks, 40)
+}
+
+func TestSplitOptions(t *testing.T) {
+	if _, err := Split(wordTokenizer{}, "text", Options{MaxTokens: 0}); err == nil {
+		t.Error("Split() should require max tokens")
+	}
+	if _, err := Split(wordTokenizer{}, "text", Options{MaxTokens: 10, Overlap: 10}); err == nil {
+		t.Error("Split() should reject an overlap as large as a chunk")
+	}
+	if _, err := Split(nil, "text", Options{MaxTokens: 10}); err == nil {
+		t.Error("Split() should fail without a tokenizer")
+	}
+	if chunks, err := Split(wordTokenizer{}, "", Options{MaxTokens: 10}); err != nil || len(chunks) != 0 {
+		t.Errorf("Split(empty) = %v, %v", chunks, err)
+	}
+}
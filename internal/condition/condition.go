@@ -0,0 +1,267 @@
+// Package condition evaluates small conditions on envelopes, such as
+// "exit==0", "tokens < 5000 && output ~ /Ready/" or "probe.lines > 100".
+//
+// A condition is comparisons joined by && and ||, where && binds tighter.
+// A comparison is a field, an operator and a value: ==, != and the ordering
+// operators compare numbers (or strings with == and !=), and ~ and !~ match
+// a regular expression, written /like this/ or quoted. A field on its own
+// tests that it is true, and ! negates it.
+package condition
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+// Vars are the values a condition can refer to, by field name
+type Vars map[string]any
+
+// EnvelopeVars returns the fields of an envelope, each name prefixed with
+// prefix and a dot when prefix is not empty: exit (or exit_code), success,
+// tokens, bytes, lines, duration, output, input and failure_reason
+func EnvelopeVars(prefix string, output *models.Output) Vars {
+	if prefix != "" {
+		prefix += "."
+	}
+	lines := strings.Count(output.Output, "\n")
+	if output.Output != "" && !strings.HasSuffix(output.Output, "\n") {
+		lines++
+	}
+	return Vars{
+		prefix + "exit":           output.Metadata.ExitCode,
+		prefix + "exit_code":      output.Metadata.ExitCode,
+		prefix + "success":        output.Metadata.Success,
+		prefix + "tokens":         output.Tokens,
+		prefix + "bytes":          output.Metadata.Bytes,
+		prefix + "lines":          lines,
+		prefix + "duration":       output.Metadata.Duration,
+		prefix + "output":         output.Output,
+		prefix + "input":          output.Input,
+		prefix + "failure_reason": output.Metadata.FailureReason,
+	}
+}
+
+// Merge adds the values of other to v
+func (v Vars) Merge(other Vars) Vars {
+	for name, value := range other {
+		v[name] = value
+	}
+	return v
+}
+
+// Condition is a parsed condition
+type Condition struct {
+	text string
+	any  [][]comparison // Alternatives (||) of conjunctions (&&)
+}
+
+type comparison struct {
+	field  string
+	op     string // "" tests the field is true
+	negate bool   // ! before a lone field
+	value  string
+	re     *regexp.Regexp // For ~ and !~
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "!~", "<", ">", "~"} // Longest first
+
+// Parse parses a condition
+func Parse(text string) (*Condition, error) {
+	c := &Condition{text: strings.TrimSpace(text)}
+	if c.text == "" {
+		return nil, fmt.Errorf("empty condition")
+	}
+	for _, alternative := range splitOutside(c.text, "||") {
+		var all []comparison
+		for _, part := range splitOutside(alternative, "&&") {
+			cmp, err := parseComparison(strings.TrimSpace(part))
+			if err != nil {
+				return nil, fmt.Errorf("condition %q: %w", c.text, err)
+			}
+			all = append(all, cmp)
+		}
+		c.any = append(c.any, all)
+	}
+	return c, nil
+}
+
+// String returns the condition as written
+func (c *Condition) String() string {
+	return c.text
+}
+
+// Eval reports whether the condition holds for vars. Fields that are not
+// in vars are an error, so a misspelt field is not silently false.
+func (c *Condition) Eval(vars Vars) (bool, error) {
+	for _, all := range c.any {
+		holds := true
+		for _, cmp := range all {
+			ok, err := cmp.eval(vars)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Check reports an error for fields that are not in vars, so conditions
+// can be checked before they are first evaluated
+func (c *Condition) Check(vars Vars) error {
+	for _, all := range c.any {
+		for _, cmp := range all {
+			if _, ok := vars[cmp.field]; !ok {
+				return unknownField(cmp.field, vars)
+			}
+		}
+	}
+	return nil
+}
+
+func parseComparison(text string) (comparison, error) {
+	if text == "" {
+		return comparison{}, fmt.Errorf("missing comparison around && or ||")
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '"' || text[i] == '\'' || text[i] == '/' {
+			break // Operators inside values do not count
+		}
+		for _, op := range operators {
+			if !strings.HasPrefix(text[i:], op) {
+				continue
+			}
+			cmp := comparison{field: strings.TrimSpace(text[:i]), op: op, value: unquote(strings.TrimSpace(text[i+len(op):]))}
+			if cmp.field == "" {
+				return comparison{}, fmt.Errorf("missing field before %s", op)
+			}
+			if op == "~" || op == "!~" {
+				re, err := regexp.Compile(cmp.value)
+				if err != nil {
+					return comparison{}, fmt.Errorf("invalid pattern %q: %w", cmp.value, err)
+				}
+				cmp.re = re
+			}
+			return cmp, nil
+		}
+	}
+
+	// A lone field, possibly negated
+	cmp := comparison{field: text}
+	if strings.HasPrefix(text, "!") {
+		cmp.field, cmp.negate = strings.TrimSpace(text[1:]), true
+	}
+	if strings.ContainsAny(cmp.field, " \t") {
+		return comparison{}, fmt.Errorf("no operator in %q", text)
+	}
+	return cmp, nil
+}
+
+func (cmp comparison) eval(vars Vars) (bool, error) {
+	value, ok := vars[cmp.field]
+	if !ok {
+		return false, unknownField(cmp.field, vars)
+	}
+	text := fmt.Sprint(value)
+
+	switch cmp.op {
+	case "":
+		truthy := text != "" && text != "0" && text != "false"
+		return truthy != cmp.negate, nil
+	case "~":
+		return cmp.re.MatchString(text), nil
+	case "!~":
+		return !cmp.re.MatchString(text), nil
+	}
+
+	// Outputs such as "42\n" compare as numbers
+	left, leftErr := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	right, rightErr := strconv.ParseFloat(cmp.value, 64)
+	numeric := leftErr == nil && rightErr == nil
+	switch cmp.op {
+	case "==":
+		if numeric {
+			return left == right, nil
+		}
+		return text == cmp.value, nil
+	case "!=":
+		if numeric {
+			return left != right, nil
+		}
+		return text != cmp.value, nil
+	}
+	if !numeric {
+		return false, fmt.Errorf("%s %s %s compares %q, which is not a number", cmp.field, cmp.op, cmp.value, text)
+	}
+	switch cmp.op {
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	default:
+		return left >= right, nil
+	}
+}
+
+func unknownField(field string, vars Vars) error {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown field %q (known: %s)", field, strings.Join(names, ", "))
+}
+
+// splitOutside splits text at sep, except inside quotes and /patterns/
+func splitOutside(text, sep string) []string {
+	var parts []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || (c == '/' && opensPattern(text[:i])):
+			quote = c
+		case strings.HasPrefix(text[i:], sep):
+			parts = append(parts, text[start:i])
+			start = i + len(sep)
+			i += len(sep) - 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// opensPattern reports whether a / follows an operator, so it starts a
+// pattern rather than being part of a value such as a path
+func opensPattern(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	return strings.HasSuffix(before, "~")
+}
+
+// unquote strips the quotes or slashes around a value
+func unquote(value string) string {
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if (first == '"' || first == '\'' || first == '/') && first == last {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
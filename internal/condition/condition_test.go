@@ -0,0 +1,92 @@
+package condition
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func TestEval(t *testing.T) {
+	output := models.NewOutput("kubectl rollout status deploy/api", []byte("Waiting for rollout\n1 of 3 updated\n"), 1, 0)
+	output.Tokens = 1200
+	vars := EnvelopeVars("", output)
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		{"exit==0", false},
+		{"exit != 0", true},
+		{"exit_code == 1", true},
+		{"success", false},
+		{"!success", true},
+		{"tokens < 5000", true},
+		{"tokens >= 1200 && tokens <= 1200", true},
+		{"lines > 2", false},
+		{"output ~ /updated$/", false},
+		{"output ~ /(?m)updated$/", true},
+		{`output ~ "of 3"`, true},
+		{"output !~ /successfully rolled out/", true},
+		{"input == 'kubectl rollout status deploy/api'", true},
+		{"exit == 0 || output ~ /Waiting/", true},
+		{"exit == 0 || tokens > 5000 && success", false},
+		{"failure_reason == ''", true},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.condition)
+		if err != nil {
+			t.Errorf("Parse(%q) = %v", tt.condition, err)
+			continue
+		}
+		got, err := c.Eval(vars)
+		if err != nil || got != tt.want {
+			t.Errorf("Eval(%q) = %v, %v; want %v", tt.condition, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseKeepsOperatorsInPatterns(t *testing.T) {
+	c, err := Parse(`output ~ /Ready||Done/ && input ~ "a&&b"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.any) != 1 || len(c.any[0]) != 2 || c.any[0][0].value != "Ready||Done" || c.any[0][1].value != "a&&b" {
+		t.Errorf("Parse() = %+v", c.any)
+	}
+}
+
+func TestPrefixedVars(t *testing.T) {
+	probe := models.NewOutput("psql -c 'select count(*)'", []byte("42\n"), 0, 0)
+	probe.Tokens = 3
+	vars := EnvelopeVars("probe", probe).Merge(Vars{"mode": "fast"})
+
+	c, err := Parse("probe.tokens < 5000 && probe.success && probe.output < 100 && mode == fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Eval(vars); !ok || err != nil {
+		t.Errorf("Eval() = %v, %v; want true", ok, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, text := range []string{"", "exit == 0 &&", "== 0", "output ~ /(/", "exit is zero"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) should fail", text)
+		}
+	}
+
+	vars := EnvelopeVars("", models.NewOutput("ls", []byte("a\n"), 0, 0))
+	c, _ := Parse("exitcode == 0")
+	if err := c.Check(vars); err == nil || !strings.Contains(err.Error(), `unknown field "exitcode"`) {
+		t.Errorf("Check() = %v, want an unknown field error", err)
+	}
+	if _, err := c.Eval(vars); err == nil {
+		t.Error("Eval() of an unknown field should fail")
+	}
+	c, _ = Parse("output > 3")
+	if _, err := c.Eval(vars); err == nil {
+		t.Error("Eval() ordering a string should fail")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -19,20 +20,65 @@ type LimitsConfig struct {
 	MaxPipelineStages *int   `yaml:"max_pipeline_stages,omitempty"`
 }
 
+// HistoryConfig holds history retention settings. Pointers distinguish an
+// unset value (use the default) from zero (no limit).
+type HistoryConfig struct {
+	Location      string  `yaml:"location,omitempty"`        // "project" (.ctx/ at the git root) or "state" ($XDG_STATE_HOME/ctx)
+	MaxAge        *string `yaml:"max_age,omitempty"`         // e.g. "30d" or "720h"
+	FailureMaxAge *string `yaml:"failure_max_age,omitempty"` // Failed runs are kept this long instead
+	MaxRecords    *int    `yaml:"max_records,omitempty"`
+	MaxTotalBytes *int64  `yaml:"max_total_bytes,omitempty"`
+	Fsync         bool    `yaml:"fsync,omitempty"` // fsync every history write, for durability over speed
+}
+
+// CacheConfig holds result caching settings. Durations are strings such as
+// "30s"; an empty TTL caches nothing.
+type CacheConfig struct {
+	TTL   string      `yaml:"ttl,omitempty"`   // For read-only commands no rule matches
+	Env   []string    `yaml:"env,omitempty"`   // Variables that are part of every cache key
+	Rules []CacheRule `yaml:"rules,omitempty"` // Per-command settings; the first match wins
+}
+
+// CacheRule sets how commands starting with Match are cached
+type CacheRule struct {
+	Match string   `yaml:"match"`           // Leading words, e.g. "kubectl get"
+	TTL   string   `yaml:"ttl"`             // "0" never caches the command
+	Env   []string `yaml:"env,omitempty"`   // Variables that are part of its key
+	Files []string `yaml:"files,omitempty"` // Files whose modification times are part of its key, relative to the project root
+}
+
+// SessionConfig holds settings for agent sessions
+type SessionConfig struct {
+	Budget     int64   `yaml:"budget,omitempty"`      // Tokens a session may use before commands are refused; 0 means no budget
+	TokenPrice float64 `yaml:"token_price,omitempty"` // USD per million tokens for session costs; 0 uses the model's default
+}
+
 type Config struct {
-	TokenModel        string
-	DefaultTimeout    time.Duration
-	OutputFormat      string
-	PrettyOutput      bool
-	CacheDir          string
-	MaxTokens         int64 // This will be deprecated in favor of Limits.MaxTokens
-	NoTokens          bool
-	NoHistory         bool
-	NoTelemetry       bool
-	NoTelemetrySource string // New field to track the source
-	Limits            LimitsConfig
-	Auth              *AuthConfig         `yaml:"auth,omitempty"`
-	Installation      *InstallationConfig `yaml:"installation,omitempty"`
+	TokenModel             string   // Primary token model, used for the top-level token count and limits
+	TokenModels            []string // All token models to count with, primary first
+	DefaultTimeout         time.Duration
+	OutputFormat           string
+	PrettyOutput           bool
+	CacheDir               string
+	TokenizerOffline       bool              // Never download tokenizer assets; use only installed ones
+	HFTokenizers           map[string]string // Hugging Face tokenizer.json aliases, e.g. "llama3" -> path
+	TokenAccuracy          string            // exact, sampled, heuristic or auto (empty)
+	TokenAccuracyThreshold int64             // Output size in bytes above which auto accuracy stops counting exactly
+	ExplainTokens          bool              // Add a token_breakdown section to the envelope
+	Delta                  bool              // Print only what changed since the previous run of the command
+	Probe                  int64             // Withhold outputs of more tokens than this, printing a summary instead; 0 to print all
+	ProbeSpill             bool              // Write a withheld output to a file
+	MaxTokens              int64             // This will be deprecated in favor of Limits.MaxTokens
+	NoTokens               bool
+	NoHistory              bool
+	NoTelemetry            bool
+	NoTelemetrySource      string // New field to track the source
+	Limits                 LimitsConfig
+	History                HistoryConfig       // History retention
+	Session                SessionConfig       // Session budget and cost
+	Cache                  CacheConfig         // Result caching
+	Auth                   *AuthConfig         `yaml:"auth,omitempty"`
+	Installation           *InstallationConfig `yaml:"installation,omitempty"`
 }
 
 // InstallationConfig tracks how ctx was installed and update preferences
@@ -76,6 +122,7 @@ func Get() *Config {
 		OutputFormat: getEnvOrDefault("CTX_OUTPUT_FORMAT", defaultConfig.OutputFormat),
 		CacheDir:     getCacheDir(),
 	}
+	cfg.setTokenModels(cfg.TokenModel)
 
 	// Parse timeout
 	if timeoutStr := os.Getenv("CTX_TIMEOUT"); timeoutStr != "" {
@@ -140,15 +187,23 @@ func LoadConfigFromFile() (*Config, error) {
 
 	// Parse YAML
 	var fileConfig struct {
-		TokenModel     string       `yaml:"token_model,omitempty"`
-		DefaultTimeout string       `yaml:"default_timeout,omitempty"`
-		OutputFormat   string       `yaml:"output_format,omitempty"`
-		CacheDir       string       `yaml:"cache_dir,omitempty"`
-		NoTokens       bool         `yaml:"no_tokens,omitempty"`
-		NoHistory      bool         `yaml:"no_history,omitempty"`
-		NoTelemetry    bool         `yaml:"no_telemetry,omitempty"`
-		Limits         LimitsConfig `yaml:"limits,omitempty"`
-		Auth           *AuthConfig  `yaml:"auth,omitempty"`
+		TokenModel        string            `yaml:"token_model,omitempty"`
+		TokenModels       []string          `yaml:"token_models,omitempty"`
+		DefaultTimeout    string            `yaml:"default_timeout,omitempty"`
+		OutputFormat      string            `yaml:"output_format,omitempty"`
+		CacheDir          string            `yaml:"cache_dir,omitempty"`
+		TokenizerOffline  bool              `yaml:"tokenizer_offline,omitempty"`
+		HFTokenizers      map[string]string `yaml:"hf_tokenizers,omitempty"`
+		TokenAccuracy     string            `yaml:"token_accuracy,omitempty"`
+		AccuracyThreshold int64             `yaml:"token_accuracy_threshold,omitempty"`
+		NoTokens          bool              `yaml:"no_tokens,omitempty"`
+		NoHistory         bool              `yaml:"no_history,omitempty"`
+		NoTelemetry       bool              `yaml:"no_telemetry,omitempty"`
+		Limits            LimitsConfig      `yaml:"limits,omitempty"`
+		History           HistoryConfig     `yaml:"history,omitempty"`
+		Session           SessionConfig     `yaml:"session,omitempty"`
+		Cache             CacheConfig       `yaml:"cache,omitempty"`
+		Auth              *AuthConfig       `yaml:"auth,omitempty"`
 	}
 
 	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
@@ -161,6 +216,10 @@ func LoadConfigFromFile() (*Config, error) {
 	if fileConfig.TokenModel != "" {
 		cfg.TokenModel = fileConfig.TokenModel
 	}
+	// An explicit list takes precedence over the single model
+	if len(fileConfig.TokenModels) > 0 {
+		cfg.TokenModel = strings.Join(fileConfig.TokenModels, ",")
+	}
 	if fileConfig.OutputFormat != "" {
 		cfg.OutputFormat = fileConfig.OutputFormat
 	}
@@ -173,10 +232,17 @@ func LoadConfigFromFile() (*Config, error) {
 		}
 	}
 
+	cfg.TokenizerOffline = fileConfig.TokenizerOffline
+	cfg.HFTokenizers = fileConfig.HFTokenizers
+	cfg.TokenAccuracy = fileConfig.TokenAccuracy
+	cfg.TokenAccuracyThreshold = fileConfig.AccuracyThreshold
 	cfg.NoTokens = fileConfig.NoTokens
 	cfg.NoHistory = fileConfig.NoHistory
 	cfg.NoTelemetry = fileConfig.NoTelemetry
 	cfg.Limits = fileConfig.Limits
+	cfg.History = fileConfig.History
+	cfg.Session = fileConfig.Session
+	cfg.Cache = fileConfig.Cache
 	cfg.Auth = fileConfig.Auth
 
 	return cfg, nil
@@ -203,6 +269,18 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		if fileConfig.DefaultTimeout != 0 {
 			cfg.DefaultTimeout = fileConfig.DefaultTimeout
 		}
+		if fileConfig.TokenizerOffline {
+			cfg.TokenizerOffline = fileConfig.TokenizerOffline
+		}
+		if len(fileConfig.HFTokenizers) > 0 {
+			cfg.HFTokenizers = fileConfig.HFTokenizers
+		}
+		if fileConfig.TokenAccuracy != "" {
+			cfg.TokenAccuracy = fileConfig.TokenAccuracy
+		}
+		if fileConfig.TokenAccuracyThreshold > 0 {
+			cfg.TokenAccuracyThreshold = fileConfig.TokenAccuracyThreshold
+		}
 		if fileConfig.NoTokens {
 			cfg.NoTokens = fileConfig.NoTokens
 		}
@@ -214,8 +292,11 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 			cfg.NoTelemetrySource = "config file"
 		}
 
-		// Merge limits
+		// Merge limits, history retention, sessions and caching
 		cfg.Limits = fileConfig.Limits
+		cfg.History = fileConfig.History
+		cfg.Session = fileConfig.Session
+		cfg.Cache = fileConfig.Cache
 
 		// Also set the deprecated MaxTokens if provided in Limits
 		if fileConfig.Limits.MaxTokens != nil {
@@ -268,6 +349,18 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		}
 	}
 
+	if os.Getenv("CTX_TOKENIZER_OFFLINE") == "true" {
+		cfg.TokenizerOffline = true
+	}
+	if val := os.Getenv("CTX_TOKEN_ACCURACY"); val != "" {
+		cfg.TokenAccuracy = val
+	}
+	if val := os.Getenv("CTX_TOKEN_ACCURACY_THRESHOLD"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n > 0 {
+			cfg.TokenAccuracyThreshold = n
+		}
+	}
+
 	// Handle other env-based configs
 	cfg.NoHistory = isPrivateEnv || noHistoryEnv
 	cfg.NoTokens = noTokensEnv
@@ -281,6 +374,13 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		}
 	}
 
+	// Handle the probe threshold from environment
+	if probeStr := os.Getenv("CTX_PROBE"); probeStr != "" {
+		if p, err := strconv.ParseInt(probeStr, 10, 64); err == nil && p > 0 {
+			cfg.Probe = p
+		}
+	}
+
 	// Handle other limit environment variables
 	if maxOutputBytesStr := os.Getenv("CTX_MAX_OUTPUT_BYTES"); maxOutputBytesStr != "" {
 		if mob, err := strconv.ParseInt(maxOutputBytesStr, 10, 64); err == nil && mob > 0 {
@@ -300,6 +400,47 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		}
 	}
 
+	// Handle the cache TTL environment variable
+	if val := os.Getenv("CTX_CACHE_TTL"); val != "" {
+		cfg.Cache.TTL = val
+	}
+
+	// Handle session environment variables
+	if val := os.Getenv("CTX_SESSION_BUDGET"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+			cfg.Session.Budget = n
+		}
+	}
+	if val := os.Getenv("CTX_TOKEN_PRICE"); val != "" {
+		if price, err := strconv.ParseFloat(val, 64); err == nil && price >= 0 {
+			cfg.Session.TokenPrice = price
+		}
+	}
+
+	// Handle history location, durability and retention environment variables
+	if val := os.Getenv("CTX_HISTORY_LOCATION"); val != "" {
+		cfg.History.Location = val
+	}
+	if os.Getenv("CTX_HISTORY_FSYNC") == "true" {
+		cfg.History.Fsync = true
+	}
+	if val := os.Getenv("CTX_HISTORY_MAX_AGE"); val != "" {
+		cfg.History.MaxAge = &val
+	}
+	if val := os.Getenv("CTX_HISTORY_FAILURE_MAX_AGE"); val != "" {
+		cfg.History.FailureMaxAge = &val
+	}
+	if val := os.Getenv("CTX_HISTORY_MAX_RECORDS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n >= 0 {
+			cfg.History.MaxRecords = &n
+		}
+	}
+	if val := os.Getenv("CTX_HISTORY_MAX_TOTAL_BYTES"); val != "" {
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && n >= 0 {
+			cfg.History.MaxTotalBytes = &n
+		}
+	}
+
 	// Handle API endpoint from environment (overrides file config)
 	if apiEndpoint := os.Getenv("CTX_API_ENDPOINT"); apiEndpoint != "" {
 		if cfg.Auth == nil {
@@ -326,6 +467,21 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 	if cmd.Flags().Changed("pretty") {
 		cfg.PrettyOutput, _ = cmd.Flags().GetBool("pretty")
 	}
+	if cmd.Flags().Changed("explain-tokens") {
+		cfg.ExplainTokens, _ = cmd.Flags().GetBool("explain-tokens")
+	}
+	if cmd.Flags().Changed("delta") {
+		cfg.Delta, _ = cmd.Flags().GetBool("delta")
+	}
+	if cmd.Flags().Changed("probe") {
+		cfg.Probe, _ = cmd.Flags().GetInt64("probe")
+	}
+	if cmd.Flags().Changed("probe-spill") {
+		cfg.ProbeSpill, _ = cmd.Flags().GetBool("probe-spill")
+	}
+	if cmd.Flags().Changed("token-accuracy") {
+		cfg.TokenAccuracy, _ = cmd.Flags().GetString("token-accuracy")
+	}
 	if cmd.Flags().Changed("max-tokens") {
 		mt, _ := cmd.Flags().GetInt64("max-tokens")
 		cfg.MaxTokens = mt
@@ -335,6 +491,15 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		}
 	}
 
+	if cmd.Flags().Changed("cache-ttl") {
+		ttl, _ := cmd.Flags().GetDuration("cache-ttl")
+		cfg.Cache.TTL = ttl.String()
+	}
+
+	if cmd.Flags().Changed("session-budget") {
+		cfg.Session.Budget, _ = cmd.Flags().GetInt64("session-budget")
+	}
+
 	if cmd.Flags().Changed("max-output-bytes") {
 		mob, _ := cmd.Flags().GetInt64("max-output-bytes")
 		if mob > 0 {
@@ -390,9 +555,39 @@ func NewFromFlagsAndEnv(cmd *cobra.Command) *Config {
 		cfg.NoTelemetrySource = SourceDefault
 	}
 
+	// Split a comma-separated token model list into primary and additional models
+	cfg.setTokenModels(cfg.TokenModel)
+
 	return cfg
 }
 
+// ParseTokenModels splits a comma-separated list of token models (e.g.
+// "anthropic,openai,gemini"), trimming whitespace and dropping duplicates.
+func ParseTokenModels(value string) []string {
+	var models []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		model := strings.TrimSpace(part)
+		if model == "" || seen[strings.ToLower(model)] {
+			continue
+		}
+		seen[strings.ToLower(model)] = true
+		models = append(models, model)
+	}
+	return models
+}
+
+// setTokenModels populates TokenModels from a comma-separated value and
+// makes TokenModel the primary (first) model.
+func (c *Config) setTokenModels(value string) {
+	c.TokenModels = ParseTokenModels(value)
+	if len(c.TokenModels) > 0 {
+		c.TokenModel = c.TokenModels[0]
+	} else {
+		c.TokenModel = ""
+	}
+}
+
 // getConfigPath returns the path to the config file
 func getConfigPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
@@ -417,32 +612,52 @@ func (c *Config) SaveConfig() error {
 
 	// Convert to file config struct (for YAML serialization)
 	fileConfig := struct {
-		TokenModel   string              `yaml:"token_model,omitempty"`
-		Timeout      string              `yaml:"timeout,omitempty"`
-		OutputFormat string              `yaml:"output_format,omitempty"`
-		PrettyOutput bool                `yaml:"pretty_output,omitempty"`
-		NoTokens     bool                `yaml:"no_tokens,omitempty"`
-		NoHistory    bool                `yaml:"no_history,omitempty"`
-		NoTelemetry  bool                `yaml:"no_telemetry,omitempty"`
-		Limits       LimitsConfig        `yaml:"limits,omitempty"`
-		Auth         *AuthConfig         `yaml:"auth,omitempty"`
-		Installation *InstallationConfig `yaml:"installation,omitempty"`
+		TokenModel        string              `yaml:"token_model,omitempty"`
+		TokenModels       []string            `yaml:"token_models,omitempty"`
+		Timeout           string              `yaml:"timeout,omitempty"`
+		OutputFormat      string              `yaml:"output_format,omitempty"`
+		PrettyOutput      bool                `yaml:"pretty_output,omitempty"`
+		Offline           bool                `yaml:"tokenizer_offline,omitempty"`
+		HFTokenizers      map[string]string   `yaml:"hf_tokenizers,omitempty"`
+		Accuracy          string              `yaml:"token_accuracy,omitempty"`
+		AccuracyThreshold int64               `yaml:"token_accuracy_threshold,omitempty"`
+		NoTokens          bool                `yaml:"no_tokens,omitempty"`
+		NoHistory         bool                `yaml:"no_history,omitempty"`
+		NoTelemetry       bool                `yaml:"no_telemetry,omitempty"`
+		Limits            LimitsConfig        `yaml:"limits,omitempty"`
+		History           HistoryConfig       `yaml:"history,omitempty"`
+		Session           SessionConfig       `yaml:"session,omitempty"`
+		Cache             CacheConfig         `yaml:"cache,omitempty"`
+		Auth              *AuthConfig         `yaml:"auth,omitempty"`
+		Installation      *InstallationConfig `yaml:"installation,omitempty"`
 	}{
-		TokenModel:   c.TokenModel,
-		OutputFormat: c.OutputFormat,
-		PrettyOutput: c.PrettyOutput,
-		NoTokens:     c.NoTokens,
-		NoHistory:    c.NoHistory,
-		NoTelemetry:  c.NoTelemetry,
-		Limits:       c.Limits,
-		Auth:         c.Auth,
-		Installation: c.Installation,
+		TokenModel:        c.TokenModel,
+		OutputFormat:      c.OutputFormat,
+		PrettyOutput:      c.PrettyOutput,
+		Offline:           c.TokenizerOffline,
+		HFTokenizers:      c.HFTokenizers,
+		Accuracy:          c.TokenAccuracy,
+		AccuracyThreshold: c.TokenAccuracyThreshold,
+		NoTokens:          c.NoTokens,
+		NoHistory:         c.NoHistory,
+		NoTelemetry:       c.NoTelemetry,
+		Limits:            c.Limits,
+		History:           c.History,
+		Session:           c.Session,
+		Cache:             c.Cache,
+		Auth:              c.Auth,
+		Installation:      c.Installation,
 	}
 
 	if c.DefaultTimeout > 0 {
 		fileConfig.Timeout = c.DefaultTimeout.String()
 	}
 
+	// Only persist the list when more than one model is configured
+	if len(c.TokenModels) > 1 {
+		fileConfig.TokenModels = c.TokenModels
+	}
+
 	data, err := yaml.Marshal(fileConfig)
 	if err != nil {
 		return err
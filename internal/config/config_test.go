@@ -0,0 +1,36 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTokenModels(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"anthropic", []string{"anthropic"}},
+		{" anthropic , openai,gemini ", []string{"anthropic", "openai", "gemini"}},
+		{"openai,,OpenAI,anthropic,openai", []string{"openai", "anthropic"}},
+		{"hf:/models/llama.json,anthropic", []string{"hf:/models/llama.json", "anthropic"}},
+	}
+	for _, tt := range tests {
+		if got := ParseTokenModels(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTokenModels(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSetTokenModelsPrimary(t *testing.T) {
+	var cfg Config
+	cfg.setTokenModels("gemini, anthropic")
+	if cfg.TokenModel != "gemini" || len(cfg.TokenModels) != 2 {
+		t.Errorf("TokenModel = %q, TokenModels = %q; want gemini first", cfg.TokenModel, cfg.TokenModels)
+	}
+	cfg.setTokenModels(" , ")
+	if cfg.TokenModel != "" || cfg.TokenModels != nil {
+		t.Errorf("empty list: TokenModel = %q, TokenModels = %q", cfg.TokenModel, cfg.TokenModels)
+	}
+}
@@ -17,8 +17,22 @@ type EnvVar struct {
 var EnvVars = []EnvVar{
 	{
 		Name:        "CTX_TOKEN_MODEL",
-		Description: "Sets the default token counting provider",
-		Example:     "\"anthropic\", \"openai\", \"gemini\"",
+		Description: "Sets the default token counting provider, or a comma-separated list to count with several models",
+		Example:     "\"anthropic\", \"openai\", \"gemini\", \"anthropic,openai\"",
+	},
+	{
+		Name:        "CTX_TOKENIZER_OFFLINE",
+		Description: "If \"true\", never downloads tokenizer files; install them with 'ctx tokenizer fetch' or 'ctx tokenizer import'",
+	},
+	{
+		Name:        "CTX_TOKEN_ACCURACY",
+		Description: "Sets how tokens are counted: exact, sampled (extrapolated from a sample), heuristic (bytes-per-token ratio) or auto",
+		Example:     "\"sampled\"",
+	},
+	{
+		Name:        "CTX_TOKEN_ACCURACY_THRESHOLD",
+		Description: "Output size in bytes above which auto accuracy switches from exact to sampled counting",
+		Example:     "\"8388608\" for 8MB (default)",
 	},
 	{
 		Name:        "CTX_NO_TOKENS",
@@ -33,6 +47,11 @@ var EnvVars = []EnvVar{
 		Description: "Sets the maximum number of tokens allowed in output",
 		Example:     "\"5000\"",
 	},
+	{
+		Name:        "CTX_PROBE",
+		Description: "Withholds outputs of more tokens than this, printing their size, first and last lines and structure instead",
+		Example:     "\"2000\"",
+	},
 	{
 		Name:        "CTX_MAX_OUTPUT_BYTES",
 		Description: "Sets the maximum number of bytes allowed in output",
@@ -52,6 +71,55 @@ var EnvVars = []EnvVar{
 		Name:        "CTX_NO_HISTORY",
 		Description: "If \"true\", disables command history recording",
 	},
+	{
+		Name:        "CTX_HISTORY_LOCATION",
+		Description: "Where history is kept: \"project\" (.ctx/ at the git root) or \"state\" ($XDG_STATE_HOME/ctx, keyed by project)",
+		Example:     "\"state\"",
+	},
+	{
+		Name:        "CTX_HISTORY_FSYNC",
+		Description: "If \"true\", fsyncs every history write so recorded runs survive a crash, at the cost of slower saves",
+	},
+	{
+		Name:        "CTX_HISTORY_MAX_AGE",
+		Description: "Removes successful runs from history after this age; \"0\" keeps them",
+		Example:     "\"30d\", \"12h\" (unset: kept; 30d for ctx history prune)",
+	},
+	{
+		Name:        "CTX_HISTORY_FAILURE_MAX_AGE",
+		Description: "Keeps failed runs in history this long instead",
+		Example:     "\"90d\" (unset: kept; 90d for ctx history prune)",
+	},
+	{
+		Name:        "CTX_HISTORY_MAX_RECORDS",
+		Description: "Sets the maximum number of runs kept in history; the oldest successful runs go first",
+		Example:     "\"5000\" (unset: no limit; 5000 for ctx history prune)",
+	},
+	{
+		Name:        "CTX_HISTORY_MAX_TOTAL_BYTES",
+		Description: "Sets the maximum size of the history log in bytes",
+		Example:     "\"104857600\" for 100MB (unset: no limit; 100MB for ctx history prune)",
+	},
+	{
+		Name:        "CTX_CACHE_TTL",
+		Description: "Reuses the result of an identical read-only command run within this time instead of running it again",
+		Example:     "\"30s\", \"5m\"",
+	},
+	{
+		Name:        "CTX_SESSION_ID",
+		Description: "Groups runs into a session whose tokens, cost and commands add up, instead of the session begun with 'ctx session start'",
+		Example:     "\"review-1234\"",
+	},
+	{
+		Name:        "CTX_SESSION_BUDGET",
+		Description: "Sets the tokens a session may use; new commands are refused once they are spent",
+		Example:     "\"200000\"",
+	},
+	{
+		Name:        "CTX_TOKEN_PRICE",
+		Description: "Sets the price in USD per million tokens for session costs, instead of the token model's default",
+		Example:     "\"3.00\"",
+	},
 	{
 		Name:        "CTX_NO_TELEMETRY",
 		Description: "If \"true\", disables OpenTelemetry tracing",
@@ -0,0 +1,219 @@
+// Package diff compares command outputs line by line, word by word, or
+// value by value when they are JSON, and formats the changes for agents.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is what an edit does to a line
+type Op int
+
+const (
+	Equal  Op = iota // The line is in both texts
+	Delete           // The line is only in the old text
+	Insert           // The line is only in the new text
+)
+
+// Edit is one line of a diff
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// maxEdits bounds the work of the diff: texts that differ in more lines
+// are reported as replaced entirely rather than diffed line by line
+const maxEdits = 4000
+
+// SplitLines splits text into lines without their newlines. A final newline
+// does not start another line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the edits that turn a into b, using Myers' algorithm so
+// the diff has as few changed lines as possible
+func Lines(a, b []string) []Edit {
+	// Common leading and trailing lines need no search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	edits := make([]Edit, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	edits = append(edits, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		edits = append(edits, Edit{Equal, line})
+	}
+	return edits
+}
+
+// myers finds a shortest edit script. Past maxEdits it gives up and
+// replaces a with b.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int // v after each round d, for k in -d..d
+
+	for d := 0; d <= limit; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insert b[y]
+			} else {
+				x = v[offset+k-1] + 1 // Right: delete a[x]
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+				return backtrack(a, b, trace)
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	edits := make([]Edit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, Edit{Delete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, Edit{Insert, line})
+	}
+	return edits
+}
+
+// backtrack walks the trace from the end of both texts to their start
+func backtrack(a, b []string, trace [][]int) []Edit {
+	x, y := len(a), len(b)
+	var edits []Edit
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1] // Indexed by k + d - 1
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			prevK = k + 1
+		}
+		prevX := prev[prevK+d-1]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, Edit{Equal, a[x]})
+		}
+		if prevK == k+1 {
+			edits = append(edits, Edit{Insert, b[prevY]})
+		} else {
+			edits = append(edits, Edit{Delete, a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, Edit{Equal, a[x]})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Stats counts the inserted and deleted lines of a diff
+func Stats(edits []Edit) (added, removed int) {
+	for _, e := range edits {
+		switch e.Op {
+		case Insert:
+			added++
+		case Delete:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// Unified formats edits as a unified diff with the given lines of context
+// around each change, headed by the names of the old and new texts unless
+// both are empty. It returns "" when nothing changed.
+func Unified(edits []Edit, from, to string, context int) string {
+	// Line numbers in the old and new texts where each edit starts
+	aLine := make([]int, len(edits)+1)
+	bLine := make([]int, len(edits)+1)
+	var changes []int
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.Op != Insert {
+			aLine[i+1]++
+		}
+		if e.Op != Delete {
+			bLine[i+1]++
+		}
+		if e.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	if from != "" || to != "" {
+		fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+	}
+	for i := 0; i < len(changes); {
+		// Changes closer than twice the context share a hunk
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*context+1 {
+			j++
+		}
+		start := max(changes[i]-context, 0)
+		end := min(changes[j]+context+1, len(edits))
+
+		aCount, bCount := aLine[end]-aLine[start], bLine[end]-bLine[start]
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(aLine[start], aCount), hunkRange(bLine[start], bCount))
+		for _, e := range edits[start:end] {
+			switch e.Op {
+			case Equal:
+				sb.WriteByte(' ')
+			case Delete:
+				sb.WriteByte('-')
+			case Insert:
+				sb.WriteByte('+')
+			}
+			sb.WriteString(e.Line)
+			sb.WriteByte('\n')
+		}
+		i = j + 1
+	}
+	return sb.String()
+}
+
+// hunkRange formats the start and length of a hunk side. An empty side
+// names the line before it, as diff(1) does.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,132 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+// apply rebuilds both texts from a diff
+func apply(edits []Edit) (a, b []string) {
+	for _, e := range edits {
+		if e.Op != Insert {
+			a = append(a, e.Line)
+		}
+		if e.Op != Delete {
+			b = append(b, e.Line)
+		}
+	}
+	return a, b
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name           string
+		a, b           string
+		added, removed int
+	}{
+		{"same", "a\nb\nc\n", "a\nb\nc\n", 0, 0},
+		{"empty to text", "", "a\nb\n", 2, 0},
+		{"text to empty", "a\nb\n", "", 0, 2},
+		{"changed line", "a\nb\nc\n", "a\nx\nc\n", 1, 1},
+		{"insert in middle", "a\nc\n", "a\nb\nc\n", 1, 0},
+		{"classic", "a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 2, 3},
+		{"pods", "api-1 Running\ndb-0 Running\nweb-2 Pending\n", "api-1 Running\ndb-0 Running\nweb-2 Running\nweb-3 Pending\n", 2, 1},
+	}
+	for _, tt := range tests {
+		a, b := SplitLines(tt.a), SplitLines(tt.b)
+		edits := Lines(a, b)
+		gotA, gotB := apply(edits)
+		if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+			t.Errorf("%s: edits do not rebuild the texts: %v", tt.name, edits)
+		}
+		if added, removed := Stats(edits); added != tt.added || removed != tt.removed {
+			t.Errorf("%s: Stats() = +%d -%d, want +%d -%d", tt.name, added, removed, tt.added, tt.removed)
+		}
+	}
+}
+
+func TestLinesTooDifferent(t *testing.T) {
+	var a, b []string
+	for i := 0; i < maxEdits; i++ {
+		a = append(a, "a"+strings.Repeat("x", i%7))
+		b = append(b, "b"+strings.Repeat("y", i%5))
+	}
+	edits := Lines(a, b)
+	if added, removed := Stats(edits); added != len(b) || removed != len(a) {
+		t.Errorf("Stats() = +%d -%d, want everything replaced", added, removed)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := SplitLines("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	b := SplitLines("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n")
+	want := `--- old
++++ new
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+@@ -10 +10,2 @@
+ 10
++11
+`
+	if got := Unified(Lines(a, b), "old", "new", 1); got != want {
+		t.Errorf("Unified() =\n%s\nwant\n%s", got, want)
+	}
+	if got := Unified(Lines(a, a), "old", "new", 3); got != "" {
+		t.Errorf("Unified() of equal texts = %q, want empty", got)
+	}
+	if got := Unified(Lines(nil, []string{"x"}), "old", "new", 3); !strings.Contains(got, "@@ -0,0 +1 @@") {
+		t.Errorf("Unified() from empty = %q", got)
+	}
+	if got := Unified(Lines(a, b), "", "", 0); !strings.HasPrefix(got, "@@ -3 +3 @@\n-3\n+three\n") {
+		t.Errorf("Unified() without names = %q", got)
+	}
+}
+
+func TestWords(t *testing.T) {
+	if got := strings.Join(SplitWords("a  b\tc\n"), "|"); got != "a|  |b|\t|c|\n" {
+		t.Errorf("SplitWords() = %q", got)
+	}
+
+	a := "PASS TestA 0.01s\nFAIL TestB 0.20s\nok pkg 1.2s\n"
+	b := "PASS TestA 0.01s\nPASS TestB 0.18s\nok pkg 1.1s\n"
+	edits := Words(a, b)
+	if added, removed := WordStats(edits); added != 3 || removed != 3 {
+		t.Errorf("WordStats() = +%d -%d, want +3 -3", added, removed)
+	}
+	want := "[-FAIL-]{+PASS+} TestB [-0.20s-]{+0.18s+}\nok pkg [-1.2s-]{+1.1s+}\n"
+	if got := WordDiff(edits); got != want {
+		t.Errorf("WordDiff() =\n%s\nwant\n%s", got, want)
+	}
+	if got := WordDiff(Words(a, a)); got != "" {
+		t.Errorf("WordDiff() of equal texts = %q", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	a, ok := ParseJSON(`{"status": "pending", "replicas": 2, "pods": ["a", "b"], "old": true, "odd key": 1}`)
+	if !ok {
+		t.Fatal("ParseJSON() rejected an object")
+	}
+	b, _ := ParseJSON(`{"status": "ready", "replicas": 2, "pods": ["a", "b", "c"], "new": null, "odd key": 1}`)
+
+	want := `+ .new: null
+- .old: true
++ .pods[2]: "c"
+~ .status: "ready" (was "pending")
+`
+	if got := FormatJSON(JSON(a, b)); got != want {
+		t.Errorf("FormatJSON() =\n%s\nwant\n%s", got, want)
+	}
+	if changes := JSON(a, a); len(changes) != 0 {
+		t.Errorf("JSON() of equal documents = %v", changes)
+	}
+
+	for _, text := range []string{"plain text", "42", `{"a": 1} {"b": 2}`, `{"a":`} {
+		if _, ok := ParseJSON(text); ok {
+			t.Errorf("ParseJSON(%q) accepted a text that is not one object or array", text)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Change is a value that differs between two JSON documents
+type Change struct {
+	Path string // Where the value is, e.g. .items[2].status
+	Op   string // "added", "removed" or "changed"
+	From any    // The old value, unless added
+	To   any    // The new value, unless removed
+}
+
+// ParseJSON parses text that is a single JSON object or array, keeping
+// numbers as written
+func ParseJSON(text string) (any, bool) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return nil, false
+	}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// JSON compares two parsed JSON documents value by value. Objects are
+// compared by key and arrays by index.
+func JSON(a, b any) []Change {
+	var changes []Change
+	compareJSON("", a, b, &changes)
+	return changes
+}
+
+func compareJSON(path string, a, b any, changes *[]Change) {
+	switch av := a.(type) {
+	case map[string]any:
+		if bv, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(av)+len(bv))
+			for k := range av {
+				keys = append(keys, k)
+			}
+			for k := range bv {
+				if _, ok := av[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				old, inA := av[k]
+				updated, inB := bv[k]
+				switch {
+				case !inB:
+					*changes = append(*changes, Change{Path: keyPath(path, k), Op: "removed", From: old})
+				case !inA:
+					*changes = append(*changes, Change{Path: keyPath(path, k), Op: "added", To: updated})
+				default:
+					compareJSON(keyPath(path, k), old, updated, changes)
+				}
+			}
+			return
+		}
+	case []any:
+		if bv, ok := b.([]any); ok {
+			for i := 0; i < max(len(av), len(bv)); i++ {
+				elem := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(bv):
+					*changes = append(*changes, Change{Path: elem, Op: "removed", From: av[i]})
+				case i >= len(av):
+					*changes = append(*changes, Change{Path: elem, Op: "added", To: bv[i]})
+				default:
+					compareJSON(elem, av[i], bv[i], changes)
+				}
+			}
+			return
+		}
+	}
+	if !jsonEqual(a, b) {
+		if path == "" {
+			path = "."
+		}
+		*changes = append(*changes, Change{Path: path, Op: "changed", From: a, To: b})
+	}
+}
+
+var plainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func keyPath(path, key string) string {
+	if plainKey.MatchString(key) {
+		return path + "." + key
+	}
+	quoted, _ := json.Marshal(key)
+	return path + "[" + string(quoted) + "]"
+}
+
+func jsonEqual(a, b any) bool {
+	return compactJSON(a) == compactJSON(b)
+}
+
+func compactJSON(value any) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// FormatJSON lists changes one per line: "~ path: new (was old)" for
+// changed values, "+ path: new" for added and "- path: old" for removed
+// ones. It avoids "<" and ">", which JSON envelopes escape.
+func FormatJSON(changes []Change) string {
+	var sb strings.Builder
+	for _, c := range changes {
+		switch c.Op {
+		case "added":
+			fmt.Fprintf(&sb, "+ %s: %s\n", c.Path, compactJSON(c.To))
+		case "removed":
+			fmt.Fprintf(&sb, "- %s: %s\n", c.Path, compactJSON(c.From))
+		default:
+			fmt.Fprintf(&sb, "~ %s: %s (was %s)\n", c.Path, compactJSON(c.To), compactJSON(c.From))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,86 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitWords splits text into words and the whitespace between them, so
+// that joining the parts gives the text back
+func SplitWords(text string) []string {
+	var words []string
+	start, prevSpace := 0, false
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if i > start && space != prevSpace {
+			words = append(words, text[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// Words returns the edits that turn text a into text b word by word
+func Words(a, b string) []Edit {
+	return Lines(SplitWords(a), SplitWords(b))
+}
+
+// WordStats counts the words, not the whitespace, inserted and deleted by
+// a word diff
+func WordStats(edits []Edit) (added, removed int) {
+	for _, e := range edits {
+		if strings.TrimSpace(e.Line) == "" {
+			continue
+		}
+		switch e.Op {
+		case Insert:
+			added++
+		case Delete:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// WordDiff formats a word diff as the new text's lines that changed, with
+// removed words in [-...-] and added words in {+...+}, as git diff
+// --word-diff=plain does
+func WordDiff(edits []Edit) string {
+	var sb strings.Builder
+	for i := 0; i < len(edits); {
+		op := edits[i].Op
+		j := i
+		for j < len(edits) && edits[j].Op == op {
+			j++
+		}
+		var run strings.Builder
+		for _, e := range edits[i:j] {
+			run.WriteString(e.Line)
+		}
+		switch op {
+		case Equal:
+			sb.WriteString(run.String())
+		case Delete:
+			sb.WriteString("[-" + run.String() + "-]")
+		case Insert:
+			sb.WriteString("{+" + run.String() + "+}")
+		}
+		i = j
+	}
+
+	var changed []string
+	for _, line := range SplitLines(sb.String()) {
+		if strings.Contains(line, "[-") || strings.Contains(line, "{+") ||
+			strings.Contains(line, "-]") || strings.Contains(line, "+}") {
+			changed = append(changed, line)
+		}
+	}
+	if len(changed) == 0 {
+		return ""
+	}
+	return strings.Join(changed, "\n") + "\n"
+}
@@ -4,33 +4,44 @@ import (
 	"context"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/slavakurilyak/ctx/internal/config"
 	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/explain"
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
 	"github.com/slavakurilyak/ctx/internal/history"
 	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/session"
 	"github.com/slavakurilyak/ctx/internal/telemetry"
 	"github.com/slavakurilyak/ctx/internal/tokenizer"
 )
 
 // Enricher handles output enrichment with injected dependencies
 type Enricher struct {
-	tokenizer tokenizer.Tokenizer
-	history   *history.HistoryManager
-	telemetry *telemetry.Manager
-	config    *config.Config
+	tokenizer  tokenizer.Tokenizer
+	tokenizers []tokenizer.Tokenizer // All models to count with (primary included) when more than one is configured
+	tokErr     error                 // Why the primary tokenizer is unavailable, if it is
+	history    *history.HistoryManager
+	sessions   *session.Manager
+	sessionID  string // Current agent session; empty outside one
+	telemetry  *telemetry.Manager
+	config     *config.Config
 }
 
 // NewEnricher creates a new enricher with the given dependencies
-func NewEnricher(tok tokenizer.Tokenizer, hist *history.HistoryManager, tel *telemetry.Manager, cfg *config.Config) *Enricher {
-	return &Enricher{
+func NewEnricher(tok tokenizer.Tokenizer, hist *history.HistoryManager, tel *telemetry.Manager, cfg *config.Config, opts ...Option) *Enricher {
+	e := &Enricher{
 		tokenizer: tok,
 		history:   hist,
 		telemetry: tel,
 		config:    cfg,
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 // EnrichOutput enriches the execution result with metadata and token counts
@@ -42,9 +53,105 @@ func (e *Enricher) EnrichOutput(ctx context.Context, result *executor.ExecutionR
 		result.Duration,
 	)
 
-	// Populate metadata context fields
+	e.EnrichContext(ctx, output)
+	output.Metadata.Fingerprint = fingerprint.Of(result.Command)
+
+	// Count tokens if enabled and tokenizer is available. Large outputs may be
+	// estimated instead of tokenized, depending on the accuracy mode.
+	e.countTokens(output, string(result.Output))
+
+	// Explain where the tokens go when asked to
+	if e.shouldCountTokens() && e.config != nil && e.config.ExplainTokens && e.tokenizer != nil {
+		mode, threshold := e.tokenAccuracy()
+		if breakdown, err := explain.AnalyzeWithAccuracy(e.tokenizer, result.Command, string(result.Output), mode, threshold); err == nil {
+			output.TokenBreakdown = breakdown
+		}
+	}
+
+	// Make a missing tokenizer visible instead of silently reporting zero tokens
+	if e.shouldCountTokens() && e.tokenizer == nil {
+		output.Metadata.TokenizerStatus = "unavailable"
+		if e.tokErr != nil {
+			output.Metadata.TokenizerError = e.tokErr.Error()
+		}
+	}
+
+	return output, nil
+}
+
+// countTokens sets the token counts of an output for text
+func (e *Enricher) countTokens(output *models.Output, text string) {
+	mode, threshold := e.tokenAccuracy()
+	if e.shouldCountTokens() && len(e.tokenizers) > 1 {
+		// Count once per model in parallel; the primary model stays the top-level count
+		estimates, errs := e.countTokensByModel(text, mode, threshold)
+		output.TokensByModel = make(map[string]int, len(estimates))
+		for model, estimate := range estimates {
+			output.TokensByModel[model] = estimate.Tokens
+		}
+		if e.tokenizer != nil {
+			primary := e.tokenizer.GetModelName()
+			if estimate, ok := estimates[primary]; ok {
+				output.Tokens = estimate.Tokens
+				output.TokenEstimate = NewTokenEstimate(estimate)
+			} else if err := errs[primary]; err != nil {
+				// The other models' counts are no substitute for the primary's
+				output.Metadata.TokenizerStatus = "error"
+				output.Metadata.TokenizerError = err.Error()
+			}
+		}
+	} else if e.shouldCountTokens() && e.tokenizer != nil {
+		estimate, err := tokenizer.CountWithAccuracy(e.tokenizer, text, mode, threshold)
+		if err == nil {
+			output.Tokens = estimate.Tokens
+			output.TokenEstimate = NewTokenEstimate(estimate)
+		} else {
+			// We still return the output, but say why tokens are missing
+			output.Metadata.TokenizerStatus = "error"
+			output.Metadata.TokenizerError = err.Error()
+		}
+	}
+}
+
+// Recount counts the tokens of an output again after its text was replaced,
+// as --delta does
+func (e *Enricher) Recount(output *models.Output) {
+	output.Tokens, output.TokensByModel, output.TokenEstimate = 0, nil, nil
+	e.countTokens(output, output.Output)
+}
+
+// Record counts a finished envelope in the current session and saves it to
+// history, if history is enabled. Call it once limit checks have set the
+// failure details, so history holds the envelope as printed. A record that
+// cannot be saved is reported in metadata.warnings instead of failing the run.
+// An envelope reused from the cache is only counted in the session.
+func (e *Enricher) Record(output *models.Output) {
+	e.RecordAs(output, output)
+}
+
+// RecordAs counts the printed envelope in the session but saves another to
+// history: --delta prints what changed and saves the full output, which the
+// next run is compared with
+func (e *Enricher) RecordAs(printed, saved *models.Output) {
+	e.recordSession(printed)
+	saved.Session = printed.Session
+	if e.history == nil {
+		return
+	}
+	if cache := saved.Metadata.Cache; cache != nil && cache.Hit {
+		return // Already saved by the run it was reused from
+	}
+	if err := e.history.SaveRecord(saved); err != nil {
+		printed.Metadata.Warnings = append(printed.Metadata.Warnings, "history: "+err.Error())
+	}
+	printed.Metadata.HistoryID = saved.Metadata.HistoryID
+}
+
+// EnrichContext populates the metadata context fields (timestamp, session,
+// directory, user, host) and the trace context of an output
+func (e *Enricher) EnrichContext(ctx context.Context, output *models.Output) {
 	output.Metadata.Timestamp = time.Now().Format(time.RFC3339)
-	output.Metadata.SessionID = uuid.New().String()
+	output.Metadata.SessionID = e.sessionID
 
 	// Get working directory
 	if cwd, err := os.Getwd(); err == nil {
@@ -61,15 +168,6 @@ func (e *Enricher) EnrichOutput(ctx context.Context, result *executor.ExecutionR
 		output.Metadata.Host = hostname
 	}
 
-	// Count tokens if enabled and tokenizer is available
-	if e.shouldCountTokens() && e.tokenizer != nil {
-		tokenCount, err := e.tokenizer.CountTokens(string(result.Output))
-		if err == nil {
-			output.Tokens = tokenCount
-		}
-		// If token counting fails, we still return the output without tokens
-	}
-
 	// Get trace context if telemetry is enabled
 	if e.telemetry != nil {
 		if traceContext := e.telemetry.GetTraceContext(ctx); traceContext != nil {
@@ -80,13 +178,87 @@ func (e *Enricher) EnrichOutput(ctx context.Context, result *executor.ExecutionR
 			}
 		}
 	}
+}
 
-	// Save to history if history manager is available
-	if e.history != nil {
-		e.history.SaveRecord(output)
+// recordSession adds a run's tokens and cost to the current session and
+// reports the session's totals in the envelope
+func (e *Enricher) recordSession(output *models.Output) {
+	if e.sessions == nil || e.sessionID == "" {
+		return
+	}
+	model := ""
+	if e.tokenizer != nil {
+		model = e.tokenizer.GetModelName()
+	}
+	var budget int64
+	var price float64
+	if e.config != nil {
+		budget, price = e.config.Session.Budget, e.config.Session.TokenPrice
 	}
+	tokens := int64(output.Tokens)
+	state, err := e.sessions.Add(e.sessionID, tokens, session.Cost(tokens, model, price), budget)
+	if err != nil {
+		output.Metadata.Warnings = append(output.Metadata.Warnings, "session: "+err.Error())
+		return
+	}
+	output.Session = state.Section()
+}
 
-	return output, nil
+// countTokensByModel counts the text with every configured tokenizer concurrently.
+// Models whose tokenizer fails are left out of the estimates, with the error
+// in errs.
+func (e *Enricher) countTokensByModel(text string, mode string, threshold int64) (map[string]tokenizer.Estimate, map[string]error) {
+	estimates := make(map[string]tokenizer.Estimate, len(e.tokenizers))
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, tok := range e.tokenizers {
+		if tok == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(tok tokenizer.Tokenizer) {
+			defer wg.Done()
+			estimate, err := tokenizer.CountWithAccuracy(tok, text, mode, threshold)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[tok.GetModelName()] = err
+				return
+			}
+			estimates[tok.GetModelName()] = estimate
+		}(tok)
+	}
+
+	wg.Wait()
+	return estimates, errs
+}
+
+// tokenAccuracy returns the configured accuracy mode and auto threshold
+func (e *Enricher) tokenAccuracy() (string, int64) {
+	if e.config == nil {
+		return tokenizer.AccuracyAuto, tokenizer.DefaultAccuracyThreshold
+	}
+	mode, err := tokenizer.ParseAccuracy(e.config.TokenAccuracy)
+	if err != nil {
+		mode = tokenizer.AccuracyAuto
+	}
+	return mode, e.config.TokenAccuracyThreshold
+}
+
+// NewTokenEstimate converts an estimate for the envelope; exact counts have none
+func NewTokenEstimate(estimate tokenizer.Estimate) *models.TokenEstimate {
+	if estimate.Method == tokenizer.AccuracyExact {
+		return nil
+	}
+	return &models.TokenEstimate{
+		Method:       estimate.Method,
+		Low:          estimate.Low,
+		High:         estimate.High,
+		Confidence:   estimate.Confidence,
+		SampledBytes: estimate.SampledBytes,
+	}
 }
 
 // shouldCountTokens checks if token counting is enabled
@@ -118,6 +290,23 @@ func WithTokenizer(tok tokenizer.Tokenizer) Option {
 	}
 }
 
+// WithTokenizers sets the tokenizers used for per-model token counts, one
+// per configured model, primary first. A model that failed to load is nil;
+// the primary tokenizer is only ever set by NewEnricher or WithTokenizer, so
+// another model never stands in for it.
+func WithTokenizers(toks ...tokenizer.Tokenizer) Option {
+	return func(e *Enricher) {
+		e.tokenizers = toks
+	}
+}
+
+// WithTokenizerError records why the primary tokenizer is unavailable
+func WithTokenizerError(err error) Option {
+	return func(e *Enricher) {
+		e.tokErr = err
+	}
+}
+
 // WithHistory sets the history manager
 func WithHistory(hist *history.HistoryManager) Option {
 	return func(e *Enricher) {
@@ -125,6 +314,15 @@ func WithHistory(hist *history.HistoryManager) Option {
 	}
 }
 
+// WithSession sets the session manager and the current session ID; runs
+// count toward that session
+func WithSession(sessions *session.Manager, id string) Option {
+	return func(e *Enricher) {
+		e.sessions = sessions
+		e.sessionID = id
+	}
+}
+
 // WithTelemetry sets the telemetry manager
 func WithTelemetry(tel *telemetry.Manager) Option {
 	return func(e *Enricher) {
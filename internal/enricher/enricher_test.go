@@ -0,0 +1,79 @@
+package enricher
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/config"
+	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// wordTokenizer counts words, or fails with err
+type wordTokenizer struct {
+	model string
+	err   error
+}
+
+func (w *wordTokenizer) CountTokens(text string) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(strings.Fields(text)), nil
+}
+
+func (w *wordTokenizer) GetModelName() string { return w.model }
+
+func enrich(t *testing.T, e *Enricher, output string) (tokens int, byModel map[string]int, status, tokErr string) {
+	t.Helper()
+	out, err := e.EnrichOutput(context.Background(), &executor.ExecutionResult{Command: "echo", Output: []byte(output)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Tokens, out.TokensByModel, out.Metadata.TokenizerStatus, out.Metadata.TokenizerError
+}
+
+func TestCountTokensByModel(t *testing.T) {
+	primary := &wordTokenizer{model: "anthropic"}
+	other := &wordTokenizer{model: "openai"}
+	broken := &wordTokenizer{model: "gemini", err: errors.New("no vocabulary")}
+	e := NewEnricher(primary, nil, nil, &config.Config{}, WithTokenizers(primary, other, broken))
+
+	estimates, errs := e.countTokensByModel("one two three", tokenizer.AccuracyExact, 0)
+	if estimates["anthropic"].Tokens != 3 || estimates["openai"].Tokens != 3 || len(estimates) != 2 {
+		t.Errorf("estimates = %+v, want 3 tokens for anthropic and openai", estimates)
+	}
+	if errs["gemini"] == nil || len(errs) != 1 {
+		t.Errorf("errs = %v, want gemini's error", errs)
+	}
+
+	tokens, byModel, status, _ := enrich(t, e, "one two three")
+	if tokens != 3 || len(byModel) != 2 || status != "" {
+		t.Errorf("tokens = %d, by model %v, status %q", tokens, byModel, status)
+	}
+}
+
+func TestPrimaryTokenizerFailure(t *testing.T) {
+	other := &wordTokenizer{model: "openai"}
+
+	// The primary did not load: it stays missing instead of openai standing in
+	loadErr := errors.New("download failed")
+	e := NewEnricher(nil, nil, nil, &config.Config{}, WithTokenizers(nil, other), WithTokenizerError(loadErr))
+	tokens, byModel, status, tokErr := enrich(t, e, "one two")
+	if tokens != 0 || status != "unavailable" || tokErr != loadErr.Error() {
+		t.Errorf("tokens = %d, status %q, error %q; want 0, unavailable, %q", tokens, status, tokErr, loadErr)
+	}
+	if byModel["openai"] != 2 {
+		t.Errorf("tokens_by_model = %v, want openai's count", byModel)
+	}
+
+	// The primary loaded but failed to count
+	primary := &wordTokenizer{model: "anthropic", err: errors.New("bad input")}
+	e = NewEnricher(primary, nil, nil, &config.Config{}, WithTokenizers(primary, other))
+	tokens, byModel, status, tokErr = enrich(t, e, "one two")
+	if tokens != 0 || status != "error" || tokErr != "bad input" || byModel["openai"] != 2 {
+		t.Errorf("tokens = %d, by model %v, status %q, error %q", tokens, byModel, status, tokErr)
+	}
+}
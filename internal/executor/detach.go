@@ -0,0 +1,25 @@
+package executor
+
+import (
+	"os/exec"
+)
+
+// StartDetached starts a command that outlives ctx, such as the supervisor
+// of a background job. Unlike the commands ctx runs itself, it is not tied
+// to a context and is not killed with ctx's process group.
+func StartDetached(cmd *exec.Cmd) error {
+	detachProcess(cmd)
+	return cmd.Start()
+}
+
+// ProcessAlive reports whether a process with the given PID is running
+func ProcessAlive(pid int) bool {
+	return pid > 0 && processAlive(pid)
+}
+
+// TerminateProcess asks a process to exit. On Unix it receives SIGTERM and
+// can stop the commands it started with killProcessGroup; on Windows it is
+// killed outright.
+func TerminateProcess(pid int) error {
+	return terminateProcess(pid)
+}
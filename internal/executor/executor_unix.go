@@ -68,3 +68,21 @@ func configureTermination(cmd *exec.Cmd) {
 	}
 	cmd.WaitDelay = waitDelay
 }
+
+// detachProcess starts the command in its own process group, so signals
+// sent to ctx's group, such as Ctrl+C in the terminal, do not reach it
+func detachProcess(cmd *exec.Cmd) {
+	setupProcessGroup(cmd)
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// terminateProcess asks a process to exit with SIGTERM, giving it the
+// chance to clean up its own process group
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
@@ -230,3 +230,48 @@ func AssociateWithJobObject(cmd *exec.Cmd) error {
 
 	return nil
 }
+
+const (
+	// Process creation flag for a process without a console
+	DETACHED_PROCESS = 0x00000008
+
+	// Process access right for querying the exit code
+	PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+
+	// Exit code of a process that is still running
+	STILL_ACTIVE = 259
+)
+
+// detachProcess starts the command without a console, in its own process
+// group. It is not put in a Job Object, which would end it when ctx exits.
+func detachProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags = syscall.CREATE_NEW_PROCESS_GROUP | DETACHED_PROCESS
+}
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	handle, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == STILL_ACTIVE
+}
+
+// terminateProcess ends a process. Windows has no SIGTERM, so the process
+// is killed; closing its Job Object handles ends the processes it started.
+func terminateProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
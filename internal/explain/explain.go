@@ -0,0 +1,493 @@
+// Package explain breaks a command's token cost down into lines, sections and
+// common sources of waste, and suggests how to make the command cheaper.
+package explain
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// Report limits
+const (
+	DefaultTopLines = 10  // Lines listed in top_lines
+	maxSections     = 20  // Sections listed in sections
+	maxPreviewRunes = 120 // Longest line text shown in top_lines
+)
+
+// Section kinds
+const (
+	SectionFile   = "file"   // Per file, e.g. git diff or grep output
+	SectionColumn = "column" // Per table column, e.g. psql output
+)
+
+// Thresholds above which a share triggers a suggestion
+const (
+	suggestShareSection    = 0.4
+	suggestShareColumn     = 0.3
+	suggestShareRepeated   = 0.2
+	suggestShareWhitespace = 0.15
+	suggestShareLongIdent  = 0.2
+	suggestShareLine       = 0.25
+	suggestManyLines       = 200
+)
+
+// longIdentifier matches hashes, UUIDs, long paths and long names
+var longIdentifier = regexp.MustCompile(`[A-Za-z0-9_][A-Za-z0-9_\-./:]{23,}`)
+
+// counter counts tokens with a cache, since outputs repeat lines a lot.
+// With tokensPerByte set, it counts bytes instead of tokenizing: costs are
+// then converted to tokens once the shares are known, since rounding every
+// line would lose small differences such as whitespace.
+type counter struct {
+	tok           tokenizer.Tokenizer
+	cache         map[string]int
+	tokensPerByte float64
+}
+
+func (c *counter) count(text string) int {
+	if c.tokensPerByte > 0 {
+		return len(text)
+	}
+	if n, ok := c.cache[text]; ok {
+		return n
+	}
+	n, err := c.tok.CountTokens(text)
+	if err != nil {
+		n = 0
+	}
+	c.cache[text] = n
+	return n
+}
+
+// Analyze explains where the tokens of a command's output are spent,
+// tokenizing every line
+func Analyze(tok tokenizer.Tokenizer, command, output string) (*models.TokenBreakdown, error) {
+	return AnalyzeWithAccuracy(tok, command, output, tokenizer.AccuracyExact, 0)
+}
+
+// AnalyzeWithAccuracy explains where the tokens of a command's output are
+// spent. Unless the accuracy mode resolves to exact for the output's size,
+// the output is counted once as a whole with that mode, and lines, sections
+// and shares are estimated from their size at the same tokens per byte, so
+// explaining a huge output costs no more than counting it.
+func AnalyzeWithAccuracy(tok tokenizer.Tokenizer, command, output string, mode string, threshold int64) (*models.TokenBreakdown, error) {
+	if tok == nil {
+		return nil, fmt.Errorf("no tokenizer available to explain tokens")
+	}
+
+	c := &counter{tok: tok, cache: make(map[string]int)}
+	estimated := tokenizer.ResolveAccuracy(mode, int64(len(output)), threshold) != tokenizer.AccuracyExact
+	if estimated && output != "" {
+		estimate, err := tokenizer.CountWithAccuracy(tok, output, mode, threshold)
+		if err != nil {
+			return nil, err
+		}
+		c.tokensPerByte = float64(estimate.Tokens) / float64(len(output))
+	}
+	lines := splitLines(output)
+	costs := make([]int, len(lines))
+	total := 0
+	for i, line := range lines {
+		costs[i] = c.count(line)
+		total += costs[i]
+	}
+
+	breakdown := &models.TokenBreakdown{TopLines: topLines(lines, costs, DefaultTopLines), Estimated: c.tokensPerByte > 0}
+	if total == 0 {
+		return breakdown, nil
+	}
+
+	breakdown.Shares = shares(c, lines, costs, total)
+	breakdown.SectionKind, breakdown.Sections = detectSections(c, command, lines, costs, total)
+	breakdown.Suggestions = suggest(command, lines, total, breakdown)
+	if c.tokensPerByte > 0 {
+		for i := range breakdown.TopLines {
+			breakdown.TopLines[i].Tokens = c.tokens(breakdown.TopLines[i].Tokens)
+		}
+		for i := range breakdown.Sections {
+			breakdown.Sections[i].Tokens = c.tokens(breakdown.Sections[i].Tokens)
+		}
+	}
+	return breakdown, nil
+}
+
+// tokens converts a size counted in bytes to estimated tokens
+func (c *counter) tokens(bytes int) int {
+	if bytes == 0 {
+		return 0
+	}
+	return max(1, int(math.Round(float64(bytes)*c.tokensPerByte)))
+}
+
+// splitLines splits output into lines that keep their newline, so that the
+// per-line counts add up to roughly the total
+func splitLines(output string) []string {
+	if output == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// topLines returns the n most expensive lines, most expensive first
+func topLines(lines []string, costs []int, n int) []models.LineCost {
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return costs[order[a]] > costs[order[b]]
+	})
+
+	top := make([]models.LineCost, 0, n)
+	for _, i := range order {
+		if len(top) == n || costs[i] == 0 {
+			break
+		}
+		top = append(top, models.LineCost{Line: i + 1, Tokens: costs[i], Text: preview(lines[i])})
+	}
+	return top
+}
+
+// preview trims the newline and shortens long lines
+func preview(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if utf8.RuneCountInString(line) <= maxPreviewRunes {
+		return line
+	}
+	runes := []rune(line)
+	return string(runes[:maxPreviewRunes]) + "…"
+}
+
+// shares measures tokens spent on whitespace, repeated lines and long identifiers
+func shares(c *counter, lines []string, costs []int, total int) models.TokenShares {
+	var whitespace, repeated, identifiers int
+	seen := make(map[string]bool, len(lines))
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" {
+			if seen[line] {
+				repeated += costs[i]
+			}
+			seen[line] = true
+		}
+
+		// Whitespace cost: the line minus the same line with whitespace collapsed
+		compact := strings.Join(strings.Fields(line), " ")
+		if strings.HasSuffix(line, "\n") {
+			compact += "\n"
+		}
+		if compact != line {
+			if saved := costs[i] - c.count(compact); saved > 0 {
+				whitespace += saved
+			}
+		}
+
+		for _, id := range longIdentifier.FindAllString(line, -1) {
+			identifiers += c.count(id)
+		}
+	}
+
+	return models.TokenShares{
+		Whitespace:      ratio(whitespace, total),
+		RepeatedLines:   ratio(repeated, total),
+		LongIdentifiers: ratio(identifiers, total),
+	}
+}
+
+// ratio returns part/total rounded to three decimals, capped at 1
+func ratio(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	r := float64(part) / float64(total)
+	if r > 1 {
+		r = 1
+	}
+	return float64(int(r*1000+0.5)) / 1000
+}
+
+// detectSections splits the output into sections based on the command and
+// the shape of the output
+func detectSections(c *counter, command string, lines []string, costs []int, total int) (string, []models.SectionCost) {
+	tokens := make(map[string]int)
+	var order []string
+	add := func(name string, n int) {
+		if _, ok := tokens[name]; !ok {
+			order = append(order, name)
+		}
+		tokens[name] += n
+	}
+
+	kind := ""
+	switch {
+	case isGitDiff(lines):
+		kind = SectionFile
+		current := "(header)"
+		for i, line := range lines {
+			if strings.HasPrefix(line, "diff --git ") {
+				current = diffFileName(line)
+			}
+			add(current, costs[i])
+		}
+	case isGrep(command, lines):
+		kind = SectionFile
+		for i, line := range lines {
+			if name, _, ok := strings.Cut(line, ":"); ok {
+				add(name, costs[i])
+			}
+		}
+	default:
+		if columns := psqlColumns(c, lines); len(columns) > 0 {
+			kind = SectionColumn
+			for _, col := range columns {
+				add(col.name, col.tokens)
+			}
+		}
+	}
+
+	if kind == "" {
+		return "", nil
+	}
+
+	sections := make([]models.SectionCost, 0, len(order))
+	for _, name := range order {
+		sections = append(sections, models.SectionCost{Name: name, Tokens: tokens[name], Share: ratio(tokens[name], total)})
+	}
+	sort.SliceStable(sections, func(a, b int) bool {
+		return sections[a].Tokens > sections[b].Tokens
+	})
+	if len(sections) > maxSections {
+		sections = sections[:maxSections]
+	}
+	return kind, sections
+}
+
+// isGitDiff reports whether the output looks like a unified git diff
+func isGitDiff(lines []string) bool {
+	for _, line := range lines {
+		if strings.HasPrefix(line, "diff --git ") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffFileName extracts the new file name from a "diff --git a/x b/y" line
+func diffFileName(line string) string {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, " b/"); i >= 0 {
+		return line[i+3:]
+	}
+	return strings.TrimPrefix(line, "diff --git ")
+}
+
+// isGrep reports whether the output is grep-style "file:match" lines
+func isGrep(command string, lines []string) bool {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+	switch filepath.Base(fields[0]) {
+	case "grep", "rg", "ag", "ack", "git":
+	default:
+		return false
+	}
+	if filepath.Base(fields[0]) == "git" && (len(fields) < 2 || fields[1] != "grep") {
+		return false
+	}
+
+	matched := 0
+	for _, line := range lines {
+		if name, _, ok := strings.Cut(line, ":"); ok && name != "" && !strings.ContainsAny(name, " \t") {
+			matched++
+		}
+	}
+	return len(lines) > 0 && matched*2 >= len(lines)
+}
+
+// columnCost is the token cost of a table column
+type columnCost struct {
+	name   string
+	tokens int
+}
+
+// psqlSeparator matches the line under a psql table header, e.g. "----+-----"
+var psqlSeparator = regexp.MustCompile(`^-+(\+-+)*\r?\n?$`)
+
+// psqlRecord matches the record header of psql expanded output (\x)
+var psqlRecord = regexp.MustCompile(`^-\[ RECORD \d+ \]`)
+
+// psqlColumns attributes tokens to columns of psql output, in aligned or expanded format
+func psqlColumns(c *counter, lines []string) []columnCost {
+	for s := 1; s < len(lines); s++ {
+		if !psqlSeparator.MatchString(lines[s]) {
+			continue
+		}
+		names := strings.Split(strings.TrimRight(lines[s-1], "\r\n"), "|")
+		if len(names) != strings.Count(lines[s], "+")+1 {
+			continue
+		}
+
+		columns := make([]columnCost, len(names))
+		for i, name := range names {
+			columns[i].name = strings.TrimSpace(name)
+		}
+		for _, row := range lines[s+1:] {
+			if strings.HasPrefix(row, "(") {
+				break // "(10 rows)"
+			}
+			cells := strings.Split(row, "|")
+			if len(cells) != len(columns) {
+				continue
+			}
+			for i, cell := range cells {
+				columns[i].tokens += c.count(cell)
+			}
+		}
+		return columns
+	}
+
+	// Expanded output: "-[ RECORD 1 ]-" followed by "column | value" lines
+	var columns []columnCost
+	index := make(map[string]int)
+	inRecord := false
+	for _, line := range lines {
+		if psqlRecord.MatchString(line) {
+			inRecord = true
+			continue
+		}
+		name, value, ok := strings.Cut(line, "|")
+		if !inRecord || !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		i, exists := index[name]
+		if !exists {
+			i = len(columns)
+			index[name] = i
+			columns = append(columns, columnCost{name: name})
+		}
+		columns[i].tokens += c.count(value)
+	}
+	return columns
+}
+
+// suggest turns the breakdown into concrete suggestions
+func suggest(command string, lines []string, total int, b *models.TokenBreakdown) []string {
+	var suggestions []string
+	fields := strings.Fields(command)
+	name := ""
+	if len(fields) > 0 {
+		name = filepath.Base(fields[0])
+	}
+	lower := strings.ToLower(command)
+
+	if len(b.Sections) > 0 {
+		top := b.Sections[0]
+		switch b.SectionKind {
+		case SectionColumn:
+			if top.Share >= suggestShareColumn && len(b.Sections) > 1 {
+				suggestions = append(suggestions, fmt.Sprintf("Column %q uses %s of tokens; project only the columns you need (SELECT col1, col2 ...) or truncate it (left(%s, 80))", top.Name, percent(top.Share), top.Name))
+			}
+		case SectionFile:
+			if top.Share >= suggestShareSection && len(b.Sections) > 1 {
+				if isGitDiff(lines) {
+					suggestions = append(suggestions, fmt.Sprintf("%s accounts for %s of tokens; run 'git diff --stat' first, then diff specific paths (git diff -- <path>) or exclude it (git diff -- . ':(exclude)%s')", top.Name, percent(top.Share), top.Name))
+				} else {
+					suggestions = append(suggestions, fmt.Sprintf("%s accounts for %s of tokens; narrow the search path or exclude it", top.Name, percent(top.Share)))
+				}
+			}
+			for _, s := range b.Sections {
+				if isGeneratedFile(s.Name) && s.Share >= 0.05 {
+					suggestions = append(suggestions, fmt.Sprintf("Generated file %s uses %s of tokens; exclude lock and generated files", s.Name, percent(s.Share)))
+					break
+				}
+			}
+		}
+	}
+
+	if isDatabase(name) && strings.Contains(lower, "select") && !strings.Contains(lower, "limit") {
+		suggestions = append(suggestions, "Add a LIMIT clause, or COUNT(*) first to gauge the result size")
+	}
+
+	if b.Shares.RepeatedLines >= suggestShareRepeated {
+		suggestions = append(suggestions, fmt.Sprintf("%s of tokens are repeated lines; deduplicate with 'sort | uniq -c' or filter them out with grep -v", percent(b.Shares.RepeatedLines)))
+	}
+	if b.Shares.Whitespace >= suggestShareWhitespace {
+		suggestions = append(suggestions, fmt.Sprintf("%s of tokens are whitespace; use a compact output format (e.g. psql -A, jq -c) or collapse spaces with 'tr -s \" \"'", percent(b.Shares.Whitespace)))
+	}
+	if b.Shares.LongIdentifiers >= suggestShareLongIdent {
+		suggestions = append(suggestions, fmt.Sprintf("%s of tokens are long identifiers (hashes, UUIDs, paths); shorten or drop them (e.g. git --abbrev-commit, cut -c1-12)", percent(b.Shares.LongIdentifiers)))
+	}
+
+	if len(b.TopLines) > 0 && len(lines) > 1 {
+		if share := float64(b.TopLines[0].Tokens) / float64(total); share >= suggestShareLine {
+			suggestions = append(suggestions, fmt.Sprintf("Line %d alone uses %s of tokens; filter it out or truncate long lines (cut -c1-200)", b.TopLines[0].Line, percent(share)))
+		}
+	}
+
+	if len(lines) > suggestManyLines {
+		switch {
+		case isGitDiff(lines):
+			suggestions = append(suggestions, fmt.Sprintf("%d lines of diff; start with 'git diff --stat' and reduce context with -U1", len(lines)))
+		case isLogCommand(fields):
+			suggestions = append(suggestions, fmt.Sprintf("%d lines of logs; use --tail 100 or --since 10m", len(lines)))
+		case !isDatabase(name):
+			suggestions = append(suggestions, fmt.Sprintf("%d lines of output; keep only what you need with '| tail -n 100', '| head -n 100' or grep, or cap it with ctx --max-lines", len(lines)))
+		}
+	}
+
+	return suggestions
+}
+
+// percent formats a share as a percentage
+func percent(share float64) string {
+	return fmt.Sprintf("%.0f%%", share*100)
+}
+
+func isDatabase(name string) bool {
+	switch name {
+	case "psql", "mysql", "sqlite3", "duckdb", "clickhouse-client", "bq":
+		return true
+	}
+	return false
+}
+
+// isLogCommand reports whether the command prints logs (docker logs, kubectl logs, journalctl, tail)
+func isLogCommand(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	switch filepath.Base(fields[0]) {
+	case "journalctl", "tail":
+		return true
+	case "docker", "kubectl", "docker-compose", "podman", "heroku":
+		for _, f := range fields[1:] {
+			if f == "logs" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isGeneratedFile reports whether a path is a lock file or other generated file
+func isGeneratedFile(path string) bool {
+	switch filepath.Base(path) {
+	case "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "go.sum", "Cargo.lock", "poetry.lock", "Gemfile.lock", "composer.lock":
+		return true
+	}
+	return strings.HasSuffix(path, ".min.js") || strings.HasSuffix(path, ".pb.go") || strings.Contains(path, "/vendor/")
+}
@@ -0,0 +1,166 @@
+package explain
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// byteTokenizer counts one token per four bytes, which is close enough to
+// real tokenizers for proportions
+type byteTokenizer struct{}
+
+func (byteTokenizer) CountTokens(text string) (int, error) { return (len(text) + 3) / 4, nil }
+func (byteTokenizer) GetModelName() string                 { return "bytes" }
+
+func hasSuggestion(suggestions []string, substr string) bool {
+	for _, s := range suggestions {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnalyzeGitDiff(t *testing.T) {
+	output := "diff --git a/main.go b/main.go\n" +
+		"@@ -1,3 +1,3 @@\n-fmt.Println(\"a\")\n+fmt.Println(\"b\")\n" +
+		"diff --git a/package-lock.json b/package-lock.json\n" +
+		strings.Repeat("+    \"resolved\": \"https://registry.npmjs.org/some-package/-/some-package-1.2.3.tgz\",\n", 40)
+
+	b, err := Analyze(byteTokenizer{}, "git diff", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.SectionKind != SectionFile || len(b.Sections) != 2 {
+		t.Fatalf("sections = %q %+v, want 2 files", b.SectionKind, b.Sections)
+	}
+	if b.Sections[0].Name != "package-lock.json" || b.Sections[0].Share < 0.9 {
+		t.Errorf("top section = %+v, want package-lock.json with most tokens", b.Sections[0])
+	}
+	if b.Shares.RepeatedLines < 0.8 {
+		t.Errorf("repeated share = %v, want most of the output", b.Shares.RepeatedLines)
+	}
+	if b.Shares.LongIdentifiers == 0 {
+		t.Error("long identifier share should count the registry URLs")
+	}
+	for _, want := range []string{"git diff --stat", "Generated file package-lock.json", "repeated lines"} {
+		if !hasSuggestion(b.Suggestions, want) {
+			t.Errorf("suggestions %q missing %q", b.Suggestions, want)
+		}
+	}
+}
+
+func TestAnalyzePsqlColumns(t *testing.T) {
+	output := " id |  name  |                        payload\n" +
+		"----+--------+--------------------------------------------------------\n" +
+		"  1 | alice  | {\"event\": \"signup\", \"source\": \"web\", \"details\": \"aaaa\"}\n" +
+		"  2 | bob    | {\"event\": \"login\", \"source\": \"ios\", \"details\": \"bbbbbb\"}\n" +
+		"(2 rows)\n"
+
+	b, err := Analyze(byteTokenizer{}, `psql -c "SELECT * FROM events"`, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.SectionKind != SectionColumn || len(b.Sections) != 3 || b.Sections[0].Name != "payload" {
+		t.Fatalf("sections = %q %+v, want payload column first", b.SectionKind, b.Sections)
+	}
+	if !hasSuggestion(b.Suggestions, `Column "payload"`) || !hasSuggestion(b.Suggestions, "LIMIT") {
+		t.Errorf("suggestions = %q, want column projection and LIMIT", b.Suggestions)
+	}
+}
+
+func TestAnalyzePsqlExpanded(t *testing.T) {
+	output := "-[ RECORD 1 ]----------\nid   | 1\nbody | " + strings.Repeat("x", 200) + "\n" +
+		"-[ RECORD 2 ]----------\nid   | 2\nbody | " + strings.Repeat("y", 200) + "\n"
+
+	b, _ := Analyze(byteTokenizer{}, "psql -x -c 'SELECT id, body FROM t LIMIT 2'", output)
+	if b.SectionKind != SectionColumn || len(b.Sections) != 2 || b.Sections[0].Name != "body" {
+		t.Fatalf("sections = %+v, want body then id", b.Sections)
+	}
+	if hasSuggestion(b.Suggestions, "LIMIT") {
+		t.Error("should not suggest LIMIT when the query has one")
+	}
+}
+
+func TestAnalyzeTopLinesAndWhitespace(t *testing.T) {
+	output := "short\n" + strings.Repeat(" ", 64) + "indented\n" + strings.Repeat("long line ", 30) + "\nend\n"
+
+	b, _ := Analyze(byteTokenizer{}, "cat file.txt", output)
+	if len(b.TopLines) != 4 || b.TopLines[0].Line != 3 {
+		t.Fatalf("top lines = %+v, want line 3 first", b.TopLines)
+	}
+	if b.Shares.Whitespace < 0.15 {
+		t.Errorf("whitespace share = %v, want the indentation counted", b.Shares.Whitespace)
+	}
+	if len(b.TopLines[0].Text) > maxPreviewRunes+len("…") {
+		t.Errorf("preview not truncated: %d bytes", len(b.TopLines[0].Text))
+	}
+	if b.SectionKind != "" {
+		t.Errorf("section kind = %q, want none for plain text", b.SectionKind)
+	}
+}
+
+func TestAnalyzeLogs(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 300; i++ {
+		sb.WriteString("2024-01-01 INFO worker heartbeat ok\n")
+	}
+	b, _ := Analyze(byteTokenizer{}, "docker logs api", sb.String())
+	if !hasSuggestion(b.Suggestions, "--tail") {
+		t.Errorf("suggestions = %q, want --tail", b.Suggestions)
+	}
+}
+
+func TestAnalyzeEmpty(t *testing.T) {
+	b, err := Analyze(byteTokenizer{}, "true", "")
+	if err != nil || len(b.TopLines) != 0 || len(b.Suggestions) != 0 {
+		t.Errorf("Analyze(empty) = %+v, %v", b, err)
+	}
+	if _, err := Analyze(nil, "true", "x"); err == nil {
+		t.Error("Analyze() without a tokenizer should fail")
+	}
+}
+
+// countingTokenizer is a byteTokenizer that counts its calls
+type countingTokenizer struct {
+	byteTokenizer
+	calls int
+}
+
+func (c *countingTokenizer) CountTokens(text string) (int, error) {
+	c.calls++
+	return c.byteTokenizer.CountTokens(text)
+}
+
+func TestAnalyzeWithAccuracy(t *testing.T) {
+	// About 2MB of distinct lines, each of which exact mode tokenizes
+	var sb strings.Builder
+	for i := 0; sb.Len() < 2<<20; i++ {
+		fmt.Fprintf(&sb, "2024-01-01 INFO  request %d handled in %dms\n", i, i%97)
+	}
+	output := sb.String()
+
+	for _, mode := range []string{tokenizer.AccuracySampled, tokenizer.AccuracyHeuristic} {
+		tok := &countingTokenizer{}
+		b, err := AnalyzeWithAccuracy(tok, "docker logs api", output, mode, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.calls > 64 {
+			t.Errorf("%s: %d tokenizer calls, want at most one per sample", mode, tok.calls)
+		}
+		if !b.Estimated || len(b.TopLines) != DefaultTopLines || b.Shares.Whitespace == 0 {
+			t.Errorf("%s: breakdown = estimated %v, %d top lines, shares %+v", mode, b.Estimated, len(b.TopLines), b.Shares)
+		}
+	}
+
+	// Small outputs are explained exactly even in auto mode
+	tok := &countingTokenizer{}
+	b, _ := AnalyzeWithAccuracy(tok, "echo", "a  b\nc\n", tokenizer.AccuracyAuto, 0)
+	if b.Estimated || tok.calls == 0 {
+		t.Errorf("small output: estimated %v after %d calls, want exact", b.Estimated, tok.calls)
+	}
+}
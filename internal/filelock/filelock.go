@@ -0,0 +1,34 @@
+// Package filelock provides advisory file locks shared between processes:
+// flock(2) on Unix and LockFileEx on Windows.
+package filelock
+
+import (
+	"os"
+)
+
+const lockFilePerm = 0644
+
+// Lock is an advisory lock on a file, shared between processes
+type Lock struct {
+	f *os.File
+}
+
+// Acquire blocks until it holds the lock on path, creating the file if
+// needed: exclusive for writers or shared for readers
+func Acquire(path string, exclusive bool) (*Lock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, lockFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release unlocks and closes the lock file
+func (l *Lock) Release() error {
+	unlockFile(l.f)
+	return l.f.Close()
+}
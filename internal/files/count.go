@@ -0,0 +1,194 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// Reasons a file was not counted
+const (
+	SkipBinary         = "binary"
+	SkipUnreadable     = "unreadable"
+	SkipTokenizerError = "tokenizer_error"
+)
+
+// Counted is a file with its token count
+type Counted struct {
+	File
+	Tokens        int                // Tokens with the primary tokenizer
+	TokensByModel map[string]int     // Tokens per model when several tokenizers are given
+	Estimate      tokenizer.Estimate // How the primary count was obtained
+	Skipped       string             // Why the file was not counted, if it was not
+	Error         string             // The tokenizer's error when Skipped is SkipTokenizerError
+}
+
+// DirTotal is the token total of all counted files under a directory
+type DirTotal struct {
+	Path   string
+	Tokens int
+	Bytes  int64
+	Files  int
+}
+
+// CountFiles reads and counts files concurrently with the given tokenizers,
+// the first being the primary one. Binary and unreadable files are marked
+// as skipped. Results keep the order of files.
+func CountFiles(files []File, toks []tokenizer.Tokenizer, mode string, threshold int64) []Counted {
+	results := make([]Counted, len(files))
+	next := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := runtime.GOMAXPROCS(0)
+	for w := 0; w < workers && w < len(files); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = countFile(files[i], toks, mode, threshold)
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// countFile reads and counts a single file
+func countFile(file File, toks []tokenizer.Tokenizer, mode string, threshold int64) Counted {
+	result := Counted{File: file}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		result.Skipped = SkipUnreadable
+		return result
+	}
+	return CountData(file, data, toks, mode, threshold)
+}
+
+// CountData counts content that was already read, such as stdin
+func CountData(file File, data []byte, toks []tokenizer.Tokenizer, mode string, threshold int64) Counted {
+	result := Counted{File: file}
+	result.Size = int64(len(data))
+	if IsBinary(data) {
+		result.Skipped = SkipBinary
+		return result
+	}
+
+	text := string(data)
+	for i, tok := range toks {
+		if tok == nil {
+			continue
+		}
+		estimate, err := tokenizer.CountWithAccuracy(tok, text, mode, threshold)
+		if err != nil {
+			// A partial count would be reported as the file's size in tokens
+			return Counted{File: result.File, Skipped: SkipTokenizerError, Error: fmt.Sprintf("%s: %v", tok.GetModelName(), err)}
+		}
+		if i == 0 {
+			result.Tokens = estimate.Tokens
+			result.Estimate = estimate
+		}
+		if len(toks) > 1 {
+			if result.TokensByModel == nil {
+				result.TokensByModel = make(map[string]int, len(toks))
+			}
+			result.TokensByModel[tok.GetModelName()] = estimate.Tokens
+		}
+	}
+	return result
+}
+
+// Total sums the counts of all counted files. The estimate is exact only
+// when every file was counted exactly; otherwise its bounds are the sums of
+// the per-file bounds.
+func Total(counted []Counted) (tokenizer.Estimate, map[string]int) {
+	total := tokenizer.Estimate{Method: tokenizer.AccuracyExact}
+	var byModel map[string]int
+	for _, c := range counted {
+		if c.Skipped != "" {
+			continue
+		}
+		total.Tokens += c.Tokens
+		total.Low += c.Estimate.Low
+		total.High += c.Estimate.High
+		switch c.Estimate.Method {
+		case tokenizer.AccuracyHeuristic:
+			total.Method = tokenizer.AccuracyHeuristic
+		case tokenizer.AccuracySampled:
+			if total.Method == tokenizer.AccuracyExact {
+				total.Method = tokenizer.AccuracySampled
+			}
+			total.Confidence = c.Estimate.Confidence
+			total.SampledBytes += c.Estimate.SampledBytes
+		default:
+			total.SampledBytes += int(c.Size)
+		}
+		for model, tokens := range c.TokensByModel {
+			if byModel == nil {
+				byModel = make(map[string]int)
+			}
+			byModel[model] += tokens
+		}
+	}
+	if total.Method == tokenizer.AccuracyHeuristic {
+		total.Confidence, total.SampledBytes = 0, 0
+	}
+	return total, byModel
+}
+
+// DirTotals rolls file counts up into every directory that contains them,
+// most tokens first. The current directory itself is left out, since its
+// total is the overall total.
+func DirTotals(counted []Counted) []DirTotal {
+	totals := make(map[string]*DirTotal)
+	for _, c := range counted {
+		if c.Skipped != "" {
+			continue
+		}
+		for dir := filepath.Dir(c.Path); !isTopDir(dir); dir = filepath.Dir(dir) {
+			t, ok := totals[dir]
+			if !ok {
+				t = &DirTotal{Path: dir}
+				totals[dir] = t
+			}
+			t.Tokens += c.Tokens
+			t.Bytes += c.Size
+			t.Files++
+		}
+	}
+
+	dirs := make([]DirTotal, 0, len(totals))
+	for _, t := range totals {
+		dirs = append(dirs, *t)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Tokens != dirs[j].Tokens {
+			return dirs[i].Tokens > dirs[j].Tokens
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	return dirs
+}
+
+// isTopDir reports whether dir is where directory roll-ups stop
+func isTopDir(dir string) bool {
+	return dir == "." || dir == ".." || filepath.Dir(dir) == dir || filepath.Base(dir) == ".."
+}
+
+// SortByTokens orders counted files by tokens, most first, then by path
+func SortByTokens(counted []Counted) {
+	sort.SliceStable(counted, func(i, j int) bool {
+		if counted[i].Tokens != counted[j].Tokens {
+			return counted[i].Tokens > counted[j].Tokens
+		}
+		return counted[i].Path < counted[j].Path
+	})
+}
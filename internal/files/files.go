@@ -0,0 +1,195 @@
+// Package files selects files for token counting: it expands directories and
+// globs, honors .gitignore files, and reads and counts the selected files.
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// binarySniffLen is how much of a file is checked for NUL bytes, as git does
+const binarySniffLen = 8000
+
+// File is a regular file selected for counting
+type File struct {
+	Path string // Path as given or found while walking
+	Size int64  // Size in bytes
+}
+
+// Options control which files Collect selects
+type Options struct {
+	NoIgnore bool // Include files ignored by .gitignore
+}
+
+// Collect expands files, directories and globs (including "**") into a sorted,
+// de-duplicated list of regular files. Directory walks and globs skip .git and
+// paths ignored by .gitignore; files named explicitly are always included.
+func Collect(args []string, opts Options) ([]File, error) {
+	c := &collector{opts: opts, seen: make(map[string]bool), matchers: make(map[string]*Matcher)}
+	for _, arg := range args {
+		if err := c.add(arg); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(c.files, func(i, j int) bool {
+		return c.files[i].Path < c.files[j].Path
+	})
+	return c.files, nil
+}
+
+// collector accumulates files across arguments
+type collector struct {
+	opts     Options
+	files    []File
+	seen     map[string]bool
+	matchers map[string]*Matcher // Keyed by repository root
+}
+
+// add expands a single argument
+func (c *collector) add(arg string) error {
+	info, err := os.Stat(arg)
+	switch {
+	case err == nil && info.IsDir():
+		return c.walk(filepath.Clean(arg), nil)
+	case err == nil && info.Mode().IsRegular():
+		c.addFile(filepath.Clean(arg), info.Size())
+		return nil
+	case err == nil:
+		return fmt.Errorf("%s is not a regular file or directory", arg)
+	case !hasMeta(arg):
+		return err
+	}
+
+	// A glob: walk from the longest literal prefix and match the rest
+	pattern := filepath.ToSlash(filepath.Clean(arg))
+	re, err := regexp.Compile("^" + globToRegexp(pattern) + "$")
+	if err != nil {
+		return fmt.Errorf("invalid glob %q: %w", arg, err)
+	}
+	before := len(c.files)
+	base := globBase(pattern)
+	if _, err := os.Stat(base); err == nil {
+		if err := c.walk(base, re); err != nil {
+			return err
+		}
+	}
+	if len(c.files) == before {
+		return fmt.Errorf("no files match %q", arg)
+	}
+	return nil
+}
+
+// walk adds the regular files under dir, keeping only those matching re when it is set
+func (c *collector) walk(dir string, re *regexp.Regexp) error {
+	matcher := c.matcher(dir)
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped rather than failing the whole walk
+			if d != nil && d.IsDir() && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && (d.Name() == ".git" || c.ignored(matcher, path, true)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if c.ignored(matcher, path, false) {
+			return nil
+		}
+		if re != nil && !re.MatchString(filepath.ToSlash(path)) {
+			return nil
+		}
+
+		// Follow symlinks to regular files; skip everything else
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		c.addFile(path, info.Size())
+		return nil
+	})
+}
+
+// matcher returns the .gitignore matcher for the repository containing dir
+func (c *collector) matcher(dir string) *Matcher {
+	if c.opts.NoIgnore {
+		return nil
+	}
+	root := FindRoot(dir)
+	if m, ok := c.matchers[root]; ok {
+		return m
+	}
+	m := NewMatcher(root)
+	c.matchers[root] = m
+	return m
+}
+
+// ignored reports whether path is ignored by the matcher's .gitignore files
+func (c *collector) ignored(m *Matcher, path string, isDir bool) bool {
+	if m == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(m.Root(), abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return m.Ignored(rel, isDir)
+}
+
+// addFile records a file once, however many arguments select it
+func (c *collector) addFile(path string, size int64) {
+	key := path
+	if abs, err := filepath.Abs(path); err == nil {
+		key = abs
+	}
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+	c.files = append(c.files, File{Path: path, Size: size})
+}
+
+// hasMeta reports whether path contains glob metacharacters
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globBase returns the directory prefix of a slash-separated glob that has no metacharacters
+func globBase(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	var base []string
+	for _, part := range parts[:len(parts)-1] {
+		if hasMeta(part) {
+			break
+		}
+		base = append(base, part)
+	}
+	if len(base) == 0 {
+		return "."
+	}
+	if len(base) == 1 && base[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(base, "/"))
+}
+
+// IsBinary reports whether data looks binary, by checking for a NUL byte near the start
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniffLen {
+		data = data[:binarySniffLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
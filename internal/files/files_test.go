@@ -0,0 +1,223 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// wordTokenizer counts whitespace-separated words
+type wordTokenizer struct{ name string }
+
+func (w wordTokenizer) CountTokens(text string) (int, error) { return len(strings.Fields(text)), nil }
+func (w wordTokenizer) GetModelName() string                 { return w.name }
+
+// failingTokenizer fails on every text
+type failingTokenizer struct{}
+
+func (failingTokenizer) CountTokens(string) (int, error) { return 0, errors.New("unsupported input") }
+func (failingTokenizer) GetModelName() string            { return "failing" }
+
+// writeTree creates files (path → content) under dir
+func writeTree(t *testing.T, dir string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// chdir changes to dir for the rest of the test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func paths(selected []File) []string {
+	var out []string
+	for _, f := range selected {
+		out = append(out, filepath.ToSlash(f.Path))
+	}
+	return out
+}
+
+func TestGitignoreRules(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "a.log", false, true},
+		{"*.log", "deep/dir/a.log", false, true},
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"build/", "src/build", true, true},
+		{"build/", "build", false, false},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/sub/a.md", false, false},
+		{"**/gen/*.go", "a/b/gen/x.go", false, true},
+		{"**/gen/*.go", "gen/x.go", false, true},
+		{"logs/**", "logs/a/b.txt", false, true},
+		{"a/**/z", "a/z", false, true},
+		{"a/**/z", "a/b/c/z", false, true},
+		{"file?.txt", "file1.txt", false, true},
+		{"file[0-9].txt", "filex.txt", false, false},
+		{"file[!0-9].txt", "filex.txt", false, true},
+		{`\#notcomment`, "#notcomment", false, true},
+	}
+	for _, tt := range tests {
+		r, ok := parseIgnoreLine(tt.pattern)
+		if !ok {
+			t.Fatalf("parseIgnoreLine(%q) yielded no rule", tt.pattern)
+		}
+		if got := r.match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q matching %q (dir=%v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok := parseIgnoreLine(line); ok {
+			t.Errorf("parseIgnoreLine(%q) should yield no rule", line)
+		}
+	}
+}
+
+func TestMatcherNestedAndNegated(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".gitignore":     "*.log\n!keep.log\n",
+		"sub/.gitignore": "!debug.log\nlocal/\n",
+	})
+
+	m := NewMatcher(dir)
+	tests := map[string]bool{
+		"a.log":           true,
+		"keep.log":        false,
+		"sub/a.log":       true,
+		"sub/debug.log":   false, // Re-included by the deeper file
+		"debug.log":       true,
+		"sub/local":       true,
+		"other/local":     false,
+		"sub/x/keep.log":  false,
+		"sub/x/other.txt": false,
+	}
+	for path, want := range tests {
+		isDir := !strings.Contains(filepath.Base(path), ".")
+		if got := m.Ignored(path, isDir); got != want {
+			t.Errorf("Ignored(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		".git/HEAD":         "ref: refs/heads/main",
+		".gitignore":        "build/\n*.tmp\n",
+		"main.go":           "package main",
+		"src/a.go":          "package src",
+		"src/deep/b.go":     "package deep",
+		"src/deep/notes.md": "notes",
+		"build/out.go":      "generated",
+		"scratch.tmp":       "scratch",
+	})
+	chdir(t, dir)
+
+	got, err := Collect([]string{"."}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".gitignore", "main.go", "src/a.go", "src/deep/b.go", "src/deep/notes.md"}
+	if strings.Join(paths(got), ",") != strings.Join(want, ",") {
+		t.Errorf("Collect(.) = %v, want %v", paths(got), want)
+	}
+
+	// Globs, explicit ignored files and duplicates
+	got, err = Collect([]string{"src/**/*.go", "build/out.go", "src/a.go"}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"build/out.go", "src/a.go", "src/deep/b.go"}
+	if strings.Join(paths(got), ",") != strings.Join(want, ",") {
+		t.Errorf("Collect(glob) = %v, want %v", paths(got), want)
+	}
+
+	// Ignored files come back with NoIgnore, but .git never does
+	got, _ = Collect([]string{"."}, Options{NoIgnore: true})
+	if len(got) != 7 {
+		t.Errorf("Collect(NoIgnore) = %v, want 7 files", paths(got))
+	}
+
+	if _, err := Collect([]string{"missing.go"}, Options{}); err == nil {
+		t.Error("Collect() should fail for a missing file")
+	}
+	if _, err := Collect([]string{"src/**/*.rs"}, Options{}); err == nil {
+		t.Error("Collect() should fail when a glob matches nothing")
+	}
+}
+
+func TestCountFilesAndTotals(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"a/one.txt":     "one two three",
+		"a/b/two.txt":   "four five",
+		"top.txt":       "six",
+		"a/b/image.bin": "PNG\x00\x01",
+	})
+	chdir(t, dir)
+
+	selected, err := Collect([]string{"."}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toks := []tokenizer.Tokenizer{wordTokenizer{"words"}, wordTokenizer{"other"}}
+	counted := CountFiles(selected, toks, tokenizer.AccuracyExact, 0)
+
+	total, byModel := Total(counted)
+	if total.Tokens != 6 || total.Method != tokenizer.AccuracyExact || byModel["other"] != 6 {
+		t.Errorf("Total() = %+v, %v; want 6 exact tokens for both models", total, byModel)
+	}
+
+	var skipped []string
+	for _, c := range counted {
+		if c.Skipped != "" {
+			skipped = append(skipped, filepath.ToSlash(c.Path)+":"+c.Skipped)
+		}
+	}
+	if len(skipped) != 1 || skipped[0] != "a/b/image.bin:binary" {
+		t.Errorf("skipped = %v, want the binary file", skipped)
+	}
+
+	// A file a tokenizer fails on is skipped rather than counted as empty
+	failing := CountData(File{Path: "x.txt"}, []byte("some text"), []tokenizer.Tokenizer{wordTokenizer{"words"}, failingTokenizer{}}, tokenizer.AccuracyExact, 0)
+	if failing.Skipped != SkipTokenizerError || failing.Tokens != 0 || !strings.Contains(failing.Error, "unsupported") {
+		t.Errorf("CountData() with a failing tokenizer = %+v, want it skipped with the error", failing)
+	}
+
+	dirs := DirTotals(counted)
+	if len(dirs) != 2 || dirs[0].Path != "a" || dirs[0].Tokens != 5 || dirs[0].Files != 2 || dirs[1].Tokens != 2 {
+		t.Errorf("DirTotals() = %+v, want a=5 (2 files) then a/b=2", dirs)
+	}
+
+	SortByTokens(counted)
+	if filepath.ToSlash(counted[0].Path) != "a/one.txt" {
+		t.Errorf("SortByTokens() first = %s, want a/one.txt", counted[0].Path)
+	}
+}
@@ -0,0 +1,223 @@
+package files
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// rule is a single compiled .gitignore pattern
+type rule struct {
+	re       *regexp.Regexp
+	negate   bool // Pattern started with "!"
+	dirOnly  bool // Pattern ended with "/"
+	basename bool // Pattern has no slash and matches the name at any depth
+}
+
+// match reports whether the rule matches rel, a slash-separated path relative
+// to the directory holding the .gitignore file
+func (r rule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.basename {
+		return r.re.MatchString(path.Base(rel))
+	}
+	return r.re.MatchString(rel)
+}
+
+// parseIgnore compiles the patterns of a .gitignore file
+func parseIgnore(content string) []rule {
+	var rules []rule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if r, ok := parseIgnoreLine(line); ok {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// parseIgnoreLine compiles one .gitignore line; blank lines and comments yield no rule
+func parseIgnoreLine(line string) (rule, bool) {
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule{}, false
+	}
+
+	var r rule
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule{}, false
+	}
+
+	// A slash anywhere but the end anchors the pattern to the .gitignore directory
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		r.basename = true
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return rule{}, false
+	}
+	r.re = re
+	return r, true
+}
+
+// globToRegexp translates a gitignore-style glob to a regular expression.
+// "*" and "?" do not cross slashes; "**" does.
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// Leading or middle "**/" matches zero or more directories
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// MatchGlob reports whether a slash-separated path matches a glob. As in
+// .gitignore, globs without a slash match the file name at any depth, and
+// "**" matches any number of directories.
+func MatchGlob(pattern, path string) bool {
+	r, ok := parseIgnoreLine(strings.TrimPrefix(filepath.ToSlash(pattern), "./"))
+	if !ok || r.negate {
+		return false
+	}
+	return r.match(strings.TrimPrefix(path, "./"), false)
+}
+
+// Matcher decides whether paths are ignored by the .gitignore files between a
+// root directory and the path. Rules in deeper files override shallower ones,
+// and later rules override earlier ones, as in git.
+type Matcher struct {
+	root    string
+	exclude []rule // From .git/info/exclude
+	mu      sync.Mutex
+	rules   map[string][]rule // Keyed by slash-separated directory relative to root
+}
+
+// NewMatcher creates a matcher for paths under root. When root is inside a
+// git repository, .git/info/exclude is honored as well.
+func NewMatcher(root string) *Matcher {
+	m := &Matcher{root: root, rules: make(map[string][]rule)}
+	if data, err := os.ReadFile(filepath.Join(root, ".git", "info", "exclude")); err == nil {
+		m.exclude = parseIgnore(string(data))
+	}
+	return m
+}
+
+// Root returns the directory the matcher's paths are relative to
+func (m *Matcher) Root() string {
+	return m.root
+}
+
+// Ignored reports whether rel, a path relative to the matcher's root, is ignored
+func (m *Matcher) Ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(filepath.Clean(rel))
+	if rel == "." || rel == "" {
+		return false
+	}
+
+	ignored := false
+	apply := func(rules []rule, sub string) {
+		for _, r := range rules {
+			if r.match(sub, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+
+	apply(m.exclude, rel)
+
+	// Walk the ancestors from the root down: ".", "a", "a/b", ...
+	dir := "."
+	parts := strings.Split(rel, "/")
+	for i := 0; i < len(parts); i++ {
+		sub := strings.Join(parts[i:], "/")
+		apply(m.load(dir), sub)
+		dir = path.Join(dir, parts[i])
+	}
+	return ignored
+}
+
+// load returns the rules of the .gitignore file in dir, reading it once
+func (m *Matcher) load(dir string) []rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rules, ok := m.rules[dir]; ok {
+		return rules
+	}
+	var rules []rule
+	if data, err := os.ReadFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore")); err == nil {
+		rules = parseIgnore(string(data))
+	}
+	m.rules[dir] = rules
+	return rules
+}
+
+// FindRoot returns the nearest directory at or above dir that contains .git,
+// or dir itself when it is not inside a git repository
+func FindRoot(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	for d := abs; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return abs
+		}
+		d = parent
+	}
+}
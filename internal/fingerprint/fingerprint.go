@@ -0,0 +1,310 @@
+// Package fingerprint normalises shell commands into templates, so that runs
+// of the same operation group together: `psql -c "SELECT * FROM users WHERE
+// id=1"` and `...id=2` share the template `psql -c "SELECT * FROM users
+// WHERE id=?"` and its fingerprint.
+//
+// Literals are replaced with placeholders: numbers become N, and hashes,
+// UUIDs, URLs and paths become <hash>, <uuid>, <url> and <path>. Quoted
+// arguments become ?, except SQL, whose values are replaced instead. Git,
+// kubectl and SQL clients have their own rules for refs, pod names and
+// queries.
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Size is the length of a fingerprint in hex characters
+const Size = 16
+
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Of returns the fingerprint of a command: a hash of its template
+func Of(command string) string {
+	sum := sha256.Sum256([]byte(Template(command)))
+	return hex.EncodeToString(sum[:Size/2])
+}
+
+// IsFingerprint reports whether s has the form of a fingerprint
+func IsFingerprint(s string) bool {
+	return fingerprintPattern.MatchString(s)
+}
+
+// Template returns the normalised form of a command
+func Template(command string) string {
+	var out, redirects []string
+	var stage []word
+	flush := func() {
+		out = append(out, templateStage(stage)...)
+		out = append(out, redirects...)
+		stage, redirects = nil, nil
+	}
+
+	words := lex(command)
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case !w.op:
+			stage = append(stage, w)
+		case isRedirect(w.text):
+			// Redirections go after the stage's arguments, with their target as a path
+			redirects = append(redirects, w.text)
+			if !strings.HasSuffix(w.text, "&") && !endsInDigit(w.text) && i+1 < len(words) && !words[i+1].op {
+				redirects = append(redirects, "<path>")
+				i++
+			}
+		default:
+			flush()
+			out = append(out, w.text)
+		}
+	}
+	flush()
+	return strings.Join(out, " ")
+}
+
+// templateStage normalises one simple command: environment assignments,
+// the command name, and its arguments by the command's rule
+func templateStage(words []word) []string {
+	var out []string
+	i := 0
+	for ; i < len(words) && isAssignment(words[i].text); i++ {
+		name, _, _ := strings.Cut(words[i].text, "=")
+		out = append(out, name+"=?")
+	}
+	if i == len(words) {
+		return out
+	}
+
+	name := words[i].text
+	out = append(out, name)
+	return append(out, rule(filepath.Base(name))(words[i+1:])...)
+}
+
+// rule returns the tool-aware normalisation of a command's arguments
+func rule(name string) func([]word) []string {
+	switch name {
+	case "git":
+		return gitArgs
+	case "kubectl":
+		return kubectlArgs
+	case "psql", "mysql", "mariadb", "sqlite3", "duckdb", "clickhouse-client":
+		return sqlArgs
+	}
+	return genericArgs
+}
+
+func genericArgs(args []word) []string {
+	out := make([]string, 0, len(args))
+	for _, arg := range args {
+		out = append(out, generic(arg))
+	}
+	return out
+}
+
+// generic normalises an argument without knowing the command
+func generic(w word) string {
+	if isFlag(w.text) {
+		if name, value, ok := strings.Cut(w.text, "="); ok {
+			if w.quoted {
+				return name + "=?"
+			}
+			return name + "=" + literal(value)
+		}
+		return w.text
+	}
+	if w.quoted {
+		if isSQL(w.text) {
+			return quoteSQL(w.text)
+		}
+		return "?"
+	}
+	return literal(w.text)
+}
+
+var (
+	urlPattern    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+	uuidPattern   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hashPattern   = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+	numberPattern = regexp.MustCompile(`^[-+]?\d+(\.\d+)?$`)
+	filePattern   = regexp.MustCompile(`^[\w.-]+\.[A-Za-z][A-Za-z0-9]{0,4}$`)
+	digitsPattern = regexp.MustCompile(`(^|[^A-Za-z])\d+`) // Not in names like s3 or ec2
+)
+
+// literal replaces a bare word with a placeholder when it is a literal, or
+// the numbers in it with N otherwise
+func literal(text string) string {
+	switch {
+	case urlPattern.MatchString(text):
+		return "<url>"
+	case uuidPattern.MatchString(text):
+		return "<uuid>"
+	case isHash(text):
+		return "<hash>"
+	case numberPattern.MatchString(text):
+		if strings.HasPrefix(text, "-") {
+			return "-N" // Counts such as head -20
+		}
+		return "N"
+	case isPath(text):
+		return "<path>"
+	}
+	return digitsPattern.ReplaceAllString(text, "${1}N")
+}
+
+// isHash reports whether text looks like a hex digest or abbreviated commit:
+// hex digits with both letters and digits
+func isHash(text string) bool {
+	return hashPattern.MatchString(text) && strings.ContainsAny(text, "0123456789") && strings.ContainsAny(text, "abcdef")
+}
+
+// isPath reports whether text looks like a file path: it has a slash, starts
+// at the home or current directory, or is a file name with an extension
+func isPath(text string) bool {
+	if strings.ContainsAny(text, "*?[") {
+		return false // Globs are patterns, not literals
+	}
+	return strings.ContainsRune(text, '/') || text == "." || text == ".." ||
+		strings.HasPrefix(text, "~") || strings.HasPrefix(text, "./") || filePattern.MatchString(text)
+}
+
+func isFlag(text string) bool {
+	return len(text) > 1 && text[0] == '-' && !numberPattern.MatchString(text)
+}
+
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+func isAssignment(text string) bool {
+	return assignmentPattern.MatchString(text)
+}
+
+// word is a shell word or operator
+type word struct {
+	text   string // With quotes and escapes removed
+	quoted bool   // Some or all of the word was quoted
+	op     bool   // An operator: | || && ; & or a redirection
+}
+
+const operatorChars = "|&;<>"
+
+// lex splits a command into words and operators the way a shell would,
+// without expanding anything
+func lex(command string) []word {
+	var words []word
+	var cur strings.Builder
+	inWord, quoted := false, false
+	flush := func() {
+		if inWord {
+			words = append(words, word{text: cur.String(), quoted: quoted})
+		}
+		cur.Reset()
+		inWord, quoted = false, false
+	}
+
+	rs := []rune(command)
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := i + 1
+			for end < len(rs) && rs[end] != c {
+				if c == '"' && rs[end] == '\\' && end+1 < len(rs) {
+					end++
+				}
+				end++
+			}
+			cur.WriteString(string(rs[i+1 : end]))
+			inWord, quoted = true, true
+			i = end
+		case c == '\\' && i+1 < len(rs):
+			cur.WriteRune(rs[i+1])
+			inWord = true
+			i++
+		case unicode.IsSpace(c):
+			flush()
+		case strings.ContainsRune(operatorChars, c):
+			// A file descriptor before a redirection belongs to it (2>&1)
+			prefix := ""
+			if inWord && !quoted && isDigits(cur.String()) && (c == '<' || c == '>') {
+				prefix = cur.String()
+				cur.Reset()
+				inWord = false
+			}
+			flush()
+			j := i
+			for j < len(rs) && strings.ContainsRune(operatorChars, rs[j]) {
+				j++
+			}
+			if rs[j-1] == '&' && strings.ContainsAny(string(rs[i:j]), "<>") {
+				for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '-') {
+					j++
+				}
+			}
+			words = append(words, word{text: prefix + string(rs[i:j]), op: true})
+			i = j - 1
+		default:
+			cur.WriteRune(c)
+			inWord = true
+		}
+	}
+	flush()
+	return words
+}
+
+func isRedirect(op string) bool {
+	return strings.ContainsAny(op, "<>")
+}
+
+func endsInDigit(op string) bool {
+	return op != "" && (unicode.IsDigit(rune(op[len(op)-1])) || op[len(op)-1] == '-')
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !unicode.IsDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stage is one simple command of a shell command line
+type Stage struct {
+	Words  []string // With quotes removed
+	Writes bool     // Output is redirected to a file other than /dev/null
+}
+
+// Stages splits a command line into its simple commands, at pipes, && ||
+// ; and &, the way a shell would without expanding anything
+func Stages(command string) []Stage {
+	var stages []Stage
+	var cur Stage
+	words := lex(command)
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case !w.op:
+			cur.Words = append(cur.Words, w.text)
+		case isRedirect(w.text):
+			// A redirection's target is not a word of the command
+			if strings.HasSuffix(w.text, "&") || endsInDigit(w.text) || i+1 == len(words) || words[i+1].op {
+				continue // 2>&1
+			}
+			if strings.Contains(w.text, ">") && words[i+1].text != "/dev/null" {
+				cur.Writes = true
+			}
+			i++
+		default:
+			stages = append(stages, cur)
+			cur = Stage{}
+		}
+	}
+	return append(stages, cur)
+}
@@ -0,0 +1,99 @@
+package fingerprint
+
+import "testing"
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		// Literals
+		{"seq 1 100", "seq N N"},
+		{"cat notes.txt", "cat <path>"},
+		{"ls -la /var/log/", "ls -la <path>"},
+		{"curl -s https://api.example.com/users/42", "curl -s <url>"},
+		{"docker inspect 3f2a9c1e8b7d", "docker inspect <hash>"},
+		{"aws s3 rm --request-id 6f1c2a4e-9b8d-4c3e-a1f2-0123456789ab", "aws s3 rm --request-id <uuid>"},
+		{`grep -rn "TODO: fix" src`, "grep -rn ? src"},
+		{"sleep 0.5 && echo done", "sleep N && echo done"},
+		{"npm run build --port=3000", "npm run build --port=N"},
+		{"DEBUG=1 make test", "DEBUG=? make test"},
+		{"make 2>&1 | tail -50 > build.log", "make 2>&1 | tail -N > <path>"},
+		{"find . -name '*.go'", "find <path> -name ?"},
+
+		// SQL, quoted or with the quotes removed by the shell
+		{`psql -c "SELECT * FROM users WHERE id=1"`, `psql -c "SELECT * FROM users WHERE id=?"`},
+		{`psql -c SELECT * FROM users WHERE id=2`, `psql -c "SELECT * FROM users WHERE id=?"`},
+		{`psql -d app -c "SELECT name FROM users WHERE email = 'a@b.c' AND age > 30 LIMIT 10" -A`,
+			`psql -d app -c "SELECT name FROM users WHERE email = ? AND age > ? LIMIT ?" -A`},
+		{`mysql -e "SELECT * FROM t WHERE id IN (1, 2, 3)"`, `mysql -e "SELECT * FROM t WHERE id IN (?)"`},
+		{`sqlite3 app.db "INSERT INTO t VALUES (1, 'a'), (2, 'b')"`, `sqlite3 <path> "INSERT INTO t VALUES (?, ?)"`},
+		{`psql --command="SELECT 1"`, `psql --command="SELECT ?"`},
+
+		// git
+		{"git show 3f2a9c1e", "git show <ref>"},
+		{"git diff HEAD~3..main -- cmd/root.go", "git diff <ref> -- <path>"},
+		{"git log -n 5 --oneline origin/main", "git log -n N --oneline <ref>"},
+		{"git add internal/history/store.go", "git add <path>"},
+		{`git commit -m "Fix the parser"`, "git commit -m ?"},
+		{"git -C ../other status", "git -C <path> status"},
+
+		// kubectl
+		{"kubectl logs api-7d9f8c6b5-x2k4p -n prod --tail 100", "kubectl logs api-* -n prod --tail N"},
+		{"kubectl logs pod/worker-4h2kq", "kubectl logs pod/worker-*"},
+		{"kubectl get pods -l app=web -o json", "kubectl get pods -l app=web -o json"},
+		{"kubectl exec -it db-0 -- psql -c 'SELECT 1'", `kubectl exec -it db-N -- psql -c "SELECT ?"`},
+	}
+	for _, tt := range tests {
+		if got := Template(tt.command); got != tt.want {
+			t.Errorf("Template(%q)\n got %q\nwant %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestOf(t *testing.T) {
+	a := Of(`psql -c "SELECT * FROM users WHERE id=1"`)
+	b := Of(`psql -c "SELECT * FROM users WHERE id=2"`)
+	c := Of(`psql -c "SELECT * FROM orders WHERE id=2"`)
+	if a != b {
+		t.Errorf("queries differing in a value have different fingerprints: %s, %s", a, b)
+	}
+	if a == c {
+		t.Error("queries on different tables share a fingerprint")
+	}
+	if !IsFingerprint(a) {
+		t.Errorf("Of() = %q, want %d hex characters", a, Size)
+	}
+	if IsFingerprint("git status") || IsFingerprint("3f2a9c1e") {
+		t.Error("IsFingerprint() accepts commands and short hashes")
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := map[string]string{
+		"select  *\n  from t -- all rows\n where a = 'it''s'":    "select * from t where a = ?",
+		"SELECT /* hint */ x1, \"Col 2\" FROM t WHERE v = -3.5;": `SELECT x1, "Col 2" FROM t WHERE v = -?`,
+		"UPDATE t SET n = n + 1 WHERE id IN (4,5)":               "UPDATE t SET n = n + ? WHERE id IN (?)",
+	}
+	for sql, want := range tests {
+		if got := NormalizeSQL(sql); got != want {
+			t.Errorf("NormalizeSQL(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
+
+func TestStages(t *testing.T) {
+	stages := Stages(`grep -r "a | b" src 2>/dev/null | sort > out.txt && echo 'done; ok'`)
+	if len(stages) != 3 {
+		t.Fatalf("Stages() = %+v, want 3 stages", stages)
+	}
+	if got := stages[0]; len(got.Words) != 4 || got.Words[2] != "a | b" || got.Writes {
+		t.Errorf("stage 0 = %+v", got)
+	}
+	if got := stages[1]; len(got.Words) != 1 || got.Words[0] != "sort" || !got.Writes {
+		t.Errorf("stage 1 = %+v, want sort writing a file", got)
+	}
+	if got := stages[2]; len(got.Words) != 2 || got.Words[1] != "done; ok" {
+		t.Errorf("stage 2 = %+v", got)
+	}
+}
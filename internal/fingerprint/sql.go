@@ -0,0 +1,146 @@
+package fingerprint
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	sqlStatement = regexp.MustCompile(`(?is)^\s*(SELECT|INSERT|UPDATE|DELETE|WITH|CREATE|ALTER|DROP|TRUNCATE|EXPLAIN|SHOW|DESCRIBE|DESC|COPY|VALUES)\b`)
+	sqlInList    = regexp.MustCompile(`(?i)\bIN \(\?(, \?)*\)`)
+	sqlTuples    = regexp.MustCompile(`\(\?(, \?)*\)(, \(\?(, \?)*\))+`)
+)
+
+// isSQL reports whether text starts like a SQL statement
+func isSQL(text string) bool {
+	return sqlStatement.MatchString(text)
+}
+
+// quoteSQL normalises a query and quotes it as one template word
+func quoteSQL(sql string) string {
+	return `"` + NormalizeSQL(sql) + `"`
+}
+
+// NormalizeSQL replaces the values in a query with ?: string and numeric
+// literals, IN lists and multi-row VALUES. Identifiers, including quoted
+// ones, are kept; comments are dropped and whitespace is collapsed.
+func NormalizeSQL(sql string) string {
+	var b strings.Builder
+	rs := []rune(sql)
+	space := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), " ") {
+			b.WriteByte(' ')
+		}
+	}
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		switch {
+		case c == '\'':
+			// String literal, with '' as an escaped quote
+			for i++; i < len(rs); i++ {
+				if rs[i] == '\'' {
+					if i+1 < len(rs) && rs[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case c == '"' || c == '`':
+			// Quoted identifier
+			end := i + 1
+			for end < len(rs) && rs[end] != c {
+				end++
+			}
+			b.WriteString(string(rs[i:min(end+1, len(rs))]))
+			i = end
+		case c == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			space()
+		case c == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i < len(rs) && !(rs[i-1] == '*' && rs[i] == '/') {
+				i++
+			}
+			space()
+		case unicode.IsDigit(c) && (i == 0 || !isIdentRune(rs[i-1])):
+			for i+1 < len(rs) && (unicode.IsDigit(rs[i+1]) || rs[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		case unicode.IsSpace(c):
+			space()
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	normalized := strings.TrimSpace(b.String())
+	normalized = strings.ReplaceAll(normalized, " ,", ",")
+	normalized = strings.ReplaceAll(normalized, ",?", ", ?")
+	normalized = strings.ReplaceAll(normalized, "( ", "(")
+	normalized = strings.ReplaceAll(normalized, " )", ")")
+	normalized = sqlInList.ReplaceAllStringFunc(normalized, func(s string) string {
+		return s[:2] + " (?)"
+	})
+	normalized = sqlTuples.ReplaceAllStringFunc(normalized, func(s string) string {
+		return s[:strings.Index(s, ")")+1]
+	})
+	return strings.TrimSuffix(normalized, ";")
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// sqlQueryFlags take the query to run as their value
+var sqlQueryFlags = map[string]bool{
+	"-c": true, "--command": true, // psql
+	"-e": true, "--execute": true, // mysql, mariadb
+	"-q": true, "--query": true, // clickhouse-client
+	"-cmd": true, // sqlite3
+}
+
+// sqlArgs normalises the arguments of a SQL client, whose query is the value
+// of a query flag or, for sqlite3 and duckdb, an argument after the database.
+// Queries whose quotes the shell already removed span the remaining words.
+func sqlArgs(args []word) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if name, value, ok := strings.Cut(arg.text, "="); ok && strings.HasPrefix(name, "--") && sqlQueryFlags[name] {
+			out = append(out, name+"="+quoteSQL(value))
+			continue
+		}
+		start := i
+		if sqlQueryFlags[arg.text] && i+1 < len(args) {
+			out = append(out, arg.text)
+			start = i + 1
+		} else if isFlag(arg.text) || !isSQL(arg.text) {
+			out = append(out, generic(arg))
+			continue
+		}
+		query, n := sqlFrom(args[start:])
+		out = append(out, quoteSQL(query))
+		i = start + n - 1
+	}
+	return out
+}
+
+// sqlFrom returns the query that starts at args and how many words it
+// spans: the first word when it was quoted, or the remaining words when the
+// shell removed the quotes
+func sqlFrom(args []word) (string, int) {
+	if args[0].quoted {
+		return args[0].text, 1
+	}
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		texts[i] = arg.text
+	}
+	return strings.Join(texts, " "), len(args)
+}
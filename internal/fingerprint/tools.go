@@ -0,0 +1,135 @@
+package fingerprint
+
+import (
+	"regexp"
+	"strings"
+)
+
+// gitRefCommands take revisions, branches or remotes as arguments
+var gitRefCommands = map[string]bool{
+	"show": true, "diff": true, "log": true, "shortlog": true, "checkout": true, "switch": true,
+	"branch": true, "rebase": true, "merge": true, "cherry-pick": true, "revert": true,
+	"reset": true, "push": true, "pull": true, "fetch": true, "tag": true, "describe": true,
+	"rev-parse": true, "rev-list": true, "merge-base": true,
+}
+
+// gitPathCommands take files as arguments
+var gitPathCommands = map[string]bool{
+	"add": true, "rm": true, "mv": true, "restore": true, "blame": true, "grep": true, "ls-files": true,
+}
+
+// gitValueFlags take the next word as their value
+var gitValueFlags = map[string]bool{
+	"-C": true, "-c": true, "-n": true, "-m": true, "-b": true, "-B": true, "-S": true, "-G": true,
+	"--author": true, "--grep": true, "--since": true, "--until": true, "--format": true,
+	"--pretty": true, "--max-count": true, "--message": true, "--date": true,
+}
+
+// gitArgs normalises git arguments: revisions and refs of history commands
+// become <ref>, files of path commands and after -- become <path>, and the
+// values of flags are normalised as literals
+func gitArgs(args []word) []string {
+	var out []string
+	subcommand := ""
+	paths := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg.text == "--" && !arg.quoted:
+			out = append(out, "--")
+			paths = true
+		case paths:
+			out = append(out, "<path>")
+		case gitValueFlags[arg.text] && i+1 < len(args):
+			value := generic(args[i+1])
+			if arg.text == "-C" {
+				value = "<path>"
+			}
+			out = append(out, arg.text, value)
+			i++
+		case isFlag(arg.text):
+			out = append(out, generic(arg))
+		case subcommand == "":
+			subcommand = arg.text
+			out = append(out, arg.text)
+		case gitRefCommands[subcommand]:
+			if isPath(arg.text) && strings.ContainsRune(arg.text, '.') && !strings.Contains(arg.text, "..") {
+				out = append(out, "<path>") // git log main.go
+			} else {
+				out = append(out, "<ref>")
+			}
+		case gitPathCommands[subcommand]:
+			out = append(out, "<path>")
+		default:
+			out = append(out, generic(arg))
+		}
+	}
+	return out
+}
+
+// kubectlValueFlags take the next word as their value
+var kubectlValueFlags = map[string]bool{
+	"-n": true, "--namespace": true, "-l": true, "--selector": true, "-c": true, "--container": true,
+	"-o": true, "--output": true, "--context": true, "-f": true, "--filename": true, "--tail": true,
+	"--since": true, "-p": true,
+}
+
+var (
+	// Pods of deployments (name-<replicaset hash>-<suffix>) and of jobs and
+	// daemonsets (name-<suffix>)
+	replicaSetPod = regexp.MustCompile(`^(.+?)-[a-z0-9]{6,10}-[a-z0-9]{5}$`)
+	generatedPod  = regexp.MustCompile(`^(.+?)-([a-z0-9]{5})$`)
+)
+
+// kubectlArgs normalises kubectl arguments: generated pod name suffixes
+// become *, so logs of any replica of a deployment group together, and the
+// command after -- (kubectl exec) is normalised as a command of its own
+func kubectlArgs(args []word) []string {
+	var out []string
+	verb := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg.text == "--" && !arg.quoted:
+			out = append(out, "--")
+			return append(out, templateStage(args[i+1:])...)
+		case kubectlValueFlags[arg.text] && i+1 < len(args):
+			out = append(out, arg.text, kubectlValue(arg.text, args[i+1]))
+			i++
+		case isFlag(arg.text):
+			out = append(out, generic(arg))
+		case verb == "":
+			verb = arg.text
+			out = append(out, arg.text)
+		default:
+			out = append(out, podName(arg.text))
+		}
+	}
+	return out
+}
+
+func kubectlValue(flag string, value word) string {
+	switch flag {
+	case "-n", "--namespace", "--context", "-o", "--output", "-l", "--selector":
+		return value.text // Where and what to look at is part of the operation
+	case "-c", "--container":
+		return podName(value.text)
+	}
+	return generic(value)
+}
+
+// podName replaces the generated suffix of a pod name with *, keeping any
+// resource type prefix (pod/api-7d9f8c6b5-x2k4p becomes pod/api-*)
+func podName(name string) string {
+	prefix := ""
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		prefix, name = name[:i+1], name[i+1:]
+	}
+	if m := replicaSetPod.FindStringSubmatch(name); m != nil {
+		return prefix + m[1] + "-*"
+	}
+	if m := generatedPod.FindStringSubmatch(name); m != nil && strings.ContainsAny(m[2], "0123456789") {
+		return prefix + m[1] + "-*"
+	}
+	return prefix + digitsPattern.ReplaceAllString(name, "${1}N")
+}
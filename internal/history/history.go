@@ -1,13 +1,10 @@
 package history
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/slavakurilyak/ctx/internal/models"
 )
 
@@ -18,65 +15,97 @@ const (
 )
 
 type HistoryManager struct {
-	enabled bool
-	baseDir string
+	enabled   bool
+	dir       string // History store directory
+	location  string // LocationProject or LocationState
+	retention Retention
+	fsync     bool // fsync every write to the store
 }
 
-func NewHistoryManager() *HistoryManager {
+// Option is a functional option for configuring a HistoryManager
+type Option func(*HistoryManager)
+
+// WithLocation sets where history is kept: LocationProject or LocationState
+func WithLocation(location string) Option {
+	return func(h *HistoryManager) {
+		h.location = location
+	}
+}
+
+// WithRetention sets the retention limits enforced after saving records;
+// without it, records are kept until 'ctx history prune' removes them
+func WithRetention(retention Retention) Option {
+	return func(h *HistoryManager) {
+		h.retention = retention
+	}
+}
+
+// WithFsync makes saves fsync the store, trading speed for durability
+func WithFsync(fsync bool) Option {
+	return func(h *HistoryManager) {
+		h.fsync = fsync
+	}
+}
+
+func NewHistoryManager(opts ...Option) *HistoryManager {
 	// Check if history is disabled
 	if os.Getenv("CTX_NO_HISTORY") == "true" {
 		return &HistoryManager{enabled: false}
 	}
 
-	// Determine base directory for history
-	baseDir := getHistoryBaseDir()
-
-	return &HistoryManager{
-		enabled: true,
-		baseDir: baseDir,
+	h := &HistoryManager{
+		enabled:  true,
+		location: LocationProject,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	// Determine the history directory for the current project
+	h.dir = ResolveDir(h.location, getWorkingDir())
+	return h
 }
 
+// SaveRecord appends an envelope to the history store and records its ID
+// in metadata.history_id. History is not critical to a run, so callers
+// report the error rather than fail on it.
 func (h *HistoryManager) SaveRecord(output *models.Output) error {
 	if !h.enabled || output == nil {
 		return nil
 	}
 
-	// Ensure history directory exists
-	historyDir := filepath.Join(h.baseDir, historyDirName)
-	if err := os.MkdirAll(historyDir, historyDirPerm); err != nil {
-		// Silently fail - history is not critical
-		return nil
-	}
-
-	// Generate filename with timestamp and UUID
-	filename := generateHistoryFilename()
-	filePath := filepath.Join(historyDir, filename)
-
-	// Marshal to JSON with indentation
-	data, err := json.MarshalIndent(output, "", "  ")
+	store, err := h.Open()
 	if err != nil {
-		return nil
+		return err
+	}
+	if _, err := store.Append(output); err != nil {
+		output.Metadata.HistoryID = ""
+		return fmt.Errorf("failed to save record: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(filePath, data, historyFilePerm); err != nil {
-		// Silently fail - history is not critical
-		return nil
+	// Keep the store within its retention limits, if any are set
+	now := time.Now()
+	if h.retention != (Retention{}) && store.dueForPrune(h.retention, now) {
+		if _, err := store.Prune(h.retention, now, false); err != nil {
+			return fmt.Errorf("record saved, but pruning failed: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func getHistoryBaseDir() string {
-	// Priority order:
-	// 1. CTX_HISTORY_DIR environment variable
-	// 2. Current working directory
-	// 3. Home directory as fallback
-
-	if dir := os.Getenv("CTX_HISTORY_DIR"); dir != "" {
-		return dir
+// Open opens the history store
+func (h *HistoryManager) Open() (*Store, error) {
+	if !h.enabled {
+		return nil, fmt.Errorf("history is disabled (CTX_NO_HISTORY or --no-history)")
 	}
+	return OpenStore(h.dir, SyncWrites(h.fsync))
+}
+
+func getWorkingDir() string {
+	// Priority order:
+	// 1. Current working directory
+	// 2. Home directory as fallback
 
 	// Try current working directory
 	if cwd, err := os.Getwd(); err == nil {
@@ -92,30 +121,12 @@ func getHistoryBaseDir() string {
 	return os.TempDir()
 }
 
-func generateHistoryFilename() string {
-	// Format: YYYY-MM-DD_HH-MM-SS_<uuid>.json
-	// Using underscores and hyphens for better readability and sorting
-	now := time.Now()
-	id := uuid.New()
-
-	return fmt.Sprintf(
-		"%04d-%02d-%02d_%02d-%02d-%02d_%s.json",
-		now.Year(), now.Month(), now.Day(),
-		now.Hour(), now.Minute(), now.Second(),
-		id.String(),
-	)
-}
-
-// HistoryRecord is no longer needed as we save Output directly
-
-// createHistoryRecord is no longer needed as Output already contains all fields
-
 // GetHistoryDir returns the path to the history directory
 func (h *HistoryManager) GetHistoryDir() string {
 	if !h.enabled {
 		return ""
 	}
-	return filepath.Join(h.baseDir, historyDirName)
+	return h.dir
 }
 
 // IsEnabled returns whether history recording is enabled
@@ -123,47 +134,12 @@ func (h *HistoryManager) IsEnabled() bool {
 	return h.enabled
 }
 
-// CleanOldRecords removes history files older than the specified duration
-func (h *HistoryManager) CleanOldRecords(maxAge time.Duration) error {
-	if !h.enabled {
-		return nil
-	}
-
-	historyDir := filepath.Join(h.baseDir, historyDirName)
-
-	// Read directory
-	entries, err := os.ReadDir(historyDir)
+// Prune removes the runs that fall outside the retention limits, or only
+// reports them with dryRun
+func (h *HistoryManager) Prune(dryRun bool) (*PruneResult, error) {
+	store, err := h.Open()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
+		return nil, err
 	}
-
-	cutoff := time.Now().Add(-maxAge)
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-
-		// Check if it's a JSON file
-		if filepath.Ext(entry.Name()) != ".json" {
-			continue
-		}
-
-		// Get file info
-		info, err := entry.Info()
-		if err != nil {
-			continue
-		}
-
-		// Remove if older than cutoff
-		if info.ModTime().Before(cutoff) {
-			filePath := filepath.Join(historyDir, entry.Name())
-			os.Remove(filePath) // Ignore errors
-		}
-	}
-
-	return nil
+	return store.Prune(h.retention, time.Now(), dryRun)
 }
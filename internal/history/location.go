@@ -0,0 +1,107 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/files"
+)
+
+// History locations
+const (
+	LocationProject = "project" // .ctx/ at the project (git) root
+	LocationState   = "state"   // $XDG_STATE_HOME/ctx/projects/<project>, outside the project
+)
+
+var unsafeKeyChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ResolveDir returns the history directory for runs in dir. CTX_HISTORY_DIR
+// overrides the location; otherwise history is kept per project, where the
+// project is the git repository containing dir, or dir itself.
+func ResolveDir(location, dir string) string {
+	if base := os.Getenv("CTX_HISTORY_DIR"); base != "" {
+		return filepath.Join(base, historyDirName)
+	}
+	root := files.FindRoot(dir)
+	if location == LocationState {
+		return filepath.Join(stateDir(), "projects", projectKey(root))
+	}
+	return filepath.Join(root, historyDirName)
+}
+
+// ValidLocation reports whether location is a known history location
+func ValidLocation(location string) bool {
+	return location == "" || location == LocationProject || location == LocationState
+}
+
+// stateDir returns $XDG_STATE_HOME/ctx, defaulting to ~/.local/state/ctx
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ctx")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "ctx")
+	}
+	return filepath.Join(os.TempDir(), "ctx-state")
+}
+
+// projectKey names a project's store in the state directory: the root's base
+// name, for humans, and a hash of its full path, to tell same-named projects apart
+func projectKey(root string) string {
+	sum := sha256.Sum256([]byte(root))
+	name := strings.Trim(unsafeKeyChars.ReplaceAllString(filepath.Base(root), "-"), "-")
+	if name == "" {
+		name = "root"
+	}
+	return name + "-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// FindStray returns the .ctx history directories under root other than
+// keep, skipping .git and dependency directories
+func FindStray(root, keep string) ([]string, error) {
+	keep = filepath.Clean(keep)
+	var stray []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // Unreadable directories are not ours to migrate
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case ".git", "node_modules", "vendor":
+			return filepath.SkipDir
+		case historyDirName:
+			if filepath.Clean(path) != keep && isHistoryDir(path) {
+				stray = append(stray, path)
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return stray, err
+}
+
+// isHistoryDir reports whether dir holds history written by ctx
+func isHistoryDir(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, logFileName)); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*_*.json"))
+	for _, match := range matches {
+		if legacyName.MatchString(filepath.Base(match)) {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyName matches per-file record names: YYYY-MM-DD_HH-MM-SS_<uuid>.json
+var legacyName = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}_[0-9a-f-]{36}\.json$`)
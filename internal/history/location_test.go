@@ -0,0 +1,102 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveDir(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	os.MkdirAll(filepath.Join(root, ".git"), 0755)
+	os.MkdirAll(sub, 0755)
+	t.Setenv("CTX_HISTORY_DIR", "")
+	t.Setenv("XDG_STATE_HOME", filepath.Join(root, "state"))
+
+	// Every subdirectory of a repository shares the store at its root
+	if got := ResolveDir(LocationProject, sub); got != filepath.Join(root, ".ctx") {
+		t.Errorf("ResolveDir(project) = %s", got)
+	}
+	state := ResolveDir(LocationState, sub)
+	if filepath.Dir(state) != filepath.Join(root, "state", "ctx", "projects") || !strings.HasPrefix(filepath.Base(state), filepath.Base(root)+"-") {
+		t.Errorf("ResolveDir(state) = %s", state)
+	}
+	if state != ResolveDir(LocationState, root) {
+		t.Error("ResolveDir(state) should be the same for a project's subdirectories")
+	}
+	if projectKey("/a/app") == projectKey("/b/app") {
+		t.Error("projects with the same name should get different keys")
+	}
+
+	t.Setenv("CTX_HISTORY_DIR", "/custom")
+	if got := ResolveDir(LocationState, sub); got != filepath.Join("/custom", ".ctx") {
+		t.Errorf("CTX_HISTORY_DIR should override the location, got %s", got)
+	}
+}
+
+func TestOpenStoreWritesGitignore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), ".ctx")
+	OpenStore(dir)
+	if data, err := os.ReadFile(filepath.Join(dir, gitignoreName)); err != nil || !strings.Contains(string(data), "*\n") {
+		t.Errorf(".gitignore = %q, %v", data, err)
+	}
+}
+
+func TestMigrateStrayStores(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+
+	target, _ := OpenStore(filepath.Join(root, ".ctx"))
+	target.Append(record("echo root", "", 0, 1, now, root))
+
+	// A store from a subdirectory, and legacy per-file records in another
+	strayA, _ := OpenStore(filepath.Join(root, "a", ".ctx"))
+	shared := record("echo a", "", 0, 1, now.Add(-2*time.Hour), root)
+	strayA.Append(shared)
+	legacyDir := filepath.Join(root, "b", "c", ".ctx")
+	os.MkdirAll(legacyDir, 0755)
+	os.WriteFile(filepath.Join(legacyDir, "2025-01-02_10-00-00_6f1c2a4e-9b8d-4c3e-a1f2-0123456789ab.json"),
+		[]byte(`{"input":"echo legacy","metadata":{"timestamp":"2025-01-02T10:00:00Z"}}`), 0644)
+	os.WriteFile(filepath.Join(legacyDir, "notes.txt"), []byte("mine"), 0644)
+	// Directories named .ctx that are not history are left alone
+	os.MkdirAll(filepath.Join(root, "d", ".ctx"), 0755)
+
+	stray, err := FindStray(root, target.Dir())
+	if err != nil || len(stray) != 2 {
+		t.Fatalf("FindStray() = %v, %v", stray, err)
+	}
+
+	var sources []*Store
+	for _, dir := range stray {
+		source, err := OpenStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+	// Records already in the target are not duplicated
+	target.Append(shared)
+	added, err := target.Merge(sources...)
+	if err != nil || added != 1 {
+		t.Fatalf("Merge() = %d, %v", added, err)
+	}
+	entries, _ := target.Entries()
+	if got := ids(entries); len(got) != 3 || got[0] != "echo legacy" || got[2] != "echo root" {
+		t.Errorf("merged entries = %v, want time order", got)
+	}
+
+	for _, source := range sources {
+		if err := source.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "a", ".ctx")); !os.IsNotExist(err) {
+		t.Errorf("empty stray directory should be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(legacyDir, "notes.txt")); err != nil {
+		t.Errorf("other files in a stray directory should be kept: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pruneMarkerName = ".last-prune" // Touched after every prune
+	pruneInterval   = time.Hour     // Minimum time between opportunistic prunes
+	day             = 24 * time.Hour
+)
+
+// Retention limits how much history is kept. Zero values disable a limit.
+type Retention struct {
+	MaxAge        time.Duration // Successful runs older than this are removed
+	FailureMaxAge time.Duration // Failed runs are kept this long instead, when longer than MaxAge
+	MaxRecords    int           // Oldest runs beyond this count are removed, successful ones first
+	MaxTotalBytes int64         // Oldest runs are removed until the log fits, successful ones first
+}
+
+// DefaultRetention is what 'ctx history prune' enforces for limits that are
+// not configured: a month of history, failures for three months, and at
+// most 5000 runs and 100MB per store. History is only pruned automatically
+// within limits that are configured.
+var DefaultRetention = Retention{
+	MaxAge:        30 * day,
+	FailureMaxAge: 90 * day,
+	MaxRecords:    5000,
+	MaxTotalBytes: 100 << 20,
+}
+
+// ParseAge parses a retention age such as "30d", "12h" or "0" (no limit)
+func ParseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * day, nil
+		}
+	}
+	if value == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q: use days (30d) or a duration (12h)", value)
+	}
+	return d, nil
+}
+
+// PruneResult describes what a prune removed, or would remove on a dry run
+type PruneResult struct {
+	Removed    []Entry `json:"removed"`     // Oldest first
+	Kept       int     `json:"kept"`        // Runs left in the store
+	FreedBytes int64   `json:"freed_bytes"` // Log and imported-file bytes released
+	DryRun     bool    `json:"dry_run"`
+}
+
+// Prune removes the runs that fall outside the retention limits. With
+// dryRun, it only reports what would be removed.
+func (s *Store) Prune(retention Retention, now time.Time, dryRun bool) (*PruneResult, error) {
+	l, err := s.lock(!dryRun)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	entries, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	remove := pruneSet(entries, retention, now)
+	result := &PruneResult{DryRun: dryRun}
+	var keep []Entry
+	for _, entry := range entries {
+		if remove[entry.ID] {
+			result.Removed = append(result.Removed, entry)
+			result.FreedBytes += entry.Length + 1
+		} else {
+			keep = append(keep, entry)
+		}
+	}
+	result.Kept = len(keep)
+
+	imported := s.importedFiles(remove)
+	for _, path := range imported {
+		if info, err := os.Stat(path); err == nil {
+			result.FreedBytes += info.Size()
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if len(result.Removed) > 0 {
+		if err := s.rewrite(keep); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range imported {
+		os.Remove(path) // Best effort; these are copies of imported records
+	}
+	s.touchPruneMarker(now)
+	return result, nil
+}
+
+// pruneSet returns the IDs of the entries to remove
+func pruneSet(entries []Entry, retention Retention, now time.Time) map[string]bool {
+	remove := make(map[string]bool)
+
+	// Age limits, with failures kept longer
+	for _, entry := range entries {
+		maxAge := retention.MaxAge
+		if entry.ExitCode != 0 && retention.FailureMaxAge > maxAge {
+			maxAge = retention.FailureMaxAge
+		}
+		if maxAge > 0 && now.Sub(entry.Time) > maxAge {
+			remove[entry.ID] = true
+		}
+	}
+
+	// Size limits evict the oldest successful runs before any failure
+	var successes, failures []Entry
+	count, size := 0, int64(0)
+	for _, entry := range entries {
+		if remove[entry.ID] {
+			continue
+		}
+		count++
+		size += entry.Length + 1
+		if entry.ExitCode == 0 {
+			successes = append(successes, entry)
+		} else {
+			failures = append(failures, entry)
+		}
+	}
+	over := func() bool {
+		return (retention.MaxRecords > 0 && count > retention.MaxRecords) ||
+			(retention.MaxTotalBytes > 0 && size > retention.MaxTotalBytes)
+	}
+	for _, candidate := range append(successes, failures...) {
+		if !over() {
+			break
+		}
+		remove[candidate.ID] = true
+		count--
+		size -= candidate.Length + 1
+	}
+	return remove
+}
+
+// dueForPrune reports whether an opportunistic prune should run: when a
+// size limit is exceeded, or when the last prune is more than an hour old
+func (s *Store) dueForPrune(retention Retention, now time.Time) bool {
+	if retention.MaxTotalBytes > 0 {
+		if info, err := os.Stat(s.logPath()); err == nil && info.Size() > retention.MaxTotalBytes {
+			return true
+		}
+	}
+	info, err := os.Stat(filepath.Join(s.dir, pruneMarkerName))
+	if err != nil || now.Sub(info.ModTime()) > pruneInterval {
+		return true
+	}
+	if retention.MaxRecords > 0 {
+		entries, err := s.Entries()
+		return err == nil && len(entries) > retention.MaxRecords
+	}
+	return false
+}
+
+func (s *Store) touchPruneMarker(now time.Time) {
+	path := filepath.Join(s.dir, pruneMarkerName)
+	if err := os.WriteFile(path, nil, historyFilePerm); err == nil {
+		os.Chtimes(path, now, now)
+	}
+}
+
+// importedFiles returns the imported per-file records of the given IDs
+func (s *Store) importedFiles(ids map[string]bool) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(filepath.Join(s.dir, importedDirName))
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if ids[legacyID(name)] {
+			paths = append(paths, filepath.Join(s.dir, importedDirName, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// rewrite replaces the log and index with only the kept entries. The caller
+// holds the exclusive lock. The new log is renamed into place first, so an
+// index left behind by an interrupted rewrite no longer covers the log and
+// is rebuilt on the next read.
+func (s *Store) rewrite(keep []Entry) error {
+	src, err := os.Open(s.logPath())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpLog, err := s.createTemp(logFileName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpLog.Name())
+
+	var index strings.Builder
+	var offset int64
+	for _, entry := range keep {
+		data := make([]byte, entry.Length)
+		if _, err := src.ReadAt(data, entry.Offset); err != nil {
+			tmpLog.Close()
+			return fmt.Errorf("failed to read history record %s: %w", entry.ID, err)
+		}
+		if _, err := tmpLog.Write(append(data, '\n')); err != nil {
+			tmpLog.Close()
+			return err
+		}
+		entry.Offset = offset
+		offset += entry.Length + 1
+		line, err := marshalEntry(entry)
+		if err != nil {
+			tmpLog.Close()
+			return err
+		}
+		index.Write(line)
+	}
+	if err := s.commitTemp(tmpLog, s.logPath()); err != nil {
+		return err
+	}
+	return s.writeFile(s.indexPath(), []byte(index.String()))
+}
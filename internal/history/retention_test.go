@@ -0,0 +1,126 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := map[string]time.Duration{"30d": 30 * day, "12h": 12 * time.Hour, "0": 0, "0d": 0}
+	for value, want := range tests {
+		if got, err := ParseAge(value); err != nil || got != want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "soon", "-3d", "-1h"} {
+		if _, err := ParseAge(value); err == nil {
+			t.Errorf("ParseAge(%q) should fail", value)
+		}
+	}
+}
+
+func TestPruneSet(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{
+		{ID: "old-ok", Time: now.Add(-40 * day), Length: 99},
+		{ID: "old-failed", Time: now.Add(-40 * day), ExitCode: 1, Length: 99},
+		{ID: "ancient-failed", Time: now.Add(-100 * day), ExitCode: 1, Length: 99},
+		{ID: "ok-1", Time: now.Add(-3 * day), Length: 99},
+		{ID: "failed-1", Time: now.Add(-2 * day), ExitCode: 2, Length: 99},
+		{ID: "ok-2", Time: now.Add(-1 * day), Length: 99},
+	}
+
+	// Failures outlive successes of the same age
+	remove := pruneSet(entries, Retention{MaxAge: 30 * day, FailureMaxAge: 90 * day}, now)
+	if !remove["old-ok"] || remove["old-failed"] || !remove["ancient-failed"] || len(remove) != 2 {
+		t.Errorf("age pruning removed %v", remove)
+	}
+
+	// Count and size limits evict the oldest successes before any failure
+	remove = pruneSet(entries, Retention{MaxRecords: 3}, now)
+	if len(remove) != 3 || !remove["old-ok"] || !remove["ok-1"] || !remove["ok-2"] {
+		t.Errorf("count pruning removed %v", remove)
+	}
+	remove = pruneSet(entries, Retention{MaxTotalBytes: 450}, now)
+	if len(remove) != 2 || !remove["old-ok"] || !remove["ok-1"] {
+		t.Errorf("size pruning removed %v", remove)
+	}
+
+	if remove := pruneSet(entries, Retention{}, now); len(remove) != 0 {
+		t.Errorf("no limits removed %v", remove)
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	now := time.Now()
+	old := record("echo old", "old\n", 0, 1, now.Add(-60*day), "/")
+	store.Append(old)
+	store.Append(record("false", "", 1, 0, now.Add(-60*day), "/"))
+	kept := record("echo new", "new\n", 0, 1, now, "/")
+	store.Append(kept)
+	os.WriteFile(filepath.Join(dir, importedDirName, "2025-01-01_00-00-00_"+old.Metadata.HistoryID+".json"), []byte("{}"), 0644)
+
+	// A dry run only reports
+	result, err := store.Prune(DefaultRetention, now, true)
+	if err != nil || len(result.Removed) != 1 || result.Removed[0].Input != "echo old" || result.Kept != 2 || result.FreedBytes == 0 {
+		t.Fatalf("Prune(dry run) = %+v, %v", result, err)
+	}
+	if entries, _ := store.Entries(); len(entries) != 3 {
+		t.Errorf("dry run removed records: %v", ids(entries))
+	}
+
+	result, err = store.Prune(DefaultRetention, now, false)
+	if err != nil || len(result.Removed) != 1 {
+		t.Fatalf("Prune() = %+v, %v", result, err)
+	}
+	entries, _ := store.Entries()
+	if got := ids(entries); len(got) != 2 || got[0] != "false" || got[1] != "echo new" {
+		t.Errorf("entries after prune = %v", got)
+	}
+	if output, err := store.Get(kept.Metadata.HistoryID); err != nil || output.Output != "new\n" {
+		t.Errorf("Get() after prune = %v, %v", output, err)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, importedDirName)); len(files) != 0 {
+		t.Errorf("imported copy of a pruned record was kept: %v", files)
+	}
+	if store.dueForPrune(DefaultRetention, now) {
+		t.Error("a store pruned just now should not be due")
+	}
+	if !store.dueForPrune(Retention{MaxRecords: 1}, now) {
+		t.Error("a store over its record limit should be due")
+	}
+}
+
+func TestSaveRecordEnforcesRetention(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CTX_HISTORY_DIR", dir)
+	t.Setenv("CTX_NO_HISTORY", "")
+
+	h := NewHistoryManager(WithRetention(Retention{MaxRecords: 2}))
+	for _, input := range []string{"echo 1", "echo 2", "echo 3"} {
+		h.SaveRecord(record(input, "", 0, 1, time.Now(), dir))
+	}
+	store, _ := h.Open()
+	if entries, _ := store.Entries(); len(entries) != 2 || entries[0].Input != "echo 2" {
+		t.Errorf("entries after saving past the limit = %v", ids(entries))
+	}
+}
+
+func TestSaveRecordKeepsHistoryByDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CTX_HISTORY_DIR", dir)
+	t.Setenv("CTX_NO_HISTORY", "")
+
+	// Runs past every default limit stay until pruned on purpose
+	h := NewHistoryManager()
+	h.SaveRecord(record("echo old", "", 0, 1, time.Now().Add(-400*day), dir))
+	h.SaveRecord(record("echo new", "", 0, 1, time.Now(), dir))
+	store, _ := h.Open()
+	if entries, _ := store.Entries(); len(entries) != 2 {
+		t.Errorf("entries without retention limits = %v", ids(entries))
+	}
+}
@@ -0,0 +1,797 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/slavakurilyak/ctx/internal/filelock"
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/telemetry"
+)
+
+const (
+	gitignoreName   = ".gitignore"
+	logFileName     = "history.jsonl" // Append-only log, one envelope per line
+	indexFileName   = "history.idx"   // One Entry per line, pointing into the log
+	lockFileName    = "history.lock"  // Locked while the log or index is read or written
+	importedDirName = "imported"      // Where per-file records go once imported
+	indexTailSize   = 64 << 10        // Bytes read from the end of the index to find its last entry
+)
+
+// Entry is the index entry of a history record. It holds what listing and
+// filtering need so the log is only read for show and search.
+type Entry struct {
+	ID          string    `json:"id"`
+	Offset      int64     `json:"offset"` // Byte offset of the record in the log
+	Length      int64     `json:"length"` // Length of the record, without the newline
+	Time        time.Time `json:"time"`
+	ExitCode    int       `json:"exit_code"`
+	Tokens      int       `json:"tokens"`
+	Type        string    `json:"type"` // Command type, e.g. "vcs" or "database"
+	Directory   string    `json:"dir"`
+	Input       string    `json:"input"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // Shared by runs of the same operation
+	CacheKey    string    `json:"cache_key,omitempty"`   // Key of a reusable result (see internal/cache)
+}
+
+// Filter selects history entries. Zero values match everything.
+type Filter struct {
+	Since       time.Time
+	Until       time.Time
+	ExitCode    *int
+	Failed      bool   // Only non-zero exit codes
+	Type        string // Command type
+	MinTokens   int
+	MaxTokens   int    // 0 means no upper bound
+	Directory   string // Records run in this directory or below it
+	Fingerprint string // Runs of the same operation (see internal/fingerprint)
+	CacheKey    string // Reusable results stored under this key
+	Limit       int    // Newest entries to return; 0 means all
+}
+
+// Match reports whether an entry passes the filter
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && e.Time.After(f.Until):
+		return false
+	case f.ExitCode != nil && e.ExitCode != *f.ExitCode:
+		return false
+	case f.Failed && e.ExitCode == 0:
+		return false
+	case f.Type != "" && e.Type != f.Type:
+		return false
+	case e.Tokens < f.MinTokens:
+		return false
+	case f.MaxTokens > 0 && e.Tokens > f.MaxTokens:
+		return false
+	case f.Directory != "" && !underDir(e.Directory, f.Directory):
+		return false
+	case f.Fingerprint != "" && e.Fingerprint != f.Fingerprint:
+		return false
+	case f.CacheKey != "" && e.CacheKey != f.CacheKey:
+		return false
+	}
+	return true
+}
+
+func underDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Store is an indexed history store: an append-only log of envelopes plus
+// an index of entries pointing into it.
+//
+// Stores are safe for concurrent use by any number of processes: writers
+// hold an exclusive lock on history.lock and readers a shared one, and
+// whole-file rewrites go to a temporary file that is renamed into place.
+type Store struct {
+	dir  string
+	sync bool // fsync files and directory after every write
+}
+
+// StoreOption is a functional option for configuring a Store
+type StoreOption func(*Store)
+
+// SyncWrites makes every write fsync before returning, so saved records
+// survive a crash or power loss at the cost of slower saves
+func SyncWrites(sync bool) StoreOption {
+	return func(s *Store) {
+		s.sync = sync
+	}
+}
+
+// OpenStore opens the history store in dir, creating it if needed and
+// importing any per-file records written by earlier versions
+func OpenStore(dir string, opts ...StoreOption) (*Store, error) {
+	if err := os.MkdirAll(dir, historyDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	s := &Store{dir: dir}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Keep history out of git status when it lives in a project
+	if filepath.Base(dir) == historyDirName {
+		gitignore := filepath.Join(dir, gitignoreName)
+		if _, err := os.Stat(gitignore); os.IsNotExist(err) {
+			s.writeFile(gitignore, []byte("# Created by ctx: history is local to this machine\n*\n"))
+		}
+	}
+	if err := s.importLegacy(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) logPath() string   { return filepath.Join(s.dir, logFileName) }
+func (s *Store) indexPath() string { return filepath.Join(s.dir, indexFileName) }
+
+// lock blocks until the store is locked, exclusively for writers
+func (s *Store) lock(exclusive bool) (*filelock.Lock, error) {
+	l, err := filelock.Acquire(filepath.Join(s.dir, lockFileName), exclusive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock history: %w", err)
+	}
+	return l, nil
+}
+
+// Append adds an envelope to the history, assigning it a new ID unless it
+// already has one, and returns the ID
+func (s *Store) Append(output *models.Output) (string, error) {
+	l, err := s.lock(true)
+	if err != nil {
+		return "", err
+	}
+	defer l.Release()
+	return s.append(output)
+}
+
+// append adds an envelope to the log and its entry to the index. The
+// caller holds the exclusive lock.
+func (s *Store) append(output *models.Output) (string, error) {
+	if output.Metadata.HistoryID == "" {
+		output.Metadata.HistoryID = uuid.New().String()
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return "", err
+	}
+
+	logFile, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, historyFilePerm)
+	if err != nil {
+		return "", err
+	}
+	defer logFile.Close()
+	info, err := logFile.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset, length := info.Size(), int64(len(data))
+
+	// An index that lags the log (after a crash between the two writes)
+	// must be rebuilt first, or the new entry would make it look complete
+	if !s.indexCovers(offset) {
+		if _, err := s.rebuildIndex(); err != nil {
+			return "", err
+		}
+	}
+
+	// Start on a new line if an earlier write was cut short
+	if offset > 0 {
+		last := make([]byte, 1)
+		if _, err := logFile.ReadAt(last, offset-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+			offset++
+		}
+	}
+	if _, err := logFile.Write(append(data, '\n')); err != nil {
+		return "", err
+	}
+	if s.sync {
+		if err := logFile.Sync(); err != nil {
+			return "", err
+		}
+	}
+
+	entry := newEntry(output, offset, length)
+	line, err := marshalEntry(entry)
+	if err != nil {
+		return "", err
+	}
+	indexFile, err := os.OpenFile(s.indexPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, historyFilePerm)
+	if err != nil {
+		return "", err
+	}
+	defer indexFile.Close()
+	if _, err := indexFile.Write(line); err != nil {
+		return "", err
+	}
+	if s.sync {
+		if err := indexFile.Sync(); err != nil {
+			return "", err
+		}
+	}
+	return entry.ID, nil
+}
+
+// indexCovers reports whether the last index entry ends where the log
+// does, reading only the end of the index
+func (s *Store) indexCovers(logSize int64) bool {
+	f, err := os.Open(s.indexPath())
+	if err != nil {
+		return logSize == 0
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if info.Size() == 0 {
+		return logSize == 0
+	}
+
+	start := info.Size() - indexTailSize
+	if start < 0 {
+		start = 0
+	}
+	tail := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(tail, start); err != nil {
+		return false
+	}
+	if tail[len(tail)-1] != '\n' {
+		return false // Cut-short index write
+	}
+	tail = tail[:len(tail)-1]
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	} else if start > 0 {
+		return false // Entry longer than the tail; let the rebuild sort it out
+	}
+	var last Entry
+	if err := json.Unmarshal(tail, &last); err != nil {
+		return false
+	}
+	return covers([]Entry{last}, logSize)
+}
+
+func newEntry(output *models.Output, offset, length int64) Entry {
+	fp := output.Metadata.Fingerprint
+	if fp == "" {
+		fp = fingerprint.Of(output.Input) // Recorded before fingerprints
+	}
+	cacheKey := ""
+	if cache := output.Metadata.Cache; cache != nil && !cache.Hit {
+		cacheKey = cache.Key
+	}
+	return Entry{
+		ID:          output.Metadata.HistoryID,
+		Offset:      offset,
+		Length:      length,
+		Time:        outputTime(output),
+		ExitCode:    output.Metadata.ExitCode,
+		Tokens:      output.Tokens,
+		Type:        telemetry.DetectCommandType(output.Input),
+		Directory:   output.Metadata.Directory,
+		Input:       output.Input,
+		Fingerprint: fp,
+		CacheKey:    cacheKey,
+	}
+}
+
+// marshalEntry encodes an entry as an index line
+func marshalEntry(entry Entry) ([]byte, error) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// Entries returns all index entries, oldest first. The index is rebuilt from
+// the log when it is missing or does not cover the whole log.
+func (s *Store) Entries() ([]Entry, error) {
+	l, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.entries()
+}
+
+// entries is Entries for callers that already hold a lock
+func (s *Store) entries() ([]Entry, error) {
+	info, err := os.Stat(s.logPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.readIndex()
+	if err == nil && covers(entries, info.Size()) {
+		return entries, nil
+	}
+	return s.rebuildIndex()
+}
+
+// covers reports whether the index entries end exactly where the log does
+func covers(entries []Entry, logSize int64) bool {
+	if len(entries) == 0 {
+		return logSize == 0
+	}
+	last := entries[len(entries)-1]
+	return last.Offset+last.Length+1 == logSize
+}
+
+func (s *Store) readIndex() ([]Entry, error) {
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("corrupt history index: %w", err)
+		}
+		if entry.Fingerprint == "" {
+			entry.Fingerprint = fingerprint.Of(entry.Input) // Indexed before fingerprints
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// rebuildIndex scans the log and replaces the index. Lines that do not
+// decode (e.g. a partial write) are skipped. Readers holding only the
+// shared lock may rebuild at the same time; each renames a complete index
+// into place, so the last one wins with the same content.
+func (s *Store) rebuildIndex() ([]Entry, error) {
+	logFile, err := os.Open(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	var entries []Entry
+	var index bytes.Buffer
+	reader := bufio.NewReader(logFile)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			record := line[:len(line)-1]
+			var output models.Output
+			if json.Unmarshal(record, &output) == nil && output.Metadata.HistoryID != "" {
+				entry := newEntry(&output, offset, int64(len(record)))
+				entries = append(entries, entry)
+				line, _ := marshalEntry(entry)
+				index.Write(line)
+			}
+		}
+		offset += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.writeFile(s.indexPath(), index.Bytes()); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writeFile atomically replaces path with data
+func (s *Store) writeFile(path string, data []byte) error {
+	tmp, err := s.createTemp(filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	return s.commitTemp(tmp, path)
+}
+
+// createTemp creates a temporary file next to the file it will replace, so
+// the final rename stays on one filesystem
+func (s *Store) createTemp(name string) (*os.File, error) {
+	return os.CreateTemp(s.dir, name+".*.tmp")
+}
+
+// commitTemp closes a temporary file from createTemp and renames it to path
+func (s *Store) commitTemp(tmp *os.File, path string) error {
+	if s.sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), historyFilePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if s.sync {
+		return syncDir(s.dir)
+	}
+	return nil
+}
+
+// List returns the entries matching the filter, newest first
+func (s *Store) List(filter Filter) ([]Entry, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return nil, err
+	}
+	return filterEntries(entries, filter), nil
+}
+
+func filterEntries(entries []Entry, filter Filter) []Entry {
+	var matched []Entry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !filter.Match(entries[i]) {
+			continue
+		}
+		matched = append(matched, entries[i])
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+	}
+	return matched
+}
+
+// Search returns the entries matching the filter whose command or output
+// contains every word of the query (case-insensitive), newest first
+func (s *Store) Search(query string, filter Filter) ([]Entry, error) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	l, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	entries, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+	limit := filter.Limit
+	filter.Limit = 0
+	candidates := filterEntries(entries, filter)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	logFile, err := os.Open(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	var matched []Entry
+	for _, entry := range candidates {
+		output, err := readRecord(logFile, entry)
+		if err != nil {
+			continue
+		}
+		text := strings.ToLower(output.Input + "\n" + output.Output)
+		if containsAll(text, terms) {
+			matched = append(matched, entry)
+			if limit > 0 && len(matched) == limit {
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func containsAll(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the record with the given ID or unique ID prefix
+func (s *Store) Get(id string) (*models.Output, error) {
+	l, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	entries, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := findEntry(entries, id)
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.Open(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+	return readRecord(logFile, entry)
+}
+
+// Find returns the entry with the given ID or unique ID prefix
+func (s *Store) Find(id string) (Entry, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return Entry{}, err
+	}
+	return findEntry(entries, id)
+}
+
+func findEntry(entries []Entry, id string) (Entry, error) {
+	var found []Entry
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+		if id != "" && strings.HasPrefix(entry.ID, id) {
+			found = append(found, entry)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Entry{}, fmt.Errorf("no history record %q", id)
+	case 1:
+		return found[0], nil
+	default:
+		return Entry{}, fmt.Errorf("history ID %q is ambiguous (%d records)", id, len(found))
+	}
+}
+
+// Records reads the envelopes of the given entries
+func (s *Store) Records(entries []Entry) ([]*models.Output, error) {
+	l, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.records(entries)
+}
+
+func (s *Store) records(entries []Entry) ([]*models.Output, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	logFile, err := os.Open(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+	defer logFile.Close()
+
+	outputs := make([]*models.Output, 0, len(entries))
+	for _, entry := range entries {
+		output, err := readRecord(logFile, entry)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// readRecord reads the envelope an entry points to. Entries read before a
+// prune rewrote the log point at other records, which the ID check catches.
+func readRecord(logFile *os.File, entry Entry) (*models.Output, error) {
+	data := make([]byte, entry.Length)
+	if _, err := logFile.ReadAt(data, entry.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read history record %s: %w", entry.ID, err)
+	}
+	var output models.Output
+	if err := json.Unmarshal(data, &output); err != nil || output.Metadata.HistoryID != entry.ID {
+		return nil, fmt.Errorf("corrupt or moved history record %s", entry.ID)
+	}
+	return &output, nil
+}
+
+// importLegacy appends the per-file records of earlier versions
+// (YYYY-MM-DD_HH-MM-SS_<uuid>.json) to the log, oldest first, and moves
+// them into the imported directory
+func (s *Store) importLegacy() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+	sort.Strings(paths)
+
+	l, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	importedDir := filepath.Join(s.dir, importedDirName)
+	if err := os.MkdirAll(importedDir, historyDirPerm); err != nil {
+		return err
+	}
+	// Records already in the log were imported by a run that stopped before moving them
+	entries, err := s.entries()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		known[entry.ID] = true
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue // Another process imported it first
+		}
+		var output models.Output
+		if err := json.Unmarshal(data, &output); err != nil || (output.Input == "" && output.Metadata.Timestamp == "") {
+			continue // Not a history record; leave it alone
+		}
+		if output.Metadata.HistoryID == "" {
+			output.Metadata.HistoryID = legacyID(filepath.Base(path))
+		}
+		if !known[output.Metadata.HistoryID] {
+			if _, err := s.append(&output); err != nil {
+				return fmt.Errorf("failed to import %s: %w", path, err)
+			}
+		}
+		if err := os.Rename(path, filepath.Join(importedDir, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyID takes the UUID from a per-file record name, or makes a new one
+func legacyID(name string) string {
+	name = strings.TrimSuffix(name, ".json")
+	if i := strings.LastIndex(name, "_"); i >= 0 {
+		if id, err := uuid.Parse(name[i+1:]); err == nil {
+			return id.String()
+		}
+	}
+	return uuid.New().String()
+}
+
+// Merge moves the records of other stores into s, skipping records it
+// already has, and rewrites the log in time order. It returns how many
+// records were added.
+func (s *Store) Merge(sources ...*Store) (int, error) {
+	l, err := s.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Release()
+
+	entries, err := s.entries()
+	if err != nil {
+		return 0, err
+	}
+	outputs, err := s.records(entries)
+	if err != nil && len(entries) > 0 {
+		return 0, err
+	}
+	known := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		known[entry.ID] = true
+	}
+
+	added := 0
+	for _, source := range sources {
+		sourceEntries, err := source.Entries()
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range sourceEntries {
+			if known[entry.ID] {
+				continue
+			}
+			records, err := source.Records([]Entry{entry})
+			if err != nil {
+				continue // Skip unreadable records rather than abandon the merge
+			}
+			known[entry.ID] = true
+			outputs = append(outputs, records[0])
+			added++
+		}
+	}
+	if added == 0 {
+		return 0, nil
+	}
+
+	sort.SliceStable(outputs, func(i, j int) bool {
+		return outputTime(outputs[i]).Before(outputTime(outputs[j]))
+	})
+	var log bytes.Buffer
+	for _, output := range outputs {
+		data, err := json.Marshal(output)
+		if err != nil {
+			return 0, err
+		}
+		log.Write(data)
+		log.WriteByte('\n')
+	}
+
+	if err := s.writeFile(s.logPath(), log.Bytes()); err != nil {
+		return 0, err
+	}
+	if _, err := s.rebuildIndex(); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+func outputTime(output *models.Output) time.Time {
+	ts, _ := time.Parse(time.RFC3339, output.Metadata.Timestamp)
+	return ts
+}
+
+// Remove deletes the files ctx keeps in the store, and the directory itself
+// when nothing else is left in it
+func (s *Store) Remove() error {
+	l, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{logFileName, indexFileName, pruneMarkerName, gitignoreName} {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			l.Release()
+			return err
+		}
+	}
+	// Temporary files left by interrupted rewrites
+	if leftovers, err := filepath.Glob(filepath.Join(s.dir, "history.*.tmp")); err == nil {
+		for _, path := range leftovers {
+			os.Remove(path)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(s.dir, importedDirName)); err != nil {
+		l.Release()
+		return err
+	}
+	l.Release()
+	os.Remove(filepath.Join(s.dir, lockFileName))
+
+	if remaining, err := os.ReadDir(s.dir); err == nil && len(remaining) == 0 {
+		return os.Remove(s.dir)
+	}
+	return nil
+}
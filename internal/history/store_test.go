@@ -0,0 +1,178 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func record(input, output string, exitCode, tokens int, ts time.Time, dir string) *models.Output {
+	return &models.Output{
+		Tokens: tokens,
+		Output: output,
+		Input:  input,
+		Metadata: models.MetadataSection{
+			ExitCode:  exitCode,
+			Timestamp: ts.Format(time.RFC3339),
+			Directory: dir,
+		},
+	}
+}
+
+func ids(entries []Entry) []string {
+	var out []string
+	for _, e := range entries {
+		out = append(out, e.Input)
+	}
+	return out
+}
+
+func TestStoreAppendListGet(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := []*models.Output{
+		record("git status", "On branch main\n", 0, 10, now.Add(-48*time.Hour), "/repo"),
+		record("psql -c 'select 1'", "connection refused\n", 2, 300, now.Add(-time.Hour), "/repo/db"),
+		record("ls -la", "total 0\n", 0, 50, now, "/tmp"),
+	}
+	records[2].Metadata.Cache = &models.CacheInfo{Key: "k1"}
+	for _, r := range records {
+		id, err := store.Append(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == "" || r.Metadata.HistoryID != id {
+			t.Fatalf("Append() = %q, history_id %q", id, r.Metadata.HistoryID)
+		}
+	}
+
+	all, _ := store.List(Filter{})
+	if got := ids(all); len(got) != 3 || got[0] != "ls -la" {
+		t.Errorf("List() = %v, want newest first", got)
+	}
+
+	failed := 2
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"since", Filter{Since: now.Add(-2 * time.Hour)}, 2},
+		{"until", Filter{Until: now.Add(-2 * time.Hour)}, 1},
+		{"exit code", Filter{ExitCode: &failed}, 1},
+		{"failed", Filter{Failed: true}, 1},
+		{"type", Filter{Type: "vcs"}, 1},
+		{"tokens", Filter{MinTokens: 20, MaxTokens: 100}, 1},
+		{"directory", Filter{Directory: "/repo"}, 2},
+		{"fingerprint", Filter{Fingerprint: fingerprint.Of("psql -c 'select 2'")}, 1},
+		{"cache key", Filter{CacheKey: "k1"}, 1},
+		{"limit", Filter{Limit: 2}, 2},
+	}
+	for _, tt := range tests {
+		got, err := store.List(tt.filter)
+		if err != nil || len(got) != tt.want {
+			t.Errorf("List(%s) = %v, %v; want %d entries", tt.name, ids(got), err, tt.want)
+		}
+	}
+
+	// Full IDs and unique prefixes both resolve
+	id := records[1].Metadata.HistoryID
+	for _, query := range []string{id, id[:8]} {
+		output, err := store.Get(query)
+		if err != nil || output.Output != "connection refused\n" {
+			t.Errorf("Get(%q) = %v, %v", query, output, err)
+		}
+	}
+	if _, err := store.Get("zzz"); err == nil {
+		t.Error("Get() should fail for unknown IDs")
+	}
+}
+
+func TestStoreSearch(t *testing.T) {
+	store, _ := OpenStore(t.TempDir())
+	now := time.Now()
+	store.Append(record("psql -c 'select 1'", "ERROR: Connection refused on port 5432\n", 2, 30, now, "/repo"))
+	store.Append(record("curl localhost:8080", "connection reset\n", 7, 5, now, "/repo"))
+
+	got, err := store.Search("connection REFUSED", Filter{})
+	if err != nil || len(got) != 1 || got[0].Input != "psql -c 'select 1'" {
+		t.Errorf("Search() = %v, %v", ids(got), err)
+	}
+	got, _ = store.Search("connection", Filter{Type: "http"})
+	if len(got) != 1 || got[0].Input != "curl localhost:8080" {
+		t.Errorf("Search() with filter = %v", ids(got))
+	}
+	if _, err := store.Search("  ", Filter{}); err == nil {
+		t.Error("Search() should reject empty queries")
+	}
+}
+
+func TestStoreRebuildsIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	store.Append(record("echo one", "one\n", 0, 1, time.Now(), "/"))
+	store.Append(record("echo two", "two\n", 0, 1, time.Now(), "/"))
+
+	// A missing index is rebuilt from the log
+	os.Remove(filepath.Join(dir, indexFileName))
+	entries, err := store.Entries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Entries() after losing the index = %v, %v", ids(entries), err)
+	}
+
+	// A cut-short write is skipped, and later records start on a new line
+	f, _ := os.OpenFile(filepath.Join(dir, logFileName), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"tokens":1,"inp`)
+	f.Close()
+	store.Append(record("echo three", "three\n", 0, 1, time.Now(), "/"))
+	entries, err = store.Entries()
+	if err != nil || len(entries) != 3 || entries[2].Input != "echo three" {
+		t.Fatalf("Entries() after a partial write = %v, %v", ids(entries), err)
+	}
+	if output, err := store.Get(entries[2].ID); err != nil || output.Output != "three\n" {
+		t.Errorf("Get() after a partial write = %v, %v", output, err)
+	}
+}
+
+func TestOpenStoreImportsLegacyRecords(t *testing.T) {
+	dir := t.TempDir()
+	legacy := map[string]string{
+		"2025-01-02_10-00-00_6f1c2a4e-9b8d-4c3e-a1f2-0123456789ab.json": `{"tokens":5,"output":"old\n","input":"echo old","metadata":{"timestamp":"2025-01-02T10:00:00Z"}}`,
+		"2025-01-03_10-00-00_7a2b3c4d-0000-4000-8000-000000000000.json": `{"tokens":7,"output":"newer\n","input":"echo newer","metadata":{"timestamp":"2025-01-03T10:00:00Z"}}`,
+		"notes.json": `not a record`,
+	}
+	for name, content := range legacy {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := store.List(Filter{})
+	if got := ids(entries); len(got) != 2 || got[0] != "echo newer" {
+		t.Fatalf("imported entries = %v", got)
+	}
+	if entries[1].ID != "6f1c2a4e-9b8d-4c3e-a1f2-0123456789ab" {
+		t.Errorf("imported record ID = %q, want the UUID from its file name", entries[1].ID)
+	}
+	if _, err := os.Stat(filepath.Join(dir, importedDirName, "2025-01-02_10-00-00_6f1c2a4e-9b8d-4c3e-a1f2-0123456789ab.json")); err != nil {
+		t.Errorf("imported record should be moved: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.json")); err != nil {
+		t.Errorf("files that are not records should be left alone: %v", err)
+	}
+
+	// Reopening does not import again
+	store, _ = OpenStore(dir)
+	if entries, _ := store.Entries(); len(entries) != 2 {
+		t.Errorf("reopening imported again: %v", ids(entries))
+	}
+}
@@ -0,0 +1,130 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStoreConcurrentWriters has hundreds of writers append at once, each
+// through its own Store (and so its own file descriptors, as separate ctx
+// processes would), while readers list and a pruner rewrites the log
+func TestStoreConcurrentWriters(t *testing.T) {
+	const writers, perWriter = 200, 5
+	dir := t.TempDir()
+	if _, err := OpenStore(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter+100)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			store, err := OpenStore(dir)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < perWriter; i++ {
+				input := fmt.Sprintf("echo %d-%d", w, i)
+				if _, err := store.Append(record(input, input+"\n", w%3, i, time.Now(), "/")); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 20; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store, _ := OpenStore(dir)
+			entries, err := store.List(Filter{Limit: 10})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := store.Records(entries); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	// A prune that removes nothing still rewrites the log and index
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		store, _ := OpenStore(dir)
+		if _, err := store.Prune(Retention{MaxRecords: writers * perWriter}, time.Now(), false); err != nil {
+			errs <- err
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	store, _ := OpenStore(dir)
+	entries, err := store.readIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != writers*perWriter {
+		t.Fatalf("index has %d entries, want %d", len(entries), writers*perWriter)
+	}
+	info, _ := os.Stat(filepath.Join(dir, logFileName))
+	if !covers(entries, info.Size()) {
+		t.Error("index does not cover the log")
+	}
+	records, err := store.Records(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if seen[r.Input] {
+			t.Errorf("duplicate record %q", r.Input)
+		}
+		seen[r.Input] = true
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestStoreRebuildsLaggingIndexBeforeAppend(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := OpenStore(dir)
+	store.Append(record("echo one", "one\n", 0, 1, time.Now(), "/"))
+
+	// A crash between the log and index writes leaves the index behind
+	index, _ := os.ReadFile(filepath.Join(dir, indexFileName))
+	store.Append(record("echo two", "two\n", 0, 1, time.Now(), "/"))
+	os.WriteFile(filepath.Join(dir, indexFileName), index, 0644)
+
+	store.Append(record("echo three", "three\n", 0, 1, time.Now(), "/"))
+	entries, err := store.readIndex()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("index after appending to a lagging one = %v, %v", ids(entries), err)
+	}
+}
+
+func TestStoreSyncWrites(t *testing.T) {
+	store, err := OpenStore(t.TempDir(), SyncWrites(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Append(record("echo synced", "synced\n", 0, 1, time.Now(), "/")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Prune(Retention{MaxRecords: 1}, time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := store.Entries(); len(entries) != 1 {
+		t.Errorf("Entries() = %v", ids(entries))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package history
+
+import "os"
+
+// syncDir flushes a directory entry, so a rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
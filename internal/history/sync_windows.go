@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package history
+
+// syncDir is a no-op on Windows, where directories cannot be synced
+func syncDir(dir string) error {
+	return nil
+}
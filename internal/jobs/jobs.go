@@ -0,0 +1,398 @@
+// Package jobs keeps the background jobs started with 'ctx run --detach'.
+//
+// Each job has a directory holding its state (job.json), the events its
+// supervisor streamed so far (events.jsonl, one numbered event per line) and,
+// once the command has finished, its envelope (result.json). The supervisor
+// is the only writer of a job's events and result.
+package jobs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/executor"
+	"github.com/slavakurilyak/ctx/internal/filelock"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+const (
+	jobDirPerm  = 0755
+	jobFilePerm = 0644
+
+	jobFileName    = "job.json"
+	eventsFileName = "events.jsonl"
+	resultFileName = "result.json"
+	lockFileName   = "job.lock" // Locked while job.json is written
+)
+
+// Job states
+const (
+	StateRunning = "running" // The supervisor is running the command
+	StateDone    = "done"    // The command exited with 0
+	StateFailed  = "failed"  // The command failed, or could not be started
+	StateKilled  = "killed"  // The job was stopped with 'ctx kill'
+	StateLost    = "lost"    // The supervisor exited without recording a result
+)
+
+// ErrNotFound is returned for an ID that matches no job
+var ErrNotFound = errors.New("job not found")
+
+// Job is a command running, or run, in the background
+type Job struct {
+	ID        string     `json:"id"`
+	Command   string     `json:"command"`
+	Directory string     `json:"directory"`
+	State     string     `json:"state"`
+	PID       int        `json:"pid,omitempty"` // Of the supervisor
+	Started   time.Time  `json:"started"`
+	Ended     *time.Time `json:"ended,omitempty"`
+	ExitCode  *int       `json:"exit_code,omitempty"`
+	HistoryID string     `json:"history_id,omitempty"` // History record of the finished run
+	LastSeq   int        `json:"last_seq"`             // Sequence number of the newest event
+}
+
+// Finished reports whether the job has stopped
+func (j *Job) Finished() bool {
+	return j.State != StateRunning
+}
+
+// Section reports the job in an envelope
+func (j *Job) Section() *models.JobSection {
+	section := &models.JobSection{
+		ID:       j.ID,
+		State:    j.State,
+		PID:      j.PID,
+		Started:  j.Started.Format(time.RFC3339),
+		ExitCode: j.ExitCode,
+		LastSeq:  j.LastSeq,
+	}
+	if j.Ended != nil {
+		section.Ended = j.Ended.Format(time.RFC3339)
+	}
+	return section
+}
+
+// Store reads and updates the jobs in a directory
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store for the jobs in dir
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// DefaultDir returns $XDG_STATE_HOME/ctx/jobs, defaulting to
+// ~/.local/state/ctx/jobs, next to sessions kept in the state directory
+func DefaultDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ctx", "jobs")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "ctx", "jobs")
+	}
+	return filepath.Join(os.TempDir(), "ctx-state", "jobs")
+}
+
+// Dir returns the directory of a job
+func (s *Store) Dir(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+// Create records a new running job for command, run in directory
+func (s *Store) Create(command, directory string) (*Job, error) {
+	if err := os.MkdirAll(s.dir, jobDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+	for {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Mkdir(s.Dir(id), jobDirPerm); errors.Is(err, os.ErrExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to create job directory: %w", err)
+		}
+		job := &Job{ID: id, Command: command, Directory: directory, State: StateRunning, Started: time.Now().UTC()}
+		return job, s.Update(job)
+	}
+}
+
+// Get returns the job with the given ID or unique ID prefix. A running job
+// whose supervisor has exited is reported as lost.
+func (s *Store) Get(id string) (*Job, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(id), jobFileName)); err == nil {
+		return s.read(id)
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), id) {
+			matches = append(matches, entry.Name())
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	case 1:
+		return s.read(matches[0])
+	default:
+		return nil, fmt.Errorf("job ID prefix %q is ambiguous (%s)", id, strings.Join(matches, ", "))
+	}
+}
+
+// List returns all jobs, newest first
+func (s *Store) List() ([]*Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		job, err := s.read(entry.Name())
+		if err != nil {
+			continue // Being created, or not a job
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Started.After(jobs[j].Started)
+	})
+	return jobs, nil
+}
+
+// Update saves a job's state
+func (s *Store) Update(job *Job) error {
+	l, err := s.lock(job.ID)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return s.save(job)
+}
+
+// SetPID records the PID of a job's supervisor, unless the job has already
+// finished: the supervisor may record its result before the process that
+// started it gets here. It returns the job as saved.
+func (s *Store) SetPID(id string, pid int) (*Job, error) {
+	l, err := s.lock(id)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	job, err := s.load(id)
+	if err != nil || job.Finished() {
+		return job, err
+	}
+	job.PID = pid
+	return job, s.save(job)
+}
+
+// Finish records a job's envelope and marks it done, failed or, when it
+// was stopped, killed
+func (s *Store) Finish(job *Job, output *models.Output, killed bool) error {
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(s.Dir(job.ID), resultFileName), append(data, '\n')); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	exitCode := output.Metadata.ExitCode
+	job.Ended, job.ExitCode, job.HistoryID = &now, &exitCode, output.Metadata.HistoryID
+	switch {
+	case killed:
+		job.State = StateKilled
+	case output.Metadata.Success:
+		job.State = StateDone
+	default:
+		job.State = StateFailed
+	}
+	job.LastSeq = s.lastSeq(job.ID)
+	return s.Update(job)
+}
+
+// Result returns the envelope of a finished job
+func (s *Store) Result(id string) (*models.Output, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir(id), resultFileName))
+	if err != nil {
+		return nil, err
+	}
+	var output models.Output
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("corrupt job result %s: %w", id, err)
+	}
+	return &output, nil
+}
+
+// Events returns a job's events with a sequence number above since
+func (s *Store) Events(id string, since int) ([]models.StreamEvent, error) {
+	f, err := os.Open(filepath.Join(s.Dir(id), eventsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []models.StreamEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var event models.StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break // A line still being written
+		}
+		if event.Seq > since {
+			events = append(events, event)
+		}
+	}
+	return events, scanner.Err()
+}
+
+// read loads a job, marking it lost when its supervisor has gone
+func (s *Store) read(id string) (*Job, error) {
+	job, err := s.load(id)
+	if err != nil || job.Finished() {
+		return job, err
+	}
+	job.LastSeq = s.lastSeq(id)
+	if job.PID > 0 && !executor.ProcessAlive(job.PID) {
+		// The supervisor may have finished since the job was loaded
+		if finished, err := s.load(id); err == nil && finished.Finished() {
+			return finished, nil
+		}
+		job.State = StateLost
+	}
+	return job, nil
+}
+
+func (s *Store) load(id string) (*Job, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir(id), jobFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("corrupt job state %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// lock blocks until a job's state is locked
+func (s *Store) lock(id string) (*filelock.Lock, error) {
+	l, err := filelock.Acquire(filepath.Join(s.Dir(id), lockFileName), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock job %s: %w", id, err)
+	}
+	return l, nil
+}
+
+// save writes a job's state; the caller holds its lock
+func (s *Store) save(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.Dir(job.ID), jobFileName), append(data, '\n'))
+}
+
+// lastSeq returns the number of events a job has logged
+func (s *Store) lastSeq(id string) int {
+	events, err := s.Events(id, 0)
+	if err != nil || len(events) == 0 {
+		return 0
+	}
+	return events[len(events)-1].Seq
+}
+
+// Log appends numbered events to a job's event log
+type Log struct {
+	mu  sync.Mutex
+	f   *os.File
+	seq int
+}
+
+// OpenLog opens a job's event log for its supervisor
+func (s *Store) OpenLog(id string) (*Log, error) {
+	f, err := os.OpenFile(filepath.Join(s.Dir(id), eventsFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, jobFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f, seq: s.lastSeq(id)}, nil
+}
+
+// Append numbers an event and writes it to the log
+func (l *Log) Append(event models.StreamEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	event.Seq = l.seq
+	if data, err := json.Marshal(event); err == nil {
+		_, _ = l.f.Write(append(data, '\n'))
+	}
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// writeFile atomically replaces path with data
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), jobFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// newID returns a short random job ID
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func TestJobLifecycle(t *testing.T) {
+	store := NewStore(t.TempDir())
+	job, err := store.Create("go test ./...", "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.PID = os.Getpid()
+	if err := store.Update(job); err != nil {
+		t.Fatal(err)
+	}
+
+	log, err := store.OpenLog(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Append(models.StreamEvent{Type: "stdout", Line: "ok  pkg/a"})
+	log.Append(models.StreamEvent{Type: "stderr", Line: "FAIL pkg/b"})
+
+	got, err := store.Get(job.ID[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateRunning || got.LastSeq != 2 {
+		t.Errorf("Get() = %s with last_seq %d, want running with 2", got.State, got.LastSeq)
+	}
+
+	events, err := store.Events(job.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Seq != 2 || events[0].Line != "FAIL pkg/b" {
+		t.Errorf("Events(since 1) = %+v", events)
+	}
+
+	output := models.NewOutput(job.Command, []byte("ok  pkg/a\nFAIL pkg/b\n"), 1, 0)
+	log.Append(models.StreamEvent{Type: "result", Envelope: output})
+	log.Close()
+	if err := store.Finish(job, output, false); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = store.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateFailed || got.ExitCode == nil || *got.ExitCode != 1 || got.Ended == nil || got.LastSeq != 3 {
+		t.Errorf("finished job = %+v", got)
+	}
+	result, err := store.Result(job.ID)
+	if err != nil || result.Output != output.Output {
+		t.Errorf("Result() = %v, %v", result, err)
+	}
+}
+
+func TestLostAndMissingJobs(t *testing.T) {
+	store := NewStore(t.TempDir())
+	if _, err := store.Get("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing job = %v, want ErrNotFound", err)
+	}
+
+	job, err := store.Create("sleep 60", "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.PID = 1 << 30 // No such process
+	if err := store.Update(job); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].State != StateLost {
+		t.Errorf("List() = %+v, want one lost job", list)
+	}
+}
+
+func TestSetPIDKeepsFinishedState(t *testing.T) {
+	store := NewStore(t.TempDir())
+	job, err := store.Create("true", "/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := *job
+
+	// A running job gets the PID
+	if got, err := store.SetPID(job.ID, os.Getpid()); err != nil || got.PID != os.Getpid() || got.State != StateRunning {
+		t.Fatalf("SetPID() of a running job = %+v, %v", got, err)
+	}
+
+	// The supervisor finishes before its parent records the PID
+	if err := store.Finish(job, models.NewOutput(job.Command, nil, 0, 0), false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.SetPID(stale.ID, 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateDone || got.PID == 1<<30 {
+		t.Errorf("SetPID() of a finished job = %+v, want it done and unchanged", got)
+	}
+	if saved, err := store.Get(job.ID); err != nil || saved.State != StateDone {
+		t.Errorf("Get() = %+v, %v; want done", saved, err)
+	}
+}
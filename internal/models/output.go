@@ -10,17 +10,29 @@ import (
 // - Increment MINOR (e.g., 0.1 -> 0.2) for any changes before 1.0
 // - Version 1.0 will indicate a stable, backward-compatible schema
 // See docs/VERSIONING.md for detailed versioning strategy.
-const CurrentSchemaVersion = "0.1"
+const CurrentSchemaVersion = "0.2"
 
 // Output represents the final output structure for ctx commands
 // This is the structure that gets printed to console and saved to history
 type Output struct {
-	Tokens        int               `json:"tokens"`   // Token count - most important, shown first
-	Output        string            `json:"output"`   // Command output - second most important
-	Input         string            `json:"input"`    // Command executed - third
-	Metadata      MetadataSection   `json:"metadata"` // Additional details
-	Telemetry     *TelemetrySection `json:"telemetry,omitempty"`
-	SchemaVersion string            `json:"schema_version"` // Schema version for parsers
+	Tokens         int               `json:"tokens"`                    // Token count - most important, shown first
+	FullTokens     int               `json:"full_tokens,omitempty"`     // Tokens of the whole output when only a delta is shown (--delta), or none (--probe)
+	TokensByModel  map[string]int    `json:"tokens_by_model,omitempty"` // Per-model token counts when several models are configured
+	TokenEstimate  *TokenEstimate    `json:"token_estimate,omitempty"`  // How tokens were approximated; absent when counted exactly
+	TokenBreakdown *TokenBreakdown   `json:"token_breakdown,omitempty"` // Where the tokens go (--explain-tokens)
+	Count          *CountSection     `json:"count,omitempty"`           // Per-file and per-directory totals (ctx count)
+	Pack           *PackSection      `json:"pack,omitempty"`            // What went into a context bundle (ctx pack)
+	Diff           *DiffSection      `json:"diff,omitempty"`            // How two recorded runs differ (ctx diff)
+	Delta          *DeltaSection     `json:"delta,omitempty"`           // What changed since the previous run (--delta)
+	Seq            *SeqSection       `json:"seq,omitempty"`             // The steps of a workflow (ctx seq)
+	Probe          *ProbeSection     `json:"probe,omitempty"`           // What a withheld output holds (--probe)
+	Output         string            `json:"output"`                    // Command output - second most important
+	Input          string            `json:"input"`                     // Command executed - third
+	Metadata       MetadataSection   `json:"metadata"`                  // Additional details
+	Session        *SessionSection   `json:"session,omitempty"`         // Running totals of the agent session (ctx session)
+	Job            *JobSection       `json:"job,omitempty"`             // The background job the run belongs to (ctx run --detach)
+	Telemetry      *TelemetrySection `json:"telemetry,omitempty"`
+	SchemaVersion  string            `json:"schema_version"` // Schema version for parsers
 }
 
 // MetadataSection contains execution details and context
@@ -36,25 +48,241 @@ type MetadataSection struct {
 	Bytes    int `json:"bytes"`    // Output size in bytes
 
 	// Context information
-	Timestamp string `json:"timestamp"`  // RFC3339 formatted timestamp
-	Directory string `json:"directory"`  // Working directory
-	User      string `json:"user"`       // Username
-	Host      string `json:"host"`       // Hostname
-	SessionID string `json:"session_id"` // Unique session identifier
+	Timestamp string `json:"timestamp"`            // RFC3339 formatted timestamp
+	Directory string `json:"directory"`            // Working directory
+	User      string `json:"user"`                 // Username
+	Host      string `json:"host"`                 // Hostname
+	SessionID string `json:"session_id,omitempty"` // Agent session the run belongs to (ctx session)
+	HistoryID string `json:"history_id,omitempty"` // ID of the history record (ctx history show <id>)
+
+	// Normalised command, shared by runs of the same operation (ctx history list --fingerprint)
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Tokenizer status (only populated when token counting was requested but unavailable)
+	TokenizerStatus string `json:"tokenizer_status,omitempty"` // "unavailable" or "error"
+	TokenizerError  string `json:"tokenizer_error,omitempty"`  // Why tokens could not be counted
 
 	// Limit information (only populated when limits are applied)
 	Limits *LimitInfo `json:"limits,omitempty"` // Information about applied limits
+
+	// Result caching (only populated when a cache TTL applies to the command)
+	Cache *CacheInfo `json:"cache,omitempty"`
+
+	// Problems that did not fail the run, e.g. "history: failed to lock history: ..."
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// TokenEstimate describes an approximate token count and its error bounds
+type TokenEstimate struct {
+	Method       string  `json:"method"`                  // "sampled" or "heuristic"
+	Low          int     `json:"low"`                     // Lower bound of the token count
+	High         int     `json:"high"`                    // Upper bound of the token count
+	Confidence   float64 `json:"confidence,omitempty"`    // Confidence level of the bounds (sampled only)
+	SampledBytes int     `json:"sampled_bytes,omitempty"` // Bytes actually tokenized (sampled only)
+}
+
+// TokenBreakdown explains where the tokens of an output are spent
+type TokenBreakdown struct {
+	TopLines    []LineCost    `json:"top_lines"`              // Most expensive lines, most expensive first
+	SectionKind string        `json:"section_kind,omitempty"` // What sections are, e.g. "file" or "column"
+	Sections    []SectionCost `json:"sections,omitempty"`     // Tokens per detected section, most expensive first
+	Shares      TokenShares   `json:"shares"`                 // Share of tokens spent on common sources of waste
+	Suggestions []string      `json:"suggestions,omitempty"`  // Concrete ways to make the command cheaper
+	Estimated   bool          `json:"estimated,omitempty"`    // Costs are estimated from sizes, as tokens are when not counted exactly
+}
+
+// LineCost is the token cost of a single output line
+type LineCost struct {
+	Line   int    `json:"line"`   // 1-based line number
+	Tokens int    `json:"tokens"` // Tokens on the line
+	Text   string `json:"text"`   // The line, truncated for long lines
+}
+
+// SectionCost is the token cost of a detected section of the output
+type SectionCost struct {
+	Name   string  `json:"name"`   // File name, column name, ...
+	Tokens int     `json:"tokens"` // Tokens in the section
+	Share  float64 `json:"share"`  // Fraction of all tokens (0-1)
+}
+
+// TokenShares are fractions (0-1) of all tokens
+type TokenShares struct {
+	Whitespace      float64 `json:"whitespace"`       // Indentation and runs of spaces
+	RepeatedLines   float64 `json:"repeated_lines"`   // Lines identical to an earlier line
+	LongIdentifiers float64 `json:"long_identifiers"` // Hashes, UUIDs, long paths and names
+}
+
+// CountSection reports token counts for files and directories (ctx count)
+type CountSection struct {
+	Files       []FileCount   `json:"files"`                 // Counted files, most tokens first
+	Directories []DirCount    `json:"directories,omitempty"` // Directory totals, most tokens first
+	Skipped     []SkippedFile `json:"skipped,omitempty"`     // Files that were not counted
+}
+
+// FileCount is the token count of a single file
+type FileCount struct {
+	Path          string         `json:"path"`
+	Tokens        int            `json:"tokens"`
+	TokensByModel map[string]int `json:"tokens_by_model,omitempty"` // Per-model counts when several models are configured
+	Bytes         int64          `json:"bytes"`
+}
+
+// DirCount is the total token count of the counted files under a directory
+type DirCount struct {
+	Path   string `json:"path"`
+	Tokens int    `json:"tokens"`
+	Bytes  int64  `json:"bytes"`
+	Files  int    `json:"files"` // Number of counted files under the directory
+}
+
+// SkippedFile is a file that was selected but not counted
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`          // "binary", "unreadable", "tokenizer_error" or "budget"
+	Error  string `json:"error,omitempty"` // The tokenizer's error, for "tokenizer_error"
+}
+
+// PackSection reports what a context bundle contains (ctx pack)
+type PackSection struct {
+	Budget   int           `json:"budget,omitempty"` // Token budget, absent when unlimited
+	Format   string        `json:"format"`           // "markdown" or "xml"
+	Priority string        `json:"priority"`         // "recent", "size" or "path"
+	Included []PackedFile  `json:"included"`         // Files packed in full, in bundle order
+	Elided   []PackedFile  `json:"elided,omitempty"` // Files reduced to an outline of their signatures
+	Skipped  []SkippedFile `json:"skipped,omitempty"`
+}
+
+// PackedFile is a file in a context bundle
+type PackedFile struct {
+	Path   string `json:"path"`
+	Tokens int    `json:"tokens"` // Tokens of the file's block in the bundle
 }
 
 // LimitInfo contains information about applied limits
 type LimitInfo struct {
 	MaxLines       *int64 `json:"max_lines,omitempty"`        // Line limit that was applied
-	MaxOutputBytes *int64 `json:"max_output_bytes,omitempty"` // Byte limit that was applied  
+	MaxOutputBytes *int64 `json:"max_output_bytes,omitempty"` // Byte limit that was applied
 	MaxTokens      *int64 `json:"max_tokens,omitempty"`       // Token limit that was applied
 	ActualLines    int    `json:"actual_lines,omitempty"`     // Number of lines that were output
 	LimitReached   string `json:"limit_reached,omitempty"`    // Which limit was reached (if any)
 }
 
+// CacheInfo says whether an envelope was reused from an earlier run
+type CacheInfo struct {
+	Hit      bool   `json:"hit"`
+	Age      int64  `json:"age,omitempty"`       // Milliseconds since the cached run (hits only)
+	SourceID string `json:"source_id,omitempty"` // History ID of the cached run (hits only)
+	Key      string `json:"key,omitempty"`       // Key the result is stored under, when it can be reused
+}
+
+// DeltaSection describes an output shown as its difference from the
+// previous run of the same command in the same directory
+type DeltaSection struct {
+	BaseID    string `json:"base_id"`   // History ID of the run compared with
+	Unchanged bool   `json:"unchanged"` // The output is the same as then
+	Added     int    `json:"added"`     // Lines only in this run's output
+	Removed   int    `json:"removed"`   // Lines only in the previous run's output
+}
+
+// ProbeSection describes an output withheld because it had more tokens
+// than --probe allows
+type ProbeSection struct {
+	Threshold int64          `json:"threshold"`           // Tokens the output could have had to be printed
+	Lines     int            `json:"lines"`               // Lines in the output
+	Bytes     int            `json:"bytes"`               // Bytes in the output
+	Head      []string       `json:"head"`                // First lines, truncated when long
+	Tail      []string       `json:"tail,omitempty"`      // Last lines, when there are more than in head
+	Structure ProbeStructure `json:"structure"`           // What the output looks like
+	Spill     string         `json:"spill,omitempty"`     // File holding the whole output (--probe-spill)
+	Estimated bool           `json:"estimated,omitempty"` // Tokens were not counted, so they were estimated from the bytes
+}
+
+// ProbeStructure summarises the shape of an output
+type ProbeStructure struct {
+	Format string   `json:"format"`           // "json", "ndjson", "table", "csv", "tsv", "diff" or "text"
+	Items  int      `json:"items"`            // Array elements, records, rows, files or lines
+	Fields []string `json:"fields,omitempty"` // Keys, columns or file names
+}
+
+// DiffSection compares two recorded runs; the envelope's output is the
+// diff of their outputs
+type DiffSection struct {
+	From      string    `json:"from"`               // History ID of the first run
+	To        string    `json:"to"`                 // History ID of the second run
+	Mode      string    `json:"mode"`               // "line", "word" or "json"
+	Identical bool      `json:"identical"`          // The outputs are the same
+	Added     int       `json:"added"`              // Lines, words or JSON values only in the second output
+	Removed   int       `json:"removed"`            // Lines, words or JSON values only in the first output
+	Changed   int       `json:"changed,omitempty"`  // JSON values that differ (json mode)
+	Commands  []string  `json:"commands,omitempty"` // Both commands, when they differ
+	ExitCode  IntChange `json:"exit_code"`
+	Duration  IntChange `json:"duration"` // Milliseconds
+	Tokens    IntChange `json:"tokens"`
+}
+
+// IntChange is a measure of two runs and how much it changed
+type IntChange struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Delta int `json:"delta"` // To minus From
+}
+
+// SessionSection reports an agent session's totals, including this run
+type SessionSection struct {
+	ID              string  `json:"id"`
+	Commands        int     `json:"commands"`
+	Tokens          int64   `json:"tokens"`
+	Cost            float64 `json:"cost"`                       // USD
+	Budget          int64   `json:"budget,omitempty"`           // Token budget (--session-budget)
+	RemainingTokens *int64  `json:"remaining_tokens,omitempty"` // Tokens left in the budget; absent without one
+}
+
+// SeqSection reports how the steps of a workflow ran
+type SeqSection struct {
+	Name   string    `json:"name"`
+	Reason string    `json:"reason"`           // "completed", "failure", "budget_exhausted" or "interrupted"
+	Budget int64     `json:"budget,omitempty"` // Tokens all steps may use together
+	Tokens int       `json:"tokens"`           // Tokens of all steps' outputs, shown or quiet
+	Steps  []SeqStep `json:"steps"`
+}
+
+// SeqStep is a step of a workflow
+type SeqStep struct {
+	Name          string `json:"name"`
+	Branch        string `json:"branch"`              // "run", "else", "skipped" or "not_run"
+	Condition     string `json:"condition,omitempty"` // The step's if
+	Input         string `json:"input,omitempty"`     // The command that ran
+	ExitCode      int    `json:"exit_code"`
+	Success       bool   `json:"success"`
+	Tokens        int    `json:"tokens"`
+	Duration      int    `json:"duration"` // Milliseconds
+	FailureReason string `json:"failure_reason,omitempty"`
+	HistoryID     string `json:"history_id,omitempty"`
+}
+
+// BatchSummary reports how a batch ended
+type BatchSummary struct {
+	Reason    string `json:"reason"`    // "completed", "failure", "budget_exhausted", "time_budget_exhausted" or "interrupted"
+	Commands  int    `json:"commands"`  // Commands in the batch
+	Succeeded int    `json:"succeeded"` // Commands that ran and succeeded
+	Failed    int    `json:"failed"`    // Commands that ran and failed
+	Withheld  int    `json:"withheld"`  // Commands that ran but whose output would have exceeded the budget
+	Skipped   int    `json:"skipped"`   // Commands not run, or cut short, once the batch stopped
+	Tokens    int    `json:"tokens"`    // Tokens of the envelopes emitted
+	Duration  int    `json:"duration"`  // Milliseconds
+}
+
+// JobSection describes a background job
+type JobSection struct {
+	ID       string `json:"id"`
+	State    string `json:"state"`               // "running", "done", "failed", "killed" or "lost"
+	PID      int    `json:"pid,omitempty"`       // Supervisor process
+	Started  string `json:"started"`             // RFC3339
+	Ended    string `json:"ended,omitempty"`     // RFC3339
+	ExitCode *int   `json:"exit_code,omitempty"` // Once the command has exited
+	LastSeq  int    `json:"last_seq"`            // Sequence number of the newest event (ctx logs --since-seq)
+}
+
 // TelemetrySection contains optional OpenTelemetry trace information
 type TelemetrySection struct {
 	TraceID    string `json:"trace_id"`    // Distributed trace identifier
@@ -64,9 +292,35 @@ type TelemetrySection struct {
 
 // StreamEvent represents a streaming event for long-running commands
 type StreamEvent struct {
-	Type     string  `json:"type"`               // "stdout", "stderr", or "result"
-	Line     string  `json:"line,omitempty"`     // The line of output for stdout/stderr events
-	Envelope *Output `json:"envelope,omitempty"` // The final envelope for the result event
+	Seq       int           `json:"seq,omitempty"`       // Position in a background job's event log (ctx logs --since-seq)
+	Type      string        `json:"type"`                // "stdout", "stderr", or "result"; ctx watch emits "change", "until" and "stop", and ctx batch "result" and "stop"
+	Line      string        `json:"line,omitempty"`      // The line of output for stdout/stderr events
+	Envelope  *Output       `json:"envelope,omitempty"`  // The final envelope for the result event, or a watched run's
+	Iteration int           `json:"iteration,omitempty"` // Run of the watched command the event comes from (ctx watch)
+	Watch     *WatchSummary `json:"watch,omitempty"`     // How the watch ended, on its stop event
+	Index     int           `json:"index,omitempty"`     // Position of the command in the batch, from 1 (ctx batch)
+	ID        string        `json:"id,omitempty"`        // ID given to the command in the batch (ctx batch)
+	Batch     *BatchSummary `json:"batch,omitempty"`     // How the batch ended, on its stop event
+}
+
+// WatchSummary reports how a watch ended
+type WatchSummary struct {
+	Reason     string `json:"reason"`          // "until", "max_iterations", "budget_exhausted", "interrupted" or "error"
+	Iterations int    `json:"iterations"`      // Runs of the command
+	Events     int    `json:"events"`          // Envelopes emitted
+	Tokens     int    `json:"tokens"`          // Tokens of the envelopes emitted
+	Until      string `json:"until,omitempty"` // The condition watched for
+	Error      string `json:"error,omitempty"` // Why the condition could not be evaluated
+}
+
+// Chunk is one line of ctx chunk's NDJSON output
+type Chunk struct {
+	Index  int    `json:"index"`  // 0-based position of the chunk
+	Tokens int    `json:"tokens"` // Exact token count of the chunk text
+	Start  int    `json:"start"`  // Byte offset of the chunk in the source
+	End    int    `json:"end"`    // Byte offset just past the chunk
+	Source string `json:"source"` // File name, "-" for stdin, or the command that produced the input
+	Text   string `json:"text"`
 }
 
 // NewOutput creates a new Output structure
@@ -0,0 +1,138 @@
+// Package outline reduces source files to their signatures: declarations
+// without bodies, or headings for documents. Packing uses outlines for files
+// that do not fit the token budget in full.
+package outline
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Line patterns per language, for languages without a parser in the standard library
+var (
+	pythonDecl   = regexp.MustCompile(`^\s*(async\s+def|def|class)\s+\w+`)
+	jsDecl       = regexp.MustCompile(`^\s*(export\s+)?(default\s+)?(async\s+)?(function\*?|class|interface|type|enum|const\s+\w+\s*=\s*(async\s*)?\(|abstract\s+class)\b`)
+	rustDecl     = regexp.MustCompile(`^\s*(pub(\([^)]*\))?\s+)?(async\s+)?(unsafe\s+)?(fn|struct|enum|trait|impl|mod|type|const|static|macro_rules!)\b`)
+	javaDecl     = regexp.MustCompile(`^\s*(public|private|protected|internal|static|abstract|final|sealed|override|open|data|suspend|fun|class|interface|enum|record|object)\b[^;=]*[({]?\s*$`)
+	rubyDecl     = regexp.MustCompile(`^\s*(class|module|def)\s+`)
+	cDecl        = regexp.MustCompile(`^[A-Za-z_][\w\s\*:<>,]*\([^;]*\)\s*(const\s*)?\{?\s*$|^\s*(struct|class|enum|union|typedef|namespace|template)\b`)
+	shellDecl    = regexp.MustCompile(`^\s*(function\s+\w+|\w+\s*\(\)\s*\{?)`)
+	markdownHead = regexp.MustCompile(`^#{1,6}\s`)
+)
+
+// Of returns the outline of a file, or false when its language is not
+// supported or it has nothing to outline
+func Of(path string, content string) (string, bool) {
+	var lines []string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		if out, ok := goOutline(content); ok {
+			return out, true
+		}
+		return "", false
+	case ".py", ".pyi":
+		lines = matchLines(content, pythonDecl, true)
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		lines = matchLines(content, jsDecl, true)
+	case ".rs":
+		lines = matchLines(content, rustDecl, true)
+	case ".java", ".kt", ".kts", ".cs", ".scala", ".swift":
+		lines = matchLines(content, javaDecl, true)
+	case ".rb":
+		lines = matchLines(content, rubyDecl, true)
+	case ".c", ".h", ".cc", ".cpp", ".hpp", ".cxx":
+		lines = matchLines(content, cDecl, true)
+	case ".sh", ".bash", ".zsh":
+		lines = matchLines(content, shellDecl, true)
+	case ".md", ".markdown":
+		lines = matchLines(content, markdownHead, false)
+	default:
+		return "", false
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return strings.Join(lines, "\n") + "\n", true
+}
+
+// matchLines keeps the lines matching re; with stripBodies, a trailing "{"
+// or ":" body opener is replaced by an ellipsis marker
+func matchLines(content string, re *regexp.Regexp, stripBodies bool) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if !re.MatchString(line) {
+			continue
+		}
+		if stripBodies {
+			switch {
+			case strings.HasSuffix(line, "{"):
+				line = strings.TrimRight(strings.TrimSuffix(line, "{"), " ") + " { … }"
+			case strings.HasSuffix(line, ":") && !strings.HasSuffix(line, "::"):
+				line += " …"
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// goOutline prints a Go file's package clause, imports and declarations
+// with function bodies removed
+func goOutline(content string) (string, bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", content, parser.ParseComments)
+	if err != nil {
+		return "", false
+	}
+
+	// Drop function bodies and all comments but declaration doc comments
+	var docs []*ast.CommentGroup
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			d.Body = nil
+			if d.Doc != nil {
+				docs = append(docs, d.Doc)
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				docs = append(docs, d.Doc)
+			}
+			elideValues(d)
+		}
+	}
+	if file.Doc != nil {
+		docs = append([]*ast.CommentGroup{file.Doc}, docs...)
+	}
+	file.Comments = docs
+
+	var buf bytes.Buffer
+	if err := (&printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}).Fprint(&buf, fset, file); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// elideValues replaces composite literal and function literal values of
+// var and const declarations, which can be as long as function bodies
+func elideValues(d *ast.GenDecl) {
+	for _, spec := range d.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		for i, value := range vs.Values {
+			switch value.(type) {
+			case *ast.CompositeLit, *ast.FuncLit:
+				vs.Values[i] = &ast.Ident{Name: "…", NamePos: value.Pos()}
+			}
+		}
+	}
+}
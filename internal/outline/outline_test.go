@@ -0,0 +1,84 @@
+package outline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOfGo(t *testing.T) {
+	src := `// Package demo does things.
+package demo
+
+import "fmt"
+
+// Greeting is shown to users
+var Greeting = map[string]string{"en": "hello", "fr": "bonjour"}
+
+// Greet prints a greeting
+func Greet(lang string) error {
+	// inline comment
+	fmt.Println(Greeting[lang])
+	return nil
+}
+
+type Server struct{ Addr string }
+
+func (s *Server) Run() {
+	for {
+	}
+}
+`
+	out, ok := Of("demo.go", src)
+	if !ok {
+		t.Fatal("Of() should outline Go files")
+	}
+	for _, want := range []string{"// Package demo does things.", "package demo", "// Greet prints a greeting", "func Greet(lang string) error\n", "var Greeting = …", "type Server struct{ Addr string }", "func (s *Server) Run()"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("outline missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"fmt.Println", "inline comment", "bonjour"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("outline should not contain %q:\n%s", unwanted, out)
+		}
+	}
+
+	if _, ok := Of("broken.go", "package x\nfunc {"); ok {
+		t.Error("Of() should fail for Go files that do not parse")
+	}
+}
+
+func TestOfLineBased(t *testing.T) {
+	tests := []struct {
+		path, src string
+		want      []string
+		unwanted  string
+	}{
+		{"app.py", "import os\n\nclass App:\n    def run(self):\n        return 1\n", []string{"class App: …", "    def run(self): …"}, "return 1"},
+		{"index.ts", "export function add(a: number, b: number) {\n  return a + b\n}\ninterface Point { x: number }\n", []string{"export function add(a: number, b: number) { … }", "interface Point { x: number }"}, "return a + b"},
+		{"lib.rs", "pub fn parse(s: &str) -> u32 {\n    s.len() as u32\n}\nstruct Inner;\n", []string{"pub fn parse(s: &str) -> u32 { … }", "struct Inner;"}, "s.len()"},
+		{"README.md", "# Title\ntext\n## Usage\nmore text\n", []string{"# Title", "## Usage"}, "more text"},
+	}
+	for _, tt := range tests {
+		out, ok := Of(tt.path, tt.src)
+		if !ok {
+			t.Errorf("Of(%s) should produce an outline", tt.path)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("Of(%s) missing %q:\n%s", tt.path, want, out)
+			}
+		}
+		if strings.Contains(out, tt.unwanted) {
+			t.Errorf("Of(%s) should not contain %q:\n%s", tt.path, tt.unwanted, out)
+		}
+	}
+
+	if _, ok := Of("data.csv", "a,b\n1,2\n"); ok {
+		t.Error("Of() should not outline unsupported files")
+	}
+	if _, ok := Of("empty.py", "x = 1\n"); ok {
+		t.Error("Of() should fail when there is nothing to outline")
+	}
+}
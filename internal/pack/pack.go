@@ -0,0 +1,417 @@
+// Package pack builds a single context bundle of files that fits a token
+// budget. Files are packed in priority order; files that do not fit in full
+// are reduced to their outline, and skipped when even that does not fit.
+package pack
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/files"
+	"github.com/slavakurilyak/ctx/internal/outline"
+	"github.com/slavakurilyak/ctx/internal/tokenizer"
+)
+
+// Bundle formats
+const (
+	FormatMarkdown = "markdown"
+	FormatXML      = "xml"
+)
+
+// Priorities decide which files are packed first
+const (
+	PriorityRecent = "recent" // Most recently committed or modified first
+	PrioritySize   = "size"   // Smallest first, to fit the most files
+	PriorityPath   = "path"   // Alphabetical
+)
+
+// How a file is packed
+const (
+	ModeFull    = "full"
+	ModeOutline = "outline"
+)
+
+// SkipBudget is the reason for files that do not fit the budget even as an outline
+const SkipBudget = "budget"
+
+// Options control packing
+type Options struct {
+	Budget   int      // Maximum tokens in the bundle, 0 for no limit
+	Format   string   // FormatMarkdown or FormatXML
+	Priority string   // PriorityRecent, PrioritySize or PriorityPath
+	Prefer   []string // Globs whose files are packed first, in order
+}
+
+// Entry is a file in the bundle
+type Entry struct {
+	Path   string
+	Mode   string // ModeFull or ModeOutline
+	Tokens int    // Tokens of the file's block in the bundle
+}
+
+// Skipped is a file left out of the bundle
+type Skipped struct {
+	Path   string
+	Reason string // SkipBudget, files.SkipBinary or files.SkipUnreadable
+}
+
+// Result is a packed bundle and what went into it
+type Result struct {
+	Bundle  string
+	Tokens  int // Exact token count of the bundle
+	Entries []Entry
+	Skipped []Skipped
+}
+
+// candidate is a readable text file waiting to be packed
+type candidate struct {
+	path       string
+	content    string
+	rank       int       // Index of the first matching preferred glob
+	recency    time.Time // Last commit or modification time
+	entry      *Entry    // Set once packed
+	outline    string    // Outline, when the language is supported
+	hasOutline bool
+}
+
+// ParseFormat validates a bundle format
+func ParseFormat(format string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case "", "md", FormatMarkdown:
+		return FormatMarkdown, nil
+	case FormatXML:
+		return FormatXML, nil
+	default:
+		return "", fmt.Errorf("invalid format %q: must be markdown or xml", format)
+	}
+}
+
+// ParsePriority validates a priority
+func ParsePriority(priority string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(priority)); p {
+	case "", PriorityRecent:
+		return PriorityRecent, nil
+	case PrioritySize, PriorityPath:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid priority %q: must be recent, size or path", priority)
+	}
+}
+
+// Pack builds a bundle of the given files that fits opts.Budget tokens
+func Pack(selected []files.File, tok tokenizer.Tokenizer, opts Options) (*Result, error) {
+	if tok == nil {
+		return nil, fmt.Errorf("no tokenizer available to pack under a token budget")
+	}
+	var err error
+	if opts.Format, err = ParseFormat(opts.Format); err != nil {
+		return nil, err
+	}
+	if opts.Priority, err = ParsePriority(opts.Priority); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	candidates := readCandidates(selected, opts, result)
+	order(candidates, opts)
+
+	count := func(text string) (int, error) {
+		return tokenizer.CountTokensParallel(tok, text, 0)
+	}
+
+	// Greedy fit, block by block
+	overhead, err := count(render(nil, opts.Format))
+	if err != nil {
+		return nil, err
+	}
+	remaining := opts.Budget - overhead
+	for _, c := range candidates {
+		for _, mode := range []string{ModeFull, ModeOutline} {
+			if mode == ModeOutline && !c.hasOutline {
+				continue
+			}
+			tokens, err := count(renderBlock(c, mode, opts.Format))
+			if err != nil {
+				return nil, err
+			}
+			if opts.Budget <= 0 || tokens <= remaining {
+				c.entry = &Entry{Path: c.path, Mode: mode, Tokens: tokens}
+				remaining -= tokens
+				break
+			}
+		}
+	}
+
+	// Blocks can merge into fewer tokens or split into more at their seams, so
+	// count the whole bundle. When it is over budget, binary-search how many
+	// times to demote the last packed file, then count the bundle that fits.
+	result.Bundle = render(candidates, opts.Format)
+	if result.Tokens, err = count(result.Bundle); err != nil {
+		return nil, err
+	}
+	if opts.Budget > 0 && result.Tokens > opts.Budget {
+		demote := demoter(candidates)
+		over, fits := 0, demote(-1) // The bundle with every file skipped fits, or nothing does
+		for fits-over > 1 {
+			mid := (over + fits) / 2
+			demote(mid)
+			tokens, err := count(render(candidates, opts.Format))
+			if err != nil {
+				return nil, err
+			}
+			if tokens <= opts.Budget {
+				fits = mid
+			} else {
+				over = mid
+			}
+		}
+		demote(fits)
+		result.Bundle = render(candidates, opts.Format)
+		if result.Tokens, err = count(result.Bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range candidates {
+		if c.entry != nil && c.entry.Tokens < 0 {
+			if c.entry.Tokens, err = count(renderBlock(c, c.entry.Mode, opts.Format)); err != nil {
+				return nil, err
+			}
+		}
+		if c.entry != nil {
+			result.Entries = append(result.Entries, *c.entry)
+		} else {
+			result.Skipped = append(result.Skipped, Skipped{Path: c.path, Reason: SkipBudget})
+		}
+	}
+	return result, nil
+}
+
+// readCandidates reads the files, recording binary and unreadable ones as skipped
+func readCandidates(selected []files.File, opts Options, result *Result) []*candidate {
+	var candidates []*candidate
+	for _, f := range selected {
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			result.Skipped = append(result.Skipped, Skipped{Path: f.Path, Reason: files.SkipUnreadable})
+			continue
+		}
+		if files.IsBinary(data) {
+			result.Skipped = append(result.Skipped, Skipped{Path: f.Path, Reason: files.SkipBinary})
+			continue
+		}
+		c := &candidate{path: f.Path, content: string(data), rank: len(opts.Prefer)}
+		for i, glob := range opts.Prefer {
+			if matchPrefer(glob, filepath.ToSlash(f.Path)) {
+				c.rank = i
+				break
+			}
+		}
+		c.outline, c.hasOutline = outline.Of(f.Path, c.content)
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// matchPrefer reports whether a preferred glob matches path or any of its
+// trailing parts, so that "docs/**" matches "../repo/docs/a.md" as well
+func matchPrefer(glob, path string) bool {
+	for {
+		if files.MatchGlob(glob, path) {
+			return true
+		}
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			return false
+		}
+		path = path[i+1:]
+	}
+}
+
+// order sorts candidates by preferred glob, then by priority
+func order(candidates []*candidate, opts Options) {
+	if opts.Priority == PriorityRecent {
+		paths := make([]string, len(candidates))
+		for i, c := range candidates {
+			paths[i] = c.path
+		}
+		times := recency(paths)
+		for _, c := range candidates {
+			c.recency = times[c.path]
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		switch opts.Priority {
+		case PriorityRecent:
+			if !a.recency.Equal(b.recency) {
+				return a.recency.After(b.recency)
+			}
+		case PrioritySize:
+			if len(a.content) != len(b.content) {
+				return len(a.content) < len(b.content)
+			}
+		}
+		return a.path < b.path
+	})
+}
+
+// demoter returns a function that restores the candidates as they are now
+// packed and then demotes the last packed file n times, or until nothing is
+// left when n is negative. It returns how many times it demoted.
+func demoter(candidates []*candidate) func(n int) int {
+	packed := make([]*Entry, len(candidates))
+	for i, c := range candidates {
+		packed[i] = c.entry
+	}
+	return func(n int) int {
+		for i, c := range candidates {
+			c.entry = nil
+			if packed[i] != nil {
+				entry := *packed[i]
+				c.entry = &entry
+			}
+		}
+		demoted := 0
+		for demoted != n && demoteLast(candidates) {
+			demoted++
+		}
+		return demoted
+	}
+}
+
+// demoteLast reduces the last packed file to its outline, or skips it.
+// It reports false when nothing is left to demote.
+func demoteLast(candidates []*candidate) bool {
+	for i := len(candidates) - 1; i >= 0; i-- {
+		c := candidates[i]
+		if c.entry == nil {
+			continue
+		}
+		if c.entry.Mode == ModeFull && c.hasOutline {
+			c.entry.Mode = ModeOutline
+			c.entry.Tokens = -1 // Recounted once the bundle fits
+		} else {
+			c.entry = nil
+		}
+		return true
+	}
+	return false
+}
+
+// render assembles the bundle from the packed candidates
+func render(candidates []*candidate, format string) string {
+	var sb strings.Builder
+	if format == FormatXML {
+		sb.WriteString("<files>\n")
+	}
+	for _, c := range candidates {
+		if c.entry != nil {
+			sb.WriteString(renderBlock(c, c.entry.Mode, format))
+		}
+	}
+	if format == FormatXML {
+		sb.WriteString("</files>\n")
+	}
+	return sb.String()
+}
+
+// renderBlock renders a single file in the given mode
+func renderBlock(c *candidate, mode string, format string) string {
+	content := c.content
+	if mode == ModeOutline {
+		content = c.outline
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	path := filepath.ToSlash(c.path)
+
+	if format == FormatXML {
+		attrs := fmt.Sprintf("path=\"%s\"", html.EscapeString(path))
+		if mode == ModeOutline {
+			attrs += " mode=\"outline\""
+		}
+		if content != "" {
+			// CDATA ends at "]]>", so split any in the content across two sections
+			content = "<![CDATA[" + strings.ReplaceAll(content, "]]>", "]]]]><![CDATA[>") + "]]>\n"
+		}
+		return fmt.Sprintf("<file %s>\n%s</file>\n", attrs, content)
+	}
+
+	title := path
+	if mode == ModeOutline {
+		title += " (outline)"
+	}
+	fence := strings.Repeat("`", max(3, longestRun(content, '`')+1))
+	return fmt.Sprintf("## %s\n\n%s%s\n%s%s\n\n", title, fence, language(path), content, fence)
+}
+
+// longestRun returns the longest run of c in s
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return longest
+}
+
+// language returns the markdown code fence language for a file
+func language(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return "go"
+	case ".py", ".pyi":
+		return "python"
+	case ".js", ".mjs", ".cjs", ".jsx":
+		return "javascript"
+	case ".ts", ".tsx":
+		return "typescript"
+	case ".rs":
+		return "rust"
+	case ".java":
+		return "java"
+	case ".kt", ".kts":
+		return "kotlin"
+	case ".rb":
+		return "ruby"
+	case ".c", ".h":
+		return "c"
+	case ".cc", ".cpp", ".hpp", ".cxx":
+		return "cpp"
+	case ".cs":
+		return "csharp"
+	case ".sh", ".bash", ".zsh":
+		return "bash"
+	case ".md", ".markdown":
+		return "markdown"
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	case ".sql":
+		return "sql"
+	case ".html":
+		return "html"
+	case ".css":
+		return "css"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,197 @@
+package pack
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/files"
+)
+
+// wordTokenizer counts whitespace-separated words
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) (int, error) { return len(strings.Fields(text)), nil }
+func (wordTokenizer) GetModelName() string                 { return "words" }
+
+// writeFiles creates files under a temporary directory and returns them in order
+func writeFiles(t *testing.T, tree map[string]string, order ...string) []files.File {
+	t.Helper()
+	dir := t.TempDir()
+	var selected []files.File
+	for _, name := range order {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(tree[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		selected = append(selected, files.File{Path: path, Size: int64(len(tree[name]))})
+	}
+	return selected
+}
+
+func modes(result *Result) map[string]string {
+	m := make(map[string]string)
+	for _, e := range result.Entries {
+		m[filepath.Base(e.Path)] = e.Mode
+	}
+	for _, s := range result.Skipped {
+		m[filepath.Base(s.Path)] = "skipped:" + s.Reason
+	}
+	return m
+}
+
+func TestPackFitsBudget(t *testing.T) {
+	body := strings.Repeat("x := compute(value)\n", 50)
+	selected := writeFiles(t, map[string]string{
+		"small.go":  "package small\n\nfunc One() int { return 1 }\n",
+		"big.go":    "package big\n\n// Run runs\nfunc Run() {\n" + body + "}\n",
+		"notes.txt": strings.Repeat("word ", 400),
+		"image.png": "\x89PNG\x00\x00",
+	}, "small.go", "big.go", "notes.txt", "image.png")
+
+	result, err := Pack(selected, wordTokenizer{}, Options{Budget: 60, Priority: PriorityPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tokens > 60 {
+		t.Errorf("bundle has %d tokens, budget 60", result.Tokens)
+	}
+	if got, _ := (wordTokenizer{}).CountTokens(result.Bundle); got != result.Tokens {
+		t.Errorf("Tokens = %d, bundle counts %d", result.Tokens, got)
+	}
+
+	want := map[string]string{
+		"small.go":  ModeFull,
+		"big.go":    ModeOutline,
+		"notes.txt": "skipped:" + SkipBudget,
+		"image.png": "skipped:" + files.SkipBinary,
+	}
+	got := modes(result)
+	for name, mode := range want {
+		if got[name] != mode {
+			t.Errorf("%s packed as %q, want %q", name, got[name], mode)
+		}
+	}
+	if !strings.Contains(result.Bundle, "big.go (outline)") || strings.Contains(result.Bundle, "compute(value)") {
+		t.Errorf("bundle should hold the outline of big.go:\n%s", result.Bundle)
+	}
+
+	// Without a budget everything text is packed in full
+	result, _ = Pack(selected, wordTokenizer{}, Options{})
+	if len(result.Entries) != 3 || len(result.Skipped) != 1 {
+		t.Errorf("unlimited Pack() = %d entries, %d skipped; want 3 and 1", len(result.Entries), len(result.Skipped))
+	}
+}
+
+func TestPackPriority(t *testing.T) {
+	selected := writeFiles(t, map[string]string{
+		"a.txt":      strings.Repeat("a ", 30),
+		"b.txt":      strings.Repeat("b ", 10),
+		"docs/c.md":  strings.Repeat("c ", 20),
+		"old.txt":    "old",
+		"recent.txt": "recent",
+	}, "a.txt", "b.txt", "docs/c.md", "old.txt", "recent.txt")
+
+	// Smallest first
+	result, _ := Pack(selected[:3], wordTokenizer{}, Options{Priority: PrioritySize})
+	if first := filepath.Base(result.Entries[0].Path); first != "b.txt" {
+		t.Errorf("size priority packed %s first, want b.txt", first)
+	}
+
+	// Preferred globs beat priority
+	result, _ = Pack(selected[:3], wordTokenizer{}, Options{Priority: PrioritySize, Prefer: []string{"docs/**"}})
+	if first := filepath.Base(result.Entries[0].Path); first != "c.md" {
+		t.Errorf("preferred glob packed %s first, want c.md", first)
+	}
+
+	// Recency falls back to modification times outside git
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(selected[3].Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	result, _ = Pack(selected[3:], wordTokenizer{}, Options{Priority: PriorityRecent})
+	if first := filepath.Base(result.Entries[0].Path); first != "recent.txt" {
+		t.Errorf("recent priority packed %s first, want recent.txt", first)
+	}
+}
+
+func TestPackFormats(t *testing.T) {
+	selected := writeFiles(t, map[string]string{"doc.md": "# Title\n```go\ncode\n```\n"}, "doc.md")
+
+	result, _ := Pack(selected, wordTokenizer{}, Options{})
+	if !strings.Contains(result.Bundle, "````markdown\n") {
+		t.Errorf("markdown fence should be longer than the backtick runs inside:\n%s", result.Bundle)
+	}
+
+	result, _ = Pack(selected, wordTokenizer{}, Options{Format: FormatXML})
+	if !strings.HasPrefix(result.Bundle, "<files>\n<file path=\"") || !strings.HasSuffix(result.Bundle, "</file>\n</files>\n") {
+		t.Errorf("unexpected XML bundle:\n%s", result.Bundle)
+	}
+
+	// XML content is character data, with "]]>" split across sections
+	selected = writeFiles(t, map[string]string{"page.html": "<b>a && b</b>\nif x[y[0]]> 1 {}\n"}, "page.html")
+	result, _ = Pack(selected, wordTokenizer{}, Options{Format: FormatXML})
+	if !strings.Contains(result.Bundle, "<![CDATA[<b>a && b</b>\nif x[y[0]]]]><![CDATA[> 1 {}\n]]>\n</file>") {
+		t.Errorf("XML content should be in CDATA sections:\n%s", result.Bundle)
+	}
+
+	if _, err := Pack(selected, wordTokenizer{}, Options{Format: "yaml"}); err == nil {
+		t.Error("Pack() should reject unknown formats")
+	}
+	if _, err := Pack(selected, nil, Options{}); err == nil {
+		t.Error("Pack() should fail without a tokenizer")
+	}
+}
+
+// seamTokenizer counts words plus one token per seam between markdown
+// blocks, so a bundle counts more than its blocks do on their own. It
+// records how many bundles of several blocks it counted.
+type seamTokenizer struct{ bundles *int }
+
+func (s seamTokenizer) CountTokens(text string) (int, error) {
+	seams := max(0, strings.Count(text, "## ")-1)
+	if seams > 0 {
+		*s.bundles++
+	}
+	return len(strings.Fields(text)) + seams, nil
+}
+func (seamTokenizer) GetModelName() string { return "seams" }
+
+func TestPackDemotesAtSeams(t *testing.T) {
+	tree := map[string]string{}
+	var names []string
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("f%02d.txt", i)
+		tree[name] = "one two three"
+		names = append(names, name)
+	}
+	selected := writeFiles(t, tree, names...)
+
+	// Each block is 7 words, so 34 fit block by block but not with their 33
+	// seams: 30 blocks and 29 seams are the most that fit
+	var bundles int
+	result, err := Pack(selected, seamTokenizer{&bundles}, Options{Budget: 240, Priority: PriorityPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tokens > 240 || len(result.Entries) != 30 {
+		t.Errorf("packed %d files in %d tokens, want 30 files within 240", len(result.Entries), result.Tokens)
+	}
+	if bundles > 10 {
+		t.Errorf("counted %d whole bundles, want a binary search", bundles)
+	}
+}
+
+func TestParseGitLog(t *testing.T) {
+	out := "\x001700000200\n\na.go\nb.go\n\x001700000100\n\na.go\nc.go\n"
+	times := parseGitLog([]byte(out))
+	if times["a.go"].Unix() != 1700000200 || times["c.go"].Unix() != 1700000100 || len(times) != 3 {
+		t.Errorf("parseGitLog() = %v", times)
+	}
+}
@@ -0,0 +1,92 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/files"
+)
+
+// gitLogTimeout bounds how long reading commit times may take on large repositories
+const gitLogTimeout = 10 * time.Second
+
+// recency returns the last commit time of each path, falling back to its
+// modification time for untracked files and outside git repositories
+func recency(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time, len(paths))
+
+	// Group paths by repository so git runs once per repository
+	byRoot := make(map[string][]string)
+	for _, path := range paths {
+		root := files.FindRoot(filepath.Dir(path))
+		byRoot[root] = append(byRoot[root], path)
+	}
+
+	for root, group := range byRoot {
+		commits := commitTimes(root)
+		for _, path := range group {
+			if commits != nil {
+				if abs, err := filepath.Abs(path); err == nil {
+					if rel, err := filepath.Rel(root, abs); err == nil {
+						if t, ok := commits[filepath.ToSlash(rel)]; ok {
+							times[path] = t
+							continue
+						}
+					}
+				}
+			}
+			if info, err := os.Stat(path); err == nil {
+				times[path] = info.ModTime()
+			}
+		}
+	}
+	return times
+}
+
+// commitTimes maps each file in the repository at root to the time of the
+// last commit that touched it, or returns nil when git is unavailable
+func commitTimes(root string) map[string]time.Time {
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), gitLogTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "git", "-C", root, "log", "--format=%x00%ct", "--name-only", "--no-renames").Output()
+	if err != nil {
+		return nil
+	}
+	return parseGitLog(out)
+}
+
+// parseGitLog parses "git log --format=%x00%ct --name-only" output, newest
+// commit first, keeping the first (latest) time seen for each file
+func parseGitLog(out []byte) map[string]time.Time {
+	times := make(map[string]time.Time)
+	var current time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\x00") {
+			if secs, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				current = time.Unix(secs, 0)
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if _, seen := times[line]; !seen {
+			times[line] = current
+		}
+	}
+	return times
+}
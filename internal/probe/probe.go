@@ -0,0 +1,223 @@
+// Package probe summarises an output that is too large to print, so an
+// agent can decide how to narrow the command without seeing the output:
+// its size, its first and last lines and the shape of its content.
+package probe
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+// Summary limits
+const (
+	sampleLines     = 5   // Lines in the head and in the tail
+	maxPreviewRunes = 200 // Longer sample lines are truncated
+	maxFields       = 20  // Keys, columns or files listed
+	checkedLines    = 100 // Lines looked at to recognise line-based formats
+	maxHeaderBytes  = 64  // Longer fields are not column names
+)
+
+// Output formats
+const (
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatTable  = "table" // psql and other aligned tables with a dashed rule
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	FormatDiff   = "diff"
+	FormatText   = "text"
+)
+
+// Summarize describes an output by its size, samples and structure
+func Summarize(output string) *models.ProbeSection {
+	lines := splitLines(output)
+	section := &models.ProbeSection{
+		Lines:     len(lines),
+		Bytes:     len(output),
+		Head:      previews(lines[:min(sampleLines, len(lines))]),
+		Structure: structure(output, lines),
+	}
+	if len(lines) > sampleLines {
+		section.Tail = previews(lines[max(sampleLines, len(lines)-sampleLines):])
+	}
+	return section
+}
+
+// structure recognises the format of an output split into lines
+func structure(output string, lines []string) models.ProbeStructure {
+	if s, ok := jsonStructure(output); ok {
+		return s
+	}
+	for _, recognise := range []func([]string) (models.ProbeStructure, bool){ndjsonStructure, diffStructure, tableStructure, delimitedStructure} {
+		if s, ok := recognise(lines); ok {
+			return s
+		}
+	}
+	return models.ProbeStructure{Format: FormatText, Items: len(lines)}
+}
+
+// jsonStructure lists an object's keys, or an array's length and the keys
+// of its first element
+func jsonStructure(output string) (models.ProbeStructure, bool) {
+	trimmed := strings.TrimSpace(output)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return models.ProbeStructure{}, false
+	}
+	var value any
+	if err := json.Unmarshal([]byte(trimmed), &value); err != nil {
+		return models.ProbeStructure{}, false
+	}
+	s := models.ProbeStructure{Format: FormatJSON}
+	switch v := value.(type) {
+	case map[string]any:
+		s.Items, s.Fields = len(v), keys(v)
+	case []any:
+		s.Items = len(v)
+		if len(v) > 0 {
+			if first, ok := v[0].(map[string]any); ok {
+				s.Fields = keys(first)
+			}
+		}
+	}
+	return s, true
+}
+
+// ndjsonStructure recognises one JSON object per line
+func ndjsonStructure(lines []string) (models.ProbeStructure, bool) {
+	if len(lines) < 2 {
+		return models.ProbeStructure{}, false
+	}
+	var first map[string]any
+	for i, line := range lines[:min(checkedLines, len(lines))] {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return models.ProbeStructure{}, false
+		}
+		if i == 0 {
+			first = record
+		}
+	}
+	return models.ProbeStructure{Format: FormatNDJSON, Items: len(lines), Fields: keys(first)}, true
+}
+
+// diffStructure lists the files of a git diff
+func diffStructure(lines []string) (models.ProbeStructure, bool) {
+	s := models.ProbeStructure{Format: FormatDiff}
+	for _, line := range lines {
+		if name, ok := strings.CutPrefix(line, "diff --git "); ok {
+			s.Items++
+			if len(s.Fields) < maxFields {
+				if _, b, found := strings.Cut(name, " b/"); found {
+					name = b
+				}
+				s.Fields = append(s.Fields, name)
+			}
+		}
+	}
+	return s, s.Items > 0
+}
+
+var tableRule = regexp.MustCompile(`^[-+=| ]*-[-+=| ]*$`)
+
+// tableStructure recognises a header, a dashed rule and rows, as printed by
+// psql, and lists the columns
+func tableStructure(lines []string) (models.ProbeStructure, bool) {
+	if len(lines) < 2 || !tableRule.MatchString(lines[1]) {
+		return models.ProbeStructure{}, false
+	}
+	var columns []string
+	if strings.Contains(lines[0], "|") {
+		for _, column := range strings.Split(strings.Trim(strings.TrimSpace(lines[0]), "|"), "|") {
+			columns = append(columns, strings.TrimSpace(column))
+		}
+	} else {
+		columns = strings.Fields(lines[0])
+	}
+	rows := 0
+	for _, line := range lines[2:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || (strings.HasPrefix(trimmed, "(") && strings.HasSuffix(trimmed, "rows)") || trimmed == "(1 row)") {
+			continue // psql's footer
+		}
+		rows++
+	}
+	return models.ProbeStructure{Format: FormatTable, Items: rows, Fields: limit(columns)}, true
+}
+
+// delimitedStructure recognises tab- or comma-separated records with a
+// header, which must have as many fields as the records after it
+func delimitedStructure(lines []string) (models.ProbeStructure, bool) {
+	if len(lines) < 2 {
+		return models.ProbeStructure{}, false
+	}
+	for _, format := range []struct {
+		name  string
+		comma rune
+	}{{FormatTSV, '\t'}, {FormatCSV, ','}} {
+		reader := csv.NewReader(strings.NewReader(strings.Join(lines[:min(checkedLines, len(lines))], "\n")))
+		reader.Comma = format.comma
+		reader.LazyQuotes = true
+		records, err := reader.ReadAll()
+		if err != nil || len(records) < 2 || len(records[0]) < 2 || !isHeader(records[0]) {
+			continue
+		}
+		return models.ProbeStructure{Format: format.name, Items: len(lines) - 1, Fields: limit(records[0])}, true
+	}
+	return models.ProbeStructure{}, false
+}
+
+// isHeader tells whether fields look like column names rather than prose
+// that happens to hold commas, which is followed by spaces
+func isHeader(fields []string) bool {
+	for _, field := range fields {
+		if field == "" || field != strings.TrimSpace(field) || len(field) > maxHeaderBytes {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits an output into lines without their line endings
+func splitLines(output string) []string {
+	output = strings.TrimSuffix(output, "\n")
+	if output == "" {
+		return nil
+	}
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// previews shortens long lines
+func previews(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = line
+		if utf8.RuneCountInString(line) > maxPreviewRunes {
+			out[i] = string([]rune(line)[:maxPreviewRunes]) + "…"
+		}
+	}
+	return out
+}
+
+// keys returns an object's keys, sorted and limited
+func keys(object map[string]any) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return limit(names)
+}
+
+func limit(fields []string) []string {
+	return fields[:min(maxFields, len(fields))]
+}
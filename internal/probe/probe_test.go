@@ -0,0 +1,61 @@
+package probe
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeSamples(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 12; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	b.WriteString(strings.Repeat("x", 300) + "\n")
+
+	s := Summarize(b.String())
+	if s.Lines != 13 || s.Bytes != b.Len() {
+		t.Errorf("lines, bytes = %d, %d; want 13, %d", s.Lines, s.Bytes, b.Len())
+	}
+	if want := []string{"line 1", "line 2", "line 3", "line 4", "line 5"}; !reflect.DeepEqual(s.Head, want) {
+		t.Errorf("head = %q, want %q", s.Head, want)
+	}
+	if len(s.Tail) != 5 || s.Tail[0] != "line 9" || !strings.HasSuffix(s.Tail[4], "…") {
+		t.Errorf("tail = %q", s.Tail)
+	}
+	if s.Structure.Format != FormatText || s.Structure.Items != 13 {
+		t.Errorf("structure = %+v, want text with 13 items", s.Structure)
+	}
+
+	if short := Summarize("a\nb\n"); len(short.Head) != 2 || short.Tail != nil {
+		t.Errorf("short output: head %q, tail %q", short.Head, short.Tail)
+	}
+}
+
+func TestSummarizeStructure(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		format string
+		items  int
+		fields []string
+	}{
+		{"json object", `{"b": 1, "a": [1, 2]}`, FormatJSON, 2, []string{"a", "b"}},
+		{"json array", `[{"id": 1, "name": "x"}, {"id": 2}]`, FormatJSON, 2, []string{"id", "name"}},
+		{"ndjson", "{\"level\":\"info\",\"msg\":\"a\"}\n{\"level\":\"error\",\"msg\":\"b\"}\n", FormatNDJSON, 2, []string{"level", "msg"}},
+		{"diff", "diff --git a/main.go b/main.go\n+x\ndiff --git a/go.mod b/go.mod\n-y\n", FormatDiff, 2, []string{"main.go", "go.mod"}},
+		{"psql", " id | status \n----+--------\n  1 | error\n  2 | ok\n(2 rows)\n", FormatTable, 2, []string{"id", "status"}},
+		{"csv", "id,status\n1,error\n2,ok\n", FormatCSV, 2, []string{"id", "status"}},
+		{"tsv", "id\tstatus\n1\terror\n", FormatTSV, 1, []string{"id", "status"}},
+		{"prose with commas", "Well, this is text that has commas, here and there\nand, again, here\n", FormatText, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Summarize(tt.output).Structure
+			if got.Format != tt.format || got.Items != tt.items || !reflect.DeepEqual(got.Fields, tt.fields) {
+				t.Errorf("structure = %+v, want %s with %d items and fields %q", got, tt.format, tt.items, tt.fields)
+			}
+		})
+	}
+}
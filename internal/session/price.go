@@ -0,0 +1,19 @@
+package session
+
+// DefaultPrices are input token prices in USD per million tokens, by token
+// model, for the cost of a session. Models without a price cost nothing
+// unless a price is configured.
+var DefaultPrices = map[string]float64{
+	"anthropic": 3.00, // Claude Sonnet
+	"openai":    2.50, // GPT-4o
+	"gemini":    1.25, // Gemini Pro
+}
+
+// Cost returns what tokens cost at price USD per million tokens, or at the
+// model's default price when price is 0
+func Cost(tokens int64, model string, price float64) float64 {
+	if price <= 0 {
+		price = DefaultPrices[model]
+	}
+	return float64(tokens) * price / 1e6
+}
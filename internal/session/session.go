@@ -0,0 +1,319 @@
+// Package session tracks agent sessions. Runs that share a session ID add up
+// their commands, tokens and cost in a state file per session, and a token
+// budget refuses new commands once it is spent.
+//
+// The session ID comes from CTX_SESSION_ID, from a variable set by the
+// coding agent, or from the session started with 'ctx session start'.
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/slavakurilyak/ctx/internal/filelock"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+const (
+	sessionDirPerm  = 0755
+	sessionFilePerm = 0644
+
+	lockFileName   = "sessions.lock"
+	activeFileName = "active" // ID of the session started with 'ctx session start'
+)
+
+// EnvSessionID names the variable that sets the session ID explicitly
+const EnvSessionID = "CTX_SESSION_ID"
+
+// SourceStarted is the source of a session begun with 'ctx session start'
+const SourceStarted = "ctx session start"
+
+// ErrNoSession is returned when no session is set or started
+var ErrNoSession = errors.New("no active session (set CTX_SESSION_ID or run 'ctx session start')")
+
+// State is the running total of a session
+type State struct {
+	ID       string     `json:"id"`
+	Source   string     `json:"source"` // Where the ID came from: a variable or 'ctx session start'
+	Started  time.Time  `json:"started"`
+	Updated  time.Time  `json:"updated"`
+	Ended    *time.Time `json:"ended,omitempty"`
+	Commands int        `json:"commands"`
+	Tokens   int64      `json:"tokens"`
+	Cost     float64    `json:"cost"`             // USD, at the configured token price
+	Budget   int64      `json:"budget,omitempty"` // Tokens; 0 means no budget
+}
+
+// Remaining returns the tokens left in the budget, or -1 without a budget
+func (s *State) Remaining() int64 {
+	if s.Budget <= 0 {
+		return -1
+	}
+	return max(s.Budget-s.Tokens, 0)
+}
+
+// Exhausted reports whether the session has spent its budget
+func (s *State) Exhausted() bool {
+	return s.Budget > 0 && s.Tokens >= s.Budget
+}
+
+// Manager reads and updates the sessions in a directory
+type Manager struct {
+	dir string
+}
+
+// NewManager returns a manager for the sessions in dir
+func NewManager(dir string) *Manager {
+	return &Manager{dir: dir}
+}
+
+// DefaultDir returns $XDG_STATE_HOME/ctx/sessions, defaulting to
+// ~/.local/state/ctx/sessions, next to history kept in the state directory
+func DefaultDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "ctx", "sessions")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "ctx", "sessions")
+	}
+	return filepath.Join(os.TempDir(), "ctx-state", "sessions")
+}
+
+// Dir returns the directory the manager keeps sessions in
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Current returns the ID of the current session and where it came from:
+// CTX_SESSION_ID or the session started with 'ctx session start'. It returns ErrNoSession when there is none.
+func (m *Manager) Current() (id, source string, err error) {
+	if id, source := EnvID(); id != "" {
+		return id, source, nil
+	}
+	data, err := os.ReadFile(filepath.Join(m.dir, activeFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", ErrNoSession
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if id := strings.TrimSpace(string(data)); id != "" {
+		return id, SourceStarted, nil
+	}
+	return "", "", ErrNoSession
+}
+
+// EnvID returns the session ID set in the environment and the variable it
+// was read from
+func EnvID() (id, source string) {
+	if id := strings.TrimSpace(os.Getenv(EnvSessionID)); id != "" {
+		return id, EnvSessionID
+	}
+	return "", ""
+}
+
+// Get returns the state of a session; a session without runs yet has a
+// zero state
+func (m *Manager) Get(id string) (*State, error) {
+	l, err := m.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return m.read(id)
+}
+
+// Start begins a session, or resumes it when it already exists. An empty id
+// generates one. A session that does not come from the environment becomes
+// the current one, until it is ended. budget, when above zero, replaces the
+// session's budget.
+func (m *Manager) Start(id string, budget int64) (*State, error) {
+	source := SourceStarted
+	if id == "" {
+		id, source = EnvID()
+		if id == "" {
+			id, source = uuid.New().String(), SourceStarted
+		}
+	}
+
+	l, err := m.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	state, err := m.read(id)
+	if err != nil {
+		return nil, err
+	}
+	if state.Commands == 0 || state.Ended != nil {
+		state.Source = source
+	}
+	state.Ended = nil
+	if budget > 0 {
+		state.Budget = budget
+	}
+	if err := m.write(state); err != nil {
+		return nil, err
+	}
+	if source == SourceStarted {
+		if err := m.writeFile(filepath.Join(m.dir, activeFileName), []byte(id+"\n")); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// End marks a session as ended; it is no longer the current one
+func (m *Manager) End(id string) (*State, error) {
+	l, err := m.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	state, err := m.read(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	state.Ended = &now
+	if err := m.write(state); err != nil {
+		return nil, err
+	}
+
+	active := filepath.Join(m.dir, activeFileName)
+	if data, err := os.ReadFile(active); err == nil && strings.TrimSpace(string(data)) == id {
+		if err := os.Remove(active); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// Add counts a finished command in a session. budget, when above zero,
+// replaces the session's budget. A run in an ended session resumes it.
+func (m *Manager) Add(id string, tokens int64, cost float64, budget int64) (*State, error) {
+	l, err := m.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	state, err := m.read(id)
+	if err != nil {
+		return nil, err
+	}
+	state.Ended = nil
+	state.Commands++
+	state.Tokens += tokens
+	state.Cost += cost
+	if budget > 0 {
+		state.Budget = budget
+	}
+	return state, m.write(state)
+}
+
+// lock blocks until the sessions are locked, exclusively for writers
+func (m *Manager) lock(exclusive bool) (*filelock.Lock, error) {
+	if err := os.MkdirAll(m.dir, sessionDirPerm); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+	l, err := filelock.Acquire(filepath.Join(m.dir, lockFileName), exclusive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock sessions: %w", err)
+	}
+	return l, nil
+}
+
+// read loads a session's state, or a new one when it has none yet
+func (m *Manager) read(id string) (*State, error) {
+	data, err := os.ReadFile(m.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		now := time.Now().UTC()
+		return &State{ID: id, Source: sourceOf(id), Started: now, Updated: now}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("corrupt session state %s: %w", m.path(id), err)
+	}
+	return &state, nil
+}
+
+func (m *Manager) write(state *State) error {
+	state.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.writeFile(m.path(state.ID), append(data, '\n'))
+}
+
+// writeFile atomically replaces path with data
+func (m *Manager) writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(m.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), sessionFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var safeID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// path returns a session's state file. IDs come from the environment, so
+// those that are not safe file names are hashed.
+func (m *Manager) path(id string) string {
+	name := id
+	if !safeID.MatchString(id) || strings.Trim(id, ".") == "" {
+		sum := sha256.Sum256([]byte(id))
+		name = hex.EncodeToString(sum[:16])
+	}
+	return filepath.Join(m.dir, name+".json")
+}
+
+// sourceOf returns the variable a new session's ID is set in, or
+// SourceStarted when it is not set in the environment
+func sourceOf(id string) string {
+	if envID, source := EnvID(); envID == id {
+		return source
+	}
+	return SourceStarted
+}
+
+// Section reports the state in an envelope
+func (s *State) Section() *models.SessionSection {
+	section := &models.SessionSection{
+		ID:       s.ID,
+		Commands: s.Commands,
+		Tokens:   s.Tokens,
+		Cost:     s.Cost,
+		Budget:   s.Budget,
+	}
+	if remaining := s.Remaining(); remaining >= 0 {
+		section.RemainingTokens = &remaining
+	}
+	return section
+}
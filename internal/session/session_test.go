@@ -0,0 +1,128 @@
+package session
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// clearEnv unsets CTX_SESSION_ID, so the tests do not pick up the session
+// they are run in
+func clearEnv(t *testing.T) {
+	t.Setenv(EnvSessionID, "")
+}
+
+func TestCurrent(t *testing.T) {
+	clearEnv(t)
+	m := NewManager(t.TempDir())
+
+	if _, _, err := m.Current(); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("Current() without a session = %v, want ErrNoSession", err)
+	}
+
+	started, err := m.Start("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, source, _ := m.Current(); id != started.ID || source != SourceStarted {
+		t.Errorf("Current() = %q, %q; want the started session %q", id, source, started.ID)
+	}
+
+	// The environment wins over the started session
+	t.Setenv(EnvSessionID, "explicit")
+	if id, source, _ := m.Current(); id != "explicit" || source != EnvSessionID {
+		t.Errorf("Current() = %q, %q; want CTX_SESSION_ID", id, source)
+	}
+
+	// Ending the started session clears it
+	t.Setenv(EnvSessionID, "")
+	if _, err := m.End(started.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Current(); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Current() after End = %v, want ErrNoSession", err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	clearEnv(t)
+	m := NewManager(t.TempDir())
+	if _, err := m.Start("s1", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := m.Add("s1", 600, 0.5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Commands != 1 || state.Tokens != 600 || state.Remaining() != 400 || state.Exhausted() {
+		t.Errorf("after 600 tokens: %+v, remaining %d", state, state.Remaining())
+	}
+	state, _ = m.Add("s1", 500, 0.5, 0)
+	if state.Remaining() != 0 || !state.Exhausted() || state.Cost != 1 {
+		t.Errorf("after 1100 tokens: %+v, remaining %d", state, state.Remaining())
+	}
+
+	// A larger budget for a run replaces the session's
+	state, _ = m.Add("s1", 0, 0, 5000)
+	if state.Budget != 5000 || state.Exhausted() {
+		t.Errorf("after raising the budget: %+v", state)
+	}
+
+	section := state.Section()
+	if section.RemainingTokens == nil || *section.RemainingTokens != 3900 || section.Commands != 3 {
+		t.Errorf("Section() = %+v", section)
+	}
+	if got := (&State{Tokens: 10}).Section(); got.RemainingTokens != nil {
+		t.Errorf("Section() without a budget has remaining_tokens %d", *got.RemainingTokens)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	clearEnv(t)
+	m := NewManager(t.TempDir())
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := NewManager(m.Dir()).Add("shared", 10, 0, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	state, err := m.Get("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Commands != 50 || state.Tokens != 500 {
+		t.Errorf("after 50 concurrent runs: %+v", state)
+	}
+}
+
+func TestPath(t *testing.T) {
+	m := NewManager("/state")
+	if got := m.path("abc-123"); got != filepath.Join("/state", "abc-123.json") {
+		t.Errorf("path(abc-123) = %q", got)
+	}
+	for _, id := range []string{"../escape", "a/b", "..", ""} {
+		if got := filepath.Dir(m.path(id)); got != "/state" {
+			t.Errorf("path(%q) = %q, outside the session directory", id, m.path(id))
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	if got := Cost(2_000_000, "anthropic", 0); got != 6 {
+		t.Errorf("Cost(2M, anthropic) = %v, want 6", got)
+	}
+	if got := Cost(1_000_000, "anthropic", 0.5); got != 0.5 {
+		t.Errorf("Cost with a configured price = %v, want 0.5", got)
+	}
+	if got := Cost(1_000_000, "hf:llama3", 0); got != 0 {
+		t.Errorf("Cost for a model without a price = %v, want 0", got)
+	}
+}
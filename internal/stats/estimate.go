@@ -0,0 +1,181 @@
+package stats
+
+import (
+	"math"
+	"sort"
+
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+// Confidence levels of an estimate
+const (
+	ConfidenceHigh    = "high"
+	ConfidenceMedium  = "medium"
+	ConfidenceLow     = "low"
+	ConfidenceUnknown = "unknown" // No usable past run
+)
+
+// Scopes of the runs an estimate is based on
+const (
+	ScopeDirectory = "directory" // Runs in the same directory
+	ScopeAll       = "all"       // Runs in any directory, when none ran in this one
+)
+
+// DefaultEstimateRuns is how many of the newest runs an estimate considers
+const DefaultEstimateRuns = 20
+
+// Range summarises a measure over past runs
+type Range struct {
+	Median int64 `json:"median"`
+	P90    int64 `json:"p90"`
+	Max    int64 `json:"max"`
+}
+
+// Estimate predicts the size and duration of a command from past runs with
+// the same fingerprint. The ranges are nil when the confidence is unknown.
+type Estimate struct {
+	Command     string  `json:"command"`
+	Fingerprint string  `json:"fingerprint"`
+	Template    string  `json:"template"`
+	Confidence  string  `json:"confidence"`
+	Scope       string  `json:"scope,omitempty"`
+	Runs        int     `json:"runs"`         // Past runs considered
+	Samples     int     `json:"samples"`      // Runs the ranges are computed from
+	LimitedRuns int     `json:"limited_runs"` // Runs stopped by a limit, whose sizes are left out
+	FailureRate float64 `json:"failure_rate"`
+	Tokens      *Range  `json:"tokens,omitempty"`
+	Bytes       *Range  `json:"bytes,omitempty"`
+	DurationMs  *Range  `json:"duration_ms,omitempty"`
+	LastRun     string  `json:"last_run,omitempty"` // Timestamp of the newest run considered
+}
+
+// EstimateOptions configure Predict
+type EstimateOptions struct {
+	Directory string // Prefer runs in this directory
+	MaxRuns   int    // Newest runs to consider; 0 means DefaultEstimateRuns
+}
+
+// Predict estimates tokens, bytes and duration of a command from the records
+// (oldest first) that share its fingerprint, preferring those run in the
+// same directory. Sizes come from the successful runs no limit stopped, or
+// from any run no limit stopped when none succeeded; runs cut short by a
+// limit would understate them. The confidence grows with the number of
+// samples and falls with their spread, and is one level lower for runs in
+// other directories.
+func Predict(command string, records []*models.Output, opts EstimateOptions) *Estimate {
+	fp := fingerprint.Of(command)
+	estimate := &Estimate{
+		Command:     command,
+		Fingerprint: fp,
+		Template:    fingerprint.Template(command),
+		Confidence:  ConfidenceUnknown,
+	}
+
+	var local, all []*models.Output
+	for _, record := range records {
+		if recordFingerprint(record) != fp {
+			continue
+		}
+		all = append(all, record)
+		if opts.Directory != "" && record.Metadata.Directory == opts.Directory {
+			local = append(local, record)
+		}
+	}
+	runs, scope := local, ScopeDirectory
+	if len(local) == 0 {
+		runs, scope = all, ScopeAll
+	}
+	if len(runs) == 0 {
+		return estimate
+	}
+	maxRuns := opts.MaxRuns
+	if maxRuns <= 0 {
+		maxRuns = DefaultEstimateRuns
+	}
+	if len(runs) > maxRuns {
+		runs = runs[len(runs)-maxRuns:]
+	}
+
+	estimate.Scope = scope
+	estimate.Runs = len(runs)
+	estimate.LastRun = runs[len(runs)-1].Metadata.Timestamp
+	var failures int
+	var succeeded, unlimited []*models.Output
+	for _, record := range runs {
+		if failed(record) {
+			failures++
+		}
+		if limitReached(record) != "" {
+			estimate.LimitedRuns++
+			continue
+		}
+		unlimited = append(unlimited, record)
+		if !failed(record) {
+			succeeded = append(succeeded, record)
+		}
+	}
+	estimate.FailureRate = float64(failures) / float64(len(runs))
+
+	samples := succeeded
+	if len(samples) == 0 {
+		samples = unlimited
+	}
+	if len(samples) == 0 {
+		return estimate
+	}
+	estimate.Samples = len(samples)
+	estimate.Tokens = summarize(samples, func(r *models.Output) int64 { return int64(r.Tokens) })
+	estimate.Bytes = summarize(samples, func(r *models.Output) int64 { return int64(r.Metadata.Bytes) })
+	estimate.DurationMs = summarize(samples, func(r *models.Output) int64 { return int64(r.Metadata.Duration) })
+
+	// Token counting may have been off; the spread of bytes stands in for it
+	size := estimate.Tokens
+	if size.Max == 0 {
+		size = estimate.Bytes
+	}
+	estimate.Confidence = confidence(len(samples), size, scope == ScopeAll)
+	return estimate
+}
+
+// summarize returns the median, 90th percentile and maximum of a measure
+func summarize(records []*models.Output, value func(*models.Output) int64) *Range {
+	values := make([]int64, len(records))
+	for i, record := range records {
+		values[i] = value(record)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	n := len(values)
+	median := values[n/2]
+	if n%2 == 0 {
+		median = (values[n/2-1] + values[n/2]) / 2
+	}
+	// Nearest rank, so a p90 is always a value that was seen
+	p90 := values[int(math.Ceil(0.9*float64(n)))-1]
+	return &Range{Median: median, P90: p90, Max: values[n-1]}
+}
+
+// confidence rates an estimate by its number of samples and the spread of
+// their sizes: how far the 90th percentile is above the median
+func confidence(samples int, size *Range, otherDirectories bool) string {
+	spread := 1.0
+	if size.Median > 0 {
+		spread = float64(size.P90) / float64(size.Median)
+	} else if size.P90 > 0 {
+		spread = math.Inf(1)
+	}
+
+	levels := []string{ConfidenceLow, ConfidenceMedium, ConfidenceHigh}
+	level := 0
+	switch {
+	case samples >= 5 && spread <= 1.5:
+		level = 2
+	case samples >= 3 && spread <= 3:
+		level = 1
+	}
+	if otherDirectories && level > 0 {
+		level--
+	}
+	return levels[level]
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func TestPredict(t *testing.T) {
+	var records []*models.Output
+	for i, tokens := range []int{100, 120, 110, 130, 90, 105} {
+		r := run("seq 1 100", tokens, 0, "/repo", "s1", "")
+		r.Metadata.Duration = 10 * (i + 1)
+		records = append(records, r)
+	}
+	records = append(records,
+		run("seq 1 9000", 5, 1, "/repo", "s1", "line_limit_exceeded"), // Left out of the sizes
+		run("seq 1 100", 5000, 0, "/other", "s2", ""),                 // Another directory
+		run("git log", 800, 0, "/repo", "s1", ""),                     // Another command
+	)
+
+	estimate := Predict("seq 1 50", records, EstimateOptions{Directory: "/repo"})
+	if estimate.Confidence != ConfidenceHigh || estimate.Scope != ScopeDirectory {
+		t.Errorf("Predict() = %+v, want high confidence from this directory", estimate)
+	}
+	if estimate.Runs != 7 || estimate.Samples != 6 || estimate.LimitedRuns != 1 {
+		t.Errorf("Runs, Samples, LimitedRuns = %d, %d, %d; want 7, 6, 1", estimate.Runs, estimate.Samples, estimate.LimitedRuns)
+	}
+	if want := (Range{Median: 107, P90: 130, Max: 130}); *estimate.Tokens != want {
+		t.Errorf("Tokens = %+v, want %+v", *estimate.Tokens, want)
+	}
+	if want := (Range{Median: 35, P90: 60, Max: 60}); *estimate.DurationMs != want {
+		t.Errorf("DurationMs = %+v, want %+v", *estimate.DurationMs, want)
+	}
+	if estimate.Template != "seq N N" || estimate.FailureRate != 1.0/7 {
+		t.Errorf("Predict() = %+v", estimate)
+	}
+
+	// Only the newest runs count
+	estimate = Predict("seq 1 50", records, EstimateOptions{Directory: "/repo", MaxRuns: 2})
+	if estimate.Runs != 2 || estimate.Samples != 1 || estimate.Confidence != ConfidenceLow {
+		t.Errorf("Predict(MaxRuns: 2) = %+v", estimate)
+	}
+}
+
+func TestPredictFallsBack(t *testing.T) {
+	records := []*models.Output{
+		run("make test", 400, 0, "/a", "s1", ""),
+		run("make test", 420, 0, "/a", "s1", ""),
+		run("make test", 410, 0, "/b", "s1", ""),
+	}
+	// Runs in other directories count, at a lower confidence
+	estimate := Predict("make test", records, EstimateOptions{Directory: "/c"})
+	if estimate.Scope != ScopeAll || estimate.Runs != 3 || estimate.Confidence != ConfidenceLow {
+		t.Errorf("Predict() = %+v, want low confidence from all directories", estimate)
+	}
+
+	estimate = Predict("make build", records, EstimateOptions{Directory: "/a"})
+	if estimate.Confidence != ConfidenceUnknown || estimate.Tokens != nil || estimate.Runs != 0 {
+		t.Errorf("Predict() of an unseen command = %+v, want unknown", estimate)
+	}
+
+	// Sizes of runs stopped by a limit are unknown
+	limited := []*models.Output{run("make test", 50, 1, "/a", "s1", "token_limit_exceeded")}
+	estimate = Predict("make test", limited, EstimateOptions{Directory: "/a"})
+	if estimate.Confidence != ConfidenceUnknown || estimate.Tokens != nil || estimate.LimitedRuns != 1 {
+		t.Errorf("Predict() of limited runs = %+v, want unknown", estimate)
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	tests := []struct {
+		samples int
+		size    Range
+		other   bool
+		want    string
+	}{
+		{10, Range{Median: 100, P90: 140}, false, ConfidenceHigh},
+		{10, Range{Median: 100, P90: 140}, true, ConfidenceMedium},
+		{10, Range{Median: 100, P90: 250}, false, ConfidenceMedium},
+		{10, Range{Median: 100, P90: 900}, false, ConfidenceLow},
+		{2, Range{Median: 100, P90: 100}, false, ConfidenceLow},
+		{5, Range{Median: 0, P90: 0}, false, ConfidenceHigh},
+		{5, Range{Median: 0, P90: 30}, false, ConfidenceLow},
+	}
+	for _, tt := range tests {
+		if got := confidence(tt.samples, &tt.size, tt.other); got != tt.want {
+			t.Errorf("confidence(%d, %+v, %v) = %s, want %s", tt.samples, tt.size, tt.other, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package stats aggregates recorded runs into usage reports: totals, the
+// most expensive commands, failure and limit rates, and an estimate of the
+// tokens that limits and filters kept out of the context window.
+package stats
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"github.com/slavakurilyak/ctx/internal/telemetry"
+)
+
+// Limits, as named in metadata.limits.limit_reached
+const (
+	LimitLines  = "lines"
+	LimitBytes  = "bytes"
+	LimitTokens = "tokens"
+)
+
+// Options control a report
+type Options struct {
+	Top int // Commands, directories and sessions to list; 0 for all
+}
+
+// Group aggregates the runs that share a key
+type Group struct {
+	Key         string  `json:"key,omitempty"`
+	Runs        int     `json:"runs"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"` // Failures / runs
+	Tokens      int64   `json:"tokens"`
+	Bytes       int64   `json:"bytes"`
+	DurationMs  int64   `json:"duration_ms"`
+}
+
+// Command aggregates the runs that share a fingerprint
+type Command struct {
+	Group            // Keyed by fingerprint
+	Template  string `json:"template"` // The command with its literals replaced
+	AvgTokens int    `json:"avg_tokens"`
+	Example   string `json:"example"` // The most recent run of the command
+}
+
+// Limit counts how often a limit stopped a run
+type Limit struct {
+	Limit string  `json:"limit"` // LimitLines, LimitBytes or LimitTokens
+	Runs  int     `json:"runs"`
+	Rate  float64 `json:"rate"` // Share of all runs
+}
+
+// Savings estimates the tokens limits and filters kept out of the context
+// window. A limited or filtered run is compared with the average of the
+// unlimited, unfiltered runs of the same command; runs without such a
+// reference are counted as unestimated.
+type Savings struct {
+	Tokens          int64 `json:"tokens"` // Limit and filter savings together
+	LimitTokens     int64 `json:"limit_tokens"`
+	FilterTokens    int64 `json:"filter_tokens"`
+	LimitedRuns     int   `json:"limited_runs"`
+	FilteredRuns    int   `json:"filtered_runs"`
+	UnestimatedRuns int   `json:"unestimated_runs"`
+}
+
+// Report is the aggregate of a set of runs
+type Report struct {
+	Since       string    `json:"since,omitempty"` // RFC3339 bounds of the runs considered
+	Until       string    `json:"until,omitempty"`
+	Totals      Group     `json:"totals"`
+	TopCommands []Command `json:"top_commands"` // Most tokens first
+	ByType      []Group   `json:"by_type"`      // Command categories, most tokens first
+	ByDirectory []Group   `json:"by_directory"`
+	BySession   []Group   `json:"by_session"`
+	Limits      []Limit   `json:"limits"` // Most frequent first
+	Savings     Savings   `json:"savings"`
+}
+
+// Compute aggregates the given runs, oldest first
+func Compute(records []*models.Output, opts Options) *Report {
+	report := &Report{
+		TopCommands: []Command{},
+		Limits:      []Limit{},
+	}
+	commands := make(map[string]*Command)
+	types := make(map[string]*Group)
+	dirs := make(map[string]*Group)
+	sessions := make(map[string]*Group)
+	limits := make(map[string]int)
+	latest := make(map[string]string) // Most recent timestamp per command
+
+	for _, record := range records {
+		add(&report.Totals, record)
+
+		key := recordFingerprint(record)
+		command, ok := commands[key]
+		if !ok {
+			command = &Command{Group: Group{Key: key}, Template: fingerprint.Template(record.Input)}
+			commands[key] = command
+		}
+		add(&command.Group, record)
+		if record.Metadata.Timestamp >= latest[key] {
+			latest[key] = record.Metadata.Timestamp
+			command.Example = record.Input
+		}
+
+		add(group(types, telemetry.DetectCommandType(record.Input)), record)
+		add(group(dirs, record.Metadata.Directory), record)
+		add(group(sessions, record.Metadata.SessionID), record)
+		if limit := limitReached(record); limit != "" {
+			limits[limit]++
+		}
+	}
+	finish(&report.Totals)
+
+	for _, command := range commands {
+		finish(&command.Group)
+		command.AvgTokens = int(command.Tokens / int64(command.Runs))
+		report.TopCommands = append(report.TopCommands, *command)
+	}
+	sort.Slice(report.TopCommands, func(i, j int) bool {
+		a, b := report.TopCommands[i], report.TopCommands[j]
+		if a.Tokens != b.Tokens {
+			return a.Tokens > b.Tokens
+		}
+		return a.Key < b.Key
+	})
+	if opts.Top > 0 && len(report.TopCommands) > opts.Top {
+		report.TopCommands = report.TopCommands[:opts.Top]
+	}
+
+	report.ByType = sortGroups(types, 0)
+	report.ByDirectory = sortGroups(dirs, opts.Top)
+	report.BySession = sortGroups(sessions, opts.Top)
+
+	for limit, runs := range limits {
+		report.Limits = append(report.Limits, Limit{Limit: limit, Runs: runs, Rate: float64(runs) / float64(len(records))})
+	}
+	sort.Slice(report.Limits, func(i, j int) bool {
+		a, b := report.Limits[i], report.Limits[j]
+		if a.Runs != b.Runs {
+			return a.Runs > b.Runs
+		}
+		return a.Limit < b.Limit
+	})
+
+	report.Savings = estimateSavings(records)
+	return report
+}
+
+// recordFingerprint returns the fingerprint of a run, computing it for runs
+// recorded before fingerprints
+func recordFingerprint(record *models.Output) string {
+	if record.Metadata.Fingerprint != "" {
+		return record.Metadata.Fingerprint
+	}
+	return fingerprint.Of(record.Input)
+}
+
+func group(groups map[string]*Group, key string) *Group {
+	g, ok := groups[key]
+	if !ok {
+		g = &Group{Key: key}
+		groups[key] = g
+	}
+	return g
+}
+
+func add(g *Group, record *models.Output) {
+	g.Runs++
+	if failed(record) {
+		g.Failures++
+	}
+	g.Tokens += int64(record.Tokens)
+	g.Bytes += int64(record.Metadata.Bytes)
+	g.DurationMs += int64(record.Metadata.Duration)
+}
+
+func finish(g *Group) {
+	if g.Runs > 0 {
+		g.FailureRate = float64(g.Failures) / float64(g.Runs)
+	}
+}
+
+// sortGroups returns the groups with the most tokens first, the top n of
+// them when n > 0
+func sortGroups(groups map[string]*Group, n int) []Group {
+	sorted := make([]Group, 0, len(groups))
+	for _, g := range groups {
+		finish(g)
+		sorted = append(sorted, *g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Tokens != sorted[j].Tokens {
+			return sorted[i].Tokens > sorted[j].Tokens
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// failed reports whether a run failed, either itself or by tripping a limit
+func failed(record *models.Output) bool {
+	return record.Metadata.ExitCode != 0 || record.Metadata.FailureReason != ""
+}
+
+// limitReached returns the limit that stopped a run, if any
+func limitReached(record *models.Output) string {
+	if record.Metadata.Limits != nil && record.Metadata.Limits.LimitReached != "" {
+		return record.Metadata.Limits.LimitReached
+	}
+	switch record.Metadata.FailureReason {
+	case "line_limit_exceeded":
+		return LimitLines
+	case "output_limit_exceeded":
+		return LimitBytes
+	case "token_limit_exceeded":
+		return LimitTokens
+	}
+	return ""
+}
+
+// estimateSavings compares limited and filtered runs with the average
+// tokens of the unlimited, unfiltered runs of the same command
+func estimateSavings(records []*models.Output) Savings {
+	type reference struct {
+		tokens int64
+		runs   int64
+	}
+	references := make(map[string]*reference)
+	for _, record := range records {
+		if limitReached(record) != "" || record.Metadata.ExitCode != 0 {
+			continue
+		}
+		if _, filtered := unfiltered(record.Input); filtered {
+			continue
+		}
+		key := recordFingerprint(record)
+		ref, ok := references[key]
+		if !ok {
+			ref = &reference{}
+			references[key] = ref
+		}
+		ref.tokens += int64(record.Tokens)
+		ref.runs++
+	}
+	saved := func(command string, tokens int) (int64, bool) {
+		ref, ok := references[fingerprint.Of(command)]
+		if !ok {
+			return 0, false
+		}
+		if avg := ref.tokens / ref.runs; avg > int64(tokens) {
+			return avg - int64(tokens), true
+		}
+		return 0, true
+	}
+
+	var savings Savings
+	for _, record := range records {
+		if limitReached(record) != "" {
+			savings.LimitedRuns++
+			if n, ok := saved(record.Input, record.Tokens); ok {
+				savings.LimitTokens += n
+			} else {
+				savings.UnestimatedRuns++
+			}
+			continue
+		}
+		if base, filtered := unfiltered(record.Input); filtered {
+			savings.FilteredRuns++
+			if n, ok := saved(base, record.Tokens); ok {
+				savings.FilterTokens += n
+			} else {
+				savings.UnestimatedRuns++
+			}
+		}
+	}
+	savings.Tokens = savings.LimitTokens + savings.FilterTokens
+	return savings
+}
+
+// filterCommands narrow the output of the pipeline stage before them
+var filterCommands = map[string]bool{
+	"head": true, "tail": true, "grep": true, "egrep": true, "rg": true,
+	"jq": true, "cut": true, "awk": true, "sed": true, "wc": true, "uniq": true,
+}
+
+var (
+	sqlLimit   = regexp.MustCompile(`(?i)\s+LIMIT\s+\d+`)
+	filterFlag = regexp.MustCompile(`\s+--(tail|since|max-count)(=|\s+)\S+`)
+)
+
+// unfiltered returns a command without its output filters: trailing pipeline
+// stages such as head or grep, SQL LIMIT clauses, and --tail, --since and
+// --max-count flags. It reports whether there were any.
+func unfiltered(command string) (string, bool) {
+	base := command
+	stages := splitPipeline(base)
+	n := len(stages)
+	for n > 1 {
+		fields := strings.Fields(stages[n-1])
+		if len(fields) == 0 || !filterCommands[fields[0]] {
+			break
+		}
+		n--
+	}
+	if n < len(stages) {
+		base = strings.Join(stages[:n], "|")
+	}
+	base = sqlLimit.ReplaceAllString(base, "")
+	base = filterFlag.ReplaceAllString(base, "")
+	base = strings.TrimSpace(base)
+	return base, base != strings.TrimSpace(command)
+}
+
+// splitPipeline splits a shell command at the pipes outside quotes
+func splitPipeline(command string) []string {
+	var stages []string
+	var quote rune
+	start := 0
+	for i, ch := range command {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+		case ch == '|':
+			if i+1 < len(command) && command[i+1] == '|' {
+				continue // ||
+			}
+			if i > 0 && command[i-1] == '|' {
+				continue
+			}
+			stages = append(stages, command[start:i])
+			start = i + 1
+		}
+	}
+	return append(stages, command[start:])
+}
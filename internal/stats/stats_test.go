@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/fingerprint"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func run(input string, tokens, exitCode int, dir, session, failureReason string) *models.Output {
+	return &models.Output{
+		Tokens: tokens,
+		Input:  input,
+		Metadata: models.MetadataSection{
+			ExitCode:      exitCode,
+			FailureReason: failureReason,
+			Bytes:         tokens * 4,
+			Duration:      10,
+			Directory:     dir,
+			SessionID:     session,
+			Timestamp:     "2025-01-01T10:00:00Z",
+		},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	records := []*models.Output{
+		run(`psql -c "SELECT * FROM users WHERE id=1"`, 400, 0, "/repo", "s1", ""),
+		run(`psql -c "SELECT * FROM users WHERE id=2"`, 600, 0, "/repo", "s1", ""),
+		run(`psql -c "SELECT * FROM users WHERE id=3"`, 50, 0, "/repo", "s2", "line_limit_exceeded"),
+		run("git log", 300, 0, "/repo/sub", "s2", ""),
+		run("git log | head -20", 20, 0, "/repo/sub", "s2", ""),
+		run("ls /missing", 10, 2, "/tmp", "s3", ""),
+	}
+	report := Compute(records, Options{Top: 2})
+
+	if report.Totals.Runs != 6 || report.Totals.Tokens != 1380 || report.Totals.Failures != 2 {
+		t.Errorf("Totals = %+v", report.Totals)
+	}
+	if report.Totals.DurationMs != 60 || report.Totals.Bytes != 1380*4 {
+		t.Errorf("Totals duration and bytes = %+v", report.Totals)
+	}
+
+	// The three queries share a fingerprint
+	if len(report.TopCommands) != 2 {
+		t.Fatalf("TopCommands = %+v, want the top 2", report.TopCommands)
+	}
+	top := report.TopCommands[0]
+	if top.Runs != 3 || top.Tokens != 1050 || top.AvgTokens != 350 || top.Example != records[2].Input {
+		t.Errorf("TopCommands[0] = %+v", top)
+	}
+	if want := `psql -c "SELECT * FROM users WHERE id=?"`; top.Template != want {
+		t.Errorf("TopCommands[0].Template = %q, want %q", top.Template, want)
+	}
+	if top.Key != fingerprint.Of(records[0].Input) {
+		t.Errorf("TopCommands[0] = %+v", top)
+	}
+
+	if got := report.ByType[0]; got.Key != "database" || got.Runs != 3 {
+		t.Errorf("ByType[0] = %+v", got)
+	}
+	if len(report.ByDirectory) != 2 || report.ByDirectory[0].Key != "/repo" {
+		t.Errorf("ByDirectory = %+v", report.ByDirectory)
+	}
+	if len(report.BySession) != 2 || report.BySession[0].Key != "s1" {
+		t.Errorf("BySession = %+v", report.BySession)
+	}
+
+	if len(report.Limits) != 1 || report.Limits[0].Limit != LimitLines || report.Limits[0].Runs != 1 {
+		t.Errorf("Limits = %+v", report.Limits)
+	}
+
+	// The limited query is compared with the average of the other two (500),
+	// and git log | head with git log (300)
+	want := Savings{Tokens: 730, LimitTokens: 450, FilterTokens: 280, LimitedRuns: 1, FilteredRuns: 1}
+	if report.Savings != want {
+		t.Errorf("Savings = %+v, want %+v", report.Savings, want)
+	}
+}
+
+func TestComputeEmpty(t *testing.T) {
+	report := Compute(nil, Options{})
+	if report.Totals.Runs != 0 || report.TopCommands == nil || report.Limits == nil {
+		t.Errorf("Compute(nil) = %+v", report)
+	}
+}
+
+func TestUnfiltered(t *testing.T) {
+	tests := []struct {
+		command  string
+		want     string
+		filtered bool
+	}{
+		{"git log | head -20", "git log", true},
+		{"kubectl logs api --tail 100", "kubectl logs api", true},
+		{"docker logs web --since=10m | grep ERROR | wc -l", "docker logs web", true},
+		{`psql -c "SELECT id FROM events LIMIT 10"`, `psql -c "SELECT id FROM events"`, true},
+		{"grep -r TODO .", "grep -r TODO .", false},
+		{`echo "a | head" | sort`, `echo "a | head" | sort`, false},
+		{"make test || tail -5 log", "make test || tail -5 log", false},
+	}
+	for _, tt := range tests {
+		got, filtered := unfiltered(tt.command)
+		if got != tt.want || filtered != tt.filtered {
+			t.Errorf("unfiltered(%q) = %q, %v; want %q, %v", tt.command, got, filtered, tt.want, tt.filtered)
+		}
+	}
+}
@@ -100,7 +100,7 @@ func (m *Manager) StartCommandSpan(ctx context.Context, command string) (context
 			attribute.String("code.function", "execute"),
 			attribute.String("code.namespace", "ctx"),
 			attribute.String("command.text", command),
-			attribute.String("command.type", detectCommandType(command)),
+			attribute.String("command.type", DetectCommandType(command)),
 		),
 	)
 }
@@ -252,7 +252,8 @@ func createOTLPExporter(ctx context.Context) (*otlptrace.Exporter, error) {
 	return exporter, nil
 }
 
-func detectCommandType(command string) string {
+// DetectCommandType categorizes a command by its first word (e.g. "vcs" for git)
+func DetectCommandType(command string) string {
 	// Simple command type detection based on the first word
 	if command == "" {
 		return "unknown"
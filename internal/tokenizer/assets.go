@@ -0,0 +1,596 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Asset kinds
+const (
+	AssetKindTiktoken = "tiktoken"
+	AssetKindGemini   = "gemini"
+)
+
+// Asset describes a downloadable tokenizer vocabulary file
+type Asset struct {
+	Name     string `json:"name"`     // Encoding name, e.g. "cl100k_base"
+	Kind     string `json:"kind"`     // AssetKindTiktoken or AssetKindGemini
+	URL      string `json:"url"`      // Upstream download location
+	SHA256   string `json:"sha256"`   // Expected checksum of the file
+	Filename string `json:"filename"` // File name inside the asset store
+}
+
+// AssetCatalog lists every tokenizer asset ctx knows how to fetch and verify.
+// The tiktoken checksums match the ones pinned by OpenAI's tiktoken package.
+var AssetCatalog = []Asset{
+	{
+		Name:     "cl100k_base",
+		Kind:     AssetKindTiktoken,
+		URL:      "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken",
+		SHA256:   "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+		Filename: "cl100k_base.tiktoken",
+	},
+	{
+		Name:     "o200k_base",
+		Kind:     AssetKindTiktoken,
+		URL:      "https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken",
+		SHA256:   "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+		Filename: "o200k_base.tiktoken",
+	},
+	{
+		Name:     "p50k_base",
+		Kind:     AssetKindTiktoken,
+		URL:      "https://openaipublic.blob.core.windows.net/encodings/p50k_base.tiktoken",
+		SHA256:   "94b5ca7dff4d00767bc256fdd1b27e5b17361d7b8a5f968547f9f23eb70d2069",
+		Filename: "p50k_base.tiktoken",
+	},
+	{
+		Name:     "r50k_base",
+		Kind:     AssetKindTiktoken,
+		URL:      "https://openaipublic.blob.core.windows.net/encodings/r50k_base.tiktoken",
+		SHA256:   "306cd27f03c1a714eca7108e03d66b7dc042abe8c258b44c199a7ed9838dd930",
+		Filename: "r50k_base.tiktoken",
+	},
+	{
+		Name:     "gemma",
+		Kind:     AssetKindGemini,
+		URL:      "https://raw.githubusercontent.com/google/gemma_pytorch/33b652c465537c6158f9a472ea5700e5e770ad3f/tokenizer/tokenizer.model",
+		SHA256:   "61a7b147390c64585d6c3543dd6fc636906c9af3865a5548f27f31aee1d4c8e2",
+		Filename: "gemma_tokenizer.model",
+	},
+}
+
+// FindAsset returns the catalog entry with the given name
+func FindAsset(name string) (Asset, bool) {
+	for _, asset := range AssetCatalog {
+		if strings.EqualFold(asset.Name, name) {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// findAssetByURL returns the catalog entry for an upstream URL
+func findAssetByURL(url string) (Asset, bool) {
+	for _, asset := range AssetCatalog {
+		if asset.URL == url {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// findAssetByChecksum returns the catalog entry whose checksum matches
+func findAssetByChecksum(sum string) (Asset, bool) {
+	for _, asset := range AssetCatalog {
+		if asset.SHA256 == sum {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// AssetsForProvider returns the assets a provider needs to count tokens
+func AssetsForProvider(provider string) []Asset {
+	var names []string
+	switch strings.ToLower(provider) {
+	case "anthropic", "openai":
+		names = []string{getEncodingForProvider(provider)}
+	case "gemini":
+		names = []string{"gemma"}
+	}
+
+	var assets []Asset
+	for _, name := range names {
+		if asset, ok := FindAsset(name); ok {
+			assets = append(assets, asset)
+		}
+	}
+	return assets
+}
+
+// ErrAssetNotInstalled is returned in offline mode when an asset is missing
+type ErrAssetNotInstalled struct {
+	Asset Asset
+}
+
+func (e *ErrAssetNotInstalled) Error() string {
+	return fmt.Sprintf("tokenizer asset %s is not installed (run 'ctx tokenizer fetch %s' or 'ctx tokenizer import <file>')", e.Asset.Name, e.Asset.Name)
+}
+
+// AssetStatus describes the local state of a catalog asset
+type AssetStatus struct {
+	Asset
+	Installed bool   `json:"installed"`
+	Verified  bool   `json:"verified"`
+	Path      string `json:"path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AssetStore manages tokenizer vocabulary files on the local file system so
+// that token counting works without network access
+type AssetStore struct {
+	dir        string
+	offline    bool
+	httpClient *http.Client
+}
+
+// NewAssetStore creates an asset store rooted at dir. When offline is true,
+// missing assets are reported instead of being downloaded.
+func NewAssetStore(dir string, offline bool) *AssetStore {
+	if dir == "" {
+		dir = DefaultAssetDir()
+	}
+	return &AssetStore{
+		dir:     dir,
+		offline: offline,
+		httpClient: &http.Client{
+			Timeout: 2 * time.Minute,
+		},
+	}
+}
+
+// DefaultAssetDir returns the default asset directory, matching the
+// default cache_dir setting
+func DefaultAssetDir() string {
+	if dir := os.Getenv("TIKTOKEN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".cache", "tiktoken")
+	}
+	return filepath.Join(os.TempDir(), "ctx-tokenizers")
+}
+
+// Dir returns the directory the store reads from and writes to
+func (s *AssetStore) Dir() string {
+	return s.dir
+}
+
+// Offline reports whether downloads are disabled
+func (s *AssetStore) Offline() bool {
+	return s.offline
+}
+
+// Path returns the location of an asset inside the store
+func (s *AssetStore) Path(asset Asset) string {
+	return filepath.Join(s.dir, asset.Filename)
+}
+
+// Status reports whether an asset is installed and intact
+func (s *AssetStore) Status(asset Asset) AssetStatus {
+	status := AssetStatus{Asset: asset}
+
+	path, data, err := s.readInstalled(asset)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			status.Error = err.Error()
+		}
+		return status
+	}
+
+	status.Installed = true
+	status.Path = path
+	status.Size = int64(len(data))
+	if sum := checksum(data); sum == asset.SHA256 {
+		status.Verified = true
+	} else {
+		status.Error = fmt.Sprintf("checksum mismatch: got %s, want %s", sum, asset.SHA256)
+	}
+	return status
+}
+
+// List returns the status of every catalog asset, sorted by name
+func (s *AssetStore) List() []AssetStatus {
+	statuses := make([]AssetStatus, 0, len(AssetCatalog))
+	for _, asset := range AssetCatalog {
+		statuses = append(statuses, s.Status(asset))
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Fetch downloads an asset, verifies its checksum and installs it.
+// Fetch always uses the network, even when the store is offline.
+func (s *AssetStore) Fetch(asset Asset) (string, error) {
+	resp, err := s.httpClient.Get(asset.URL)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: HTTP %d", asset.Name, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+
+	return s.install(asset, data)
+}
+
+// Import installs assets from a local file or directory. Files are matched to
+// catalog assets by checksum, so both renamed copies and other tools' cache
+// files are recognised. The imported assets are returned.
+func (s *AssetStore) Import(path string) ([]Asset, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		asset, err := s.importFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Asset{asset}, nil
+	}
+
+	var imported []Asset
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		asset, err := s.importFile(p)
+		if err != nil || seen[asset.Name] {
+			// Unrelated files are skipped when importing a directory
+			return nil
+		}
+		seen[asset.Name] = true
+		imported = append(imported, asset)
+		return nil
+	})
+	if err != nil {
+		return imported, err
+	}
+
+	if len(imported) == 0 {
+		return nil, fmt.Errorf("no known tokenizer assets found in %s", path)
+	}
+	return imported, nil
+}
+
+// importFile installs a single file if its checksum matches a catalog asset
+func (s *AssetStore) importFile(path string) (Asset, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Asset{}, err
+	}
+
+	asset, ok := findAssetByChecksum(checksum(data))
+	if !ok {
+		return Asset{}, fmt.Errorf("%s does not match the checksum of any known tokenizer asset", path)
+	}
+
+	if _, err := s.install(asset, data); err != nil {
+		return Asset{}, err
+	}
+	return asset, nil
+}
+
+// install verifies data against the asset checksum and writes it atomically
+func (s *AssetStore) install(asset Asset, data []byte) (string, error) {
+	if sum := checksum(data); sum != asset.SHA256 {
+		return "", fmt.Errorf("checksum mismatch for %s: got %s, want %s", asset.Name, sum, asset.SHA256)
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create tokenizer directory: %w", err)
+	}
+
+	path := s.Path(asset)
+	if err := writeFileAtomic(path, data); err != nil {
+		return "", fmt.Errorf("failed to install %s: %w", asset.Name, err)
+	}
+
+	// The Gemini tokenizer only reads from its own cache location
+	if asset.Kind == AssetKindGemini {
+		if err := writeFileAtomic(geminiCachePath(asset), data); err != nil {
+			return "", fmt.Errorf("failed to install %s: %w", asset.Name, err)
+		}
+	}
+
+	return path, nil
+}
+
+// Load returns the contents of an installed asset, downloading it first when
+// it is missing and the store is online
+func (s *AssetStore) Load(asset Asset) ([]byte, error) {
+	if _, data, err := s.readInstalled(asset); err == nil {
+		if checksum(data) == asset.SHA256 {
+			return data, nil
+		}
+		if s.offline {
+			return nil, fmt.Errorf("tokenizer asset %s at %s is corrupt (checksum mismatch)", asset.Name, s.Path(asset))
+		}
+	}
+
+	if s.offline {
+		return nil, &ErrAssetNotInstalled{Asset: asset}
+	}
+
+	path, err := s.Fetch(asset)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// readInstalled reads an asset from the store, falling back to the cache
+// locations used by the upstream tokenizer libraries
+func (s *AssetStore) readInstalled(asset Asset) (string, []byte, error) {
+	candidates := []string{s.Path(asset)}
+	switch asset.Kind {
+	case AssetKindTiktoken:
+		candidates = append(candidates, tiktokenCachePaths(asset)...)
+	case AssetKindGemini:
+		candidates = append(candidates, geminiCachePath(asset))
+	}
+
+	var firstErr error
+	for _, path := range candidates {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return path, data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", nil, firstErr
+}
+
+// prepareGemini makes sure the Gemini tokenizer finds its model in its cache,
+// so that it never needs to download it
+func (s *AssetStore) prepareGemini() error {
+	asset, _ := FindAsset("gemma")
+
+	if data, err := os.ReadFile(geminiCachePath(asset)); err == nil && checksum(data) == asset.SHA256 {
+		return nil
+	}
+
+	data, err := s.Load(asset)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(geminiCachePath(asset), data)
+}
+
+// tiktokenCachePaths returns where tiktoken-go caches a downloaded encoding
+func tiktokenCachePaths(asset Asset) []string {
+	key := fmt.Sprintf("%x", sha1.Sum([]byte(asset.URL)))
+	var paths []string
+	for _, env := range []string{"TIKTOKEN_CACHE_DIR", "DATA_GYM_CACHE_DIR"} {
+		if dir := os.Getenv(env); dir != "" {
+			paths = append(paths, filepath.Join(dir, key))
+		}
+	}
+	return append(paths, filepath.Join(os.TempDir(), "data-gym-cache", key))
+}
+
+// geminiCachePath returns where the Vertex AI tokenizer caches its model
+func geminiCachePath(asset Asset) string {
+	return filepath.Join(os.TempDir(), "vertexai_tokenizer_model", checksum([]byte(asset.URL)))
+}
+
+// checksum returns the hex-encoded SHA-256 of data
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// hfDir is the sub-directory holding imported Hugging Face tokenizers
+const hfDir = "hf"
+
+// HFAssetStatus describes an imported Hugging Face tokenizer
+type HFAssetStatus struct {
+	Alias    string `json:"alias"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// HFPath returns the path of an imported Hugging Face tokenizer, or "" when
+// no tokenizer was imported under that alias
+func (s *AssetStore) HFPath(alias string) string {
+	if alias == "" || strings.ContainsAny(alias, `/\`) {
+		return ""
+	}
+	path := filepath.Join(s.dir, hfDir, strings.ToLower(alias), "tokenizer.json")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// ImportHF validates a Hugging Face tokenizer.json and installs it under
+// alias, recording its checksum so that it can be verified later
+func (s *AssetStore) ImportHF(alias, path string) (string, error) {
+	if alias == "" || strings.ContainsAny(alias, `/\`) || strings.HasPrefix(alias, ".") {
+		return "", fmt.Errorf("invalid alias %q", alias)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parseHFTokenizer(data); err != nil {
+		return "", fmt.Errorf("%s is not a usable tokenizer.json: %w", path, err)
+	}
+
+	dest := filepath.Join(s.dir, hfDir, strings.ToLower(alias), "tokenizer.json")
+	if err := writeFileAtomic(dest, data); err != nil {
+		return "", fmt.Errorf("failed to install %s: %w", alias, err)
+	}
+	if err := writeFileAtomic(dest+".sha256", []byte(checksum(data)+"\n")); err != nil {
+		return "", fmt.Errorf("failed to install %s: %w", alias, err)
+	}
+	return dest, nil
+}
+
+// ListHF returns the status of every imported Hugging Face tokenizer
+func (s *AssetStore) ListHF() []HFAssetStatus {
+	entries, err := os.ReadDir(filepath.Join(s.dir, hfDir))
+	if err != nil {
+		return nil
+	}
+
+	var statuses []HFAssetStatus
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(s.dir, hfDir, entry.Name(), "tokenizer.json")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		status := HFAssetStatus{Alias: entry.Name(), Path: path, Size: int64(len(data)), SHA256: checksum(data)}
+		want, err := os.ReadFile(path + ".sha256")
+		switch {
+		case err != nil:
+			status.Error = "no recorded checksum"
+		case strings.TrimSpace(string(want)) != status.SHA256:
+			status.Error = fmt.Sprintf("checksum mismatch: got %s, want %s", status.SHA256, strings.TrimSpace(string(want)))
+		default:
+			status.Verified = true
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// assetLoader implements tiktoken.BpeLoader on top of the asset store
+type assetLoader struct{}
+
+// LoadTiktokenBpe loads BPE ranks from the asset store instead of the network
+func (l *assetLoader) LoadTiktokenBpe(url string) (map[string]int, error) {
+	asset, ok := findAssetByURL(url)
+	if !ok {
+		return nil, fmt.Errorf("unknown tiktoken encoding file: %s", url)
+	}
+
+	data, err := currentAssetStore().Load(asset)
+	if err != nil {
+		return nil, err
+	}
+	return parseTiktokenBpe(data)
+}
+
+// parseTiktokenBpe parses the "<base64 token> <rank>" format used by tiktoken
+func parseTiktokenBpe(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid tiktoken line: %q", line)
+		}
+		token, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(token)] = rank
+	}
+	return ranks, nil
+}
+
+var (
+	assetStore   *AssetStore
+	assetStoreMu sync.RWMutex
+)
+
+func init() {
+	// Route every tiktoken encoding load through the asset store
+	tiktoken.SetBpeLoader(&assetLoader{})
+}
+
+// SetAssetStore sets the store used to load tokenizer assets
+func SetAssetStore(store *AssetStore) {
+	assetStoreMu.Lock()
+	defer assetStoreMu.Unlock()
+	assetStore = store
+}
+
+// currentAssetStore returns the configured store, or an online default store
+func currentAssetStore() *AssetStore {
+	assetStoreMu.RLock()
+	store := assetStore
+	assetStoreMu.RUnlock()
+
+	if store == nil {
+		return NewAssetStore("", os.Getenv("CTX_TOKENIZER_OFFLINE") == "true")
+	}
+	return store
+}
@@ -0,0 +1,79 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetStoreOfflineMissing(t *testing.T) {
+	store := NewAssetStore(t.TempDir(), true)
+	asset, _ := FindAsset("cl100k_base")
+
+	// Point the upstream cache lookups at an empty directory
+	t.Setenv("TIKTOKEN_CACHE_DIR", t.TempDir())
+	t.Setenv("TMPDIR", t.TempDir())
+
+	_, err := store.Load(asset)
+	var notInstalled *ErrAssetNotInstalled
+	if !errors.As(err, &notInstalled) {
+		t.Fatalf("Load() error = %v, want ErrAssetNotInstalled", err)
+	}
+
+	if status := store.Status(asset); status.Installed {
+		t.Errorf("Status() = %+v, want not installed", status)
+	}
+}
+
+func TestAssetStoreImportRejectsUnknownFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "random.tiktoken")
+	if err := os.WriteFile(path, []byte("not a tokenizer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewAssetStore(t.TempDir(), true)
+	if _, err := store.Import(path); err == nil {
+		t.Error("Import() should reject a file with an unknown checksum")
+	}
+	if _, err := store.Import(dir); err == nil {
+		t.Error("Import() should fail for a directory without known assets")
+	}
+}
+
+func TestAssetStoreInstallVerifiesChecksum(t *testing.T) {
+	store := NewAssetStore(t.TempDir(), true)
+	data := []byte("fake vocabulary")
+	asset := Asset{Name: "fake", Kind: AssetKindTiktoken, SHA256: checksum(data), Filename: "fake.tiktoken"}
+
+	if _, err := store.install(asset, []byte("tampered")); err == nil {
+		t.Error("install() should reject data with a mismatched checksum")
+	}
+
+	path, err := store.install(asset, data)
+	if err != nil {
+		t.Fatalf("install() error = %v", err)
+	}
+	if status := store.Status(asset); !status.Verified || status.Path != path {
+		t.Errorf("Status() = %+v, want verified at %s", status, path)
+	}
+}
+
+func TestParseTiktokenBpe(t *testing.T) {
+	data := base64.StdEncoding.EncodeToString([]byte("hello")) + " 0\n" +
+		base64.StdEncoding.EncodeToString([]byte(" world")) + " 1\n"
+
+	ranks, err := parseTiktokenBpe([]byte(data))
+	if err != nil {
+		t.Fatalf("parseTiktokenBpe() error = %v", err)
+	}
+	if ranks["hello"] != 0 || ranks[" world"] != 1 || len(ranks) != 2 {
+		t.Errorf("parseTiktokenBpe() = %v", ranks)
+	}
+
+	if _, err := parseTiktokenBpe([]byte("bad-line\n")); err == nil {
+		t.Error("parseTiktokenBpe() should reject malformed lines")
+	}
+}
@@ -0,0 +1,289 @@
+package tokenizer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Token accuracy modes
+const (
+	AccuracyAuto      = "auto"      // Exact below the size threshold, sampled above it
+	AccuracyExact     = "exact"     // Tokenize everything
+	AccuracySampled   = "sampled"   // Tokenize a stratified sample and extrapolate
+	AccuracyHeuristic = "heuristic" // Use bytes-per-token ratios, no tokenization
+)
+
+// DefaultAccuracyThreshold is the output size above which auto mode samples
+const DefaultAccuracyThreshold int64 = 8 << 20 // 8 MiB
+
+// Sampling parameters. Texts too small to sample meaningfully are counted exactly.
+const (
+	sampleChunks     = 64
+	sampleChunkBytes = 4096
+	minSampledSize   = 4 * sampleChunks * sampleChunkBytes
+	sampleConfidence = 0.95
+	sampleZScore     = 1.96  // Two-sided 95% normal quantile
+	boundarySlack    = 0.005 // Allowance for tokens split at chunk edges, as a share of the estimate
+)
+
+// ParseAccuracy validates a token accuracy mode. An empty string means auto.
+func ParseAccuracy(mode string) (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+	case "":
+		return AccuracyAuto, nil
+	case AccuracyAuto, AccuracyExact, AccuracySampled, AccuracyHeuristic:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid token accuracy %q (valid: exact, sampled, heuristic, auto)", mode)
+	}
+}
+
+// ResolveAccuracy returns the method to use for a text of the given size
+func ResolveAccuracy(mode string, size, threshold int64) string {
+	if mode != AccuracyAuto && mode != "" {
+		return mode
+	}
+	if threshold <= 0 {
+		threshold = DefaultAccuracyThreshold
+	}
+	if size > threshold {
+		return AccuracySampled
+	}
+	return AccuracyExact
+}
+
+// Estimate is a token count together with how it was obtained
+type Estimate struct {
+	Tokens       int
+	Method       string  // AccuracyExact, AccuracySampled or AccuracyHeuristic
+	Low          int     // Lower bound of the count
+	High         int     // Upper bound of the count
+	Confidence   float64 // Confidence level of the bounds, 0 when they are plausible ranges
+	SampledBytes int     // Bytes actually tokenized when sampling
+}
+
+// CountWithAccuracy counts the tokens in text using the requested accuracy mode
+func CountWithAccuracy(tok Tokenizer, text string, mode string, threshold int64) (Estimate, error) {
+	switch ResolveAccuracy(mode, int64(len(text)), threshold) {
+	case AccuracyHeuristic:
+		return EstimateHeuristic(tok, len(text)), nil
+	case AccuracySampled:
+		return EstimateSampled(tok, text)
+	default:
+		// Large texts are split and counted concurrently, with the same result
+		count, err := CountTokensParallel(tok, text, 0)
+		if err != nil {
+			return Estimate{}, err
+		}
+		return Estimate{Tokens: count, Method: AccuracyExact, Low: count, High: count}, nil
+	}
+}
+
+// bytesPerToken describes how many bytes a token typically covers
+type bytesPerToken struct {
+	typical, min, max float64
+}
+
+// heuristicRatios are per-provider bytes-per-token ratios. The min/max range
+// covers dense content (code, non-Latin scripts) and sparse content (prose, logs).
+var heuristicRatios = map[string]bytesPerToken{
+	"anthropic": {typical: 3.8, min: 2.0, max: 5.5},
+	"openai":    {typical: 3.8, min: 2.0, max: 5.5},
+	"gemini":    {typical: 4.0, min: 2.0, max: 6.0},
+}
+
+// defaultRatio is used for tokenizers without a known ratio (e.g. Hugging Face)
+var defaultRatio = bytesPerToken{typical: 3.5, min: 1.8, max: 5.5}
+
+func ratioFor(tok Tokenizer) bytesPerToken {
+	if tok != nil {
+		if r, ok := heuristicRatios[strings.ToLower(tok.GetModelName())]; ok {
+			return r
+		}
+	}
+	return defaultRatio
+}
+
+// EstimateHeuristic estimates the token count of size bytes without tokenizing
+func EstimateHeuristic(tok Tokenizer, size int) Estimate {
+	r := ratioFor(tok)
+	return Estimate{
+		Tokens: int(math.Round(float64(size) / r.typical)),
+		Method: AccuracyHeuristic,
+		Low:    int(math.Floor(float64(size) / r.max)),
+		High:   int(math.Ceil(float64(size) / r.min)),
+	}
+}
+
+// EstimateSampled tokenizes one chunk from each of several equal strata of the
+// text and extrapolates with a ratio estimator. The bounds are a 95%
+// confidence interval. Small texts are counted exactly.
+func EstimateSampled(tok Tokenizer, text string) (Estimate, error) {
+	size := len(text)
+	if size < minSampledSize {
+		count, err := tok.CountTokens(text)
+		if err != nil {
+			return Estimate{}, err
+		}
+		return Estimate{Tokens: count, Method: AccuracyExact, Low: count, High: count}, nil
+	}
+
+	// Seed from the size so that repeated runs over the same output agree
+	rng := rand.New(rand.NewSource(int64(size)))
+	stratum := size / sampleChunks
+
+	tokens := make([]float64, 0, sampleChunks)
+	lengths := make([]float64, 0, sampleChunks)
+	var sumTokens, sumBytes float64
+	for i := 0; i < sampleChunks; i++ {
+		start := i*stratum + rng.Intn(stratum-sampleChunkBytes+1)
+		chunk := alignChunk(text, start, start+sampleChunkBytes)
+		if chunk == "" {
+			continue
+		}
+		count, err := tok.CountTokens(chunk)
+		if err != nil {
+			return Estimate{}, err
+		}
+		tokens = append(tokens, float64(count))
+		lengths = append(lengths, float64(len(chunk)))
+		sumTokens += float64(count)
+		sumBytes += float64(len(chunk))
+	}
+
+	n := float64(len(tokens))
+	if n < 2 || sumBytes == 0 {
+		count, err := tok.CountTokens(text)
+		if err != nil {
+			return Estimate{}, err
+		}
+		return Estimate{Tokens: count, Method: AccuracyExact, Low: count, High: count}, nil
+	}
+
+	// Ratio estimator: tokens per byte, with its standard error
+	ratio := sumTokens / sumBytes
+	var residuals float64
+	for i := range tokens {
+		d := tokens[i] - ratio*lengths[i]
+		residuals += d * d
+	}
+	meanBytes := sumBytes / n
+	stdErr := math.Sqrt(residuals/(n-1)/n) / meanBytes
+
+	total := float64(size)
+	margin := sampleZScore*stdErr*total + boundarySlack*ratio*total
+	return Estimate{
+		Tokens:       int(math.Round(ratio * total)),
+		Method:       AccuracySampled,
+		Low:          int(math.Max(0, math.Floor(ratio*total-margin))),
+		High:         int(math.Ceil(ratio*total + margin)),
+		Confidence:   sampleConfidence,
+		SampledBytes: int(sumBytes),
+	}, nil
+}
+
+// alignChunk returns text[start:end] moved to line boundaries when one is
+// close by, and otherwise to rune boundaries, so tokens are not cut in half
+func alignChunk(text string, start, end int) string {
+	if end > len(text) {
+		end = len(text)
+	}
+	window := (end - start) / 4
+
+	if start > 0 {
+		if i := strings.IndexByte(text[start:start+window], '\n'); i >= 0 {
+			start += i + 1
+		} else {
+			for start < end && !utf8.RuneStart(text[start]) {
+				start++
+			}
+		}
+	}
+	if end < len(text) {
+		if i := strings.LastIndexByte(text[end-window:end], '\n'); i >= 0 {
+			end = end - window + i + 1
+		} else {
+			for end > start && !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+	}
+	if start >= end {
+		return ""
+	}
+	return text[start:end]
+}
+
+// streamEstimator counts tokens line by line for streaming limit checks,
+// trading exactness for speed once the output grows large
+type streamEstimator struct {
+	tok       Tokenizer
+	mode      string
+	threshold int64
+
+	mu            sync.Mutex
+	bytes         int64
+	lines         int64
+	sampledBytes  int64
+	sampledTokens int64
+	carry         float64 // Fractional tokens carried between estimated lines
+}
+
+// streamSampleEvery is how often a line is tokenized exactly in sampled mode
+const streamSampleEvery = 8
+
+// NewStreamEstimator wraps tok so that CountTokens, called once per output
+// line, follows the accuracy mode: exact counts, heuristic ratios, or exact
+// counts for every few lines with the rest extrapolated. In auto mode,
+// counting is exact until threshold bytes have been seen.
+func NewStreamEstimator(tok Tokenizer, mode string, threshold int64) Tokenizer {
+	if tok == nil || mode == AccuracyExact {
+		return tok
+	}
+	if threshold <= 0 {
+		threshold = DefaultAccuracyThreshold
+	}
+	return &streamEstimator{tok: tok, mode: mode, threshold: threshold}
+}
+
+// CountTokens counts (or estimates) the tokens in one line
+func (s *streamEstimator) CountTokens(line string) (int, error) {
+	s.mu.Lock()
+	s.bytes += int64(len(line))
+	s.lines++
+	mode := ResolveAccuracy(s.mode, s.bytes, s.threshold)
+	sample := mode == AccuracyExact || (mode == AccuracySampled && s.lines%streamSampleEvery == 1)
+	s.mu.Unlock()
+
+	if sample {
+		count, err := s.tok.CountTokens(line)
+		if err != nil {
+			return 0, err
+		}
+		s.mu.Lock()
+		s.sampledBytes += int64(len(line))
+		s.sampledTokens += int64(count)
+		s.mu.Unlock()
+		return count, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perByte := 1 / ratioFor(s.tok).typical
+	if mode == AccuracySampled && s.sampledBytes > 0 {
+		perByte = float64(s.sampledTokens) / float64(s.sampledBytes)
+	}
+	estimate := float64(len(line))*perByte + s.carry
+	count := math.Floor(estimate)
+	s.carry = estimate - count
+	return int(count), nil
+}
+
+// GetModelName returns the wrapped tokenizer's model name
+func (s *streamEstimator) GetModelName() string {
+	return s.tok.GetModelName()
+}
@@ -0,0 +1,142 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// wordTokenizer counts whitespace-separated words, so exact counts are cheap to check
+type wordTokenizer struct {
+	name string
+}
+
+func (w wordTokenizer) CountTokens(text string) (int, error) {
+	return len(strings.Fields(text)), nil
+}
+
+func (w wordTokenizer) GetModelName() string {
+	return w.name
+}
+
+func TestParseAccuracy(t *testing.T) {
+	for input, want := range map[string]string{"": AccuracyAuto, "EXACT": AccuracyExact, " sampled ": AccuracySampled, "heuristic": AccuracyHeuristic} {
+		got, err := ParseAccuracy(input)
+		if err != nil || got != want {
+			t.Errorf("ParseAccuracy(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseAccuracy("precise"); err == nil {
+		t.Error("ParseAccuracy() should reject unknown modes")
+	}
+}
+
+func TestResolveAccuracy(t *testing.T) {
+	tests := []struct {
+		mode      string
+		size      int64
+		threshold int64
+		want      string
+	}{
+		{AccuracyAuto, 100, 1000, AccuracyExact},
+		{AccuracyAuto, 1001, 1000, AccuracySampled},
+		{"", DefaultAccuracyThreshold + 1, 0, AccuracySampled},
+		{AccuracyExact, 1 << 40, 1000, AccuracyExact},
+		{AccuracyHeuristic, 10, 1000, AccuracyHeuristic},
+	}
+	for _, tt := range tests {
+		if got := ResolveAccuracy(tt.mode, tt.size, tt.threshold); got != tt.want {
+			t.Errorf("ResolveAccuracy(%q, %d, %d) = %q, want %q", tt.mode, tt.size, tt.threshold, got, tt.want)
+		}
+	}
+}
+
+func TestEstimateHeuristic(t *testing.T) {
+	estimate := EstimateHeuristic(wordTokenizer{name: "openai"}, 38000)
+	if estimate.Method != AccuracyHeuristic || estimate.Tokens != 10000 {
+		t.Errorf("EstimateHeuristic() = %+v, want 10000 heuristic tokens", estimate)
+	}
+	if estimate.Low > estimate.Tokens || estimate.High < estimate.Tokens {
+		t.Errorf("EstimateHeuristic() bounds %d..%d do not contain %d", estimate.Low, estimate.High, estimate.Tokens)
+	}
+}
+
+func TestEstimateSampled(t *testing.T) {
+	// Mixed content: dense and sparse lines, so the per-chunk ratio varies
+	var sb strings.Builder
+	for i := 0; sb.Len() < 4*minSampledSize; i++ {
+		if i%3 == 0 {
+			fmt.Fprintf(&sb, "a b c d e f g h %d\n", i)
+		} else {
+			fmt.Fprintf(&sb, "INFO request_id=%08d handled_successfully_in_the_usual_amount_of_time\n", i)
+		}
+	}
+	text := sb.String()
+	tok := wordTokenizer{name: "test"}
+	exact, _ := tok.CountTokens(text)
+
+	estimate, err := EstimateSampled(tok, text)
+	if err != nil {
+		t.Fatalf("EstimateSampled() error = %v", err)
+	}
+	if estimate.Method != AccuracySampled || estimate.Confidence != sampleConfidence {
+		t.Fatalf("EstimateSampled() = %+v, want a sampled estimate", estimate)
+	}
+	if estimate.SampledBytes == 0 || estimate.SampledBytes >= len(text)/2 {
+		t.Errorf("EstimateSampled() tokenized %d of %d bytes", estimate.SampledBytes, len(text))
+	}
+	if exact < estimate.Low || exact > estimate.High {
+		t.Errorf("exact count %d outside estimate bounds %d..%d", exact, estimate.Low, estimate.High)
+	}
+	if diff := float64(estimate.Tokens-exact) / float64(exact); diff > 0.05 || diff < -0.05 {
+		t.Errorf("EstimateSampled() = %d, exact %d (off by %.1f%%)", estimate.Tokens, exact, diff*100)
+	}
+
+	// Repeated runs agree
+	again, _ := EstimateSampled(tok, text)
+	if again != estimate {
+		t.Errorf("EstimateSampled() is not deterministic: %+v vs %+v", estimate, again)
+	}
+
+	// Small texts are counted exactly
+	small, _ := EstimateSampled(tok, "just a few words")
+	if small.Method != AccuracyExact || small.Tokens != 4 {
+		t.Errorf("EstimateSampled(small) = %+v, want exact 4", small)
+	}
+}
+
+func TestStreamEstimator(t *testing.T) {
+	tok := wordTokenizer{name: "test"}
+
+	if got := NewStreamEstimator(tok, AccuracyExact, 0); got != Tokenizer(tok) {
+		t.Error("NewStreamEstimator() should return the tokenizer itself in exact mode")
+	}
+
+	// Auto mode is exact until the threshold is crossed
+	auto := NewStreamEstimator(tok, AccuracyAuto, 1000)
+	if count, _ := auto.CountTokens("one two three"); count != 3 {
+		t.Errorf("auto CountTokens() = %d, want 3 below the threshold", count)
+	}
+
+	// Heuristic mode never tokenizes; fractions carry over between lines
+	heuristic := NewStreamEstimator(tok, AccuracyHeuristic, 0)
+	total := 0
+	for i := 0; i < 100; i++ {
+		count, _ := heuristic.CountTokens("abcdefg") // 7 bytes, 2 tokens/line at 3.5 bytes/token
+		total += count
+	}
+	if total != 200 {
+		t.Errorf("heuristic total = %d, want 200", total)
+	}
+
+	// Sampled mode extrapolates from the lines it tokenizes
+	sampled := NewStreamEstimator(tok, AccuracySampled, 0)
+	total = 0
+	for i := 0; i < 800; i++ {
+		count, _ := sampled.CountTokens("w1 w2 w3 w4 w5")
+		total += count
+	}
+	if total < 3900 || total > 4000 {
+		t.Errorf("sampled total = %d, want about 4000", total)
+	}
+}
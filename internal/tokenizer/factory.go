@@ -25,7 +25,11 @@ func (f *DefaultTokenizerFactory) CreateTokenizer(provider string) (Tokenizer, e
 	case "gemini":
 		return NewGeminiTokenizer(provider)
 	default:
-		return nil, fmt.Errorf("unsupported provider: %s (supported: anthropic, openai, gemini)", provider)
+		// Hugging Face tokenizers are addressed as hf:<path>, hf:<alias> or a known alias
+		if path, ok := resolveHFTokenizer(provider); ok {
+			return NewHFTokenizer(provider, path)
+		}
+		return nil, fmt.Errorf("unsupported provider: %s (supported: anthropic, openai, gemini, hf:<tokenizer.json>)", provider)
 	}
 }
 
@@ -58,5 +62,6 @@ func IsProviderSupported(provider string) bool {
 			return true
 		}
 	}
-	return false
+	_, ok := resolveHFTokenizer(provider)
+	return ok
 }
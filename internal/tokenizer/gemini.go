@@ -19,6 +19,11 @@ func NewGeminiTokenizer(provider string) (*GeminiTokenizer, error) {
 	// Use a default Gemini model for tokenization
 	defaultModel := "gemini-1.5-pro"
 
+	// Make the model available from the asset store so that no download is needed
+	if err := currentAssetStore().prepareGemini(); err != nil {
+		return nil, fmt.Errorf("failed to create Gemini tokenizer for provider %s: %w", provider, err)
+	}
+
 	tok, err := tokenizer.New(defaultModel)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Gemini tokenizer for provider %s: %w", provider, err)
@@ -0,0 +1,1278 @@
+package tokenizer
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dlclark/regexp2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// HFProviderPrefix selects a Hugging Face tokenizer, e.g. "hf:/path/to/tokenizer.json" or "hf:llama3"
+const HFProviderPrefix = "hf:"
+
+// HFTokenizer counts tokens with a Hugging Face tokenizer.json file.
+// It supports BPE (byte-level and SentencePiece-style with byte fallback),
+// Unigram and WordPiece models. Special tokens added by post-processors
+// (e.g. a leading <s>) are not counted, matching how tiktoken counts raw text.
+type HFTokenizer struct {
+	provider     string
+	path         string
+	addedTokens  []hfAddedToken
+	normalizer   hfNormalizer
+	preTokenizer hfPreTokenizer
+	model        hfModel
+
+	cacheMu sync.RWMutex
+	cache   map[string]int // Token counts per pre-tokenized piece
+}
+
+// maxHFCacheEntries bounds the per-piece count cache
+const maxHFCacheEntries = 50000
+
+// NewHFTokenizer loads a tokenizer.json file
+func NewHFTokenizer(provider string, path string) (*HFTokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Hugging Face tokenizer %s: %w", path, err)
+	}
+
+	tok, err := parseHFTokenizer(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Hugging Face tokenizer %s: %w", path, err)
+	}
+
+	tok.provider = provider
+	tok.path = path
+	return tok, nil
+}
+
+// CountTokens counts the number of tokens in the given text
+func (t *HFTokenizer) CountTokens(text string) (int, error) {
+	total := 0
+	for i, segment := range t.splitAddedTokens(text) {
+		if segment.added {
+			total++
+			continue
+		}
+
+		content := segment.text
+		if t.normalizer != nil {
+			content = t.normalizer.normalize(content)
+		}
+		if content == "" {
+			continue
+		}
+
+		pieces := []hfPiece{{text: content, first: i == 0}}
+		if t.preTokenizer != nil {
+			pieces = t.preTokenizer.preTokenize(pieces)
+		}
+
+		for _, piece := range pieces {
+			if piece.text == "" {
+				continue
+			}
+			total += t.countPiece(piece.text)
+		}
+	}
+	return total, nil
+}
+
+// GetModelName returns the provider name (for compatibility)
+func (t *HFTokenizer) GetModelName() string {
+	return t.provider
+}
+
+// countPiece counts a single pre-tokenized piece, using the cache for repeats
+func (t *HFTokenizer) countPiece(piece string) int {
+	t.cacheMu.RLock()
+	count, ok := t.cache[piece]
+	t.cacheMu.RUnlock()
+	if ok {
+		return count
+	}
+
+	count = t.model.count(piece)
+
+	t.cacheMu.Lock()
+	if len(t.cache) < maxHFCacheEntries {
+		t.cache[piece] = count
+	}
+	t.cacheMu.Unlock()
+	return count
+}
+
+// hfSegment is a part of the input that is either an added token or plain text
+type hfSegment struct {
+	text  string
+	added bool
+}
+
+// splitAddedTokens splits the input around added (special) tokens, which are
+// always a single token each
+func (t *HFTokenizer) splitAddedTokens(text string) []hfSegment {
+	if len(t.addedTokens) == 0 {
+		return []hfSegment{{text: text}}
+	}
+
+	var segments []hfSegment
+	rest := text
+	for rest != "" {
+		idx, tok := t.nextAddedToken(rest)
+		if tok == nil {
+			segments = append(segments, hfSegment{text: rest})
+			break
+		}
+
+		before := rest[:idx]
+		after := rest[idx+len(tok.Content):]
+		if tok.LStrip {
+			before = strings.TrimRightFunc(before, unicode.IsSpace)
+		}
+		if tok.RStrip {
+			after = strings.TrimLeftFunc(after, unicode.IsSpace)
+		}
+
+		if before != "" {
+			segments = append(segments, hfSegment{text: before})
+		}
+		segments = append(segments, hfSegment{text: tok.Content, added: true})
+		rest = after
+	}
+	return segments
+}
+
+// nextAddedToken finds the earliest (then longest) added token in text
+func (t *HFTokenizer) nextAddedToken(text string) (int, *hfAddedToken) {
+	bestIdx := -1
+	var best *hfAddedToken
+	for i := range t.addedTokens {
+		tok := &t.addedTokens[i]
+		idx := strings.Index(text, tok.Content)
+		if idx < 0 {
+			continue
+		}
+		if bestIdx < 0 || idx < bestIdx || (idx == bestIdx && len(tok.Content) > len(best.Content)) {
+			bestIdx = idx
+			best = tok
+		}
+	}
+	return bestIdx, best
+}
+
+// tokenizer.json structure (only the parts needed for counting)
+
+type hfFile struct {
+	AddedTokens  []hfAddedToken  `json:"added_tokens"`
+	Normalizer   json.RawMessage `json:"normalizer"`
+	PreTokenizer json.RawMessage `json:"pre_tokenizer"`
+	Model        hfModelSpec     `json:"model"`
+}
+
+type hfAddedToken struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	LStrip  bool   `json:"lstrip"`
+	RStrip  bool   `json:"rstrip"`
+	Special bool   `json:"special"`
+}
+
+type hfModelSpec struct {
+	Type                    string          `json:"type"`
+	Vocab                   json.RawMessage `json:"vocab"`
+	Merges                  json.RawMessage `json:"merges"`
+	UnkToken                *string         `json:"unk_token"`
+	UnkID                   *int            `json:"unk_id"`
+	ByteFallback            bool            `json:"byte_fallback"`
+	FuseUnk                 bool            `json:"fuse_unk"`
+	IgnoreMerges            bool            `json:"ignore_merges"`
+	ContinuingSubwordPrefix *string         `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string         `json:"end_of_word_suffix"`
+	MaxInputCharsPerWord    int             `json:"max_input_chars_per_word"`
+}
+
+// hfComponent holds the type tag and raw fields of a normalizer or pre-tokenizer
+type hfComponent struct {
+	Type string `json:"type"`
+	raw  json.RawMessage
+}
+
+func parseHFTokenizer(data []byte) (*HFTokenizer, error) {
+	var file hfFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid tokenizer.json: %w", err)
+	}
+
+	normalizer, err := parseHFNormalizer(file.Normalizer)
+	if err != nil {
+		return nil, err
+	}
+
+	preTokenizer, err := parseHFPreTokenizer(file.PreTokenizer)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := parseHFModel(file.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop empty added tokens so they can never match
+	var added []hfAddedToken
+	for _, tok := range file.AddedTokens {
+		if tok.Content != "" {
+			added = append(added, tok)
+		}
+	}
+
+	return &HFTokenizer{
+		addedTokens:  added,
+		normalizer:   normalizer,
+		preTokenizer: preTokenizer,
+		model:        model,
+		cache:        make(map[string]int),
+	}, nil
+}
+
+func decodeHFComponent(raw json.RawMessage) (*hfComponent, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var c hfComponent
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	c.raw = raw
+	return &c, nil
+}
+
+// hfPattern is the {"String": ...} or {"Regex": ...} pattern used by Split and Replace
+type hfPattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+// compile turns the pattern into a regular expression
+func (p hfPattern) compile() (*regexp2.Regexp, error) {
+	switch {
+	case p.Regex != nil:
+		return regexp2.Compile(*p.Regex, regexp2.Unicode)
+	case p.String != nil:
+		return regexp2.Compile(regexp2.Escape(*p.String), regexp2.None)
+	default:
+		return nil, fmt.Errorf("pattern has neither String nor Regex")
+	}
+}
+
+// Normalizers
+
+type hfNormalizer interface {
+	normalize(text string) string
+}
+
+type hfNormalizerFunc func(string) string
+
+func (f hfNormalizerFunc) normalize(text string) string { return f(text) }
+
+type hfNormalizerSequence []hfNormalizer
+
+func (s hfNormalizerSequence) normalize(text string) string {
+	for _, n := range s {
+		text = n.normalize(text)
+	}
+	return text
+}
+
+func parseHFNormalizer(raw json.RawMessage) (hfNormalizer, error) {
+	c, err := decodeHFComponent(raw)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "Sequence":
+		var spec struct {
+			Normalizers []json.RawMessage `json:"normalizers"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		var seq hfNormalizerSequence
+		for _, child := range spec.Normalizers {
+			n, err := parseHFNormalizer(child)
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				seq = append(seq, n)
+			}
+		}
+		return seq, nil
+	case "Prepend":
+		var spec struct {
+			Prepend string `json:"prepend"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		return hfNormalizerFunc(func(s string) string {
+			if s == "" {
+				return s
+			}
+			return spec.Prepend + s
+		}), nil
+	case "Replace":
+		var spec struct {
+			Pattern hfPattern `json:"pattern"`
+			Content string    `json:"content"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		if spec.Pattern.String != nil {
+			old := *spec.Pattern.String
+			return hfNormalizerFunc(func(s string) string {
+				return strings.ReplaceAll(s, old, spec.Content)
+			}), nil
+		}
+		re, err := spec.Pattern.compile()
+		if err != nil {
+			return nil, err
+		}
+		return hfNormalizerFunc(func(s string) string {
+			out, err := re.Replace(s, spec.Content, -1, -1)
+			if err != nil {
+				return s
+			}
+			return out
+		}), nil
+	case "Strip":
+		var spec struct {
+			Left  bool `json:"strip_left"`
+			Right bool `json:"strip_right"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		return hfNormalizerFunc(func(s string) string {
+			if spec.Left {
+				s = strings.TrimLeftFunc(s, unicode.IsSpace)
+			}
+			if spec.Right {
+				s = strings.TrimRightFunc(s, unicode.IsSpace)
+			}
+			return s
+		}), nil
+	case "Lowercase":
+		return hfNormalizerFunc(strings.ToLower), nil
+	case "NFC":
+		return hfNormalizerFunc(norm.NFC.String), nil
+	case "NFD":
+		return hfNormalizerFunc(norm.NFD.String), nil
+	case "NFKC":
+		return hfNormalizerFunc(norm.NFKC.String), nil
+	case "Precompiled":
+		var spec struct {
+			Charsmap []byte `json:"precompiled_charsmap"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		if len(spec.Charsmap) == 0 {
+			return nil, nil // Nothing to normalize
+		}
+		return newHFPrecompiled(spec.Charsmap)
+	case "NFKD":
+		return hfNormalizerFunc(norm.NFKD.String), nil
+	case "BertNormalizer":
+		spec := struct {
+			CleanText          bool  `json:"clean_text"`
+			HandleChineseChars bool  `json:"handle_chinese_chars"`
+			StripAccents       *bool `json:"strip_accents"`
+			Lowercase          bool  `json:"lowercase"`
+		}{}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		// Like BERT, accents are stripped whenever lowercasing unless disabled explicitly
+		stripAccents := spec.Lowercase
+		if spec.StripAccents != nil {
+			stripAccents = *spec.StripAccents
+		}
+		return hfNormalizerFunc(func(s string) string {
+			return bertNormalize(s, spec.CleanText, spec.HandleChineseChars, stripAccents, spec.Lowercase)
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported normalizer type %q", c.Type)
+	}
+}
+
+// bertNormalize implements the BertNormalizer steps
+func bertNormalize(s string, cleanText, chineseChars, stripAccents, lowercase bool) string {
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case cleanText && (r == 0 || r == utf8.RuneError || (unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r')):
+			continue
+		case cleanText && unicode.IsSpace(r):
+			sb.WriteByte(' ')
+		case chineseChars && unicode.Is(unicode.Han, r):
+			sb.WriteByte(' ')
+			sb.WriteRune(r)
+			sb.WriteByte(' ')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	s = sb.String()
+
+	if stripAccents {
+		decomposed := norm.NFD.String(s)
+		sb.Reset()
+		for _, r := range decomposed {
+			if !unicode.Is(unicode.Mn, r) {
+				sb.WriteRune(r)
+			}
+		}
+		s = sb.String()
+	}
+	if lowercase {
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// Pre-tokenizers
+
+// hfPiece is a pre-tokenized piece of text. first marks the piece that starts
+// the input, which matters for Metaspace's "first" prepend scheme.
+type hfPiece struct {
+	text  string
+	first bool
+}
+
+type hfPreTokenizer interface {
+	preTokenize(pieces []hfPiece) []hfPiece
+}
+
+type hfPreTokenizerSequence []hfPreTokenizer
+
+func (s hfPreTokenizerSequence) preTokenize(pieces []hfPiece) []hfPiece {
+	for _, p := range s {
+		pieces = p.preTokenize(pieces)
+	}
+	return pieces
+}
+
+// punctuationPattern matches what BERT considers punctuation: Unicode
+// punctuation plus every ASCII symbol
+const punctuationPattern = `[\p{P}!-/:-@\[-` + "`" + `{-~]`
+
+// gpt2Pattern is the split pattern used by the ByteLevel pre-tokenizer
+const gpt2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+func parseHFPreTokenizer(raw json.RawMessage) (hfPreTokenizer, error) {
+	c, err := decodeHFComponent(raw)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	switch c.Type {
+	case "Sequence":
+		var spec struct {
+			PreTokenizers []json.RawMessage `json:"pretokenizers"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		var seq hfPreTokenizerSequence
+		for _, child := range spec.PreTokenizers {
+			p, err := parseHFPreTokenizer(child)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				seq = append(seq, p)
+			}
+		}
+		return seq, nil
+	case "ByteLevel":
+		spec := struct {
+			AddPrefixSpace bool  `json:"add_prefix_space"`
+			UseRegex       *bool `json:"use_regex"`
+		}{}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		p := &hfByteLevel{addPrefixSpace: spec.AddPrefixSpace}
+		if spec.UseRegex == nil || *spec.UseRegex {
+			p.split = &hfSplit{re: regexp2.MustCompile(gpt2Pattern, regexp2.Unicode), behavior: "Isolated"}
+		}
+		return p, nil
+	case "Split":
+		var spec struct {
+			Pattern  hfPattern `json:"pattern"`
+			Behavior string    `json:"behavior"`
+			Invert   bool      `json:"invert"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		re, err := spec.Pattern.compile()
+		if err != nil {
+			return nil, err
+		}
+		return &hfSplit{re: re, behavior: spec.Behavior, invert: spec.Invert}, nil
+	case "Metaspace":
+		spec := struct {
+			Replacement    string `json:"replacement"`
+			PrependScheme  string `json:"prepend_scheme"`
+			AddPrefixSpace *bool  `json:"add_prefix_space"`
+			Split          *bool  `json:"split"`
+		}{Replacement: "▁"}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		scheme := spec.PrependScheme
+		if scheme == "" {
+			// Older files use add_prefix_space instead of prepend_scheme
+			scheme = "always"
+			if spec.AddPrefixSpace != nil && !*spec.AddPrefixSpace {
+				scheme = "never"
+			}
+		}
+		return &hfMetaspace{
+			replacement:   spec.Replacement,
+			prependScheme: scheme,
+			split:         spec.Split == nil || *spec.Split,
+		}, nil
+	case "BertPreTokenizer":
+		return hfPreTokenizerSequence{
+			&hfSplit{re: regexp2.MustCompile(`\s+`, regexp2.Unicode), behavior: "Removed"},
+			&hfSplit{re: regexp2.MustCompile(punctuationPattern, regexp2.Unicode), behavior: "Isolated"},
+		}, nil
+	case "Whitespace":
+		return &hfSplit{re: regexp2.MustCompile(`\w+|[^\w\s]+`, regexp2.Unicode), behavior: "Removed", invert: true}, nil
+	case "WhitespaceSplit":
+		return &hfSplit{re: regexp2.MustCompile(`\s+`, regexp2.Unicode), behavior: "Removed"}, nil
+	case "Digits":
+		var spec struct {
+			IndividualDigits bool `json:"individual_digits"`
+		}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		if spec.IndividualDigits {
+			return &hfSplit{re: regexp2.MustCompile(`\p{N}`, regexp2.Unicode), behavior: "Isolated"}, nil
+		}
+		return &hfSplit{re: regexp2.MustCompile(`\p{N}+`, regexp2.Unicode), behavior: "Isolated"}, nil
+	case "Punctuation":
+		spec := struct {
+			Behavior string `json:"behavior"`
+		}{Behavior: "Isolated"}
+		if err := json.Unmarshal(c.raw, &spec); err != nil {
+			return nil, err
+		}
+		return &hfSplit{re: regexp2.MustCompile(punctuationPattern, regexp2.Unicode), behavior: spec.Behavior}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pre-tokenizer type %q", c.Type)
+	}
+}
+
+// hfSplit splits pieces on a pattern; behavior decides what happens to the matches
+type hfSplit struct {
+	re       *regexp2.Regexp
+	behavior string // Removed, Isolated, MergedWithPrevious, MergedWithNext or Contiguous
+	invert   bool
+}
+
+func (s *hfSplit) preTokenize(pieces []hfPiece) []hfPiece {
+	var out []hfPiece
+	for _, piece := range pieces {
+		for i, part := range s.splitString(piece.text) {
+			out = append(out, hfPiece{text: part, first: piece.first && i == 0})
+		}
+	}
+	return out
+}
+
+// splitString splits one string according to the pattern and behavior
+func (s *hfSplit) splitString(text string) []string {
+	type span struct {
+		text  string
+		delim bool
+	}
+
+	// Collect alternating non-matching and matching spans (regexp2 indexes runes)
+	runes := []rune(text)
+	var spans []span
+	last := 0
+	m, _ := s.re.FindRunesMatch(runes)
+	for m != nil {
+		start, end := m.Index, m.Index+m.Length
+		if end == start {
+			m, _ = s.re.FindNextMatch(m)
+			continue
+		}
+		if start > last {
+			spans = append(spans, span{text: string(runes[last:start]), delim: s.invert})
+		}
+		spans = append(spans, span{text: string(runes[start:end]), delim: !s.invert})
+		last = end
+		m, _ = s.re.FindNextMatch(m)
+	}
+	if last < len(runes) {
+		spans = append(spans, span{text: string(runes[last:]), delim: s.invert})
+	}
+
+	var out []string
+	switch s.behavior {
+	case "Removed":
+		for _, sp := range spans {
+			if !sp.delim {
+				out = append(out, sp.text)
+			}
+		}
+	case "MergedWithPrevious":
+		for _, sp := range spans {
+			if sp.delim && len(out) > 0 {
+				out[len(out)-1] += sp.text
+			} else {
+				out = append(out, sp.text)
+			}
+		}
+	case "MergedWithNext":
+		pending := ""
+		for _, sp := range spans {
+			if sp.delim {
+				pending += sp.text
+				continue
+			}
+			out = append(out, pending+sp.text)
+			pending = ""
+		}
+		if pending != "" {
+			out = append(out, pending)
+		}
+	case "Contiguous":
+		prevDelim := false
+		for _, sp := range spans {
+			if sp.delim && prevDelim {
+				out[len(out)-1] += sp.text
+			} else {
+				out = append(out, sp.text)
+			}
+			prevDelim = sp.delim
+		}
+	default: // Isolated
+		for _, sp := range spans {
+			out = append(out, sp.text)
+		}
+	}
+	return out
+}
+
+// hfByteLevel maps bytes to printable characters, as GPT-2 style tokenizers do
+type hfByteLevel struct {
+	addPrefixSpace bool
+	split          *hfSplit
+}
+
+func (b *hfByteLevel) preTokenize(pieces []hfPiece) []hfPiece {
+	if b.addPrefixSpace {
+		for i := range pieces {
+			if pieces[i].first && !strings.HasPrefix(pieces[i].text, " ") {
+				pieces[i].text = " " + pieces[i].text
+			}
+		}
+	}
+	if b.split != nil {
+		pieces = b.split.preTokenize(pieces)
+	}
+	for i := range pieces {
+		pieces[i].text = byteLevelEncode(pieces[i].text)
+	}
+	return pieces
+}
+
+var byteToRune = buildByteToRune()
+
+// buildByteToRune builds the GPT-2 bytes_to_unicode table
+func buildByteToRune() [256]rune {
+	var table [256]rune
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			table[b] = rune(b)
+		} else {
+			table[b] = rune(256 + n)
+			n++
+		}
+	}
+	return table
+}
+
+func byteLevelEncode(text string) string {
+	var sb strings.Builder
+	sb.Grow(len(text) * 2)
+	for i := 0; i < len(text); i++ {
+		sb.WriteRune(byteToRune[text[i]])
+	}
+	return sb.String()
+}
+
+// hfMetaspace replaces spaces with a visible marker (▁), as SentencePiece does
+type hfMetaspace struct {
+	replacement   string
+	prependScheme string // always, first or never
+	split         bool
+}
+
+func (m *hfMetaspace) preTokenize(pieces []hfPiece) []hfPiece {
+	var out []hfPiece
+	for _, piece := range pieces {
+		text := strings.ReplaceAll(piece.text, " ", m.replacement)
+		if m.prependScheme == "always" || (m.prependScheme == "first" && piece.first) {
+			if !strings.HasPrefix(text, m.replacement) {
+				text = m.replacement + text
+			}
+		}
+
+		if !m.split {
+			out = append(out, hfPiece{text: text, first: piece.first})
+			continue
+		}
+
+		// Split so that every piece starts with the replacement character
+		parts := strings.SplitAfter(text, m.replacement)
+		current := ""
+		var split []string
+		for _, part := range parts {
+			if strings.HasSuffix(part, m.replacement) {
+				body := strings.TrimSuffix(part, m.replacement)
+				current += body
+				if current != "" {
+					split = append(split, current)
+				}
+				current = m.replacement
+			} else {
+				current += part
+			}
+		}
+		if current != "" {
+			split = append(split, current)
+		}
+		for i, s := range split {
+			out = append(out, hfPiece{text: s, first: piece.first && i == 0})
+		}
+	}
+	return out
+}
+
+// Models
+
+type hfModel interface {
+	count(piece string) int
+}
+
+func parseHFModel(spec hfModelSpec) (hfModel, error) {
+	modelType := spec.Type
+	if modelType == "" {
+		// Older files omit the type; infer it from the fields
+		switch {
+		case len(spec.Merges) > 0:
+			modelType = "BPE"
+		case spec.UnkID != nil:
+			modelType = "Unigram"
+		default:
+			modelType = "WordPiece"
+		}
+	}
+
+	switch modelType {
+	case "BPE":
+		return newHFBPE(spec)
+	case "Unigram":
+		return newHFUnigram(spec)
+	case "WordPiece":
+		return newHFWordPiece(spec)
+	default:
+		return nil, fmt.Errorf("unsupported model type %q", modelType)
+	}
+}
+
+// hfBPE is a byte-pair encoding model
+type hfBPE struct {
+	vocab        map[string]int
+	merges       map[[2]int]hfMerge
+	unkID        int // -1 when the model has no unknown token
+	byteFallback bool
+	fuseUnk      bool
+	ignoreMerges bool
+	prefix       string // continuing_subword_prefix
+	suffix       string // end_of_word_suffix
+}
+
+type hfMerge struct {
+	rank int
+	id   int
+}
+
+func newHFBPE(spec hfModelSpec) (*hfBPE, error) {
+	var vocab map[string]int
+	if err := json.Unmarshal(spec.Vocab, &vocab); err != nil {
+		return nil, fmt.Errorf("invalid BPE vocab: %w", err)
+	}
+
+	pairs, err := parseHFMerges(spec.Merges)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &hfBPE{
+		vocab:        vocab,
+		merges:       make(map[[2]int]hfMerge, len(pairs)),
+		unkID:        -1,
+		byteFallback: spec.ByteFallback,
+		fuseUnk:      spec.FuseUnk,
+		ignoreMerges: spec.IgnoreMerges,
+	}
+	if spec.ContinuingSubwordPrefix != nil {
+		m.prefix = *spec.ContinuingSubwordPrefix
+	}
+	if spec.EndOfWordSuffix != nil {
+		m.suffix = *spec.EndOfWordSuffix
+	}
+	if spec.UnkToken != nil {
+		if id, ok := vocab[*spec.UnkToken]; ok {
+			m.unkID = id
+		}
+	}
+
+	for rank, pair := range pairs {
+		left, okLeft := vocab[pair[0]]
+		right, okRight := vocab[pair[1]]
+		merged := pair[0] + strings.TrimPrefix(pair[1], m.prefix)
+		id, okMerged := vocab[merged]
+		if !okLeft || !okRight || !okMerged {
+			return nil, fmt.Errorf("merge %q %q refers to tokens missing from the vocab", pair[0], pair[1])
+		}
+		key := [2]int{left, right}
+		if _, exists := m.merges[key]; !exists {
+			m.merges[key] = hfMerge{rank: rank, id: id}
+		}
+	}
+	return m, nil
+}
+
+// parseHFMerges accepts both the "a b" string form and the ["a", "b"] array form
+func parseHFMerges(raw json.RawMessage) ([][2]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var asStrings []string
+	if err := json.Unmarshal(raw, &asStrings); err == nil {
+		pairs := make([][2]string, 0, len(asStrings))
+		for _, s := range asStrings {
+			parts := strings.SplitN(s, " ", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid merge %q", s)
+			}
+			pairs = append(pairs, [2]string{parts[0], parts[1]})
+		}
+		return pairs, nil
+	}
+
+	var asArrays [][]string
+	if err := json.Unmarshal(raw, &asArrays); err != nil {
+		return nil, fmt.Errorf("invalid merges: %w", err)
+	}
+	pairs := make([][2]string, 0, len(asArrays))
+	for _, a := range asArrays {
+		if len(a) != 2 {
+			return nil, fmt.Errorf("invalid merge %v", a)
+		}
+		pairs = append(pairs, [2]string{a[0], a[1]})
+	}
+	return pairs, nil
+}
+
+func (m *hfBPE) count(word string) int {
+	if m.ignoreMerges {
+		if _, ok := m.vocab[word]; ok {
+			return 1
+		}
+	}
+
+	symbols := m.initialSymbols(word)
+	if len(symbols) < 2 || len(m.merges) == 0 {
+		return len(symbols)
+	}
+	return m.mergeSymbols(symbols)
+}
+
+// initialSymbols turns a word into character tokens, applying byte fallback
+// and unknown-token fusing
+func (m *hfBPE) initialSymbols(word string) []int {
+	symbols := make([]int, 0, len(word))
+	lastWasUnk := false
+	runeCount := utf8.RuneCountInString(word)
+	i := 0
+	for _, r := range word {
+		s := string(r)
+		if i > 0 {
+			s = m.prefix + s
+		}
+		if i == runeCount-1 {
+			s += m.suffix
+		}
+		i++
+
+		if id, ok := m.vocab[s]; ok {
+			symbols = append(symbols, id)
+			lastWasUnk = false
+			continue
+		}
+
+		if m.byteFallback {
+			if ids, ok := m.byteTokens(string(r)); ok {
+				symbols = append(symbols, ids...)
+				lastWasUnk = false
+				continue
+			}
+		}
+
+		if m.unkID < 0 {
+			// Without an unknown token, HF drops the character
+			continue
+		}
+		if m.fuseUnk && lastWasUnk {
+			continue
+		}
+		symbols = append(symbols, m.unkID)
+		lastWasUnk = true
+	}
+	return symbols
+}
+
+// byteTokens returns the <0xXX> tokens for the bytes of s
+func (m *hfBPE) byteTokens(s string) ([]int, bool) {
+	ids := make([]int, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		id, ok := m.vocab[fmt.Sprintf("<0x%02X>", s[i])]
+		if !ok {
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// mergeSymbols applies merges lowest rank first (leftmost on ties) and
+// returns the number of remaining symbols
+func (m *hfBPE) mergeSymbols(ids []int) int {
+	n := len(ids)
+	prev := make([]int, n)
+	next := make([]int, n)
+	alive := make([]bool, n)
+	for i := range ids {
+		prev[i] = i - 1
+		next[i] = i + 1
+		alive[i] = true
+	}
+	next[n-1] = -1
+
+	queue := &mergeQueue{}
+	push := func(pos int) {
+		right := next[pos]
+		if right < 0 {
+			return
+		}
+		if merge, ok := m.merges[[2]int{ids[pos], ids[right]}]; ok {
+			heap.Push(queue, mergeCandidate{rank: merge.rank, pos: pos, left: ids[pos], right: ids[right], id: merge.id})
+		}
+	}
+	for i := 0; i < n-1; i++ {
+		push(i)
+	}
+
+	count := n
+	for queue.Len() > 0 {
+		c := heap.Pop(queue).(mergeCandidate)
+		right := next[c.pos]
+		// Skip candidates invalidated by earlier merges
+		if !alive[c.pos] || right < 0 || ids[c.pos] != c.left || ids[right] != c.right {
+			continue
+		}
+
+		ids[c.pos] = c.id
+		alive[right] = false
+		next[c.pos] = next[right]
+		if next[right] >= 0 {
+			prev[next[right]] = c.pos
+		}
+		count--
+
+		if prev[c.pos] >= 0 {
+			push(prev[c.pos])
+		}
+		push(c.pos)
+	}
+	return count
+}
+
+type mergeCandidate struct {
+	rank, pos, left, right, id int
+}
+
+// mergeQueue orders merge candidates by rank, then position
+type mergeQueue []mergeCandidate
+
+func (q mergeQueue) Len() int { return len(q) }
+func (q mergeQueue) Less(i, j int) bool {
+	if q[i].rank != q[j].rank {
+		return q[i].rank < q[j].rank
+	}
+	return q[i].pos < q[j].pos
+}
+func (q mergeQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *mergeQueue) Push(x interface{}) { *q = append(*q, x.(mergeCandidate)) }
+func (q *mergeQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// hfUnigram is a SentencePiece unigram language model
+type hfUnigram struct {
+	scores       map[string]float64
+	maxPieceLen  int // In runes
+	unkScore     float64
+	byteFallback bool
+}
+
+// unigramUnkPenalty matches the penalty SentencePiece applies to unknown pieces
+const unigramUnkPenalty = 10.0
+
+func newHFUnigram(spec hfModelSpec) (*hfUnigram, error) {
+	var entries [][2]json.RawMessage
+	if err := json.Unmarshal(spec.Vocab, &entries); err != nil {
+		return nil, fmt.Errorf("invalid Unigram vocab: %w", err)
+	}
+
+	m := &hfUnigram{
+		scores:       make(map[string]float64, len(entries)),
+		byteFallback: spec.ByteFallback,
+	}
+	minScore := math.Inf(1)
+	for i, entry := range entries {
+		var piece string
+		var score float64
+		if err := json.Unmarshal(entry[0], &piece); err != nil {
+			return nil, fmt.Errorf("invalid Unigram piece %d: %w", i, err)
+		}
+		if err := json.Unmarshal(entry[1], &score); err != nil {
+			return nil, fmt.Errorf("invalid Unigram score %d: %w", i, err)
+		}
+		if spec.UnkID != nil && i == *spec.UnkID {
+			// The unknown piece itself is never produced by matching
+			continue
+		}
+		m.scores[piece] = score
+		if l := utf8.RuneCountInString(piece); l > m.maxPieceLen {
+			m.maxPieceLen = l
+		}
+		if score < minScore {
+			minScore = score
+		}
+	}
+	if math.IsInf(minScore, 1) {
+		minScore = 0
+	}
+	m.unkScore = minScore - unigramUnkPenalty
+	return m, nil
+}
+
+// count runs Viterbi to find the most likely segmentation and returns its length
+func (m *hfUnigram) count(text string) int {
+	runes := []rune(text)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+
+	type node struct {
+		score float64
+		start int  // Start of the best piece ending here
+		unk   bool // Whether that piece is unknown
+	}
+	best := make([]node, n+1)
+	for i := 1; i <= n; i++ {
+		best[i].score = math.Inf(-1)
+	}
+
+	for start := 0; start < n; start++ {
+		if math.IsInf(best[start].score, -1) {
+			continue
+		}
+		base := best[start].score
+		hasSingle := false
+		for l := 1; l <= m.maxPieceLen && start+l <= n; l++ {
+			score, ok := m.scores[string(runes[start:start+l])]
+			if !ok {
+				continue
+			}
+			if l == 1 {
+				hasSingle = true
+			}
+			if s := base + score; s > best[start+l].score {
+				best[start+l] = node{score: s, start: start}
+			}
+		}
+		if !hasSingle {
+			if s := base + m.unkScore; s > best[start+1].score {
+				best[start+1] = node{score: s, start: start, unk: true}
+			}
+		}
+	}
+
+	// Walk back through the best path, fusing consecutive unknown pieces
+	count := 0
+	prevUnk := false
+	for end := n; end > 0; {
+		nd := best[end]
+		switch {
+		case nd.unk && m.byteFallback:
+			count += len(string(runes[nd.start:end]))
+		case nd.unk:
+			if !prevUnk {
+				count++
+			}
+		default:
+			count++
+		}
+		prevUnk = nd.unk && !m.byteFallback
+		end = nd.start
+	}
+	return count
+}
+
+// hfWordPiece is a greedy longest-match-first model (BERT style)
+type hfWordPiece struct {
+	vocab        map[string]int
+	prefix       string
+	maxInputRune int
+}
+
+func newHFWordPiece(spec hfModelSpec) (*hfWordPiece, error) {
+	var vocab map[string]int
+	if err := json.Unmarshal(spec.Vocab, &vocab); err != nil {
+		return nil, fmt.Errorf("invalid WordPiece vocab: %w", err)
+	}
+	m := &hfWordPiece{vocab: vocab, prefix: "##", maxInputRune: spec.MaxInputCharsPerWord}
+	if spec.ContinuingSubwordPrefix != nil {
+		m.prefix = *spec.ContinuingSubwordPrefix
+	}
+	if m.maxInputRune == 0 {
+		m.maxInputRune = 100
+	}
+	return m, nil
+}
+
+func (m *hfWordPiece) count(word string) int {
+	runes := []rune(word)
+	if len(runes) > m.maxInputRune {
+		return 1 // The whole word becomes [UNK]
+	}
+
+	count := 0
+	for start := 0; start < len(runes); {
+		end := len(runes)
+		found := false
+		for end > start {
+			sub := string(runes[start:end])
+			if start > 0 {
+				sub = m.prefix + sub
+			}
+			if _, ok := m.vocab[sub]; ok {
+				found = true
+				break
+			}
+			end--
+		}
+		if !found {
+			return 1 // The whole word becomes [UNK]
+		}
+		count++
+		start = end
+	}
+	return count
+}
+
+// HF alias registry
+
+var (
+	hfAliases   = make(map[string]string)
+	hfAliasesMu sync.RWMutex
+)
+
+// RegisterHFAlias makes "hf:<alias>" (and the bare alias) resolve to a tokenizer.json path
+func RegisterHFAlias(alias, path string) {
+	hfAliasesMu.Lock()
+	defer hfAliasesMu.Unlock()
+	hfAliases[strings.ToLower(alias)] = path
+}
+
+// HFAliases returns the registered aliases, sorted
+func HFAliases() []string {
+	hfAliasesMu.RLock()
+	defer hfAliasesMu.RUnlock()
+	aliases := make([]string, 0, len(hfAliases))
+	for alias := range hfAliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// resolveHFTokenizer returns the tokenizer.json path for a provider name, if
+// it names a Hugging Face tokenizer: "hf:<path>", "hf:<alias>", or an alias
+// registered in config or installed in the asset store
+func resolveHFTokenizer(provider string) (string, bool) {
+	name := provider
+	explicit := false
+	if strings.HasPrefix(strings.ToLower(provider), HFProviderPrefix) {
+		name = provider[len(HFProviderPrefix):]
+		explicit = true
+	}
+
+	hfAliasesMu.RLock()
+	path, ok := hfAliases[strings.ToLower(name)]
+	hfAliasesMu.RUnlock()
+	if ok {
+		return path, true
+	}
+
+	if path := currentAssetStore().HFPath(name); path != "" {
+		return path, true
+	}
+
+	if explicit {
+		// Anything else after "hf:" is a file path
+		return name, true
+	}
+	return "", false
+}
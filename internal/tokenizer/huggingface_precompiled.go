@@ -0,0 +1,114 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// hfPrecompiled is the normalizer SentencePiece models (T5, Llama, Mistral)
+// ship with: a precompiled_charsmap holding a darts-clone double-array trie
+// of UTF-8 sequences and the strings they normalize to. It is applied the
+// way the tokenizers library applies it: each grapheme shorter than 6 bytes
+// is looked up whole, and otherwise, or when it has no entry, each of its
+// characters is.
+type hfPrecompiled struct {
+	trie       []uint32
+	normalized string // NUL-terminated strings the trie's values point into
+}
+
+// newHFPrecompiled decodes a precompiled_charsmap: the trie's size in bytes
+// as a little-endian uint32, the trie's units, then the normalized strings
+func newHFPrecompiled(charsmap []byte) (*hfPrecompiled, error) {
+	if len(charsmap) < 4 {
+		return nil, fmt.Errorf("precompiled_charsmap is truncated")
+	}
+	size := binary.LittleEndian.Uint32(charsmap)
+	if size%4 != 0 || uint64(size) > uint64(len(charsmap)-4) || size == 0 {
+		return nil, fmt.Errorf("precompiled_charsmap has an invalid trie size %d", size)
+	}
+	p := &hfPrecompiled{trie: make([]uint32, size/4), normalized: string(charsmap[4+size:])}
+	for i := range p.trie {
+		p.trie[i] = binary.LittleEndian.Uint32(charsmap[4+4*i:])
+	}
+	return p, nil
+}
+
+func (p *hfPrecompiled) normalize(text string) string {
+	var sb strings.Builder
+	sb.Grow(len(text))
+	graphemes := uniseg.NewGraphemes(text)
+	for graphemes.Next() {
+		grapheme := graphemes.Str()
+		if len(grapheme) < 6 {
+			if norm, ok := p.transform(grapheme); ok {
+				sb.WriteString(norm)
+				continue
+			}
+		}
+		for len(grapheme) > 0 {
+			_, size := utf8.DecodeRuneInString(grapheme)
+			char := grapheme[:size]
+			grapheme = grapheme[size:]
+			if norm, ok := p.transform(char); ok {
+				sb.WriteString(norm)
+			} else {
+				sb.WriteString(char)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// transform returns the normalization of the shortest prefix of chunk in
+// the trie, as the tokenizers library does
+func (p *hfPrecompiled) transform(chunk string) (string, bool) {
+	value, ok := p.firstPrefix(chunk)
+	if !ok || value >= len(p.normalized) {
+		return "", false
+	}
+	end := strings.IndexByte(p.normalized[value:], 0)
+	if end < 0 {
+		end = len(p.normalized) - value
+	}
+	return p.normalized[value : value+end], true
+}
+
+// firstPrefix walks the double array along key and returns the value of
+// the first key that is a prefix of it
+func (p *hfPrecompiled) firstPrefix(key string) (int, bool) {
+	unit := func(pos uint32) (uint32, bool) {
+		if int(pos) >= len(p.trie) {
+			return 0, false
+		}
+		return p.trie[pos], true
+	}
+	// Unit layout: bit 31 marks a value, bits 10-30 the offset (shifted by
+	// 8 more when bit 9 is set), bit 8 a leaf child and bits 0-7 the label
+	offset := func(u uint32) uint32 { return (u >> 10) << ((u & (1 << 9)) >> 6) }
+
+	u, ok := unit(0)
+	if !ok {
+		return 0, false
+	}
+	pos := offset(u)
+	for i := 0; i < len(key) && key[i] != 0; i++ {
+		c := uint32(key[i])
+		pos ^= c
+		if u, ok = unit(pos); !ok || u&((1<<31)|0xFF) != c {
+			return 0, false
+		}
+		pos ^= offset(u)
+		if u&(1<<8) != 0 {
+			leaf, ok := unit(pos)
+			if !ok {
+				return 0, false
+			}
+			return int(leaf & ((1 << 31) - 1)), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// buildCharsmap builds a precompiled_charsmap mapping each key to its
+// normalization, laid out as darts-clone lays out a double array
+func buildCharsmap(t *testing.T, mapping map[string]string) []byte {
+	t.Helper()
+	type node struct {
+		children map[byte]*node
+		value    int // Offset of the normalized string, or -1
+	}
+	newNode := func() *node { return &node{children: map[byte]*node{}, value: -1} }
+	root := newNode()
+	var normalized []byte
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		n := root
+		for i := 0; i < len(key); i++ {
+			if n.children[key[i]] == nil {
+				n.children[key[i]] = newNode()
+			}
+			n = n.children[key[i]]
+		}
+		n.value = len(normalized)
+		normalized = append(append(normalized, mapping[key]...), 0)
+	}
+
+	units := make([]uint32, 4096)
+	used := map[uint32]bool{0: true}
+	bases := map[uint32]bool{}
+	// place finds a free block for a node's children and its value (label 0)
+	var place func(pos uint32, n *node)
+	place = func(pos uint32, n *node) {
+		labels := []byte{}
+		if n.value >= 0 {
+			labels = append(labels, 0)
+		}
+		for c := range n.children {
+			labels = append(labels, c)
+		}
+		if len(labels) == 0 {
+			return
+		}
+		base := uint32(1)
+	search:
+		for ; ; base++ {
+			if bases[base] {
+				continue
+			}
+			for _, c := range labels {
+				if used[base^uint32(c)] {
+					continue search
+				}
+			}
+			break
+		}
+		bases[base] = true
+		for _, c := range labels {
+			used[base^uint32(c)] = true
+		}
+		units[pos] |= (pos ^ base) << 10
+		if n.value >= 0 {
+			units[pos] |= 1 << 8
+			units[base] = 1<<31 | uint32(n.value)
+		}
+		for c, child := range n.children {
+			units[base^uint32(c)] |= uint32(c)
+			place(base^uint32(c), child)
+		}
+	}
+	// The root's unit only holds the offset of its children
+	rootBase := uint32(256)
+	for c := range root.children {
+		used[rootBase^uint32(c)] = true
+	}
+	bases[rootBase] = true
+	units[0] = rootBase << 10
+	for c, child := range root.children {
+		units[rootBase^uint32(c)] |= uint32(c)
+		place(rootBase^uint32(c), child)
+	}
+
+	blob := binary.LittleEndian.AppendUint32(nil, uint32(len(units)*4))
+	for _, u := range units {
+		blob = binary.LittleEndian.AppendUint32(blob, u)
+	}
+	return append(blob, normalized...)
+}
+
+func TestHFPrecompiledNormalizer(t *testing.T) {
+	charsmap := buildCharsmap(t, map[string]string{
+		"\uff46":  "f",      // Fullwidth letter, one character
+		"e\u0301": "\u00e9", // A grapheme of two characters
+		"\u00a0":  " ",      // No-break space
+		"\ufb01":  "fi",     // Ligature
+	})
+	spec, _ := json.Marshal(map[string]string{"type": "Precompiled", "precompiled_charsmap": base64.StdEncoding.EncodeToString(charsmap)})
+	n, err := parseHFNormalizer(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"\uff46oo":                     "foo",
+		"cafe\u0301":                   "caf\u00e9",
+		"a\u00a0b":                     "a b",
+		"\ufb01le":                     "file",
+		"\uff26 unmapped \u4e16\u754c": "\uff26 unmapped \u4e16\u754c",
+		"":                             "",
+	}
+	for in, want := range tests {
+		if got := n.normalize(in); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	// An empty charsmap normalizes nothing
+	if n, err := parseHFNormalizer(json.RawMessage(`{"type": "Precompiled", "precompiled_charsmap": null}`)); err != nil || n != nil {
+		t.Errorf("empty charsmap = %v, %v; want no normalizer", n, err)
+	}
+	if _, err := parseHFNormalizer(json.RawMessage(`{"type": "Precompiled", "precompiled_charsmap": "AQID"}`)); err == nil {
+		t.Error("a truncated charsmap should be rejected")
+	}
+}
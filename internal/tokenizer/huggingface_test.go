@@ -0,0 +1,122 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// referenceCount is an expected token count for a fixture in testdata/hf
+type referenceCount struct {
+	Text   string `json:"text"`
+	Tokens int    `json:"tokens"`
+}
+
+// referenceCounts are the counts of the Hugging Face tokenizers library for
+// each fixture, written by testdata/hf/generate_reference_counts.py
+type referenceCounts struct {
+	Generator string                      `json:"generator"` // e.g. "tokenizers 0.20.3"
+	Fixtures  map[string][]referenceCount `json:"fixtures"`
+}
+
+func TestHFTokenizerReferenceCounts(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "hf", "reference_counts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var references referenceCounts
+	if err := json.Unmarshal(data, &references); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(references.Generator, "tokenizers ") {
+		t.Fatalf("reference counts were not generated with tokenizers (%s); run testdata/hf/generate_reference_counts.py", references.Generator)
+	}
+
+	for fixture, cases := range references.Fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			path := filepath.Join("testdata", "hf", fixture, "tokenizer.json")
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				t.Skipf("%s is missing; run testdata/hf/generate_reference_counts.py --download", path)
+			}
+			tok, err := NewHFTokenizer("hf:"+fixture, path)
+			if err != nil {
+				t.Fatalf("NewHFTokenizer() error = %v", err)
+			}
+
+			for _, tc := range cases {
+				got, err := tok.CountTokens(tc.Text)
+				if err != nil {
+					t.Fatalf("CountTokens(%q) error = %v", tc.Text, err)
+				}
+				if got != tc.Tokens {
+					t.Errorf("CountTokens(%q) = %d, want %d", tc.Text, got, tc.Tokens)
+				}
+			}
+		})
+	}
+}
+
+func TestHFTokenizerFactory(t *testing.T) {
+	path := filepath.Join("testdata", "hf", "sentencepiece_bpe", "tokenizer.json")
+
+	tok, err := NewTokenizer("hf:" + path)
+	if err != nil {
+		t.Fatalf("NewTokenizer() error = %v", err)
+	}
+	if tok.GetModelName() != "hf:"+path {
+		t.Errorf("GetModelName() = %q, want %q", tok.GetModelName(), "hf:"+path)
+	}
+	if !IsProviderSupported("hf:" + path) {
+		t.Error("IsProviderSupported() should accept hf: providers")
+	}
+
+	RegisterHFAlias("test-llama", path)
+	for _, name := range []string{"test-llama", "hf:test-llama", "HF:Test-Llama"} {
+		if _, err := NewTokenizer(name); err != nil {
+			t.Errorf("NewTokenizer(%q) error = %v", name, err)
+		}
+	}
+
+	if _, err := NewTokenizer("hf:" + filepath.Join("testdata", "missing.json")); err == nil {
+		t.Error("NewTokenizer() should fail for a missing tokenizer.json")
+	}
+}
+
+func TestAssetStoreImportHF(t *testing.T) {
+	store := NewAssetStore(t.TempDir(), true)
+	src := filepath.Join("testdata", "hf", "unigram", "tokenizer.json")
+
+	if _, err := store.ImportHF("../escape", src); err == nil {
+		t.Error("ImportHF() should reject aliases containing path separators")
+	}
+
+	invalid := filepath.Join(t.TempDir(), "tokenizer.json")
+	if err := os.WriteFile(invalid, []byte(`{"model": {"type": "Nope"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ImportHF("nope", invalid); err == nil {
+		t.Error("ImportHF() should reject unsupported tokenizers")
+	}
+
+	dest, err := store.ImportHF("T5", src)
+	if err != nil {
+		t.Fatalf("ImportHF() error = %v", err)
+	}
+	if got := store.HFPath("t5"); got != dest {
+		t.Errorf("HFPath() = %q, want %q", got, dest)
+	}
+
+	statuses := store.ListHF()
+	if len(statuses) != 1 || !statuses[0].Verified || statuses[0].Alias != "t5" {
+		t.Fatalf("ListHF() = %+v, want one verified t5 entry", statuses)
+	}
+
+	if err := os.WriteFile(dest, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := store.ListHF(); statuses[0].Verified {
+		t.Error("ListHF() should detect a modified tokenizer.json")
+	}
+}
@@ -0,0 +1,215 @@
+package tokenizer
+
+import (
+	"runtime"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParallelThreshold is the text size above which CountTokensParallel splits
+// the text across workers; smaller texts are counted in a single pass
+const ParallelThreshold = 1 << 20 // 1 MiB
+
+// Chunking parameters
+const (
+	minParallelChunk = 256 << 10 // Smallest chunk worth a goroutine
+	boundaryWindow   = 64 << 10  // How far around a split point reconciliation looks for safe edges
+)
+
+// CountTokensParallel counts the tokens in text by splitting it at safe
+// boundaries and counting the chunks concurrently on up to workers goroutines
+// (GOMAXPROCS when workers <= 0). The tokenizer must be safe for concurrent use.
+//
+// Splits are placed after a newline that is followed by a letter or digit, or
+// failing that before a space that starts a word, where tokenizers that
+// pre-split on whitespace and punctuation never merge across. Each split is
+// then reconciled: the text around it, between the nearest safe points on
+// either side, is counted once whole and once split, and the difference is
+// added, so the total matches a single-pass count. A split without safe
+// points within boundaryWindow is dropped, leaving its two chunks whole.
+func CountTokensParallel(tok Tokenizer, text string, workers int) (int, error) {
+	if len(text) < ParallelThreshold {
+		return tok.CountTokens(text)
+	}
+	return countParallel(tok, text, workers, minParallelChunk)
+}
+
+// countParallel implements CountTokensParallel with a configurable minimum chunk size
+func countParallel(tok Tokenizer, text string, workers int, minChunk int) (int, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers < 2 {
+		return tok.CountTokens(text)
+	}
+
+	type window struct{ start, end int }
+	var splits []int
+	var windows []window
+	for _, p := range findSplitPoints(text, workers, minChunk) {
+		start, okStart := safeBefore(text, p, boundaryWindow)
+		end, okEnd := safeAfter(text, p, boundaryWindow)
+		if okStart && okEnd {
+			splits = append(splits, p)
+			windows = append(windows, window{start, end})
+		}
+	}
+	if len(splits) == 0 {
+		return tok.CountTokens(text)
+	}
+
+	// Chunk i covers [bounds[i], bounds[i+1])
+	bounds := append(append([]int{0}, splits...), len(text))
+
+	type job struct {
+		text string
+		// For reconciliation jobs, the split position inside text; -1 for chunks
+		split int
+	}
+	jobs := make([]job, 0, 2*len(bounds))
+	for i := 0; i+1 < len(bounds); i++ {
+		jobs = append(jobs, job{text: text[bounds[i]:bounds[i+1]], split: -1})
+	}
+	for i, p := range splits {
+		w := windows[i]
+		jobs = append(jobs, job{text: text[w.start:w.end], split: p - w.start})
+	}
+
+	results := make([]int, len(jobs))
+	errs := make([]error, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				j := jobs[i]
+				if j.split < 0 {
+					results[i], errs[i] = tok.CountTokens(j.text)
+					continue
+				}
+				// Correction = whole window minus its two halves
+				whole, err := tok.CountTokens(j.text)
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				left, err := tok.CountTokens(j.text[:j.split])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				right, err := tok.CountTokens(j.text[j.split:])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i] = whole - left - right
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	total := 0
+	for i := range jobs {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		total += results[i]
+	}
+	return total, nil
+}
+
+// findSplitPoints picks up to workers-1 split points near equal intervals
+func findSplitPoints(text string, workers int, minChunk int) []int {
+	chunks := workers
+	if max := len(text) / minChunk; chunks > max {
+		chunks = max
+	}
+	if chunks < 2 {
+		return nil
+	}
+
+	size := len(text) / chunks
+	var splits []int
+	last := 0
+	for i := 1; i < chunks; i++ {
+		target := i * size
+		if target <= last {
+			continue
+		}
+		p := nextSplitPoint(text, target, target+size/2)
+		if p < 0 || p <= last || p >= len(text) {
+			continue
+		}
+		splits = append(splits, p)
+		last = p
+	}
+	return splits
+}
+
+// nextSplitPoint finds the first safe split point in [from, limit). Newline
+// boundaries are preferred; word starts are the fallback.
+func nextSplitPoint(text string, from, limit int) int {
+	if limit > len(text)-1 {
+		limit = len(text) - 1
+	}
+	fallback := -1
+	for p := from; p < limit; p++ {
+		if isLineSplit(text, p) {
+			return p
+		}
+		if fallback < 0 && isWordSplit(text, p) {
+			fallback = p
+		}
+	}
+	return fallback
+}
+
+// safeBefore returns the nearest safe split point before p, at most window
+// bytes away, or the start of the text. ok is false when there is none: a
+// window ending mid-merge would make the reconciliation wrong.
+func safeBefore(text string, p, window int) (int, bool) {
+	for q := p - 1; q > 0 && q >= p-window; q-- {
+		if isLineSplit(text, q) || isWordSplit(text, q) {
+			return q, true
+		}
+	}
+	return 0, p <= window
+}
+
+// safeAfter returns the nearest safe split point after p, at most window
+// bytes away, or the end of the text. ok is false when there is none.
+func safeAfter(text string, p, window int) (int, bool) {
+	for q := p + 1; q < len(text) && q <= p+window; q++ {
+		if isLineSplit(text, q) || isWordSplit(text, q) {
+			return q, true
+		}
+	}
+	return len(text), len(text)-p <= window
+}
+
+// isLineSplit reports whether p follows a newline and starts with a letter or digit
+func isLineSplit(text string, p int) bool {
+	if p <= 0 || p >= len(text) || text[p-1] != '\n' {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(text[p:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isWordSplit reports whether p is a single space between a non-space and a letter
+func isWordSplit(text string, p int) bool {
+	if p <= 0 || p+1 >= len(text) || text[p] != ' ' {
+		return false
+	}
+	prev, _ := utf8.DecodeLastRuneInString(text[:p])
+	next, _ := utf8.DecodeRuneInString(text[p+1:])
+	return !unicode.IsSpace(prev) && unicode.IsLetter(next)
+}
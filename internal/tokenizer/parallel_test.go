@@ -0,0 +1,159 @@
+package tokenizer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// largeSample builds mixed log, code and prose text of about size bytes
+func largeSample(size int) string {
+	lines := []string{
+		"2024-05-01T12:00:%02d INFO request handled path=/api/v1/users/%d status=200 duration=%dms",
+		"func handle%d(w http.ResponseWriter, r *http.Request) { log.Printf(\"%%v\", r.URL) } // %d",
+		"    The quick brown fox jumps over the lazy dog, again and again (%d times); %d",
+		"héllo wörld — ünïcode line %d with emoji 🚀 and CJK 漢字 %d",
+		"",
+		"\t\tindented\t%d   with   runs   of   spaces %d",
+		"}}\n/* comment %d */ %d",
+	}
+	var sb strings.Builder
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, lines[i%len(lines)], i%60, i, i%997)
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func TestCountParallelMatchesSinglePass(t *testing.T) {
+	text := largeSample(200 << 10)
+
+	for _, fixture := range []string{"bytelevel_bpe", "sentencepiece_bpe", "unigram", "wordpiece"} {
+		t.Run(fixture, func(t *testing.T) {
+			tok, err := NewHFTokenizer(fixture, filepath.Join("testdata", "hf", fixture, "tokenizer.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := tok.CountTokens(text)
+
+			for _, workers := range []int{2, 3, 8, 32} {
+				got, err := countParallel(tok, text, workers, 4<<10)
+				if err != nil {
+					t.Fatalf("countParallel() error = %v", err)
+				}
+				if got != want {
+					t.Errorf("countParallel(workers=%d) = %d, want %d", workers, got, want)
+				}
+			}
+		})
+	}
+}
+
+// lineTokenizer merges whole lines, one token per 4 bytes, so only newlines
+// are safe to split at; a split at a space must be reconciled exactly
+type lineTokenizer struct{}
+
+func (lineTokenizer) CountTokens(text string) (int, error) {
+	total := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		total += (len(line) + 3) / 4
+	}
+	return total, nil
+}
+
+func (lineTokenizer) GetModelName() string { return "lines" }
+
+func TestCountParallelWithoutSafeWindow(t *testing.T) {
+	// The only split point is the space, with no other safe point within
+	// boundaryWindow; windows cut at arbitrary bytes would be off by one
+	text := strings.Repeat("a", 122882) + " b" + strings.Repeat("c", 77116)
+	if splits := findSplitPoints(text, 2, 4<<10); len(splits) != 1 {
+		t.Fatalf("findSplitPoints() = %v, want the one word split", splits)
+	}
+	want, _ := lineTokenizer{}.CountTokens(text)
+	if got, _ := countParallel(lineTokenizer{}, text, 2, 4<<10); got != want {
+		t.Errorf("countParallel() = %d, want %d", got, want)
+	}
+
+	// Within reach of line starts, the split is reconciled between them
+	text = strings.Repeat("a", 30000) + "\n" + strings.Repeat("a", 50002) + " b" + strings.Repeat("c", 49998) + "\n" + strings.Repeat("d", 30000)
+	want, _ = lineTokenizer{}.CountTokens(text)
+	if got, _ := countParallel(lineTokenizer{}, text, 2, 4<<10); got != want {
+		t.Errorf("countParallel() with line windows = %d, want %d", got, want)
+	}
+}
+
+func TestCountParallelTiktoken(t *testing.T) {
+	tok, err := NewTiktokenTokenizer("openai")
+	if err != nil {
+		t.Skipf("cl100k_base not available: %v", err)
+	}
+
+	text := largeSample(2 << 20)
+	want, _ := tok.CountTokens(text)
+	got, err := CountTokensParallel(tok, text, 8)
+	if err != nil {
+		t.Fatalf("CountTokensParallel() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("CountTokensParallel() = %d, want %d", got, want)
+	}
+}
+
+func TestFindSplitPoints(t *testing.T) {
+	text := largeSample(64 << 10)
+	splits := findSplitPoints(text, 4, 4<<10)
+	if len(splits) != 3 {
+		t.Fatalf("findSplitPoints() = %v, want 3 splits", splits)
+	}
+	for _, p := range splits {
+		if !isLineSplit(text, p) && !isWordSplit(text, p) {
+			t.Errorf("split %d is not at a safe boundary: %q", p, text[p-5:p+5])
+		}
+	}
+
+	// Without any safe boundary there is nothing to split
+	if splits := findSplitPoints(strings.Repeat("x", 64<<10), 4, 4<<10); len(splits) != 0 {
+		t.Errorf("findSplitPoints() = %v, want none", splits)
+	}
+}
+
+// benchmarkTokenizer returns cl100k_base when available, or the byte-level
+// BPE fixture otherwise, so the benchmarks run offline too
+func benchmarkTokenizer(b *testing.B) Tokenizer {
+	if tok, err := NewTiktokenTokenizer("openai"); err == nil {
+		return tok
+	}
+	tok, err := NewHFTokenizer("bytelevel_bpe", filepath.Join("testdata", "hf", "bytelevel_bpe", "tokenizer.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return tok
+}
+
+func benchmarkCount(b *testing.B, size int, parallel bool) {
+	tok := benchmarkTokenizer(b)
+	text := largeSample(size)
+	b.SetBytes(int64(len(text)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var err error
+		if parallel {
+			_, err = CountTokensParallel(tok, text, 0)
+		} else {
+			_, err = tok.CountTokens(text)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCountTokensSinglePass4MB(b *testing.B) { benchmarkCount(b, 4<<20, false) }
+func BenchmarkCountTokensParallel4MB(b *testing.B)   { benchmarkCount(b, 4<<20, true) }
+func BenchmarkCountTokensSinglePass16MB(b *testing.B) {
+	benchmarkCount(b, 16<<20, false)
+}
+func BenchmarkCountTokensParallel16MB(b *testing.B) { benchmarkCount(b, 16<<20, true) }
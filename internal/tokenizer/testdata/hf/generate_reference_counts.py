@@ -0,0 +1,104 @@
+#!/usr/bin/env python3
+"""Regenerate reference_counts.json with the Hugging Face tokenizers library.
+
+HFTokenizer must count exactly what `tokenizers` encodes without the special
+tokens a post-processor adds, so every count in reference_counts.json comes
+from this script rather than from the Go implementation:
+
+    pip install 'tokenizers>=0.19'
+    python3 generate_reference_counts.py --download   # Fetch the real models once
+    python3 generate_reference_counts.py              # Recount every fixture
+
+Each directory next to this script holding a tokenizer.json is a fixture: the
+small synthetic ones exercise edge cases of each model type, and the real
+models below (fetched with --download) cover the BPE, Unigram and WordPiece
+paths as they are used in practice. Texts already listed for a fixture are
+kept; fixtures without any get their FIXTURE_TEXTS, or COMMON_TEXTS.
+"""
+
+import argparse
+import json
+import os
+import sys
+
+from tokenizers import Tokenizer, __version__
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+COUNTS = os.path.join(HERE, "reference_counts.json")
+
+# Real models, by fixture directory: the Hub repository holding tokenizer.json
+MODELS = {
+    "gpt2": "openai-community/gpt2",                        # Byte-level BPE
+    "llama": "hf-internal-testing/llama-tokenizer",         # SentencePiece BPE with byte fallback
+    "t5": "google-t5/t5-small",                             # Unigram with a Precompiled normalizer
+    "bert": "google-bert/bert-base-uncased",                # WordPiece
+}
+
+COMMON_TEXTS = [
+    "",
+    "hello world",
+    "Hello, World! How are you today?",
+    "  leading and trailing spaces  ",
+    "tabs\tand\nnewlines\n\n",
+    "func main() {\n\tfmt.Println(\"héllo, 世界\")\n}\n",
+    "SELECT id, status FROM events WHERE status = 'error' LIMIT 100;",
+    "2024-05-01T12:00:00Z ERROR pod/api-7d9f8c6b5-x2k4q CrashLoopBackOff",
+    "naïve café déjà vu — emoji 🚀🔥 and numbers 1234567890",
+    "unaffable antidisestablishmentarianism",
+]
+
+# Texts for synthetic fixtures that COMMON_TEXTS would not exercise
+FIXTURE_TEXTS = {
+    # Unigram whose Precompiled charsmap maps fullwidth letters to ASCII
+    "precompiled": [
+        "hello world",
+        "\uff48\uff45\uff4c\uff4c\uff4f \uff57\uff4f\uff52\uff4c\uff44",
+        "\uff58\uff59\uff5a hello",
+        "he\uff4c\uff4co",
+    ],
+}
+
+
+def download():
+    for name, repo in MODELS.items():
+        path = os.path.join(HERE, name, "tokenizer.json")
+        os.makedirs(os.path.dirname(path), exist_ok=True)
+        Tokenizer.from_pretrained(repo).save(path, pretty=False)
+        print(f"saved {repo} to {os.path.relpath(path, HERE)}")
+
+
+def count(tokenizer, text):
+    return len(tokenizer.encode(text, add_special_tokens=False).ids)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--download", action="store_true", help="fetch the real models' tokenizer.json first")
+    args = parser.parse_args()
+    if args.download:
+        download()
+
+    with open(COUNTS) as f:
+        existing = json.load(f).get("fixtures", {})
+
+    fixtures = {}
+    for name in sorted(os.listdir(HERE)):
+        path = os.path.join(HERE, name, "tokenizer.json")
+        if not os.path.isfile(path):
+            continue
+        tokenizer = Tokenizer.from_file(path)
+        texts = [case["text"] for case in existing.get(name, [])] or FIXTURE_TEXTS.get(name, COMMON_TEXTS)
+        fixtures[name] = [{"text": text, "tokens": count(tokenizer, text)} for text in texts]
+
+    missing = sorted(set(MODELS) - set(fixtures))
+    if missing:
+        sys.exit(f"missing real models {missing}; run with --download")
+
+    with open(COUNTS, "w") as f:
+        json.dump({"generator": f"tokenizers {__version__}", "fixtures": fixtures}, f, indent=2, ensure_ascii=False)
+        f.write("\n")
+    print(f"wrote {sum(map(len, fixtures.values()))} counts for {len(fixtures)} fixtures")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,201 @@
+// Package workflow reads the workflows run by 'ctx seq': steps run one after
+// another, each optionally guarded by a condition on the envelopes of the
+// steps before it, such as "probe.tokens < 5000".
+//
+// A workflow is YAML:
+//
+//	name: error-events
+//	budget: 20000          # Tokens all steps may use together
+//	limits:                # Apply to every step unless a step sets its own
+//	  max_tokens: 5000
+//	  timeout: 30s
+//	steps:
+//	  - name: probe
+//	    run: psql -tA -c "SELECT COUNT(*) FROM events WHERE status='error'"
+//	    quiet: true        # Leave its output out of the combined envelope
+//	  - name: errors
+//	    if: probe.output < 1000
+//	    run: psql -c "SELECT * FROM events WHERE status='error'"
+//	    else: psql -c "SELECT * FROM events WHERE status='error' LIMIT 100"
+//
+// A condition may use the envelope fields of every earlier step, prefixed
+// with its name (see condition.EnvelopeVars), and <name>.ran and
+// <name>.branch, which say whether and how the step ran.
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slavakurilyak/ctx/internal/condition"
+	"github.com/slavakurilyak/ctx/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// How a step ran
+const (
+	BranchRun     = "run"     // Its command ran: it has no condition, or the condition held
+	BranchElse    = "else"    // Its else command ran, because the condition did not hold
+	BranchSkipped = "skipped" // Neither ran: the condition did not hold and there is no else
+	BranchNotRun  = "not_run" // The workflow stopped before the step
+)
+
+// Limits are the limits a step runs with. Unset limits fall back to the
+// workflow's, and then to ctx's flags and configuration.
+type Limits struct {
+	MaxTokens      *int64 `yaml:"max_tokens,omitempty"`
+	MaxLines       *int64 `yaml:"max_lines,omitempty"`
+	MaxOutputBytes *int64 `yaml:"max_output_bytes,omitempty"`
+	Timeout        string `yaml:"timeout,omitempty"` // e.g. "30s"
+}
+
+// Merge returns the limits with those set in override replacing them
+func (l Limits) Merge(override Limits) Limits {
+	if override.MaxTokens != nil {
+		l.MaxTokens = override.MaxTokens
+	}
+	if override.MaxLines != nil {
+		l.MaxLines = override.MaxLines
+	}
+	if override.MaxOutputBytes != nil {
+		l.MaxOutputBytes = override.MaxOutputBytes
+	}
+	if override.Timeout != "" {
+		l.Timeout = override.Timeout
+	}
+	return l
+}
+
+// TimeoutDuration returns the timeout, or 0 when it is not set
+func (l Limits) TimeoutDuration() (time.Duration, error) {
+	if l.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(l.Timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", l.Timeout)
+	}
+	return d, nil
+}
+
+// Step is a command in a workflow
+type Step struct {
+	Name            string `yaml:"name"`
+	Run             string `yaml:"run"`
+	If              string `yaml:"if,omitempty"`   // Condition on earlier steps for running Run
+	Else            string `yaml:"else,omitempty"` // Command run instead when If does not hold
+	Limits          `yaml:",inline"`
+	ContinueOnError bool `yaml:"continue_on_error,omitempty"` // A failure does not stop the workflow
+	Quiet           bool `yaml:"quiet,omitempty"`             // Leave the output out of the combined envelope
+
+	condition *condition.Condition
+}
+
+// Condition returns the step's parsed condition, or nil without one
+func (s *Step) Condition() *condition.Condition {
+	return s.condition
+}
+
+// Workflow is a sequence of steps
+type Workflow struct {
+	Name   string `yaml:"name,omitempty"`
+	Budget int64  `yaml:"budget,omitempty"` // Tokens all steps may use together; 0 for no budget
+	Limits Limits `yaml:"limits,omitempty"`
+	Steps  []Step `yaml:"steps"`
+}
+
+// Load reads and checks a workflow file. A workflow without a name is
+// named after its file.
+func Load(path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return w, nil
+}
+
+// Parse reads and checks a workflow. Unknown keys are an error, so a
+// misspelt limit is not silently ignored.
+func Parse(data []byte) (*Workflow, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var w Workflow
+	if err := decoder.Decode(&w); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("empty workflow")
+		}
+		return nil, err
+	}
+	if err := w.check(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+var stepName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// check validates the steps and parses their conditions, which may only
+// refer to earlier steps
+func (w *Workflow) check() error {
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+	if _, err := w.Limits.TimeoutDuration(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+
+	vars := condition.Vars{}
+	for i := range w.Steps {
+		step := &w.Steps[i]
+		switch {
+		case step.Name == "":
+			return fmt.Errorf("step %d: no name", i+1)
+		case !stepName.MatchString(step.Name):
+			return fmt.Errorf("step %q: names may only hold letters, digits, _ and -", step.Name)
+		case vars[step.Name+".ran"] != nil:
+			return fmt.Errorf("step %q: name used twice", step.Name)
+		case strings.TrimSpace(step.Run) == "":
+			return fmt.Errorf("step %q: no command to run", step.Name)
+		case step.Else != "" && step.If == "":
+			return fmt.Errorf("step %q: else without if", step.Name)
+		}
+		if _, err := step.TimeoutDuration(); err != nil {
+			return fmt.Errorf("step %q: %w", step.Name, err)
+		}
+		if step.If != "" {
+			c, err := condition.Parse(step.If)
+			if err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			if err := c.Check(vars); err != nil {
+				return fmt.Errorf("step %q: %w", step.Name, err)
+			}
+			step.condition = c
+		}
+		vars.Merge(StepVars(step.Name, &models.Output{}, BranchNotRun))
+	}
+	return nil
+}
+
+// StepVars returns the fields later conditions can use for a step: its
+// envelope's, prefixed with its name, and <name>.ran and <name>.branch
+func StepVars(name string, output *models.Output, branch string) condition.Vars {
+	return condition.EnvelopeVars(name, output).Merge(condition.Vars{
+		name + ".ran":    branch == BranchRun || branch == BranchElse,
+		name + ".branch": branch,
+	})
+}
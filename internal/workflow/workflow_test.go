@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slavakurilyak/ctx/internal/condition"
+	"github.com/slavakurilyak/ctx/internal/models"
+)
+
+func TestParse(t *testing.T) {
+	w, err := Parse([]byte(`
+budget: 20000
+limits:
+  max_tokens: 5000
+  timeout: 30s
+steps:
+  - name: probe
+    run: wc -l events.log
+    quiet: true
+  - name: errors
+    if: probe.output < 1000
+    run: grep ERROR events.log
+    else: grep ERROR events.log | head -100
+    max_tokens: 2000
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Budget != 20000 || len(w.Steps) != 2 || !w.Steps[0].Quiet {
+		t.Fatalf("Parse() = %+v", w)
+	}
+	if w.Steps[0].Condition() != nil || w.Steps[1].Condition() == nil {
+		t.Error("only the second step should have a condition")
+	}
+
+	limits := w.Limits.Merge(w.Steps[1].Limits)
+	if *limits.MaxTokens != 2000 || limits.Timeout != "30s" {
+		t.Errorf("merged limits = max_tokens %d, timeout %q", *limits.MaxTokens, limits.Timeout)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"empty", ``, "empty workflow"},
+		{"no steps", `name: x`, "no steps"},
+		{"unknown key", "steps:\n  - name: a\n    run: ls\n    max_token: 5", "max_token"},
+		{"no name", "steps:\n  - run: ls", "no name"},
+		{"bad name", "steps:\n  - name: a.b\n    run: ls", "names may only"},
+		{"duplicate", "steps:\n  - name: a\n    run: ls\n  - name: a\n    run: ls", "used twice"},
+		{"no run", "steps:\n  - name: a", "no command"},
+		{"else without if", "steps:\n  - name: a\n    run: ls\n    else: pwd", "else without if"},
+		{"bad timeout", "steps:\n  - name: a\n    run: ls\n    timeout: soon", "invalid timeout"},
+		{"later step", "steps:\n  - name: a\n    if: b.exit == 0\n    run: ls\n  - name: b\n    run: ls", "b.exit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepVars(t *testing.T) {
+	output := models.NewOutput("wc -l events.log", []byte("42\n"), 0, 0)
+	vars := condition.Vars{}
+	vars.Merge(StepVars("probe", output, BranchRun))
+	vars.Merge(StepVars("skip", &models.Output{}, BranchSkipped))
+
+	for _, expr := range []string{"probe.output < 100", "probe.ran", "!skip.ran", "skip.branch == skipped"} {
+		c, err := condition.Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holds, err := c.Eval(vars); err != nil || !holds {
+			t.Errorf("%s = %v, %v; want true", expr, holds, err)
+		}
+	}
+}